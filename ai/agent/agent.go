@@ -2,8 +2,11 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -23,6 +26,10 @@ type IAgent interface {
 	GetRunningTasks() AgentTaskMap
 	GetCompletedTasks() AgentTaskMap
 	GetKilledTasks() AgentTaskMap
+	GetTask(id string) (AgentTaskRecord, bool)
+	GetTaskRecords() []AgentTaskRecord
+	GetTasksByType(taskType AgentTaskType) []AgentTaskRecord
+	GetTasksByState(states ...AgentTaskState) []AgentTaskRecord
 	AddTask(task IAgentTask) error
 	addSequentialTask(task IAgentTask) error
 	addStandardTask(task IAgentTask) error
@@ -30,44 +37,51 @@ type IAgent interface {
 	runTaskInBackground(task IAgentTask) error
 	executeTaskInBackground(task IAgentTask)
 	AwaitAllTasks()
-	runTaskLoop(wg *sync.WaitGroup)
-	runSequentialTaskLoop(taskType AgentTaskType, wg *sync.WaitGroup)
-	runSequentialTaskEventLoop(wg *sync.WaitGroup)
+	runTaskLoop(ctx context.Context, taskQueue *agentTaskQueue)
+	runSequentialTaskLoop(ctx context.Context, sequentialTaskQueue *agentTaskQueue)
 	Start()
 	Stop()
+	Shutdown(ctx context.Context) (AgentShutdownReport, error)
 	Kill()
 }
 
-type agentSequentialTaskEvent string
+type AgentTaskMap map[string]*IAgentTask
+type AgentSequentialTaskQueueMap map[AgentTaskType]*agentTaskQueue
 
-const (
-	agentSequentialTaskEventNewType agentSequentialTaskEvent = "newType"
-)
+// ErrAgentStopped is returned when adding a task to an agent that is shutting
+// down, or that was stopped or killed and has not been started again.
+var ErrAgentStopped = errors.New("agent is stopped")
 
-type AgentTaskMap map[string]*IAgentTask
-type AgentSequentialTaskQueueMap map[AgentTaskType]chan IAgentTask
-type agentSequentialTaskEvents chan struct {
-	agentSequentialTaskEvent
-	string
-}
 type Agent struct {
 	IAgent
-	ctx                  context.Context             // Agent context
-	id                   string                      // Agent ID
-	name                 string                      // Agent human-readable name
-	_type                string                      // Agent type
-	config               interface{}                 // Agent configuration
-	isRunning            bool                        // Agent running state
-	completedTasks       AgentTaskMap                // Agent completed tasks
-	runningTasks         AgentTaskMap                // Agent running tasks
-	killedTasks          AgentTaskMap                // Agent killed tasks
-	taskQueue            chan IAgentTask             // Agent general task queue
-	sequentialTaskQueues AgentSequentialTaskQueueMap // Agent queues for sequential tasks
-	sequentialTaskEvents agentSequentialTaskEvents   // Agent events for sequential tasks
-	routines             sync.WaitGroup              // Waitgroup for running tasks
-	taskLoopRoutines     sync.WaitGroup              // Waitgroup for task loop
-	taskLoopMutex        sync.Mutex                  // Mutex for task loop
-	killChannel          chan bool                   // Channel to kill agent
+	ctx                   context.Context                    // Agent context
+	cancel                context.CancelFunc                 // Cancels the agent context
+	id                    string                             // Agent ID
+	name                  string                             // Agent human-readable name
+	_type                 string                             // Agent type
+	config                interface{}                        // Agent configuration
+	isRunning             bool                               // Agent running state
+	runningMutex          sync.RWMutex                       // Mutex for running state
+	tasks                 *agentTaskRegistry                 // Agent task states
+	taskQueue             *agentTaskQueue                    // Agent general task queue
+	sequentialTaskQueues  AgentSequentialTaskQueueMap        // Agent queues for sequential tasks
+	sequentialLoopContext context.Context                    // Context for sequential task loops, nil while not running
+	sequentialQueueMutex  sync.Mutex                         // Mutex for sequential task queues and queue settings
+	queueCapacity         int                                // Capacity of each task queue
+	priorityAgingInterval time.Duration                      // Wait after which a queued task gains a priority level
+	queuesOpen            bool                               // Whether the task queues accept tasks
+	queueMutex            sync.RWMutex                       // Held for reading while sending to the queues, for writing while replacing or closing them
+	workerLimits          *agentWorkerLimits                 // Concurrency limits for standard tasks
+	events                *agentEventBus                     // Lifecycle event subscribers
+	schedules             *agentScheduler                    // Scheduled and recurring tasks
+	routines              sync.WaitGroup                     // Waitgroup for running tasks
+	taskLoopRoutines      sync.WaitGroup                     // Waitgroup for task loop
+	taskLoopMutex         sync.Mutex                         // Mutex for task loop
+	retryPolicies         map[AgentTaskType]*RetryPolicy     // Retry policies per task type
+	retryPolicyMutex      sync.RWMutex                       // Mutex for retry policies
+	journal               *AgentJournal                      // Optional task journal
+	taskFactories         map[AgentTaskType]AgentTaskFactory // Factories to resume journaled tasks
+	journalMutex          sync.RWMutex                       // Mutex for the journal and task factories
 }
 
 // NewAgent creates a new agent
@@ -80,30 +94,30 @@ func NewAgent(name string, agentType string, config interface{}) *Agent {
 	ctx := context.Background()
 	id := generateUUID()
 	isRunning := false
-	completedTasks := make(AgentTaskMap)
-	runningTasks := make(AgentTaskMap)
-	killedTasks := make(AgentTaskMap)
-	taskQueue := make(chan IAgentTask, 100)
+	taskQueue := newAgentTaskQueue(DefaultQueueCapacity, DefaultPriorityAgingInterval)
 	sequentialTaskQueues := make(AgentSequentialTaskQueueMap)
-	sequentialTaskEvents := make(agentSequentialTaskEvents)
-	kill := make(chan bool)
 	return &Agent{
-		ctx:                  ctx,
-		id:                   id,
-		name:                 name,
-		_type:                agentType,
-		config:               config,
-		isRunning:            isRunning,
-		routines:             sync.WaitGroup{},
-		taskLoopRoutines:     sync.WaitGroup{},
-		runningTasks:         runningTasks,
-		completedTasks:       completedTasks,
-		killedTasks:          killedTasks,
-		taskQueue:            taskQueue,
-		sequentialTaskQueues: sequentialTaskQueues,
-		taskLoopMutex:        sync.Mutex{},
-		sequentialTaskEvents: sequentialTaskEvents,
-		killChannel:          kill,
+		ctx:                   ctx,
+		cancel:                func() {},
+		id:                    id,
+		name:                  name,
+		_type:                 agentType,
+		config:                config,
+		isRunning:             isRunning,
+		routines:              sync.WaitGroup{},
+		taskLoopRoutines:      sync.WaitGroup{},
+		tasks:                 newAgentTaskRegistry(),
+		workerLimits:          newAgentWorkerLimits(),
+		retryPolicies:         make(map[AgentTaskType]*RetryPolicy),
+		events:                newAgentEventBus(),
+		schedules:             newAgentScheduler(),
+		taskFactories:         make(map[AgentTaskType]AgentTaskFactory),
+		taskQueue:             taskQueue,
+		sequentialTaskQueues:  sequentialTaskQueues,
+		queueCapacity:         DefaultQueueCapacity,
+		priorityAgingInterval: DefaultPriorityAgingInterval,
+		queuesOpen:            true,
+		taskLoopMutex:         sync.Mutex{},
 	}
 }
 
@@ -124,19 +138,83 @@ func (a *Agent) GetConfig() interface{} {
 }
 
 func (a *Agent) IsRunning() bool {
+	a.runningMutex.RLock()
+	defer a.runningMutex.RUnlock()
 	return a.isRunning
 }
 
+func (a *Agent) setRunning(isRunning bool) {
+	a.runningMutex.Lock()
+	defer a.runningMutex.Unlock()
+	a.isRunning = isRunning
+}
+
+// GetRunningTasks returns a snapshot of the tasks currently running.
 func (a *Agent) GetRunningTasks() AgentTaskMap {
-	return a.runningTasks
+	return a.tasks.taskMap(AgentTaskStateRunning)
 }
 
+// GetCompletedTasks returns a snapshot of the tasks that finished without
+// being killed, including those that failed.
 func (a *Agent) GetCompletedTasks() AgentTaskMap {
-	return a.completedTasks
+	return a.tasks.taskMap(AgentTaskStateCompleted, AgentTaskStateFailed)
 }
 
+// GetKilledTasks returns a snapshot of the tasks that were killed.
 func (a *Agent) GetKilledTasks() AgentTaskMap {
-	return a.killedTasks
+	return a.tasks.taskMap(AgentTaskStateKilled)
+}
+
+// GetTask returns a snapshot of the task with the given ID.
+func (a *Agent) GetTask(id string) (AgentTaskRecord, bool) {
+	return a.tasks.get(id)
+}
+
+// GetTaskRecords returns snapshots of every task added to the agent, oldest
+// first.
+func (a *Agent) GetTaskRecords() []AgentTaskRecord {
+	return a.tasks.snapshot(nil)
+}
+
+// GetTasksByType returns snapshots of the tasks of the given type, oldest
+// first.
+func (a *Agent) GetTasksByType(taskType AgentTaskType) []AgentTaskRecord {
+	return a.tasks.snapshot(func(record *AgentTaskRecord) bool {
+		return record.Type == taskType
+	})
+}
+
+// GetTasksByState returns snapshots of the tasks in any of the given states,
+// oldest first.
+func (a *Agent) GetTasksByState(states ...AgentTaskState) []AgentTaskRecord {
+	return a.tasks.snapshot(matchTaskStates(states...))
+}
+
+// SetMaxWorkers limits how many standard tasks the agent runs at the same
+// time. Tasks over the limit stay queued until a worker frees up. A value of
+// zero or less removes the limit. Sequential tasks are not counted, as each
+// sequential task type already runs one task at a time.
+func (a *Agent) SetMaxWorkers(maxWorkers int) {
+	a.workerLimits.setMaxWorkers(maxWorkers)
+}
+
+func (a *Agent) GetMaxWorkers() int {
+	return a.workerLimits.getMaxWorkers()
+}
+
+// SetTaskTypeConcurrencyLimit limits how many standard tasks of taskType the
+// agent runs at the same time. A limit of zero or less removes the limit.
+func (a *Agent) SetTaskTypeConcurrencyLimit(taskType AgentTaskType, limit int) {
+	a.workerLimits.setTypeLimit(taskType, limit)
+}
+
+func (a *Agent) GetTaskTypeConcurrencyLimit(taskType AgentTaskType) int {
+	return a.workerLimits.getTypeLimit(taskType)
+}
+
+// GetActiveWorkerCount returns the number of standard tasks currently running.
+func (a *Agent) GetActiveWorkerCount() int {
+	return a.workerLimits.getActive()
 }
 
 // AddTask adds a task for the agent to execute in its task loop
@@ -150,45 +228,154 @@ func (a *Agent) AddTask(task IAgentTask) error {
 }
 
 func (a *Agent) addSequentialTask(task IAgentTask) error {
-	if !a.taskExists(task) {
-		zap.S().Infof("Adding sequential task <ID: %s, Name: %s> to agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-		taskType := task.GetType()
-		_, sequentialTaskQueueExists := a.sequentialTaskQueues[taskType]
-		if !sequentialTaskQueueExists {
-			a.sequentialTaskQueues[taskType] = make(chan IAgentTask, 100)
-			a.sequentialTaskEvents <- struct {
-				agentSequentialTaskEvent
-				string
-			}{agentSequentialTaskEventNewType, string(taskType.Type)}
-		}
-		a.sequentialTaskQueues[taskType] <- task
+	a.queueMutex.RLock()
+	defer a.queueMutex.RUnlock()
+	if !a.queuesOpen {
+		return fmt.Errorf("%w <ID: %s, Name: %s>", ErrAgentStopped, a.GetID(), a.GetName())
+	}
+	isNew, err := a.tasks.queue(task)
+	if err != nil {
+		return fmt.Errorf("%w on agent <ID: %s>", err, a.GetID())
+	}
+	if !isNew {
 		return nil
 	}
-	return fmt.Errorf("task already exists with <ID: %s> on agent <ID: %s>", task.GetID(), a.GetID())
+	zap.S().Infof("Adding sequential task <ID: %s, Name: %s> to agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
+	a.emitTaskEvent(AgentEventTaskQueued, task, 0, nil)
+	return a.enqueueTask(a.getSequentialTaskQueue(task.GetType()), task)
 }
 
 func (a *Agent) addStandardTask(task IAgentTask) error {
-	if !a.taskExists(task) {
-		zap.S().Infof("Adding standard task <ID: %s, Name: %s> to agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-		a.taskQueue <- task
+	a.queueMutex.RLock()
+	defer a.queueMutex.RUnlock()
+	if !a.queuesOpen {
+		return fmt.Errorf("%w <ID: %s, Name: %s>", ErrAgentStopped, a.GetID(), a.GetName())
+	}
+	isNew, err := a.tasks.queue(task)
+	if err != nil {
+		return fmt.Errorf("%w on agent <ID: %s>", err, a.GetID())
+	}
+	if !isNew {
 		return nil
 	}
-	return fmt.Errorf("task already exists with <ID: %s>", task.GetID())
+	zap.S().Infof("Adding standard task <ID: %s, Name: %s> to agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
+	a.emitTaskEvent(AgentEventTaskQueued, task, 0, nil)
+	return a.enqueueTask(a.taskQueue, task)
+}
+
+// enqueueTask adds a task to one of the agent's queues, blocking while the
+// queue is full. Callers must hold queueMutex for reading. A task that cannot
+// be added is dropped from the registry and reported as skipped, so that no
+// record of it is left queued.
+func (a *Agent) enqueueTask(queue *agentTaskQueue, task IAgentTask) error {
+	err := queue.push(a.getContext(), task)
+	if err != nil {
+		err = fmt.Errorf("%w <ID: %s, Name: %s>", ErrAgentStopped, a.GetID(), a.GetName())
+		if a.tasks.unqueue(task) {
+			task.abandon(err)
+			a.emitTaskEvent(AgentEventTaskSkipped, task, 0, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// getSequentialTaskQueue returns the queue for taskType, creating it and,
+// while the agent runs, its task loop on first use.
+func (a *Agent) getSequentialTaskQueue(taskType AgentTaskType) *agentTaskQueue {
+	a.sequentialQueueMutex.Lock()
+	defer a.sequentialQueueMutex.Unlock()
+	sequentialTaskQueue, exists := a.sequentialTaskQueues[taskType]
+	if !exists {
+		sequentialTaskQueue = newAgentTaskQueue(a.queueCapacity, a.priorityAgingInterval)
+		a.sequentialTaskQueues[taskType] = sequentialTaskQueue
+		if a.sequentialLoopContext != nil {
+			a.startSequentialTaskLoop(a.sequentialLoopContext, sequentialTaskQueue)
+		}
+	}
+	return sequentialTaskQueue
+}
+
+// startSequentialTaskLoops starts a task loop for every sequential queue and
+// for queues created later on. Callers must not hold sequentialQueueMutex.
+func (a *Agent) startSequentialTaskLoops(ctx context.Context) {
+	a.sequentialQueueMutex.Lock()
+	defer a.sequentialQueueMutex.Unlock()
+	a.sequentialLoopContext = ctx
+	for _, sequentialTaskQueue := range a.sequentialTaskQueues {
+		a.startSequentialTaskLoop(ctx, sequentialTaskQueue)
+	}
+}
+
+func (a *Agent) startSequentialTaskLoop(ctx context.Context, sequentialTaskQueue *agentTaskQueue) {
+	a.incrementTaskLoopRoutines()
+	go a.runSequentialTaskLoop(ctx, sequentialTaskQueue)
+}
+
+// openQueues replaces queues closed by a previous shutdown so the agent
+// accepts tasks again. Open queues are checked for under the read lock, as
+// AddTask may hold it while waiting for room in a full queue.
+func (a *Agent) openQueues() {
+	a.queueMutex.RLock()
+	queuesOpen := a.queuesOpen
+	a.queueMutex.RUnlock()
+	if queuesOpen {
+		return
+	}
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	if a.queuesOpen {
+		return
+	}
+	a.sequentialQueueMutex.Lock()
+	a.taskQueue = newAgentTaskQueue(a.queueCapacity, a.priorityAgingInterval)
+	a.sequentialTaskQueues = make(AgentSequentialTaskQueueMap)
+	a.sequentialQueueMutex.Unlock()
+	a.queuesOpen = true
+}
+
+// closeQueues stops the agent from accepting tasks. With drain set the queues
+// are closed so the task loops run what was already queued and exit;
+// otherwise the loops are expected to exit on the agent context.
+func (a *Agent) closeQueues(drain bool) {
+	a.queueMutex.Lock()
+	defer a.queueMutex.Unlock()
+	if !a.queuesOpen {
+		return
+	}
+	a.queuesOpen = false
+	a.sequentialQueueMutex.Lock()
+	defer a.sequentialQueueMutex.Unlock()
+	a.sequentialLoopContext = nil
+	if !drain {
+		return
+	}
+	a.taskQueue.close()
+	for _, sequentialTaskQueue := range a.sequentialTaskQueues {
+		sequentialTaskQueue.close()
+	}
+}
+
+func (a *Agent) getTaskQueue() *agentTaskQueue {
+	a.queueMutex.RLock()
+	defer a.queueMutex.RUnlock()
+	return a.taskQueue
 }
 
-func (a *Agent) taskExists(task IAgentTask) bool {
-	_, completedTaskExists := a.completedTasks[task.GetID()]
-	_, runningTaskExists := a.runningTasks[task.GetID()]
-	return completedTaskExists || runningTaskExists
+func (a *Agent) getContext() context.Context {
+	a.runningMutex.RLock()
+	defer a.runningMutex.RUnlock()
+	return a.ctx
 }
 
 func (a *Agent) runTaskInBackground(task IAgentTask) error {
-	if !a.taskExists(task) {
-		a.runningTasks[task.GetID()] = &task
-		a.executeTaskInBackground(task)
-		return nil
+	err := a.tasks.start(task)
+	if err != nil {
+		return err
 	}
-	return fmt.Errorf("task already exists with <ID: %s>", task.GetID())
+	a.emitTaskEvent(AgentEventTaskStarted, task, 0, nil)
+	a.executeTaskInBackground(task)
+	return nil
 }
 
 func (a *Agent) runTask(task IAgentTask) error {
@@ -196,23 +383,28 @@ func (a *Agent) runTask(task IAgentTask) error {
 	if err != nil {
 		return err
 	}
-	task.AwaitCompletion()
+	<-task.Done()
 	return nil
 }
 
 func (a *Agent) executeTaskInBackground(task IAgentTask) {
-	a.runningTasks[task.GetID()] = &task
+	ctx := contextWithTask(a.getContext(), a, task)
 	a.incrementTaskRoutines()
 	go func() {
 		zap.S().Infof("Executing task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-		task.Execute(func() {
-			a.decrementTaskRoutines()
-			delete(a.runningTasks, task.GetID())
-			if task.WasKilled() {
-				a.killedTasks[task.GetID()] = &task
-			} else {
-				a.completedTasks[task.GetID()] = &task
+		onRetry := func(attempt int, err error) {
+			zap.S().Infof("Retrying task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>, attempt %d: %v", task.GetID(), task.GetName(), a.GetID(), a.GetName(), attempt, err)
+			a.emitTaskEvent(AgentEventTaskRetried, task, attempt, err)
+		}
+		task.execute(ctx, a.getRetryPolicy(task), onRetry, func() {
+			record, finished := a.tasks.finish(task)
+			if finished {
+				a.emitTaskFinished(record)
+			}
+			if !task.IsSequential() {
+				a.workerLimits.release(task.GetType())
 			}
+			a.decrementTaskRoutines()
 		})
 		zap.S().Infof("Finished executing task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
 	}()
@@ -254,113 +446,161 @@ func (a *Agent) decrementTaskRoutines() {
 	a.unlockTaskLoop()
 }
 
-func (a *Agent) runTaskLoop() {
+// runTaskLoop dispatches standard tasks as worker slots become available,
+// highest priority first. Tasks that exceed the worker limits stay queued, so
+// AddTask blocks once the queue is full until workers catch up.
+func (a *Agent) runTaskLoop(ctx context.Context, taskQueue *agentTaskQueue) {
 	defer a.decrementTaskLoopRoutines()
 	for {
-		select {
-		case task, ok := <-a.taskQueue:
-			if !ok {
-				return
-			}
+		tasks, changed, drained := taskQueue.take(func(task IAgentTask) bool {
+			return a.workerLimits.tryAcquire(task.GetType())
+		}, 0)
+		for _, task := range tasks {
 			zap.S().Infof("Running standard task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-			_ = a.runTaskInBackground(task)
-		case <-a.killChannel:
-			close(a.taskQueue)
+			err := a.runTaskInBackground(task)
+			if err != nil {
+				a.workerLimits.release(task.GetType())
+			}
+		}
+		if drained {
 			return
 		}
-	}
-}
-func (a *Agent) runSequentialTaskLoop(taskType AgentTaskType) {
-	defer a.decrementTaskLoopRoutines()
-	for {
 		select {
-		case task, ok := <-a.sequentialTaskQueues[taskType]:
-			if !ok {
-				return
-			}
-			zap.S().Infof("Running sequential task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-			_ = a.runTask(task) // Runs the task blocking the task loop
-			zap.S().Infof("Finished sequential task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
-		case <-a.killChannel:
-			close(a.sequentialTaskQueues[taskType])
+		case <-changed:
+		case <-a.workerLimits.released:
+		case <-ctx.Done():
 			return
 		}
 	}
 }
 
-func (a *Agent) runSequentialTaskEventLoop() {
+func (a *Agent) runSequentialTaskLoop(ctx context.Context, sequentialTaskQueue *agentTaskQueue) {
 	defer a.decrementTaskLoopRoutines()
 	for {
-		select {
-		case eventType, ok := <-a.sequentialTaskEvents:
-			if !ok {
-				return
-			}
-			switch eventType.agentSequentialTaskEvent {
-			case agentSequentialTaskEventNewType:
-				a.incrementTaskLoopRoutines()
-				taskType := AgentTaskType{
-					Type:         eventType.string,
-					IsSequential: true,
-				}
-				go a.runSequentialTaskLoop(taskType)
-			}
-		case <-a.killChannel:
-			for taskType := range a.sequentialTaskQueues {
-				close(a.sequentialTaskQueues[taskType])
-			}
-			close(a.sequentialTaskEvents)
+		task, ok := sequentialTaskQueue.pop(ctx)
+		if !ok {
+			return
 		}
+		zap.S().Infof("Running sequential task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
+		_ = a.runTask(task) // Runs the task blocking the task loop
+		zap.S().Infof("Finished sequential task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", task.GetID(), task.GetName(), a.GetID(), a.GetName())
 	}
 }
 
 func (a *Agent) Start() {
 	zap.S().Infof("Starting agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
+	a.runningMutex.Lock()
 	if a.isRunning {
+		a.runningMutex.Unlock()
 		zap.S().Infof("Agent <ID: %s, Name: %s> is already running. Start canceled.", a.GetID(), a.GetName())
 		return
 	}
 	a.isRunning = true
+	a.ctx, a.cancel = context.WithCancel(context.Background())
+	ctx := a.ctx
+	a.runningMutex.Unlock()
+	a.openQueues()
 	a.incrementTaskLoopRoutines()
-	go a.runTaskLoop()
-	a.incrementTaskLoopRoutines()
-	go a.runSequentialTaskEventLoop()
+	go a.runTaskLoop(ctx, a.getTaskQueue())
+	a.startSequentialTaskLoops(ctx)
+	a.emitAgentEvent(AgentEventAgentStarted)
 	zap.S().Infof("Started agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
 }
 
+// Stop shuts the agent down gracefully, waiting for every queued and running
+// task to finish. Use Shutdown to bound how long to wait.
 func (a *Agent) Stop() {
-	zap.S().Infof("Stopping agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
-	if !a.isRunning {
-		zap.S().Infof("Agent <ID: %s, Name: %s> is not running. Stop canceled.", a.GetID(), a.GetName())
-		return
+	_, _ = a.Shutdown(context.Background())
+}
+
+// AgentShutdownReport describes the outcome of Agent.Shutdown.
+type AgentShutdownReport struct {
+	// Abandoned holds the tasks that were still queued or running when the
+	// shutdown deadline passed and were killed. The records reflect the state
+	// the tasks were in before they were killed.
+	Abandoned []AgentTaskRecord
+}
+
+// Shutdown cancels the agent's schedules, stops it from accepting tasks and
+// waits for the queued and running tasks to finish, then compacts its
+// journal, if any. If ctx is done first, the remaining tasks are killed,
+// reported as abandoned, and ctx's error is returned.
+func (a *Agent) Shutdown(ctx context.Context) (AgentShutdownReport, error) {
+	zap.S().Infof("Shutting down agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
+	if !a.IsRunning() {
+		zap.S().Infof("Agent <ID: %s, Name: %s> is not running. Shutdown canceled.", a.GetID(), a.GetName())
+		return AgentShutdownReport{}, nil
 	}
-	close(a.taskQueue)
-	if a.sequentialTaskQueues != nil {
-		for taskType := range a.sequentialTaskQueues {
-			close(a.sequentialTaskQueues[taskType])
+	a.cancelSchedules()
+	a.closeQueues(true)
+	drained := make(chan struct{})
+	go func() {
+		a.taskLoopRoutines.Wait()
+		a.AwaitAllTasks()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		if a.markStopped() {
+			a.emitAgentEvent(AgentEventAgentStopped)
 		}
+		a.compactJournal()
+		zap.S().Infof("Stopped agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
+		return AgentShutdownReport{}, nil
+	case <-ctx.Done():
+		abandoned := a.kill()
+		zap.S().Infof("Shutdown of agent <ID: %s, Name: %s> abandoned %d tasks: %v", a.GetID(), a.GetName(), len(abandoned), ctx.Err())
+		return AgentShutdownReport{Abandoned: abandoned}, ctx.Err()
+	}
+}
+
+// markStopped marks the agent as no longer running and cancels its context,
+// reporting false if the agent was already stopped or killed.
+func (a *Agent) markStopped() bool {
+	a.runningMutex.Lock()
+	defer a.runningMutex.Unlock()
+	if !a.isRunning {
+		return false
 	}
-	close(a.sequentialTaskEvents)
-	a.taskLoopRoutines.Wait()
 	a.isRunning = false
-	zap.S().Infof("Stopped agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
+	a.cancel()
+	return true
 }
 
+// Kill stops the agent immediately, cancelling its schedules and killing its
+// queued and running tasks.
 func (a *Agent) Kill() {
 	zap.S().Infof("Killing agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
-	if !a.isRunning {
+	if !a.IsRunning() {
 		zap.S().Infof("Agent <ID: %s, Name: %s> is not running. Kill canceled.", a.GetID(), a.GetName())
 		return
 	}
-	a.killChannel <- true
-	for task := range a.runningTasks {
-		(*a.runningTasks[task]).Kill()
-		a.killedTasks[task] = a.runningTasks[task]
-	}
-	a.isRunning = false
+	a.kill()
 	zap.S().Infof("Killed agent <ID: %s, Name: %s>", a.GetID(), a.GetName())
 }
 
+// kill stops the agent and kills its unfinished tasks, returning their
+// records as they were before the kill.
+func (a *Agent) kill() []AgentTaskRecord {
+	if !a.markStopped() {
+		return nil
+	}
+	a.cancelSchedules()
+	a.closeQueues(false)
+	killed := a.tasks.kill()
+	for _, record := range killed {
+		record.Task.Kill()
+		if record.State == AgentTaskStateRunning {
+			a.emitTaskEvent(AgentEventTaskKilled, record.Task, record.Task.GetAttempts(), ErrTaskKilled)
+			continue
+		}
+		record.Task.abandon(ErrTaskKilled)
+		a.emitTaskEvent(AgentEventTaskKilled, record.Task, 0, ErrTaskKilled)
+	}
+	a.emitAgentEvent(AgentEventAgentKilled)
+	return killed
+}
+
 type AgentTaskType struct {
 	Type         string
 	IsSequential bool
@@ -373,6 +613,26 @@ func NewAgentTaskType(taskType string, isSequential bool) AgentTaskType {
 	}
 }
 
+// ErrTaskKilled is reported by a task that was killed before its handler
+// finished.
+var ErrTaskKilled = errors.New("agent task was killed")
+
+// AgentTaskPanicError is reported by a task whose handler panicked.
+type AgentTaskPanicError struct {
+	Value interface{} // Value passed to panic
+	Stack []byte      // Stack trace of the panicking goroutine
+}
+
+func (e *AgentTaskPanicError) Error() string {
+	return fmt.Sprintf("agent task panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *AgentTaskPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
 type IAgentTask interface {
 	GetID() string
 	GetName() string
@@ -380,91 +640,397 @@ type IAgentTask interface {
 	IsSequential() bool
 	WasKilled() bool
 	GetResult() interface{}
-	Execute(callback func())
+	GetError() error
+	GetPayload() interface{}
+	GetPriority() AgentTaskPriority
+	GetRetryPolicy() *RetryPolicy
+	GetAttempts() int
+	GetAttemptErrors() []error
+	GetParentID() string
+	GetSubTasks() []IAgentTask
+	GetProgress() AgentTaskProgress
+	Execute(ctx context.Context, callback func())
 	Kill()
-	AwaitCompletion() interface{}
+	Done() <-chan struct{}
 	IsCompleted() bool
+	execute(ctx context.Context, policy *RetryPolicy, onRetry func(attempt int, err error), callback func())
+	abandon(err error)
+	addSubTask(child IAgentTask) error
+	setParentID(parentID string)
+	updateProgress(fn func(progress *AgentTaskProgress)) AgentTaskProgress
 }
 
-type HandlerFunction func(kill chan bool) interface{}
-type AgentTask struct {
+// HandlerFunction is the work performed by an AgentTask. The context is
+// cancelled when the task is killed, when its owning agent is killed, or when
+// the task's timeout or deadline passes, so handlers should pass it on to any
+// blocking calls they make.
+type HandlerFunction[T any] func(ctx context.Context) (T, error)
+
+// AgentTask is a unit of work run by an Agent. It doubles as a future for the
+// handler's typed result, which can be waited on with Await.
+type AgentTask[T any] struct {
 	IAgentTask
-	id                string
-	name              string
-	_type             AgentTaskType
-	completionChannel chan interface{}
-	result            interface{}
-	isCompleted       bool
-	wasKilled         bool
-	handler           HandlerFunction
-	killChannel       chan bool
-}
-
-func NewAgentTask(name string, taskType AgentTaskType, handler HandlerFunction) *AgentTask {
+	id            string
+	name          string
+	_type         AgentTaskType
+	result        T
+	err           error
+	isCompleted   bool
+	wasKilled     bool
+	handler       HandlerFunction[T]
+	timeout       time.Duration      // Maximum run time per attempt, zero for no timeout
+	deadline      time.Time          // Absolute deadline, zero for no deadline
+	payload       interface{}        // Serialisable input recorded in the agent journal
+	priority      AgentTaskPriority  // Queue priority, AgentTaskPriorityNormal by default
+	retryPolicy   *RetryPolicy       // Retry policy, nil to use the task type's
+	attempts      int                // Number of handler attempts so far
+	attemptErrors []error            // Errors of failed handler attempts
+	parentID      string             // ID of the task that spawned this one
+	subTasks      []IAgentTask       // Tasks spawned by this one, killed along with it
+	progress      AgentTaskProgress  // Latest progress reported by the handler
+	cancel        context.CancelFunc // Cancels the running handler's context
+	done          chan struct{}      // Closed once the task completes
+	mutex         sync.Mutex
+}
+
+func NewAgentTask[T any](name string, taskType AgentTaskType, handler HandlerFunction[T]) *AgentTask[T] {
 	id := generateUUID()
-	var result interface{}
-	completionChannel := make(chan interface{}, 1)
-	killChannel := make(chan bool, 1)
 	isCompleted := false
 	wasKilled := false
-	return &AgentTask{
-		id:                id,
-		name:              name,
-		_type:             taskType,
-		completionChannel: completionChannel,
-		result:            result,
-		killChannel:       killChannel,
-		isCompleted:       isCompleted,
-		wasKilled:         wasKilled,
-		handler:           handler,
+	return &AgentTask[T]{
+		id:          id,
+		name:        name,
+		_type:       taskType,
+		isCompleted: isCompleted,
+		wasKilled:   wasKilled,
+		handler:     handler,
+		done:        make(chan struct{}),
 	}
 }
 
-func (t *AgentTask) GetID() string {
+func (t *AgentTask[T]) GetID() string {
 	return t.id
 }
 
-func (t *AgentTask) GetName() string {
+func (t *AgentTask[T]) GetName() string {
 	return t.name
 }
 
-func (t *AgentTask) GetType() AgentTaskType {
+func (t *AgentTask[T]) GetType() AgentTaskType {
 	return t._type
 }
 
-func (t *AgentTask) IsSequential() bool {
+func (t *AgentTask[T]) IsSequential() bool {
 	return t._type.IsSequential
 }
 
-func (t *AgentTask) GetResult() interface{} {
-	return t.result
+// GetResult returns the handler's result as an untyped value. Use Result or
+// Await for the typed result.
+func (t *AgentTask[T]) GetResult() interface{} {
+	result, _ := t.Result()
+	return result
 }
 
-func (t *AgentTask) Execute(callback func()) {
-	result := t.handler(t.killChannel)
+// GetError returns the error reported by the task, if any.
+func (t *AgentTask[T]) GetError() error {
+	_, err := t.Result()
+	return err
+}
+
+// Result returns the task's typed result and error without waiting. Both are
+// zero values until the task completes.
+func (t *AgentTask[T]) Result() (T, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.result, t.err
+}
+
+// SetTimeout limits how long each attempt of the task's handler may run. A
+// zero duration disables the timeout.
+func (t *AgentTask[T]) SetTimeout(timeout time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.timeout = timeout
+}
+
+func (t *AgentTask[T]) GetTimeout() time.Duration {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.timeout
+}
+
+// SetDeadline sets an absolute time after which the task's context is
+// cancelled. A zero time disables the deadline.
+func (t *AgentTask[T]) SetDeadline(deadline time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.deadline = deadline
+}
+
+func (t *AgentTask[T]) GetDeadline() time.Time {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.deadline
+}
+
+// buildContext derives the task context from the agent context, applying
+// the task's deadline. A task killed before it starts gets an already
+// cancelled context.
+func (t *AgentTask[T]) buildContext(parent context.Context) (context.Context, context.CancelFunc) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.deadline.IsZero() {
+		ctx, cancel = context.WithCancel(parent)
+	} else {
+		ctx, cancel = context.WithDeadline(parent, t.deadline)
+	}
+	if t.wasKilled {
+		cancel()
+	}
+	t.cancel = cancel
+	return ctx, cancel
+}
+
+// buildAttemptContext derives the context for a single handler attempt,
+// applying the task's timeout.
+func (t *AgentTask[T]) buildAttemptContext(parent context.Context) (context.Context, context.CancelFunc) {
+	timeout := t.GetTimeout()
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, timeout)
+}
+
+// Execute runs the task handler with a context derived from ctx, retrying it
+// according to the task's retry policy, calls callback once the handler is
+// done and then resolves the task.
+func (t *AgentTask[T]) Execute(ctx context.Context, callback func()) {
+	t.execute(ctx, t.GetRetryPolicy(), nil, callback)
+}
+
+// execute is Execute with an explicit retry policy, which lets the agent
+// apply the policy of the task's type. onRetry, if set, is called before
+// every attempt after the first with the error of the previous one.
+func (t *AgentTask[T]) execute(ctx context.Context, policy *RetryPolicy, onRetry func(attempt int, err error), callback func()) {
+	taskCtx, cancel := t.buildContext(ctx)
+	defer cancel()
+	var result T
+	var lastErr error
+	attempt := 0
+	_, err := policy.Do(taskCtx, func(ctx context.Context) error {
+		attempt++
+		if attempt > 1 && onRetry != nil {
+			onRetry(attempt, lastErr)
+		}
+		attemptCtx, cancelAttempt := t.buildAttemptContext(ctx)
+		defer cancelAttempt()
+		var err error
+		result, err = t.runHandler(attemptCtx)
+		t.recordAttempt(err)
+		lastErr = err
+		return err
+	})
+	t.mutex.Lock()
+	if t.wasKilled {
+		err = ErrTaskKilled
+	}
 	t.result = result
+	t.err = err
+	t.mutex.Unlock()
 	callback()
+	t.mutex.Lock()
+	wasCompleted := t.isCompleted
 	t.isCompleted = true
+	t.mutex.Unlock()
+	if !wasCompleted {
+		close(t.done)
+	}
+}
+
+// runHandler calls the task's handler, turning a panic into an
+// *AgentTaskPanicError so it fails the task instead of the process.
+func (t *AgentTask[T]) runHandler(ctx context.Context) (result T, err error) {
+	defer func() {
+		if value := recover(); value != nil {
+			stack := debug.Stack()
+			zap.S().Errorf("Task <ID: %s, Name: %s> panicked: %v\n%s", t.GetID(), t.GetName(), value, stack)
+			var zero T
+			result = zero
+			err = &AgentTaskPanicError{Value: value, Stack: stack}
+		}
+	}()
+	return t.handler(ctx)
 }
 
-func (t *AgentTask) Kill() {
-	for len(t.killChannel) > 0 {
-		<-t.killChannel
+func (t *AgentTask[T]) recordAttempt(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.attempts++
+	if err != nil {
+		t.attemptErrors = append(t.attemptErrors, err)
 	}
-	t.killChannel <- true
+}
+
+// SetPriority sets the priority the task is queued with. It has no effect on
+// a task that was already added to an agent.
+func (t *AgentTask[T]) SetPriority(priority AgentTaskPriority) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.priority = priority
+}
+
+func (t *AgentTask[T]) GetPriority() AgentTaskPriority {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.priority
+}
+
+// SetPayload attaches a serialisable description of the task's input. It is
+// recorded in the agent journal so the task can be rebuilt after a crash.
+func (t *AgentTask[T]) SetPayload(payload interface{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.payload = payload
+}
+
+func (t *AgentTask[T]) GetPayload() interface{} {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.payload
+}
+
+// SetRetryPolicy sets the policy used to retry the task's handler when it
+// fails. It overrides the retry policy of the task's type.
+func (t *AgentTask[T]) SetRetryPolicy(policy *RetryPolicy) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.retryPolicy = policy
+}
+
+func (t *AgentTask[T]) GetRetryPolicy() *RetryPolicy {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.retryPolicy
+}
+
+// GetAttempts returns how many times the task's handler has been run.
+func (t *AgentTask[T]) GetAttempts() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.attempts
+}
+
+// GetAttemptErrors returns the error of every failed handler attempt, oldest
+// first.
+func (t *AgentTask[T]) GetAttemptErrors() []error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]error{}, t.attemptErrors...)
+}
+
+// GetProgress returns the latest progress reported by the task's handler
+// through its ProgressReporter.
+func (t *AgentTask[T]) GetProgress() AgentTaskProgress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.progress
+}
+
+func (t *AgentTask[T]) updateProgress(fn func(progress *AgentTaskProgress)) AgentTaskProgress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	fn(&t.progress)
+	return t.progress
+}
+
+// GetParentID returns the ID of the task that spawned this one, or an empty
+// string for top-level tasks.
+func (t *AgentTask[T]) GetParentID() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.parentID
+}
+
+// GetSubTasks returns the tasks spawned by this one, oldest first.
+func (t *AgentTask[T]) GetSubTasks() []IAgentTask {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]IAgentTask{}, t.subTasks...)
+}
+
+func (t *AgentTask[T]) setParentID(parentID string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.parentID = parentID
+}
+
+// addSubTask links child to the task so that killing the task kills child.
+// It fails if the task was already killed.
+func (t *AgentTask[T]) addSubTask(child IAgentTask) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.wasKilled {
+		return fmt.Errorf("%w: cannot spawn sub-task of task <ID: %s, Name: %s>", ErrTaskKilled, t.GetID(), t.GetName())
+	}
+	t.subTasks = append(t.subTasks, child)
+	return nil
+}
+
+// abandon resolves a task that will never be executed with err.
+func (t *AgentTask[T]) abandon(err error) {
+	t.mutex.Lock()
+	if t.isCompleted {
+		t.mutex.Unlock()
+		return
+	}
+	t.err = err
+	t.isCompleted = true
+	t.mutex.Unlock()
+	close(t.done)
+}
+
+// Kill cancels the task's context and kills its sub-tasks. If the task has
+// not started yet, its handler will start with an already cancelled context.
+func (t *AgentTask[T]) Kill() {
+	t.mutex.Lock()
 	t.wasKilled = true
+	if t.cancel != nil {
+		t.cancel()
+	}
+	subTasks := append([]IAgentTask{}, t.subTasks...)
+	t.mutex.Unlock()
+	for _, subTask := range subTasks {
+		subTask.Kill()
+	}
+}
+
+// Done returns a channel that is closed once the task completes.
+func (t *AgentTask[T]) Done() <-chan struct{} {
+	return t.done
 }
 
-func (t *AgentTask) AwaitCompletion() interface{} {
-	for !t.IsCompleted() {
+// Await blocks until the task completes or ctx is done and returns the task's
+// typed result and error.
+func (t *AgentTask[T]) Await(ctx context.Context) (T, error) {
+	select {
+	case <-t.done:
+		return t.Result()
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
 	}
-	return t.GetResult()
 }
 
-func (t *AgentTask) IsCompleted() bool {
+func (t *AgentTask[T]) IsCompleted() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.isCompleted
 }
 
-func (t *AgentTask) WasKilled() bool {
+func (t *AgentTask[T]) WasKilled() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 	return t.wasKilled
 }
@@ -1,25 +1,31 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 var (
 	// Test task handler functions
-	testTaskHandlerWaitKill = func(kill chan bool) interface{} {
-		<-kill
-		return "CSX Labs, Launching ideas into cyberspace. ;)"
+	testTaskHandlerWaitKill = func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "CSX Labs, Launching ideas into cyberspace. ;)", nil
 	}
-	testTaskHandlerWithResult = func(kill chan bool) interface{} {
-		return "test"
+	testTaskHandlerWithResult = func(ctx context.Context) (string, error) {
+		return "test", nil
 	}
-	testTaskHandlerWaitForever = func(kill chan bool) interface{} {
+	testTaskHandlerWithError = func(ctx context.Context) (string, error) {
+		return "", errors.New("test error")
+	}
+	testTaskHandlerWaitForever = func(ctx context.Context) (string, error) {
 		mischeviousChannel := make(chan bool)
 		<-mischeviousChannel
-		return "This will never be returned :("
+		return "This will never be returned :(", nil
 	}
 	// Test task types
 	testTaskType = AgentTaskType{
@@ -94,7 +100,8 @@ func TestTaskWithResult(t *testing.T) {
 	task := NewAgentTask("test", testTaskType, testTaskHandlerWithResult)
 	err := agent.AddTask(task)
 	assert.Nil(t, err)
-	result := task.AwaitCompletion()
+	result, err := task.Await(context.Background())
+	assert.Nil(t, err)
 	assert.Equal(t, "test", result)
 	assert.True(t, task.IsCompleted())
 }
@@ -106,7 +113,8 @@ func TestSequentialTaskWithResult(t *testing.T) {
 	task := NewAgentTask("test", testSequentialTaskType, testTaskHandlerWithResult)
 	err := agent.AddTask(task)
 	assert.Nil(t, err)
-	result := task.AwaitCompletion()
+	result, err := task.Await(context.Background())
+	assert.Nil(t, err)
 	assert.Equal(t, "test", result)
 	assert.True(t, task.IsCompleted())
 }
@@ -225,12 +233,12 @@ func TestSequentialTaskCompletionOrder(t *testing.T) {
 	defer agent.Kill()
 	handlerSignal := make(chan bool)
 	testerSignal := make(chan bool)
-	testHandler := func(kill chan bool) interface{} {
+	testHandler := func(ctx context.Context) (interface{}, error) {
 		handlerSignal <- true
 		<-testerSignal
-		return nil
+		return nil, nil
 	}
-	var taskList []*AgentTask
+	var taskList []*AgentTask[interface{}]
 	for i := 0; i < 10; i++ {
 		taskName := fmt.Sprintf("task#%d", i)
 		task := NewAgentTask(taskName, testSequentialTaskType, testHandler)
@@ -243,9 +251,233 @@ func TestSequentialTaskCompletionOrder(t *testing.T) {
 		_, inRunningTasks := agent.GetRunningTasks()[task.GetID()]
 		assert.Truef(t, inRunningTasks, "Task <ID: %s, Name: %s> not in running tasks <%s>", task.GetID(), task.GetName(), fmt.Sprint(agent.GetRunningTasks()))
 		testerSignal <- true
-		task.AwaitCompletion()
+		<-task.Done()
 		assert.True(t, task.IsCompleted())
 		_, inCompletedTasks := agent.GetCompletedTasks()[task.GetID()]
 		assert.True(t, inCompletedTasks)
 	}
 }
+
+func TestTaskWithError(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testTaskType, testTaskHandlerWithError)
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	result, err := task.Await(context.Background())
+	assert.EqualError(t, err, "test error")
+	assert.Equal(t, "", result)
+	assert.Equal(t, err, task.GetError())
+}
+
+func TestAwaitWithCanceledContext(t *testing.T) {
+	task := NewAgentTask("test", testTaskType, testTaskHandlerWithResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := task.Await(ctx)
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, task.IsCompleted())
+}
+
+func TestTaskWithKillCancelsContext(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	cause := make(chan error, 1)
+	task := NewAgentTask("test", testTaskType, func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		cause <- ctx.Err()
+		return "", ctx.Err()
+	})
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	task.Kill()
+	_, err = task.Await(context.Background())
+	assert.Equal(t, ErrTaskKilled, err)
+	assert.Equal(t, context.Canceled, <-cause)
+}
+
+func TestTaskWithTimeout(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testTaskType, func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	task.SetTimeout(10 * time.Millisecond)
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	_, err = task.Await(context.Background())
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, task.WasKilled())
+}
+
+func TestTaskWithDeadline(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	task.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	_, err = task.Await(context.Background())
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestKillCancelsRunningTasks(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	started := make(chan bool)
+	cause := make(chan error, 1)
+	task := NewAgentTask("test", testTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-ctx.Done()
+		cause <- ctx.Err()
+		return "", ctx.Err()
+	})
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	<-started
+	agent.Kill()
+	_, err = task.Await(context.Background())
+	assert.Equal(t, ErrTaskKilled, err)
+	assert.Equal(t, context.Canceled, <-cause)
+	assert.True(t, task.WasKilled())
+}
+
+func TestShutdownDrainsQueuedTasks(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetMaxWorkers(1)
+	agent.Start()
+	var tasks []*AgentTask[string]
+	for i := 0; i < 5; i++ {
+		for _, taskType := range []AgentTaskType{testTaskType, testSequentialTaskType} {
+			task := NewAgentTask(fmt.Sprintf("task#%d", i), taskType, func(ctx context.Context) (string, error) {
+				time.Sleep(time.Millisecond)
+				return "test", nil
+			})
+			assert.Nil(t, agent.AddTask(task))
+			tasks = append(tasks, task)
+		}
+	}
+	report, err := agent.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Abandoned))
+	assert.False(t, agent.IsRunning())
+	for _, task := range tasks {
+		assert.True(t, task.IsCompleted())
+		assert.Nil(t, task.GetError())
+	}
+}
+
+func TestShutdownDeadlineAbandonsTasks(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	started := make(chan bool)
+	runningTask := NewAgentTask("running", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	queuedTask := NewAgentTask("queued", testSequentialTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(runningTask))
+	assert.Nil(t, agent.AddTask(queuedTask))
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	report, err := agent.Shutdown(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, agent.IsRunning())
+	assert.Equal(t, 2, len(report.Abandoned))
+	assert.Equal(t, runningTask.GetID(), report.Abandoned[0].ID)
+	assert.Equal(t, AgentTaskStateRunning, report.Abandoned[0].State)
+	assert.Equal(t, queuedTask.GetID(), report.Abandoned[1].ID)
+	assert.Equal(t, AgentTaskStateQueued, report.Abandoned[1].State)
+	for _, task := range []*AgentTask[string]{runningTask, queuedTask} {
+		_, err := task.Await(context.Background())
+		assert.Equal(t, ErrTaskKilled, err)
+	}
+}
+
+func TestShutdownWhenNotRunning(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	report, err := agent.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Abandoned))
+}
+
+func TestAddTaskAfterStop(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	agent.Stop()
+	for _, taskType := range []AgentTaskType{testTaskType, testSequentialTaskType} {
+		task := NewAgentTask("test", taskType, testTaskHandlerWithResult)
+		err := agent.AddTask(task)
+		assert.True(t, errors.Is(err, ErrAgentStopped))
+		_, exists := agent.GetTask(task.GetID())
+		assert.False(t, exists)
+	}
+	agent.Start()
+	defer agent.Kill()
+	for _, taskType := range []AgentTaskType{testTaskType, testSequentialTaskType} {
+		task := NewAgentTask("test", taskType, testTaskHandlerWithResult)
+		assert.Nil(t, agent.AddTask(task))
+		_, err := task.Await(context.Background())
+		assert.Nil(t, err)
+	}
+}
+
+func TestAddTaskTwice(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	for _, taskType := range []AgentTaskType{testTaskType, testSequentialTaskType} {
+		task := NewAgentTask("test", taskType, testTaskHandlerWithResult)
+		assert.Nil(t, agent.AddTask(task))
+		_, err := task.Await(context.Background())
+		assert.Nil(t, err)
+		err = agent.AddTask(task)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "on agent <ID: "+agent.GetID()+">")
+	}
+}
+
+func TestRestartAfterKill(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	agent.Kill()
+	assert.True(t, errors.Is(agent.AddTask(NewAgentTask("test", testTaskType, testTaskHandlerWithResult)), ErrAgentStopped))
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testSequentialTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(task))
+	_, err := task.Await(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestKillStopsEveryTaskLoop(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	for i := 0; i < 3; i++ {
+		taskType := NewAgentTaskType(fmt.Sprintf("sequential#%d", i), true)
+		task := NewAgentTask("test", taskType, testTaskHandlerWithResult)
+		assert.Nil(t, agent.AddTask(task))
+		<-task.Done()
+	}
+	agent.Kill()
+	stopped := make(chan bool)
+	go func() {
+		agent.taskLoopRoutines.Wait()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("task loops did not exit after Kill")
+	}
+}
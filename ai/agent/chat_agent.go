@@ -1,11 +1,15 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/openai"
+	"github.com/CSXL/solus/ai/providers"
 	"go.uber.org/zap"
 )
 
@@ -19,17 +23,31 @@ const (
 	ChatAgentMessageRoleUser      ChatAgentMessageRole = "user"
 	ChatAgentMessageRoleAssistant ChatAgentMessageRole = "assistant"
 	ChatAgentMessageRoleSystem    ChatAgentMessageRole = "system"
+	ChatAgentMessageRoleFunction  ChatAgentMessageRole = "function"
 	// ChatAgent Message Types
 	ChatAgentMessageTypeText  ChatAgentMessageType = "text"
 	ChatAgentMessageTypeFile  ChatAgentMessageType = "file"
 	ChatAgentMessageTypeLink  ChatAgentMessageType = "link"
 	ChatAgentMessageTypeQuery ChatAgentMessageType = "query"
+	// Tool calls made by the assistant and their results, see ToolRegistry.
+	ChatAgentMessageTypeToolCall   ChatAgentMessageType = "tool_call"
+	ChatAgentMessageTypeToolResult ChatAgentMessageType = "tool_result"
 )
 
 type ChatAgentMessage struct {
-	Type    ChatAgentMessageType
-	Role    ChatAgentMessageRole
-	Content string
+	Type     ChatAgentMessageType
+	Role     ChatAgentMessageRole
+	Content  string
+	ToolCall *ChatAgentToolCall `json:",omitempty"` // Set on tool call messages
+	ToolName string             `json:",omitempty"` // Set on tool result messages
+	Metadata ai.MessageMetadata // ID and creation time, and for responses how they were completed
+}
+
+// ChatAgentToolCall is a request from the assistant to call a registered
+// tool.
+type ChatAgentToolCall struct {
+	Name      string
+	Arguments string // JSON object matching the tool's parameters
 }
 
 // NewChatAgentMessage creates a new ChatAgentMessage. This message can be sent
@@ -49,12 +67,34 @@ type ChatAgentMessage struct {
 // content: The content of the message. The content can only be a string.
 func NewChatAgentMessage(msgType ChatAgentMessageType, role ChatAgentMessageRole, content string) *ChatAgentMessage {
 	return &ChatAgentMessage{
-		Type:    msgType,
-		Role:    role,
-		Content: content,
+		Type:     msgType,
+		Role:     role,
+		Content:  content,
+		Metadata: ai.NewMessageMetadata(),
+	}
+}
+
+// NewChatAgentToolResultMessage creates the message sending the result of a
+// call to the tool named toolName back to the assistant.
+func NewChatAgentToolResultMessage(toolName string, result string) *ChatAgentMessage {
+	return &ChatAgentMessage{
+		Type:     ChatAgentMessageTypeToolResult,
+		Role:     ChatAgentMessageRoleFunction,
+		Content:  result,
+		ToolName: toolName,
+		Metadata: ai.NewMessageMetadata(),
 	}
 }
 
+// GetID returns the ID of the message, which it keeps when saved and loaded.
+func (c *ChatAgentMessage) GetID() string {
+	return c.Metadata.ID
+}
+
+func (c *ChatAgentMessage) GetMetadata() ai.MessageMetadata {
+	return c.Metadata
+}
+
 func (c *ChatAgentMessage) GetType() ChatAgentMessageType {
 	return c.Type
 }
@@ -87,6 +127,20 @@ func (c *ChatAgentMessage) IsQueryMessage() bool {
 	return c.IsMessageOfType(ChatAgentMessageTypeQuery)
 }
 
+func (c *ChatAgentMessage) IsToolCallMessage() bool {
+	return c.IsMessageOfType(ChatAgentMessageTypeToolCall) && c.ToolCall != nil
+}
+
+func (c *ChatAgentMessage) IsToolResultMessage() bool {
+	return c.IsMessageOfType(ChatAgentMessageTypeToolResult)
+}
+
+// isToolMessage reports whether the message is part of a tool call, which is
+// sent as is rather than in the {"type", "content"} schema.
+func (c *ChatAgentMessage) isToolMessage() bool {
+	return c.IsToolCallMessage() || c.IsToolResultMessage()
+}
+
 func (c *ChatAgentMessage) IsMessageOfRole(role ChatAgentMessageRole) bool {
 	return c.Role == role
 }
@@ -120,6 +174,9 @@ func (c *ChatAgentMessage) mutateContentFromNonJSONMessage() error {
 }
 
 func (c *ChatAgentMessage) Serialize() {
+	if c.isToolMessage() {
+		return
+	}
 	msgContent, err := chatAgentMessageContentFromJSON(c.GetContent())
 	if err != nil {
 		// We don't need to handle this error because we can just assume that the
@@ -132,15 +189,27 @@ func (c *ChatAgentMessage) Serialize() {
 }
 
 func (c *ChatAgentMessage) Marshal() error {
+	if c.isToolMessage() {
+		return nil
+	}
 	c.Serialize()
 	return c.mutateContentFromNonJSONMessage()
 }
 
 func (c *ChatAgentMessage) ToOpenAIChatMessage() *openai.ChatMessage {
-	return &openai.ChatMessage{
-		Role:    string(c.Role),
-		Content: c.Content,
+	openaiMessage := &openai.ChatMessage{
+		Role:     string(c.Role),
+		Content:  c.Content,
+		Name:     c.ToolName,
+		Metadata: c.Metadata,
+	}
+	if c.ToolCall != nil {
+		openaiMessage.FunctionCall = &openai.ChatFunctionCall{
+			Name:      c.ToolCall.Name,
+			Arguments: c.ToolCall.Arguments,
+		}
 	}
+	return openaiMessage
 }
 
 func ChatAgentMessageFromJSON(jsonStr string) (*ChatAgentMessage, error) {
@@ -150,7 +219,23 @@ func ChatAgentMessageFromJSON(jsonStr string) (*ChatAgentMessage, error) {
 }
 
 func ChatAgentMessageFromOpenAIChatMessage(c openai.ChatMessage) *ChatAgentMessage {
-	return NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRole(c.Role), c.Content)
+	var msg *ChatAgentMessage
+	switch {
+	case c.FunctionCall != nil:
+		msg = NewChatAgentMessage(ChatAgentMessageTypeToolCall, ChatAgentMessageRole(c.Role), c.Content)
+		msg.ToolCall = &ChatAgentToolCall{
+			Name:      c.FunctionCall.Name,
+			Arguments: c.FunctionCall.Arguments,
+		}
+	case c.Role == string(ChatAgentMessageRoleFunction):
+		msg = NewChatAgentToolResultMessage(c.Name, c.Content)
+	default:
+		msg = NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRole(c.Role), c.Content)
+	}
+	if c.Metadata.ID != "" {
+		msg.Metadata = c.Metadata
+	}
+	return msg
 }
 
 type chatAgentMessageContent struct {
@@ -188,38 +273,101 @@ func chatAgentMessageContentFromChatAgentMessage(msg ChatAgentMessage) *chatAgen
 
 type ChatAgent struct {
 	*Agent
-	OpenAIChatClient *openai.ChatClient
-	Messages         []ChatAgentMessage
+	OpenAIChatClient  *openai.ChatClient
+	Messages          []ChatAgentMessage // Guarded by messagesMutex, read with GetMessages
+	messagesMutex     sync.Mutex
+	tools             *ToolRegistry
+	maxToolIterations int
+	validator         ResponseValidator
+	maxRepairAttempts int
+	toolsMutex        sync.Mutex // Guards the tool and validation settings
 }
 
 // NewChatAgent creates a new ChatAgent. The ChatAgent can be used to hold a
 // conversation between a language model (currently using OpenAI's APIs), and
 // the user.
 //
+// Rate limited and otherwise transient OpenAI failures are retried with
+// exponential backoff, see SetTaskTypeRetryPolicy to change the policy.
+//
 // Remember to call the Start() method on the ChatAgent!
 func NewChatAgent(name string, config *ai.AIConfig) *ChatAgent {
-	return &ChatAgent{
-		Agent:            NewAgent(name, ChatAgentType, config),
-		OpenAIChatClient: openai.NewChatClient(config.OpenAIAPIKey),
-		Messages:         []ChatAgentMessage{},
+	chatAgent := &ChatAgent{
+		Agent:             NewAgent(name, ChatAgentType, config),
+		OpenAIChatClient:  openai.NewChatClientWithProvider(providers.New(config)),
+		Messages:          []ChatAgentMessage{},
+		maxToolIterations: DefaultMaxToolIterations,
+		maxRepairAttempts: DefaultMaxRepairAttempts,
 	}
+	chatAgent.OpenAIChatClient.SetModelOptions(config.ModelOptions)
+	chatAgent.SetTaskTypeRetryPolicy(NewChatAgentTaskType(ChatAgentTaskTypeSendMessage), NewChatAgentRetryPolicy())
+	return chatAgent
+}
+
+// NewChatAgentRetryPolicy returns the default retry policy for requests sent
+// by a ChatAgent, which only retries transient OpenAI errors.
+func NewChatAgentRetryPolicy() *RetryPolicy {
+	return NewRetryPolicy(4, time.Second).WithRetryable(openai.IsRetryableError)
+}
+
+// SetModelOptions sets the model and sampling parameters of the agent's
+// completions, replacing those of the config it was created with.
+func (c *ChatAgent) SetModelOptions(options ai.ModelOptions) {
+	c.OpenAIChatClient.SetModelOptions(options)
+}
+
+func (c *ChatAgent) GetModelOptions() ai.ModelOptions {
+	return c.OpenAIChatClient.GetModelOptions()
+}
+
+// SetContextBudget sets the budget deciding which messages are sent when the
+// conversation outgrows the model's context window. The whole conversation is
+// kept either way. Nil sends every message. See openai.NewContextBudget for
+// the default.
+func (c *ChatAgent) SetContextBudget(budget *openai.ContextBudget) {
+	c.OpenAIChatClient.SetContextBudget(budget)
 }
 
 func (c *ChatAgent) AddMessage(msg ChatAgentMessage) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	msg.Metadata.EnsureID()
 	msg.Serialize()
 	c.Messages = append(c.Messages, msg)
 	c.syncMessages()
 }
 
+// GetMessages returns a copy of the agent's messages, safe to read while
+// messages are being sent.
 func (c *ChatAgent) GetMessages() []ChatAgentMessage {
-	return c.Messages
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	return append([]ChatAgentMessage{}, c.Messages...)
 }
 
 func (c *ChatAgent) SetMessages(msgs []ChatAgentMessage) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	msgs = append([]ChatAgentMessage{}, msgs...)
+	for i := range msgs {
+		msgs[i].Metadata.EnsureID()
+	}
 	c.Messages = msgs
 	c.syncMessages()
 }
 
+// replaceLastMessage replaces the last of the agent's messages with msg.
+func (c *ChatAgent) replaceLastMessage(msg ChatAgentMessage) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	if len(c.Messages) == 0 {
+		return
+	}
+	c.Messages[len(c.Messages)-1] = msg
+}
+
+// The following helpers must be called with messagesMutex held.
+
 func (c *ChatAgent) getMarshalledMessages() []ChatAgentMessage {
 	marshalledMessages := []ChatAgentMessage{}
 	for _, msg := range c.Messages {
@@ -253,11 +401,15 @@ func (c *ChatAgent) syncMessages() {
 }
 
 func (c *ChatAgent) ResetMessages() {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
 	c.Messages = []ChatAgentMessage{}
 	c.syncMessages()
 }
 
 func (c *ChatAgent) GetLastMessage() ChatAgentMessage {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
 	if len(c.Messages) == 0 {
 		return ChatAgentMessage{}
 	}
@@ -272,11 +424,11 @@ func (c *ChatAgent) GetLastMessageRole() ChatAgentMessageRole {
 	return c.GetLastMessage().Role
 }
 
-func (c *ChatAgent) sendMessageToAgent(msg ChatAgentMessage) (*ChatAgentTask, error) {
+func (c *ChatAgent) sendMessageToAgent(msg ChatAgentMessage, overrides ai.ModelOptions) (*ChatAgentTask, error) {
 	if !c.IsRunning() {
 		zap.S().Info("Note: Agent is not running, message will be queued but not sent.")
 	}
-	sendTask, err := NewChatAgentTask(c, ChatAgentTaskTypeSendMessage, msg)
+	sendTask, err := newChatAgentTask(c, ChatAgentTaskTypeSendMessage, msg, overrides)
 	if err != nil {
 		return nil, err
 	}
@@ -287,19 +439,18 @@ func (c *ChatAgent) sendMessageToAgent(msg ChatAgentMessage) (*ChatAgentTask, er
 	return sendTask, err
 }
 
-func (c *ChatAgent) sendMessage(msg ChatAgentMessage) (*ChatAgentMessage, error) {
-	messageTask, err := c.sendMessageToAgent(msg)
+func (c *ChatAgent) sendMessage(ctx context.Context, msg ChatAgentMessage, overrides ai.ModelOptions) (*ChatAgentMessage, error) {
+	messageTask, err := c.sendMessageToAgent(msg, overrides)
 	if err != nil {
 		return nil, err
 	}
-	messageTask.AwaitCompletion()
-	// Checks for error in result by attempting to type assert to ChatAgentMessage
-	// If the type assertion fails, the task failed and we return the error
-	_, ok := messageTask.GetResult().(*ChatAgentMessage)
-	if !ok {
-		return nil, messageTask.GetResult().(error)
+	aiResponseMessage, err := messageTask.Await(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			messageTask.Kill()
+		}
+		return nil, err
 	}
-	aiResponseMessage := messageTask.GetResult().(*ChatAgentMessage)
 	zap.S().Infof("Received chat message from ChatAgent <ID: %s, Name: %s>: %s", c.GetID(), c.GetName(), aiResponseMessage.Content)
 	return aiResponseMessage, nil
 }
@@ -313,19 +464,37 @@ func (c *ChatAgent) sendMessage(msg ChatAgentMessage) (*ChatAgentMessage, error)
 //	  "content": string // Your message content (e.g. "Hello", "https://example.com", "What is the weather like in 2023?")
 //	}
 func (c *ChatAgent) SendChatMessage(msg ChatAgentMessage) (*ChatAgentMessage, error) {
+	return c.SendChatMessageWithOptions(msg, ai.ModelOptions{})
+}
+
+// SendChatMessageWithOptions is SendChatMessage with the model options set in
+// overrides replacing the agent's for this message and its tool calls.
+func (c *ChatAgent) SendChatMessageWithOptions(msg ChatAgentMessage, overrides ai.ModelOptions) (*ChatAgentMessage, error) {
+	return c.sendChatMessage(context.Background(), msg, overrides)
+}
+
+// SendChatMessageWithContext is SendChatMessage, except that once ctx is done
+// it stops waiting for the response and kills the task sending the message,
+// which aborts its completion request.
+func (c *ChatAgent) SendChatMessageWithContext(ctx context.Context, msg ChatAgentMessage) (*ChatAgentMessage, error) {
+	return c.sendChatMessage(ctx, msg, ai.ModelOptions{})
+}
+
+func (c *ChatAgent) sendChatMessage(ctx context.Context, msg ChatAgentMessage, overrides ai.ModelOptions) (*ChatAgentMessage, error) {
+	err := overrides.Validate()
+	if err != nil {
+		return nil, err
+	}
 	zap.S().Infof("Sending chat message to ChatAgent <ID: %s, Name: %s>: %s", c.GetID(), c.GetName(), msg.Content)
 	// Ignoring error for tolerance of AI Messages.
 	// trunk-ignore(golangci-lint/errcheck)
 	msg.Marshal()
-	aiMessage, err := c.sendMessage(msg)
+	aiMessage, err := c.sendMessage(ctx, msg, overrides)
 	if err != nil {
 		return nil, err
 	}
 	processedAIMessage := c.ProcessChatMessage(*aiMessage)
-	if err != nil {
-		return nil, err
-	}
-	c.Messages[len(c.Messages)-1] = processedAIMessage
+	c.replaceLastMessage(processedAIMessage)
 	return &processedAIMessage, nil
 }
 
@@ -360,44 +529,95 @@ const (
 )
 
 type ChatAgentTask struct {
-	*AgentTask
+	*AgentTask[*ChatAgentMessage]
 }
 
-func NewChatAgentTask(agent *ChatAgent, taskType ChatAgentTaskType, payload ChatAgentTaskPayload) (*ChatAgentTask, error) {
+// NewChatAgentTaskType returns the AgentTaskType used for ChatAgent tasks of
+// the given type. ChatAgent tasks always run sequentially.
+func NewChatAgentTaskType(taskType ChatAgentTaskType) AgentTaskType {
 	isSequential := true
-	agentTaskType := NewAgentTaskType(string(taskType), isSequential)
-	handler, err := buildChatAgentHandler(agent, taskType, payload)
+	return NewAgentTaskType(string(taskType), isSequential)
+}
+
+func NewChatAgentTask(agent *ChatAgent, taskType ChatAgentTaskType, payload ChatAgentTaskPayload) (*ChatAgentTask, error) {
+	return newChatAgentTask(agent, taskType, payload, ai.ModelOptions{})
+}
+
+func newChatAgentTask(agent *ChatAgent, taskType ChatAgentTaskType, payload ChatAgentTaskPayload, overrides ai.ModelOptions) (*ChatAgentTask, error) {
+	agentTaskType := NewChatAgentTaskType(taskType)
+	handler, err := buildChatAgentHandler(agent, taskType, payload, overrides)
 	if err != nil {
 		return nil, err
 	}
-	return &ChatAgentTask{
+	task := &ChatAgentTask{
 		AgentTask: NewAgentTask(string(taskType), agentTaskType, handler),
-	}, nil
+	}
+	task.SetPayload(payload)
+	if msg, ok := payload.(ChatAgentMessage); ok {
+		setChatAgentTaskPriority(task, msg)
+	}
+	return task, nil
 }
 
-func buildChatAgentHandler(agent *ChatAgent, taskType ChatAgentTaskType, payload ChatAgentTaskPayload) (HandlerFunction, error) {
+func setChatAgentTaskPriority(task *ChatAgentTask, msg ChatAgentMessage) {
+	if msg.IsUserMessage() {
+		// A user is waiting on the response, so it goes ahead of background work.
+		task.SetPriority(AgentTaskPriorityHigh)
+	}
+}
+
+func buildChatAgentHandler(agent *ChatAgent, taskType ChatAgentTaskType, payload ChatAgentTaskPayload, overrides ai.ModelOptions) (HandlerFunction[*ChatAgentMessage], error) {
 	switch taskType {
 	case ChatAgentTaskTypeSendMessage:
 		msg := payload.(ChatAgentMessage)
-		return buildChatAgentMessageHandler(agent, msg), nil
+		return buildChatAgentMessageHandler(agent, msg, overrides), nil
 	default:
 		return nil, fmt.Errorf("unknown task type: %s", taskType)
 	}
 }
 
-func buildChatAgentMessageHandler(agent *ChatAgent, msg ChatAgentMessage) HandlerFunction {
-	return func(kill chan bool) interface{} {
+func buildChatAgentMessageHandler(agent *ChatAgent, msg ChatAgentMessage, overrides ai.ModelOptions) HandlerFunction[*ChatAgentMessage] {
+	return func(ctx context.Context) (*ChatAgentMessage, error) {
+		ctx = ai.ContextWithModelOptions(ctx, overrides)
+		progress := ProgressFromContext(ctx)
+		progress.Report(0, "waiting for completion", "")
+		previousMessages := agent.GetMessages()
+		agent.offerTools()
 		agent.AddMessage(msg)
-		err := agent.OpenAIChatClient.SendMessage(msg.Content, string(msg.Role))
+		err := agent.OpenAIChatClient.SendMessageWithContext(ctx, msg.Content, string(msg.Role))
+		complete := func() error {
+			return agent.OpenAIChatClient.RequestCompletion(ctx)
+		}
+		var serializedResponse *ChatAgentMessage
+		if err == nil {
+			serializedResponse, err = agent.resolveToolCalls(ctx, agent.recordResponse(), complete)
+		}
+		if err == nil {
+			serializedResponse, err = agent.repairResponse(ctx, serializedResponse, complete)
+		}
 		if err != nil {
-			return err
+			// Roll back so that a retry does not send the message twice.
+			agent.SetMessages(previousMessages)
+			return nil, err
 		}
-		openaiResponse := agent.OpenAIChatClient.GetLastMessage()
-		serializedResponse := ChatAgentMessageFromOpenAIChatMessage(openaiResponse)
-		processedResponse := agent.ProcessChatMessage(*serializedResponse)
-		agent.Messages = append(agent.Messages, processedResponse)
-		agent.syncMessages()
-		agent.serializeAllMessages()
-		return serializedResponse
+		progress.Report(100, "completed", "")
+		return serializedResponse, nil
 	}
 }
+
+// recordResponse adds the last message of the OpenAI chat client, the
+// response to a message just sent, to the agent's messages and returns it.
+func (c *ChatAgent) recordResponse() *ChatAgentMessage {
+	openaiResponse := c.OpenAIChatClient.GetLastMessage()
+	serializedResponse := ChatAgentMessageFromOpenAIChatMessage(openaiResponse)
+	if metadata := serializedResponse.Metadata; metadata.FinishReason == "length" {
+		zap.S().Warnf("Response %s of ChatAgent <ID: %s, Name: %s> was cut off at %d tokens by %s", metadata.ID, c.GetID(), c.GetName(), metadata.CompletionTokens, metadata.Model)
+	}
+	processedResponse := c.ProcessChatMessage(*serializedResponse)
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.Messages = append(c.Messages, processedResponse)
+	c.syncMessages()
+	c.serializeAllMessages()
+	return serializedResponse
+}
@@ -1,7 +1,11 @@
 package agent
 
 import (
+	"context"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/openai"
@@ -48,21 +52,32 @@ func TestChatAgentMessage_IsXRoleMessage(t *testing.T) {
 
 func TestChatAgentMessage_ToJSON(t *testing.T) {
 	chatAgentMessage := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	chatAgentMessage.Metadata = ai.MessageMetadata{ID: "test-id", CreatedAt: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)}
 	json, err := chatAgentMessage.ToJSON()
 	assert.Nil(t, err)
-	assert.Equal(t, `{"Type":"text","Role":"user","Content":"test-content"}`, json)
+	assert.Equal(t, `{"Type":"text","Role":"user","Content":"test-content","Metadata":{"ID":"test-id","CreatedAt":"2023-06-01T12:00:00Z"}}`, json)
 }
 
 func TestChatAgentMessage_FromJSON(t *testing.T) {
 	chatAgentMessage := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	chatAgentMessage.Metadata = ai.MessageMetadata{ID: "test-id", CreatedAt: time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)}
 	json, err := chatAgentMessage.ToJSON()
 	assert.Nil(t, err)
-	assert.Equal(t, `{"Type":"text","Role":"user","Content":"test-content"}`, json)
+	assert.Equal(t, `{"Type":"text","Role":"user","Content":"test-content","Metadata":{"ID":"test-id","CreatedAt":"2023-06-01T12:00:00Z"}}`, json)
 	chatAgentMessage, err = ChatAgentMessageFromJSON(json)
 	assert.Nil(t, err)
 	assert.Equal(t, ChatAgentMessageTypeText, chatAgentMessage.Type)
 	assert.Equal(t, ChatAgentMessageRoleUser, chatAgentMessage.Role)
 	assert.Equal(t, "test-content", chatAgentMessage.Content)
+	assert.Equal(t, "test-id", chatAgentMessage.GetID())
+}
+
+func TestNewChatAgentMessage_HasMetadata(t *testing.T) {
+	first := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	second := NewChatAgentToolResultMessage("web_search", "result")
+	assert.NotEmpty(t, first.GetID())
+	assert.NotEqual(t, first.GetID(), second.GetID())
+	assert.False(t, first.GetMetadata().CreatedAt.IsZero())
 }
 
 func TestNewChatAgentMessageContent(t *testing.T) {
@@ -108,6 +123,9 @@ func TestChatAgent_GetMessages(t *testing.T) {
 	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
 	chatAgent.AddMessage(*NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content"))
 	assert.Equal(t, 1, len(chatAgent.GetMessages()))
+	messages := chatAgent.GetMessages()
+	messages[0].Content = "changed-content"
+	assert.Equal(t, "test-content", chatAgent.GetMessages()[0].GetContent())
 }
 
 func TestChatAgent_SetMessages(t *testing.T) {
@@ -155,9 +173,11 @@ func TestChatAgent_SendMessageToAgent(t *testing.T) {
 	defer ts.Close()
 	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
 	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
-	messageTask, err := chatAgent.sendMessageToAgent(*msg)
+	messageTask, err := chatAgent.sendMessageToAgent(*msg, ai.ModelOptions{})
+	assert.Nil(t, err)
+	response, err := messageTask.Await(context.Background())
 	assert.Nil(t, err)
-	messageTask.AwaitCompletion()
+	assert.NotNil(t, response)
 	assert.Equal(t, 2, len(chatAgent.Messages))
 }
 
@@ -169,7 +189,7 @@ func TestChatAgent_SendMessage(t *testing.T) {
 	defer ts.Close()
 	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
 	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
-	aiResponse, err := chatAgent.sendMessage(*msg)
+	aiResponse, err := chatAgent.sendMessage(context.Background(), *msg, ai.ModelOptions{})
 	assert.Nil(t, err)
 	assert.NotNil(t, aiResponse)
 	assert.Equal(t, 2, len(chatAgent.Messages))
@@ -189,6 +209,20 @@ func TestChatAgent_SendChatMessage(t *testing.T) {
 	assert.Equal(t, 2, len(chatAgent.Messages))
 }
 
+func TestChatAgent_SendChatMessageToLocalProvider(t *testing.T) {
+	requests := make(chan []byte, 1)
+	ts := openai.StartSequenceHTTPTestServer(requests, openai.SampleChatCompletion)
+	defer ts.Close()
+	chatAgent := NewChatAgent("testAgent", ai.NewLocalAIConfig(ts.URL, "llama2"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.Nil(t, err)
+	assert.Contains(t, string(<-requests), `"model":"llama2"`)
+	assert.Equal(t, 2, len(chatAgent.Messages))
+}
+
 func TestChatAgent_ProcessChatMessage(t *testing.T) {
 	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
 	chatAgent.Start()
@@ -228,3 +262,63 @@ func TestChatAgent_SendChatMessageAndWriteResponseToChannel(t *testing.T) {
 	assert.NotNil(t, aiResponse)
 	assert.Equal(t, 2, len(chatAgent.Messages))
 }
+
+func TestChatAgent_SendChatMessageRetriesRateLimit(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	taskType := NewChatAgentTaskType(ChatAgentTaskTypeSendMessage)
+	assert.NotNil(t, chatAgent.GetTaskTypeRetryPolicy(taskType))
+	chatAgent.SetTaskTypeRetryPolicy(taskType, NewRetryPolicy(3, time.Millisecond).WithRetryable(openai.IsRetryableError))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartFlakyHTTPTestServer(2, http.StatusTooManyRequests, openai.SampleChatCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	aiResponse, err := chatAgent.SendChatMessage(*msg)
+	assert.Nil(t, err)
+	assert.NotNil(t, aiResponse)
+	assert.Equal(t, 2, len(chatAgent.Messages))
+}
+
+func TestChatAgent_SendChatMessageDoesNotRetryClientError(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartFlakyHTTPTestServer(1, http.StatusBadRequest, openai.SampleChatCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "test-content")
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(chatAgent.Messages))
+}
+
+func TestChatAgent_SendChatMessageFailsWhenContextIsExceeded(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	budget := openai.NewContextBudget()
+	budget.MaxContextTokens = 50
+	budget.CompletionTokens = 0
+	budget.Strategy = openai.ContextStrategyError
+	chatAgent.SetContextBudget(budget)
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartHTTPTestServer(openai.SampleChatCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, strings.Repeat("test-content ", 100))
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.ErrorIs(t, err, openai.ErrContextLengthExceeded)
+	assert.Equal(t, 0, len(chatAgent.Messages))
+}
+
+func TestNewChatAgentTask_UserMessagePriority(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	userMessage := *NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Hello")
+	userTask, err := NewChatAgentTask(chatAgent, ChatAgentTaskTypeSendMessage, userMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, AgentTaskPriorityHigh, userTask.GetPriority())
+	systemMessage := *NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleSystem, "Hello")
+	systemTask, err := NewChatAgentTask(chatAgent, ChatAgentTaskTypeSendMessage, systemMessage)
+	assert.Nil(t, err)
+	assert.Equal(t, AgentTaskPriorityNormal, systemTask.GetPriority())
+}
@@ -0,0 +1,324 @@
+package agent
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+
+	"github.com/CSXL/solus/ai"
+	"go.uber.org/zap"
+)
+
+// ChatAgentMessageDelta is a piece of a response streamed by a ChatAgent.
+//
+// Responses wrapped in the {"type", "content"} schema are unwrapped as they
+// arrive, so Delta and Content only ever hold message content. Retried
+// requests stream their response again from the start; renderers that draw
+// Content rather than appending Delta always show the right text.
+type ChatAgentMessageDelta struct {
+	Type    ChatAgentMessageType // Text until the response's type is received
+	Role    ChatAgentMessageRole
+	Delta   string // Content received since the previous delta
+	Content string // Content received so far
+}
+
+// StreamChatMessage is SendChatMessage with the response streamed: every
+// piece of content is written to deltas as it arrives. deltas is closed once
+// the response is complete or the request failed, so it can be ranged over
+// from another goroutine. The caller must keep reading from deltas until it
+// is closed.
+func (c *ChatAgent) StreamChatMessage(msg ChatAgentMessage, deltas chan<- ChatAgentMessageDelta) (*ChatAgentMessage, error) {
+	return c.StreamChatMessageWithOptions(msg, deltas, ai.ModelOptions{})
+}
+
+// StreamChatMessageWithOptions is StreamChatMessage with the model options
+// set in overrides replacing the agent's for this message.
+func (c *ChatAgent) StreamChatMessageWithOptions(msg ChatAgentMessage, deltas chan<- ChatAgentMessageDelta, overrides ai.ModelOptions) (*ChatAgentMessage, error) {
+	sink := &chatAgentDeltaSink{deltas: deltas}
+	defer sink.close()
+	err := overrides.Validate()
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("Streaming chat message to ChatAgent <ID: %s, Name: %s>: %s", c.GetID(), c.GetName(), msg.Content)
+	// Ignoring error for tolerance of AI Messages.
+	// trunk-ignore(golangci-lint/errcheck)
+	msg.Marshal()
+	if !c.IsRunning() {
+		zap.S().Info("Note: Agent is not running, message will be queued but not sent.")
+	}
+	streamTask := newChatAgentStreamTask(c, msg, sink, overrides)
+	err = c.AddTask(streamTask)
+	if err != nil {
+		return nil, err
+	}
+	aiMessage, err := streamTask.Await(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	zap.S().Infof("Received streamed chat message from ChatAgent <ID: %s, Name: %s>: %s", c.GetID(), c.GetName(), aiMessage.Content)
+	processedAIMessage := c.ProcessChatMessage(*aiMessage)
+	c.replaceLastMessage(processedAIMessage)
+	return &processedAIMessage, nil
+}
+
+// newChatAgentStreamTask creates the task streaming a message. It shares the
+// send_message task type so that streamed and regular messages are sent one
+// at a time, in order, and are retried and resumed the same way.
+func newChatAgentStreamTask(agent *ChatAgent, msg ChatAgentMessage, sink *chatAgentDeltaSink, overrides ai.ModelOptions) *ChatAgentTask {
+	task := &ChatAgentTask{
+		AgentTask: NewAgentTask("stream_message", NewChatAgentTaskType(ChatAgentTaskTypeSendMessage), buildChatAgentStreamHandler(agent, msg, sink, overrides)),
+	}
+	task.SetPayload(msg)
+	setChatAgentTaskPriority(task, msg)
+	return task
+}
+
+func buildChatAgentStreamHandler(agent *ChatAgent, msg ChatAgentMessage, sink *chatAgentDeltaSink, overrides ai.ModelOptions) HandlerFunction[*ChatAgentMessage] {
+	return func(ctx context.Context) (*ChatAgentMessage, error) {
+		ctx = ai.ContextWithModelOptions(ctx, overrides)
+		progress := ProgressFromContext(ctx)
+		progress.Report(0, "streaming", "")
+		previousMessages := agent.GetMessages()
+		agent.offerTools()
+		agent.AddMessage(msg)
+		stream := &chatAgentMessageStream{role: ChatAgentMessageRoleAssistant}
+		onChunk := func(chunk string) {
+			if delta, ok := stream.write(chunk); ok {
+				sink.send(ctx, delta)
+			}
+		}
+		err := agent.OpenAIChatClient.SendMessageStream(ctx, msg.Content, string(msg.Role), onChunk)
+		complete := func() error {
+			return agent.OpenAIChatClient.RequestCompletionStream(ctx, onChunk)
+		}
+		var response *ChatAgentMessage
+		if err == nil {
+			response, err = agent.resolveToolCalls(ctx, agent.recordResponse(), complete)
+		}
+		if err == nil {
+			// A repaired response is streamed again from the start, like the
+			// response of a retried request.
+			response, err = agent.repairResponse(ctx, response, func() error {
+				stream = &chatAgentMessageStream{role: ChatAgentMessageRoleAssistant}
+				return complete()
+			})
+		}
+		if err != nil {
+			// Roll back so that a retry does not send the message twice.
+			agent.SetMessages(previousMessages)
+			return nil, err
+		}
+		if delta, ok := stream.finish(agent.ProcessChatMessage(*response)); ok {
+			sink.send(ctx, delta)
+		}
+		progress.Report(100, "completed", "")
+		return response, nil
+	}
+}
+
+// chatAgentDeltaSink forwards deltas to a caller's channel until it is
+// closed. A killed task's handler may still be running when the stream is
+// closed, so sends after closing are dropped rather than panicking.
+type chatAgentDeltaSink struct {
+	deltas chan<- ChatAgentMessageDelta
+	closed bool
+	mutex  sync.Mutex
+}
+
+func (s *chatAgentDeltaSink) send(ctx context.Context, delta ChatAgentMessageDelta) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.deltas <- delta:
+	case <-ctx.Done():
+	}
+}
+
+func (s *chatAgentDeltaSink) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.deltas)
+}
+
+// chatAgentMessageStream accumulates a streamed response and turns each chunk
+// into a delta of its content.
+type chatAgentMessageStream struct {
+	raw     strings.Builder
+	role    ChatAgentMessageRole
+	msgType ChatAgentMessageType
+	content string // Content already sent in deltas
+}
+
+// write adds a chunk of the raw response, reporting false if it added no
+// content, such as while the "type" key of the schema is received.
+func (s *chatAgentMessageStream) write(chunk string) (ChatAgentMessageDelta, bool) {
+	s.raw.WriteString(chunk)
+	msgType, content := parsePartialChatAgentMessageContent(s.raw.String())
+	if msgType != "" {
+		s.msgType = ChatAgentMessageType(msgType)
+	}
+	return s.advance(content)
+}
+
+// finish reconciles the streamed content with the complete response, which
+// differs when the response only looked like the schema while streaming.
+func (s *chatAgentMessageStream) finish(response ChatAgentMessage) (ChatAgentMessageDelta, bool) {
+	s.msgType = response.Type
+	return s.advance(response.Content)
+}
+
+func (s *chatAgentMessageStream) advance(content string) (ChatAgentMessageDelta, bool) {
+	if content == s.content {
+		return ChatAgentMessageDelta{}, false
+	}
+	delta := ""
+	if strings.HasPrefix(content, s.content) {
+		delta = content[len(s.content):]
+	}
+	s.content = content
+	msgType := s.msgType
+	if msgType == "" {
+		msgType = ChatAgentMessageTypeText
+	}
+	return ChatAgentMessageDelta{
+		Type:    msgType,
+		Role:    s.role,
+		Delta:   delta,
+		Content: content,
+	}, true
+}
+
+// parsePartialChatAgentMessageContent extracts the type and content from a
+// possibly incomplete response in the {"type", "content"} schema. The type is
+// only returned once it is complete, while the content is returned as far as
+// it was received. Responses that are not a JSON object are returned whole as
+// the content.
+func parsePartialChatAgentMessageContent(raw string) (string, string) {
+	trimmed := strings.TrimLeft(raw, " \t\r\n")
+	if trimmed == "" {
+		return "", ""
+	}
+	if trimmed[0] != '{' {
+		return "", raw
+	}
+	var msgType, content string
+	rest := trimmed[1:]
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if rest == "" || rest[0] != '"' {
+			return msgType, content
+		}
+		key, keyComplete, remaining := parsePartialJSONString(rest)
+		if !keyComplete {
+			return msgType, content
+		}
+		rest = strings.TrimLeft(remaining, " \t\r\n")
+		if rest == "" || rest[0] != ':' {
+			return msgType, content
+		}
+		rest = strings.TrimLeft(rest[1:], " \t\r\n")
+		if rest == "" || rest[0] != '"' {
+			// Only string values are part of the schema.
+			return msgType, content
+		}
+		value, valueComplete, remaining := parsePartialJSONString(rest)
+		switch {
+		case key == "content":
+			content = value
+		case key == "type" && valueComplete:
+			msgType = value
+		}
+		if !valueComplete {
+			return msgType, content
+		}
+		rest = strings.TrimLeft(remaining, " \t\r\n")
+		if rest == "" || rest[0] != ',' {
+			return msgType, content
+		}
+		rest = rest[1:]
+	}
+}
+
+// parsePartialJSONString decodes the JSON string at the start of s, which
+// begins with its opening quote. If the string is incomplete it decodes as
+// much as was received, leaving out a trailing partial escape sequence. It
+// returns the decoded string, whether it was complete, and what follows it.
+func parsePartialJSONString(s string) (string, bool, string) {
+	var decoded strings.Builder
+	for i := 1; i < len(s); {
+		switch c := s[i]; c {
+		case '"':
+			return decoded.String(), true, s[i+1:]
+		case '\\':
+			if i+1 >= len(s) {
+				return decoded.String(), false, ""
+			}
+			if s[i+1] != 'u' {
+				decoded.WriteString(unescapeJSONChar(s[i+1]))
+				i += 2
+				continue
+			}
+			r, size, ok := parseJSONUnicodeEscape(s[i:])
+			if !ok {
+				return decoded.String(), false, ""
+			}
+			decoded.WriteRune(r)
+			i += size
+		default:
+			decoded.WriteByte(c)
+			i++
+		}
+	}
+	return decoded.String(), false, ""
+}
+
+func unescapeJSONChar(c byte) string {
+	switch c {
+	case 'b':
+		return "\b"
+	case 'f':
+		return "\f"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	}
+	return string(c)
+}
+
+// parseJSONUnicodeEscape decodes the \uXXXX escape at the start of s,
+// combining surrogate pairs. It reports false if the escape, or the second
+// half of a surrogate pair, is incomplete.
+func parseJSONUnicodeEscape(s string) (rune, int, bool) {
+	if len(s) < 6 {
+		return 0, 0, false
+	}
+	r1, err := strconv.ParseUint(s[2:6], 16, 16)
+	if err != nil {
+		return unicode.ReplacementChar, 6, true
+	}
+	if !utf16.IsSurrogate(rune(r1)) {
+		return rune(r1), 6, true
+	}
+	if len(s) < 12 {
+		return 0, 0, false
+	}
+	r2, err := strconv.ParseUint(s[8:12], 16, 16)
+	if s[6] != '\\' || s[7] != 'u' || err != nil {
+		return unicode.ReplacementChar, 6, true
+	}
+	return utf16.DecodeRune(rune(r1), rune(r2)), 12, true
+}
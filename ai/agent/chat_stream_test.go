@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePartialChatAgentMessageContent(t *testing.T) {
+	tests := []struct {
+		raw     string
+		msgType string
+		content string
+	}{
+		{raw: "", msgType: "", content: ""},
+		{raw: "  ", msgType: "", content: ""},
+		{raw: "Hello", msgType: "", content: "Hello"},
+		{raw: `{"ty`, msgType: "", content: ""},
+		{raw: `{"type":"mess`, msgType: "", content: ""},
+		{raw: `{"type":"message", "content": "Hi`, msgType: "message", content: "Hi"},
+		{raw: `{"type":"message","content":"Hi \`, msgType: "message", content: "Hi "},
+		{raw: `{"type":"message","content":"Hi \"there\"\n`, msgType: "message", content: "Hi \"there\"\n"},
+		{raw: `{"type":"message","content":"caf\u00`, msgType: "message", content: "caf"},
+		{raw: `{"type":"message","content":"café`, msgType: "message", content: "café"},
+		{raw: `{"type":"message","content":"\ud83d`, msgType: "message", content: ""},
+		{raw: `{"type":"message","content":"😀"}`, msgType: "message", content: "😀"},
+		{raw: `{"content":"Hi","type":"query"}`, msgType: "query", content: "Hi"},
+		{raw: `{"answer": 42}`, msgType: "", content: ""},
+	}
+	for _, test := range tests {
+		msgType, content := parsePartialChatAgentMessageContent(test.raw)
+		assert.Equal(t, test.msgType, msgType, test.raw)
+		assert.Equal(t, test.content, content, test.raw)
+	}
+}
+
+func streamChatMessage(t *testing.T, chatAgent *ChatAgent, content string) (*ChatAgentMessage, []ChatAgentMessageDelta) {
+	deltas := make(chan ChatAgentMessageDelta)
+	received := make(chan []ChatAgentMessageDelta)
+	go func() {
+		collected := []ChatAgentMessageDelta{}
+		for delta := range deltas {
+			collected = append(collected, delta)
+		}
+		received <- collected
+	}()
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, content)
+	response, err := chatAgent.StreamChatMessage(*msg, deltas)
+	assert.Nil(t, err)
+	return response, <-received
+}
+
+func TestChatAgent_StreamChatMessage(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartStreamingHTTPTestServer(openai.SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+
+	response, deltas := streamChatMessage(t, chatAgent, "test-content")
+	expected := `CSX Labs is an "amazing" organization.`
+	assert.Equal(t, ChatAgentMessageType("message"), response.Type)
+	assert.Equal(t, expected, response.Content)
+	assert.Equal(t, 2, len(chatAgent.Messages))
+	assert.Equal(t, *response, chatAgent.GetLastMessage())
+
+	content := ""
+	for _, delta := range deltas {
+		content += delta.Delta
+		assert.Equal(t, content, delta.Content)
+		assert.Equal(t, ChatAgentMessageRoleAssistant, delta.Role)
+		assert.Equal(t, ChatAgentMessageType("message"), delta.Type)
+	}
+	assert.Equal(t, expected, content)
+	assert.Equal(t, []string{"CSX Labs is ", "an ", `"amazing" organization.`}, deltaContents(deltas))
+}
+
+func TestChatAgent_StreamChatMessageWithPlainText(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartStreamingHTTPTestServer([]string{"Hi ", "there"})
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+
+	response, deltas := streamChatMessage(t, chatAgent, "test-content")
+	assert.Equal(t, "Hi there", response.Content)
+	assert.Equal(t, []string{"Hi ", "there"}, deltaContents(deltas))
+	assert.Equal(t, ChatAgentMessageTypeText, deltas[0].Type)
+}
+
+func deltaContents(deltas []ChatAgentMessageDelta) []string {
+	contents := []string{}
+	for _, delta := range deltas {
+		contents = append(contents, delta.Delta)
+	}
+	return contents
+}
@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchYears bounds how far ahead CronExpression.Next looks for a match,
+// so that expressions like "0 0 30 2 *" that never match do not loop forever.
+const cronSearchYears = 5
+
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 7},
+}
+
+// CronExpression is a parsed five field cron expression: minute, hour, day of
+// month, month and day of week. Fields accept "*", single values, ranges
+// ("1-5"), lists ("1,15") and steps ("*/15", "0-30/10"). Day of week runs from
+// 0 (Sunday) to 6, with 7 also meaning Sunday. As in cron, when both day
+// fields are restricted a time matches if either of them does; a field
+// starting with "*", such as "*/2", does not count as restricted.
+type CronExpression struct {
+	expression    string
+	minutes       uint64
+	hours         uint64
+	daysOfMonth   uint64
+	months        uint64
+	daysOfWeek    uint64
+	dayOfMonthAny bool
+	dayOfWeekAny  bool
+}
+
+// ParseCronExpression parses a five field cron expression.
+func ParseCronExpression(expression string) (*CronExpression, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields, got %d", expression, len(cronFields), len(fields))
+	}
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expression, err)
+		}
+		values[i] = bits
+	}
+	daysOfWeek := values[4]
+	if daysOfWeek&(1<<7) != 0 {
+		daysOfWeek = daysOfWeek&^(1<<7) | 1
+	}
+	return &CronExpression{
+		expression:    expression,
+		minutes:       values[0],
+		hours:         values[1],
+		daysOfMonth:   values[2],
+		months:        values[3],
+		daysOfWeek:    daysOfWeek,
+		dayOfMonthAny: strings.HasPrefix(fields[2], "*"),
+		dayOfWeekAny:  strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, spec cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, spec.name)
+			}
+		}
+		start, end := spec.min, spec.max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			start, err = parseCronValue(startPart, spec)
+			if err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				end, err = parseCronValue(endPart, spec)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				end = spec.max
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q in %s field", rangePart, spec.name)
+			}
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, spec cronField) (int, error) {
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < spec.min || parsed > spec.max {
+		return 0, fmt.Errorf("invalid value %q in %s field, expected %d-%d", value, spec.name, spec.min, spec.max)
+	}
+	return parsed, nil
+}
+
+func (c *CronExpression) String() string {
+	return c.expression
+}
+
+// Next returns the first time after t that matches the expression, in t's
+// location, or the zero time if there is none within the next few years.
+func (c *CronExpression) Next(t time.Time) time.Time {
+	location := t.Location()
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(cronSearchYears, 0, 0)
+	for next.Before(limit) {
+		year, month, day := next.Date()
+		switch {
+		case !c.matches(c.months, int(month)):
+			next = time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+		case !c.matchesDay(next):
+			next = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+		case !c.matches(c.hours, next.Hour()):
+			next = time.Date(year, month, day, next.Hour()+1, 0, 0, 0, location)
+		case !c.matches(c.minutes, next.Minute()):
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+func (c *CronExpression) matches(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
+
+func (c *CronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := c.matches(c.daysOfMonth, t.Day())
+	dayOfWeek := c.matches(c.daysOfWeek, int(t.Weekday()))
+	if c.dayOfMonthAny || c.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCronExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+	} {
+		_, err := ParseCronExpression(expression)
+		assert.NotNil(t, err, expression)
+	}
+}
+
+func TestCronExpressionNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2023, time.May, 10, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2023, time.May, 10, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.May, 10, 10, 15, 0, 0, time.UTC)},
+		{"0 9 * * *", time.Date(2023, time.May, 11, 9, 0, 0, 0, time.UTC)},
+		{"30 8-18/2 * * *", time.Date(2023, time.May, 10, 10, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2023, time.May, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, time.May, 14, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * 5", time.Date(2023, time.May, 12, 12, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 1", time.Date(2023, time.May, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 */2 * 2", time.Date(2023, time.May, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31/2 * 1", time.Date(2023, time.May, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		cron, err := ParseCronExpression(test.expression)
+		assert.Nil(t, err, test.expression)
+		assert.Equal(t, test.expected, cron.Next(from), test.expression)
+		assert.Equal(t, test.expression, cron.String())
+	}
+	never, err := ParseCronExpression("0 0 30 2 *")
+	assert.Nil(t, err)
+	assert.True(t, never.Next(from).IsZero())
+}
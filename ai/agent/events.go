@@ -0,0 +1,150 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+type AgentEventType string
+
+const (
+	AgentEventTaskQueued    AgentEventType = "task_queued"
+	AgentEventTaskStarted   AgentEventType = "task_started"
+	AgentEventTaskRetried   AgentEventType = "task_retried"
+	AgentEventTaskProgress  AgentEventType = "task_progress"
+	AgentEventTaskCompleted AgentEventType = "task_completed"
+	AgentEventTaskFailed    AgentEventType = "task_failed"
+	AgentEventTaskKilled    AgentEventType = "task_killed"
+	AgentEventTaskSkipped   AgentEventType = "task_skipped"
+	AgentEventAgentStarted  AgentEventType = "agent_started"
+	AgentEventAgentStopped  AgentEventType = "agent_stopped"
+	AgentEventAgentKilled   AgentEventType = "agent_killed"
+)
+
+// IsTaskEvent reports whether events of this type describe a task rather
+// than the agent itself.
+func (t AgentEventType) IsTaskEvent() bool {
+	switch t {
+	case AgentEventAgentStarted, AgentEventAgentStopped, AgentEventAgentKilled:
+		return false
+	}
+	return true
+}
+
+// AgentEvent describes a change in the lifecycle of an agent or one of its
+// tasks. Task fields are empty for agent events.
+type AgentEvent struct {
+	Type      AgentEventType
+	Time      time.Time
+	AgentID   string
+	AgentName string
+	TaskID    string
+	TaskName  string
+	TaskType  AgentTaskType
+	Attempt   int               // Attempt about to run for retries, attempts made otherwise
+	Err       error             // Error that caused a retry, failure, kill or skip
+	Progress  AgentTaskProgress // Progress reported by the task, for progress events
+}
+
+// agentEventBus fans agent events out to subscribers. Publishing never
+// blocks: events are dropped for subscribers whose buffer is full. All
+// methods are safe for concurrent use.
+type agentEventBus struct {
+	subscribers map[int]chan AgentEvent
+	nextID      int
+	mutex       sync.RWMutex
+}
+
+func newAgentEventBus() *agentEventBus {
+	return &agentEventBus{
+		subscribers: make(map[int]chan AgentEvent),
+	}
+}
+
+func (b *agentEventBus) subscribe(buffer int) (<-chan AgentEvent, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	id := b.nextID
+	b.nextID++
+	events := make(chan AgentEvent, buffer)
+	b.subscribers[id] = events
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+			delete(b.subscribers, id)
+			close(events)
+		})
+	}
+	return events, unsubscribe
+}
+
+func (b *agentEventBus) publish(event AgentEvent) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, events := range b.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the agent's lifecycle events and a
+// function that ends the subscription and closes the channel. Events are
+// dropped rather than blocking the agent when the channel's buffer is full,
+// so consumers should pick a buffer that fits how fast they read.
+func (a *Agent) Subscribe(buffer int) (<-chan AgentEvent, func()) {
+	return a.events.subscribe(buffer)
+}
+
+func (a *Agent) emitAgentEvent(eventType AgentEventType) {
+	a.events.publish(AgentEvent{
+		Type:      eventType,
+		Time:      time.Now(),
+		AgentID:   a.GetID(),
+		AgentName: a.GetName(),
+	})
+}
+
+func (a *Agent) emitTaskEvent(eventType AgentEventType, task IAgentTask, attempt int, err error) {
+	a.journalTaskEvent(eventType, task, err)
+	a.events.publish(AgentEvent{
+		Type:      eventType,
+		Time:      time.Now(),
+		AgentID:   a.GetID(),
+		AgentName: a.GetName(),
+		TaskID:    task.GetID(),
+		TaskName:  task.GetName(),
+		TaskType:  task.GetType(),
+		Attempt:   attempt,
+		Err:       err,
+	})
+}
+
+func (a *Agent) emitTaskProgress(task IAgentTask, progress AgentTaskProgress) {
+	a.events.publish(AgentEvent{
+		Type:      AgentEventTaskProgress,
+		Time:      progress.UpdatedAt,
+		AgentID:   a.GetID(),
+		AgentName: a.GetName(),
+		TaskID:    task.GetID(),
+		TaskName:  task.GetName(),
+		TaskType:  task.GetType(),
+		Attempt:   task.GetAttempts(),
+		Progress:  progress,
+	})
+}
+
+// emitTaskFinished publishes the event matching a finished task's state.
+func (a *Agent) emitTaskFinished(record AgentTaskRecord) {
+	eventType := AgentEventTaskCompleted
+	switch record.State {
+	case AgentTaskStateFailed:
+		eventType = AgentEventTaskFailed
+	case AgentTaskStateKilled:
+		eventType = AgentEventTaskKilled
+	}
+	a.emitTaskEvent(eventType, record.Task, record.Attempts, record.Err)
+}
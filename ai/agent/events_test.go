@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectEvents reads events until one of type until arrives or the timeout
+// passes.
+func collectEvents(t *testing.T, events <-chan AgentEvent, until AgentEventType) []AgentEvent {
+	var collected []AgentEvent
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case event := <-events:
+			collected = append(collected, event)
+			if event.Type == until {
+				return collected
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s event, got %v", until, collected)
+			return collected
+		}
+	}
+}
+
+func eventTypes(events []AgentEvent) []AgentEventType {
+	types := []AgentEventType{}
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestSubscribeTaskLifecycle(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	events, unsubscribe := agent.Subscribe(100)
+	defer unsubscribe()
+	agent.Start()
+	task := NewAgentTask("test", testSequentialTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(task))
+	<-task.Done()
+	agent.Stop()
+	collected := collectEvents(t, events, AgentEventAgentStopped)
+	assert.Equal(t, []AgentEventType{
+		AgentEventAgentStarted,
+		AgentEventTaskQueued,
+		AgentEventTaskStarted,
+		AgentEventTaskCompleted,
+		AgentEventAgentStopped,
+	}, eventTypes(collected))
+	completed := collected[3]
+	assert.Equal(t, task.GetID(), completed.TaskID)
+	assert.Equal(t, "test", completed.TaskName)
+	assert.Equal(t, testSequentialTaskType, completed.TaskType)
+	assert.Equal(t, agent.GetID(), completed.AgentID)
+	assert.Equal(t, 1, completed.Attempt)
+	assert.False(t, completed.Time.IsZero())
+	assert.False(t, collected[0].Type.IsTaskEvent())
+	assert.True(t, completed.Type.IsTaskEvent())
+}
+
+func TestSubscribeTaskFailedAndRetried(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	events, unsubscribe := agent.Subscribe(100)
+	defer unsubscribe()
+	agent.Start()
+	defer agent.Kill()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, flakyHandler(5, &calls))
+	task.SetRetryPolicy(NewRetryPolicy(2, time.Millisecond))
+	assert.Nil(t, agent.AddTask(task))
+	collected := collectEvents(t, events, AgentEventTaskFailed)
+	assert.Equal(t, []AgentEventType{
+		AgentEventAgentStarted,
+		AgentEventTaskQueued,
+		AgentEventTaskStarted,
+		AgentEventTaskRetried,
+		AgentEventTaskFailed,
+	}, eventTypes(collected))
+	assert.Equal(t, 2, collected[3].Attempt)
+	assert.Equal(t, errTransient, collected[3].Err)
+	assert.Equal(t, errTransient, collected[4].Err)
+}
+
+func TestSubscribeTaskKilled(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	started := make(chan bool)
+	runningTask := NewAgentTask("running", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	assert.Nil(t, agent.AddTask(runningTask))
+	<-started
+	events, unsubscribe := agent.Subscribe(100)
+	defer unsubscribe()
+	agent.Kill()
+	collected := collectEvents(t, events, AgentEventAgentKilled)
+	assert.Equal(t, []AgentEventType{AgentEventTaskKilled, AgentEventAgentKilled}, eventTypes(collected))
+	assert.Equal(t, ErrTaskKilled, collected[0].Err)
+	<-runningTask.Done()
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event after kill: %v", event)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSubscribeTaskSkipped(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	events, unsubscribe := agent.Subscribe(100)
+	defer unsubscribe()
+	graph := NewAgentTaskGraph()
+	failing := NewAgentTask("failing", testTaskType, testTaskHandlerWithError)
+	dependent := NewAgentTask("dependent", testTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, graph.AddTask(failing))
+	assert.Nil(t, graph.AddTask(dependent, failing))
+	assert.Nil(t, agent.AddTaskGraph(graph))
+	collected := collectEvents(t, events, AgentEventTaskSkipped)
+	skipped := collected[len(collected)-1]
+	assert.Equal(t, dependent.GetID(), skipped.TaskID)
+	assert.ErrorIs(t, skipped.Err, ErrTaskDependencyFailed)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	events, unsubscribe := agent.Subscribe(1)
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-events
+	assert.False(t, ok)
+	agent.Start()
+	agent.Kill()
+}
+
+func TestSlowSubscriberDoesNotBlockAgent(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	events, unsubscribe := agent.Subscribe(0)
+	defer unsubscribe()
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(task))
+	_, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	select {
+	case event := <-events:
+		t.Errorf("unbuffered subscriber received event: %v", event)
+	default:
+	}
+}
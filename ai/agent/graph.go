@@ -0,0 +1,280 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrTaskGraphCycle is returned when a task graph's dependencies form a
+	// cycle.
+	ErrTaskGraphCycle = errors.New("agent task graph contains a dependency cycle")
+	// ErrTaskDependencyFailed is reported by graph tasks that were skipped
+	// because a task they depend on failed or was killed.
+	ErrTaskDependencyFailed = errors.New("agent task dependency failed")
+)
+
+// AgentTaskGraph is a set of tasks with declared dependencies between them.
+// When added to an Agent, each task is queued once all of its dependencies
+// completed successfully, so independent tasks run in parallel. If a task
+// fails or is killed, every task that depends on it, directly or not, is
+// skipped.
+//
+//	graph := NewAgentTaskGraph()
+//	graph.AddTask(outline)
+//	graph.AddTask(file, outline)
+//	graph.AddTask(tests, file)
+//	agent.AddTaskGraph(graph)
+type AgentTaskGraph struct {
+	tasks        map[string]IAgentTask
+	order        []string            // Task IDs in the order they were added
+	dependencies map[string][]string // Task ID to the IDs it depends on
+	submitted    bool
+	done         chan struct{} // Closed once every task in the graph resolved
+	mutex        sync.Mutex
+}
+
+func NewAgentTaskGraph() *AgentTaskGraph {
+	return &AgentTaskGraph{
+		tasks:        make(map[string]IAgentTask),
+		dependencies: make(map[string][]string),
+		done:         make(chan struct{}),
+	}
+}
+
+// AddTask adds a task that depends on the given tasks. Dependencies must
+// already be part of the graph.
+func (g *AgentTaskGraph) AddTask(task IAgentTask, dependencies ...IAgentTask) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.submitted {
+		return fmt.Errorf("cannot add task <ID: %s> to a submitted graph", task.GetID())
+	}
+	if _, exists := g.tasks[task.GetID()]; exists {
+		return fmt.Errorf("task already exists with <ID: %s> in graph", task.GetID())
+	}
+	for _, dependency := range dependencies {
+		if _, exists := g.tasks[dependency.GetID()]; !exists {
+			return fmt.Errorf("dependency <ID: %s> of task <ID: %s> is not in graph", dependency.GetID(), task.GetID())
+		}
+	}
+	g.tasks[task.GetID()] = task
+	g.order = append(g.order, task.GetID())
+	for _, dependency := range dependencies {
+		g.dependencies[task.GetID()] = append(g.dependencies[task.GetID()], dependency.GetID())
+	}
+	return nil
+}
+
+// AddDependency declares that task depends on dependency. Both tasks must
+// already be part of the graph. Cycles are reported when the graph is added
+// to an agent.
+func (g *AgentTaskGraph) AddDependency(task IAgentTask, dependency IAgentTask) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.submitted {
+		return fmt.Errorf("cannot add dependency to a submitted graph")
+	}
+	for _, graphTask := range []IAgentTask{task, dependency} {
+		if _, exists := g.tasks[graphTask.GetID()]; !exists {
+			return fmt.Errorf("task <ID: %s> is not in graph", graphTask.GetID())
+		}
+	}
+	g.dependencies[task.GetID()] = append(g.dependencies[task.GetID()], dependency.GetID())
+	return nil
+}
+
+// GetTasks returns the graph's tasks in the order they were added.
+func (g *AgentTaskGraph) GetTasks() []IAgentTask {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	tasks := make([]IAgentTask, 0, len(g.order))
+	for _, id := range g.order {
+		tasks = append(tasks, g.tasks[id])
+	}
+	return tasks
+}
+
+// GetDependencies returns the tasks that task directly depends on.
+func (g *AgentTaskGraph) GetDependencies(task IAgentTask) []IAgentTask {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	dependencies := []IAgentTask{}
+	for _, id := range g.dependencies[task.GetID()] {
+		dependencies = append(dependencies, g.tasks[id])
+	}
+	return dependencies
+}
+
+// TopologicalOrder returns the tasks ordered so that every task comes after
+// its dependencies, or ErrTaskGraphCycle if no such order exists.
+func (g *AgentTaskGraph) TopologicalOrder() ([]IAgentTask, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.topologicalOrder()
+}
+
+func (g *AgentTaskGraph) topologicalOrder() ([]IAgentTask, error) {
+	remaining := g.dependencyCounts()
+	dependents := g.dependents()
+	var ready []string
+	for _, id := range g.order {
+		if remaining[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	ordered := make([]IAgentTask, 0, len(g.order))
+	for len(ready) > 0 {
+		id := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, g.tasks[id])
+		for _, dependent := range dependents[id] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if len(ordered) != len(g.order) {
+		return nil, ErrTaskGraphCycle
+	}
+	return ordered, nil
+}
+
+func (g *AgentTaskGraph) dependencyCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, id := range g.order {
+		counts[id] = len(g.dependencies[id])
+	}
+	return counts
+}
+
+func (g *AgentTaskGraph) dependents() map[string][]string {
+	dependents := make(map[string][]string)
+	for _, id := range g.order {
+		for _, dependency := range g.dependencies[id] {
+			dependents[dependency] = append(dependents[dependency], id)
+		}
+	}
+	return dependents
+}
+
+// Done returns a channel that is closed once every task in the graph has
+// completed, failed, been killed or been skipped.
+func (g *AgentTaskGraph) Done() <-chan struct{} {
+	return g.done
+}
+
+// Await blocks until every task in the graph resolved or ctx is done. It
+// returns the error of the first task, in topological order, that did not
+// succeed.
+func (g *AgentTaskGraph) Await(ctx context.Context) error {
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	ordered, err := g.TopologicalOrder()
+	if err != nil {
+		return err
+	}
+	for _, task := range ordered {
+		if task.GetError() != nil {
+			return fmt.Errorf("task <ID: %s, Name: %s> failed: %w", task.GetID(), task.GetName(), task.GetError())
+		}
+	}
+	return nil
+}
+
+// AddTaskGraph schedules the tasks of graph on the agent. Tasks without
+// dependencies are queued right away and the rest as soon as their
+// dependencies complete. A graph can only be added once.
+func (a *Agent) AddTaskGraph(graph *AgentTaskGraph) error {
+	graph.mutex.Lock()
+	defer graph.mutex.Unlock()
+	if graph.submitted {
+		return fmt.Errorf("task graph was already added to an agent")
+	}
+	_, err := graph.topologicalOrder()
+	if err != nil {
+		return err
+	}
+	graph.submitted = true
+	zap.S().Infof("Adding task graph with %d tasks to agent <ID: %s, Name: %s>", len(graph.order), a.GetID(), a.GetName())
+	go a.runTaskGraph(graph, graph.dependencyCounts(), graph.dependents())
+	return nil
+}
+
+// runTaskGraph queues graph tasks as their dependencies complete and skips
+// the dependents of tasks that do not succeed.
+func (a *Agent) runTaskGraph(graph *AgentTaskGraph, remaining map[string]int, dependents map[string][]string) {
+	defer close(graph.done)
+	finished := make(chan IAgentTask, len(graph.order))
+	unresolved := len(graph.order)
+	submit := func(task IAgentTask) {
+		err := a.AddTask(task)
+		if err != nil && a.skipTask(task, err) {
+			finished <- task
+			return
+		}
+		go func() {
+			<-task.Done()
+			finished <- task
+		}()
+	}
+	for _, id := range graph.order {
+		if remaining[id] == 0 {
+			submit(graph.tasks[id])
+		}
+	}
+	for unresolved > 0 {
+		task := <-finished
+		unresolved--
+		if task.GetError() != nil {
+			unresolved -= a.skipDependents(graph, task, dependents, remaining)
+			continue
+		}
+		for _, dependent := range dependents[task.GetID()] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				submit(graph.tasks[dependent])
+			}
+		}
+	}
+}
+
+// skipDependents skips every task that transitively depends on failedTask
+// and returns how many tasks were skipped.
+func (a *Agent) skipDependents(graph *AgentTaskGraph, failedTask IAgentTask, dependents map[string][]string, remaining map[string]int) int {
+	skipped := 0
+	stack := append([]string{}, dependents[failedTask.GetID()]...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if remaining[id] < 0 {
+			continue
+		}
+		remaining[id] = -1
+		err := fmt.Errorf("%w: <ID: %s, Name: %s>: %v", ErrTaskDependencyFailed, failedTask.GetID(), failedTask.GetName(), failedTask.GetError())
+		a.skipTask(graph.tasks[id], err)
+		skipped++
+		stack = append(stack, dependents[id]...)
+	}
+	return skipped
+}
+
+// skipTask resolves a task that will not run with err. Tasks the agent
+// already tracks are left alone and reported by returning false.
+func (a *Agent) skipTask(task IAgentTask, err error) bool {
+	if !a.tasks.skip(task, err) {
+		return false
+	}
+	zap.S().Infof("Skipping task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>: %v", task.GetID(), task.GetName(), a.GetID(), a.GetName(), err)
+	task.abandon(err)
+	a.emitTaskEvent(AgentEventTaskSkipped, task, 0, err)
+	return true
+}
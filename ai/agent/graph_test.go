@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// executionLog records the order in which task handlers finish.
+type executionLog struct {
+	entries []string
+	mutex   sync.Mutex
+}
+
+func (l *executionLog) handler(name string) HandlerFunction[string] {
+	return func(ctx context.Context) (string, error) {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.entries = append(l.entries, name)
+		return name, nil
+	}
+}
+
+func (l *executionLog) indexOf(name string) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for i, entry := range l.entries {
+		if entry == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestAddTaskGraph(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	log := &executionLog{}
+	graph := NewAgentTaskGraph()
+	outline := NewAgentTask("outline", testTaskType, log.handler("outline"))
+	assert.Nil(t, graph.AddTask(outline))
+	var files []IAgentTask
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("file#%d", i)
+		file := NewAgentTask(name, testTaskType, log.handler(name))
+		assert.Nil(t, graph.AddTask(file, outline))
+		files = append(files, file)
+	}
+	tests := NewAgentTask("tests", testSequentialTaskType, log.handler("tests"))
+	assert.Nil(t, graph.AddTask(tests, files...))
+	assert.Nil(t, agent.AddTaskGraph(graph))
+	assert.Nil(t, graph.Await(context.Background()))
+	for i := 0; i < 3; i++ {
+		fileIndex := log.indexOf(fmt.Sprintf("file#%d", i))
+		assert.Greater(t, fileIndex, log.indexOf("outline"))
+		assert.Less(t, fileIndex, log.indexOf("tests"))
+	}
+	result, err := tests.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "tests", result)
+}
+
+func TestTaskGraphSkipsDependentsOfFailedTask(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	log := &executionLog{}
+	graph := NewAgentTaskGraph()
+	failing := NewAgentTask("failing", testTaskType, testTaskHandlerWithError)
+	independent := NewAgentTask("independent", testTaskType, log.handler("independent"))
+	dependent := NewAgentTask("dependent", testTaskType, log.handler("dependent"))
+	transitive := NewAgentTask("transitive", testTaskType, log.handler("transitive"))
+	assert.Nil(t, graph.AddTask(failing))
+	assert.Nil(t, graph.AddTask(independent))
+	assert.Nil(t, graph.AddTask(dependent, failing, independent))
+	assert.Nil(t, graph.AddTask(transitive, dependent))
+	assert.Nil(t, agent.AddTaskGraph(graph))
+	err := graph.Await(context.Background())
+	assert.ErrorContains(t, err, "test error")
+	_, err = dependent.Await(context.Background())
+	assert.True(t, errors.Is(err, ErrTaskDependencyFailed))
+	_, err = transitive.Await(context.Background())
+	assert.True(t, errors.Is(err, ErrTaskDependencyFailed))
+	_, err = independent.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, -1, log.indexOf("dependent"))
+	assert.Equal(t, -1, log.indexOf("transitive"))
+	record, exists := agent.GetTask(transitive.GetID())
+	assert.True(t, exists)
+	assert.Equal(t, AgentTaskStateSkipped, record.State)
+}
+
+func TestTaskGraphSkipsDependentsOfKilledTask(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	started := make(chan bool)
+	graph := NewAgentTaskGraph()
+	blocking := NewAgentTask("blocking", testTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	dependent := NewAgentTask("dependent", testTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, graph.AddTask(blocking))
+	assert.Nil(t, graph.AddTask(dependent, blocking))
+	assert.Nil(t, agent.AddTaskGraph(graph))
+	<-started
+	blocking.Kill()
+	_, err := dependent.Await(context.Background())
+	assert.True(t, errors.Is(err, ErrTaskDependencyFailed))
+	assert.True(t, dependent.IsCompleted())
+	assert.False(t, dependent.WasKilled())
+}
+
+func TestTaskGraphCycle(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	graph := NewAgentTaskGraph()
+	first := NewAgentTask("first", testTaskType, testTaskHandlerWithResult)
+	second := NewAgentTask("second", testTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, graph.AddTask(first))
+	assert.Nil(t, graph.AddTask(second, first))
+	assert.Nil(t, graph.AddDependency(first, second))
+	_, err := graph.TopologicalOrder()
+	assert.Equal(t, ErrTaskGraphCycle, err)
+	assert.Equal(t, ErrTaskGraphCycle, agent.AddTaskGraph(graph))
+}
+
+func TestTaskGraphValidation(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	graph := NewAgentTaskGraph()
+	task := NewAgentTask("task", testTaskType, testTaskHandlerWithResult)
+	missing := NewAgentTask("missing", testTaskType, testTaskHandlerWithResult)
+	assert.NotNil(t, graph.AddTask(task, missing))
+	assert.Nil(t, graph.AddTask(task))
+	assert.NotNil(t, graph.AddTask(task))
+	assert.Equal(t, []IAgentTask{}, graph.GetDependencies(task))
+	assert.Nil(t, agent.AddTaskGraph(graph))
+	assert.NotNil(t, agent.AddTaskGraph(graph))
+	assert.NotNil(t, graph.AddTask(missing))
+	assert.Nil(t, graph.Await(context.Background()))
+}
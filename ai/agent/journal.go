@@ -0,0 +1,386 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultJournalFileName is the conventional name of an agent journal inside a
+// workspace.
+const DefaultJournalFileName = ".solus_journal.jsonl"
+
+// AgentJournalStateResumed marks a journal entry recording that an
+// interrupted task was resumed as a new task.
+const AgentJournalStateResumed AgentTaskState = "resumed"
+
+// AgentJournalStateInterrupted marks a journal entry recording that an
+// interrupted task was reported instead of being resumed.
+const AgentJournalStateInterrupted AgentTaskState = "interrupted"
+
+// AgentJournalEntry is a single line of an agent journal. Every task produces
+// a queued entry followed, once it finishes, by an entry with its final state.
+// Payloads are only recorded for task types that can be resumed.
+type AgentJournalEntry struct {
+	Time      time.Time       `json:"time"`
+	AgentName string          `json:"agent_name"`
+	TaskID    string          `json:"task_id"`
+	TaskName  string          `json:"task_name"`
+	TaskType  AgentTaskType   `json:"task_type"`
+	ParentID  string          `json:"parent_id,omitempty"` // ID of the task that spawned this one
+	State     AgentTaskState  `json:"state"`
+	Payload   json.RawMessage `json:"payload,omitempty"`    // Task payload, recorded when queued
+	Result    json.RawMessage `json:"result,omitempty"`     // Task result, recorded when completed
+	Err       string          `json:"error,omitempty"`      // Task error, recorded when it did not succeed
+	ResumedAs string          `json:"resumed_as,omitempty"` // ID of the task that resumed this one
+}
+
+// DecodePayload unmarshals the entry's payload into v.
+func (e AgentJournalEntry) DecodePayload(v interface{}) error {
+	if len(e.Payload) == 0 {
+		return fmt.Errorf("journal entry for task <ID: %s> has no payload", e.TaskID)
+	}
+	return json.Unmarshal(e.Payload, v)
+}
+
+// DecodeResult unmarshals the entry's result into v.
+func (e AgentJournalEntry) DecodeResult(v interface{}) error {
+	if len(e.Result) == 0 {
+		return fmt.Errorf("journal entry for task <ID: %s> has no result", e.TaskID)
+	}
+	return json.Unmarshal(e.Result, v)
+}
+
+// AgentJournal is an append-only JSONL log of the tasks an agent queued and
+// how they finished. Each entry is synced to disk before the agent moves on,
+// so after a crash the journal tells which tasks never finished. Only the
+// entries of unfinished tasks are kept in memory, and Compact rewrites the
+// log down to them. All methods are safe for concurrent use.
+type AgentJournal struct {
+	path    string
+	file    *os.File
+	pending []AgentJournalEntry // Latest entry of every unfinished task, oldest first
+	mutex   sync.Mutex
+}
+
+// OpenAgentJournal opens the journal at path, creating it if needed, loads
+// the unfinished tasks of previous runs and compacts it.
+func OpenAgentJournal(path string) (*AgentJournal, error) {
+	entries, err := ReadAgentJournal(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	journal := &AgentJournal{path: path}
+	for _, entry := range entries {
+		journal.track(entry)
+	}
+	err = journal.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	return journal, nil
+}
+
+// ReadAgentJournal reads every entry of the journal at path. A final line cut
+// short by a crash is ignored.
+func ReadAgentJournal(path string) ([]AgentJournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	entries := []AgentJournalEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	var lineErr error
+	for scanner.Scan() {
+		if lineErr != nil {
+			return nil, lineErr
+		}
+		var entry AgentJournalEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			lineErr = fmt.Errorf("corrupt journal entry in %s: %w", path, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lineErr != nil {
+		zap.S().Warnf("Ignoring truncated last entry of journal %s: %v", path, lineErr)
+	}
+	return entries, nil
+}
+
+func (j *AgentJournal) GetPath() string {
+	return j.path
+}
+
+// Append writes entry to the journal and syncs it to disk.
+func (j *AgentJournal) Append(entry AgentJournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+	j.track(entry)
+	return j.file.Sync()
+}
+
+// track keeps the latest entry of unfinished tasks, forgetting the tasks
+// entry finishes. Callers must hold mutex, if the journal is shared.
+func (j *AgentJournal) track(entry AgentJournalEntry) {
+	for i, pending := range j.pending {
+		if pending.TaskID != entry.TaskID {
+			continue
+		}
+		if entry.State == AgentTaskStateQueued {
+			j.pending[i] = entry
+			return
+		}
+		j.pending = append(j.pending[:i], j.pending[i+1:]...)
+		return
+	}
+	if entry.State == AgentTaskStateQueued {
+		j.pending = append(j.pending, entry)
+	}
+}
+
+// Compact rewrites the journal down to the latest entry of every unfinished
+// task, dropping finished tasks along with their results.
+func (j *AgentJournal) Compact() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return fmt.Errorf("journal %s is closed", j.path)
+	}
+	return j.rewrite()
+}
+
+// rewrite replaces the journal file with the pending entries and reopens it
+// for appending. The file is replaced by renaming a complete copy over it, so
+// a crash leaves either the old or the new journal. Callers must hold mutex,
+// if the journal is shared.
+func (j *AgentJournal) rewrite() error {
+	temp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Nothing is left to remove once the file was renamed.
+	defer os.Remove(temp.Name()) // trunk-ignore(golangci-lint/errcheck)
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range j.pending {
+		err = encoder.Encode(entry)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(temp.Name(), j.path)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		// The old file was replaced, only its handle is left to close.
+		_ = j.file.Close()
+	}
+	j.file = file
+	return nil
+}
+
+// GetInterruptedTasks returns the queued entries of tasks that never
+// finished and were not resumed, oldest first.
+func (j *AgentJournal) GetInterruptedTasks() []AgentJournalEntry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return append([]AgentJournalEntry{}, j.pending...)
+}
+
+// MarkInterrupted records that the interrupted task of entry will not be
+// resumed, so that it is no longer returned by GetInterruptedTasks.
+func (j *AgentJournal) MarkInterrupted(entry AgentJournalEntry) error {
+	entry.Time = time.Now()
+	entry.State = AgentJournalStateInterrupted
+	entry.Payload = nil
+	return j.Append(entry)
+}
+
+func (j *AgentJournal) Close() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// AgentTaskFactory rebuilds a task from the journal entry recorded when it
+// was queued, so that interrupted work can be resumed.
+type AgentTaskFactory func(entry AgentJournalEntry) (IAgentTask, error)
+
+// SetJournal makes the agent record its tasks in journal. A nil journal stops
+// journaling.
+func (a *Agent) SetJournal(journal *AgentJournal) {
+	a.journalMutex.Lock()
+	defer a.journalMutex.Unlock()
+	a.journal = journal
+}
+
+func (a *Agent) GetJournal() *AgentJournal {
+	a.journalMutex.RLock()
+	defer a.journalMutex.RUnlock()
+	return a.journal
+}
+
+// RegisterTaskFactory registers the factory used to resume interrupted tasks
+// of the given type.
+func (a *Agent) RegisterTaskFactory(taskType AgentTaskType, factory AgentTaskFactory) {
+	a.journalMutex.Lock()
+	defer a.journalMutex.Unlock()
+	a.taskFactories[taskType] = factory
+}
+
+func (a *Agent) getTaskFactory(taskType AgentTaskType) (AgentTaskFactory, bool) {
+	a.journalMutex.RLock()
+	defer a.journalMutex.RUnlock()
+	factory, exists := a.taskFactories[taskType]
+	return factory, exists
+}
+
+// ResumeInterruptedTasks rebuilds the interrupted tasks of the agent's journal
+// with the registered factories and adds them to the agent. Interrupted tasks
+// without a factory, or whose factory fails, are returned as unresumed so the
+// caller can report them.
+func (a *Agent) ResumeInterruptedTasks() (resumed []IAgentTask, unresumed []AgentJournalEntry, err error) {
+	journal := a.GetJournal()
+	if journal == nil {
+		return nil, nil, fmt.Errorf("agent <ID: %s, Name: %s> has no journal", a.GetID(), a.GetName())
+	}
+	for _, entry := range journal.GetInterruptedTasks() {
+		factory, exists := a.getTaskFactory(entry.TaskType)
+		if !exists {
+			unresumed = append(unresumed, entry)
+			continue
+		}
+		task, factoryErr := factory(entry)
+		if factoryErr != nil {
+			zap.S().Warnf("Could not rebuild interrupted task <ID: %s, Name: %s>: %v", entry.TaskID, entry.TaskName, factoryErr)
+			unresumed = append(unresumed, entry)
+			continue
+		}
+		resumedEntry := entry
+		resumedEntry.Time = time.Now()
+		resumedEntry.State = AgentJournalStateResumed
+		resumedEntry.Payload = nil
+		resumedEntry.ResumedAs = task.GetID()
+		err = journal.Append(resumedEntry)
+		if err != nil {
+			return resumed, unresumed, err
+		}
+		zap.S().Infof("Resuming interrupted task <ID: %s, Name: %s> as <ID: %s> on agent <ID: %s, Name: %s>", entry.TaskID, entry.TaskName, task.GetID(), a.GetID(), a.GetName())
+		err = a.AddTask(task)
+		if err != nil {
+			return resumed, unresumed, err
+		}
+		resumed = append(resumed, task)
+	}
+	return resumed, unresumed, nil
+}
+
+// compactJournal compacts the agent's journal, if any. Failures are logged,
+// as the journal stays usable without compaction.
+func (a *Agent) compactJournal() {
+	journal := a.GetJournal()
+	if journal == nil {
+		return
+	}
+	err := journal.Compact()
+	if err != nil {
+		zap.S().Warnf("Could not compact journal %s of agent <ID: %s, Name: %s>: %v", journal.GetPath(), a.GetID(), a.GetName(), err)
+	}
+}
+
+// journalTaskEvent records task lifecycle events that matter for recovery.
+// Journal failures are logged rather than failing the task.
+func (a *Agent) journalTaskEvent(eventType AgentEventType, task IAgentTask, err error) {
+	journal := a.GetJournal()
+	if journal == nil {
+		return
+	}
+	entry := AgentJournalEntry{
+		Time:      time.Now(),
+		AgentName: a.GetName(),
+		TaskID:    task.GetID(),
+		TaskName:  task.GetName(),
+		TaskType:  task.GetType(),
+		ParentID:  task.GetParentID(),
+	}
+	var marshalErr error
+	switch eventType {
+	case AgentEventTaskQueued:
+		entry.State = AgentTaskStateQueued
+		// Payloads are only of use to resume a task, and can be large, such
+		// as prompts holding a whole project.
+		if _, resumable := a.getTaskFactory(task.GetType()); resumable && task.GetPayload() != nil {
+			entry.Payload, marshalErr = json.Marshal(task.GetPayload())
+		}
+	case AgentEventTaskCompleted:
+		entry.State = AgentTaskStateCompleted
+		entry.Result, marshalErr = json.Marshal(task.GetResult())
+	case AgentEventTaskFailed:
+		entry.State = AgentTaskStateFailed
+	case AgentEventTaskKilled:
+		entry.State = AgentTaskStateKilled
+	case AgentEventTaskSkipped:
+		entry.State = AgentTaskStateSkipped
+	default:
+		return
+	}
+	if err != nil {
+		entry.Err = err.Error()
+	}
+	if marshalErr == nil {
+		marshalErr = journal.Append(entry)
+	}
+	if marshalErr != nil {
+		zap.S().Errorf("Could not journal task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>: %v", task.GetID(), task.GetName(), a.GetID(), a.GetName(), marshalErr)
+	}
+}
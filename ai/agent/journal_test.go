@@ -0,0 +1,174 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestJournal(t *testing.T) (*AgentJournal, string) {
+	path := filepath.Join(t.TempDir(), DefaultJournalFileName)
+	journal, err := OpenAgentJournal(path)
+	assert.Nil(t, err)
+	t.Cleanup(func() { journal.Close() })
+	return journal, path
+}
+
+func TestAgentJournalRecordsTasks(t *testing.T) {
+	journal, path := newTestJournal(t)
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetJournal(journal)
+	agent.RegisterTaskFactory(testTaskType, func(entry AgentJournalEntry) (IAgentTask, error) {
+		return nil, errors.New("not resumable in this test")
+	})
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("testTaskName", testTaskType, testTaskHandlerWithResult)
+	task.SetPayload(map[string]string{"input": "test input"})
+	failing := NewAgentTask("failing", testTaskType, testTaskHandlerWithError)
+	unresumable := NewAgentTask("unresumable", testSequentialTaskType, testTaskHandlerWithResult)
+	unresumable.SetPayload("a whole project")
+	assert.Nil(t, agent.AddTask(task))
+	assert.Nil(t, agent.AddTask(failing))
+	assert.Nil(t, agent.AddTask(unresumable))
+	_, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	_, err = failing.Await(context.Background())
+	assert.NotNil(t, err)
+	_, err = unresumable.Await(context.Background())
+	assert.Nil(t, err)
+	agent.AwaitAllTasks()
+	entries, err := ReadAgentJournal(path)
+	assert.Nil(t, err)
+	states := make(map[string][]AgentTaskState)
+	for _, entry := range entries {
+		states[entry.TaskID] = append(states[entry.TaskID], entry.State)
+	}
+	assert.Equal(t, []AgentTaskState{AgentTaskStateQueued, AgentTaskStateCompleted}, states[task.GetID()])
+	assert.Equal(t, []AgentTaskState{AgentTaskStateQueued, AgentTaskStateFailed}, states[failing.GetID()])
+	for _, entry := range entries {
+		if entry.TaskID == unresumable.GetID() {
+			assert.Empty(t, entry.Payload)
+			continue
+		}
+		if entry.TaskID != task.GetID() {
+			if entry.State == AgentTaskStateFailed {
+				assert.Equal(t, "test error", entry.Err)
+			}
+			continue
+		}
+		switch entry.State {
+		case AgentTaskStateQueued:
+			var payload map[string]string
+			assert.Nil(t, entry.DecodePayload(&payload))
+			assert.Equal(t, "test input", payload["input"])
+		case AgentTaskStateCompleted:
+			var result string
+			assert.Nil(t, entry.DecodeResult(&result))
+			assert.Equal(t, "test", result)
+		}
+	}
+	assert.Empty(t, journal.GetInterruptedTasks())
+
+	agent.Stop()
+	entries, err = ReadAgentJournal(path)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestOpenAgentJournalCompacts(t *testing.T) {
+	journal, path := newTestJournal(t)
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "done", TaskType: testTaskType, State: AgentTaskStateQueued, Payload: []byte(`"done input"`)}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "done", TaskType: testTaskType, State: AgentTaskStateCompleted, Result: []byte(`"done result"`)}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "retried", TaskType: testTaskType, State: AgentTaskStateQueued, Payload: []byte(`"first"`)}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "retried", TaskType: testTaskType, State: AgentTaskStateQueued, Payload: []byte(`"second"`)}))
+	assert.Nil(t, journal.Close())
+
+	journal, err := OpenAgentJournal(path)
+	assert.Nil(t, err)
+	defer journal.Close()
+	entries, err := ReadAgentJournal(path)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "retried", entries[0].TaskID)
+	assert.Equal(t, `"second"`, string(entries[0].Payload))
+	assert.Equal(t, entries, journal.GetInterruptedTasks())
+
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "retried", TaskType: testTaskType, State: AgentTaskStateFailed}))
+	assert.Nil(t, journal.Compact())
+	entries, err = ReadAgentJournal(path)
+	assert.Nil(t, err)
+	assert.Empty(t, entries)
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "next", TaskType: testTaskType, State: AgentTaskStateQueued}))
+	entries, err = ReadAgentJournal(path)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	matches, err := filepath.Glob(path + ".*.tmp")
+	assert.Nil(t, err)
+	assert.Empty(t, matches)
+}
+
+func TestReadAgentJournalIgnoresTruncatedEntry(t *testing.T) {
+	journal, path := newTestJournal(t)
+	entry := AgentJournalEntry{TaskID: "first", TaskType: testTaskType, State: AgentTaskStateQueued}
+	assert.Nil(t, journal.Append(entry))
+	assert.Nil(t, journal.Close())
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteString(`{"task_id":"second","sta`)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+	entries, err := ReadAgentJournal(path)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "first", entries[0].TaskID)
+	err = os.WriteFile(path, []byte("{\"task_id\":\n{}\n"), 0644)
+	assert.Nil(t, err)
+	_, err = ReadAgentJournal(path)
+	assert.NotNil(t, err)
+}
+
+func TestResumeInterruptedTasks(t *testing.T) {
+	journal, path := newTestJournal(t)
+	otherTaskType := NewAgentTaskType("otherTaskType", false)
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "done", TaskType: testTaskType, State: AgentTaskStateQueued}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "done", TaskType: testTaskType, State: AgentTaskStateCompleted}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "interrupted", TaskName: "interrupted", TaskType: testTaskType, State: AgentTaskStateQueued, Payload: []byte(`"resumed input"`)}))
+	assert.Nil(t, journal.Append(AgentJournalEntry{TaskID: "unknown", TaskType: otherTaskType, State: AgentTaskStateQueued}))
+	assert.Nil(t, journal.Close())
+
+	journal, err := OpenAgentJournal(path)
+	assert.Nil(t, err)
+	defer journal.Close()
+	interrupted := journal.GetInterruptedTasks()
+	assert.Len(t, interrupted, 2)
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetJournal(journal)
+	agent.RegisterTaskFactory(testTaskType, func(entry AgentJournalEntry) (IAgentTask, error) {
+		var input string
+		err := entry.DecodePayload(&input)
+		if err != nil {
+			return nil, err
+		}
+		return NewAgentTask(entry.TaskName, entry.TaskType, func(ctx context.Context) (string, error) {
+			return input, nil
+		}), nil
+	})
+	agent.Start()
+	defer agent.Kill()
+	resumed, unresumed, err := agent.ResumeInterruptedTasks()
+	assert.Nil(t, err)
+	assert.Len(t, resumed, 1)
+	assert.Len(t, unresumed, 1)
+	assert.Equal(t, "unknown", unresumed[0].TaskID)
+	<-resumed[0].Done()
+	assert.Equal(t, "resumed input", resumed[0].GetResult())
+	assert.Nil(t, journal.MarkInterrupted(unresumed[0]))
+	assert.Empty(t, journal.GetInterruptedTasks())
+	_, _, err = NewAgent("testName", "testAgentType", nil).ResumeInterruptedTasks()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testTaskHandlerWithPanic(ctx context.Context) (string, error) {
+	panic("test panic")
+}
+
+func TestTaskWithPanic(t *testing.T) {
+	for _, taskType := range []AgentTaskType{testTaskType, testSequentialTaskType} {
+		agent := NewAgent("testName", "testAgentType", nil)
+		agent.Start()
+		panicking := NewAgentTask("panicking", taskType, testTaskHandlerWithPanic)
+		assert.Nil(t, agent.AddTask(panicking))
+		_, err := panicking.Await(context.Background())
+		var panicErr *AgentTaskPanicError
+		assert.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "test panic", panicErr.Value)
+		assert.Contains(t, string(panicErr.Stack), "testTaskHandlerWithPanic")
+		assert.EqualError(t, err, "agent task panicked: test panic")
+		record, _ := agent.GetTask(panicking.GetID())
+		assert.Equal(t, AgentTaskStateFailed, record.State)
+		// The agent keeps serving tasks after a panic.
+		task := NewAgentTask("test", taskType, testTaskHandlerWithResult)
+		assert.Nil(t, agent.AddTask(task))
+		result, err := task.Await(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, "test", result)
+		agent.AwaitAllTasks()
+		assert.Equal(t, 0, agent.GetActiveWorkerCount())
+		agent.Kill()
+	}
+}
+
+func TestTaskWithErrorPanic(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("panicking", testTaskType, func(ctx context.Context) (string, error) {
+		panic(errTransient)
+	})
+	task.SetRetryPolicy(NewRetryPolicy(3, time.Millisecond))
+	assert.Nil(t, agent.AddTask(task))
+	_, err := task.Await(context.Background())
+	assert.True(t, errors.Is(err, errTransient))
+	assert.Equal(t, 1, task.GetAttempts())
+}
@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AgentTaskProgress is the latest progress reported by a running task.
+type AgentTaskProgress struct {
+	Percent   float64   // Between 0 and 100
+	Stage     string    // Label of the current stage, such as "scraping"
+	Message   string    // Free-form status message
+	UpdatedAt time.Time // Zero until the task reports progress
+}
+
+// IsReported reports whether the task has reported any progress.
+func (p AgentTaskProgress) IsReported() bool {
+	return !p.UpdatedAt.IsZero()
+}
+
+// Bar renders the progress as a text progress bar of the given width followed
+// by the percentage, stage and message. Negative widths render an empty bar,
+// and percentages outside 0 to 100 an empty or full one.
+func (p AgentTaskProgress) Bar(width int) string {
+	if width < 0 {
+		width = 0
+	}
+	filled := int(p.Percent / 100 * float64(width))
+	if filled < 0 {
+		filled = 0
+	} else if filled > width {
+		filled = width
+	}
+	bar := fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("#", filled), strings.Repeat("-", width-filled), p.Percent)
+	if p.Stage != "" {
+		bar += " " + p.Stage
+	}
+	if p.Message != "" {
+		bar += ": " + p.Message
+	}
+	return bar
+}
+
+// ProgressReporter lets a task handler report its progress. Every report
+// updates the task's progress, readable with GetProgress, and is published to
+// the agent's subscribers as an AgentEventTaskProgress event. Reporters are
+// safe for concurrent use.
+type ProgressReporter struct {
+	agent *Agent
+	task  IAgentTask
+}
+
+// ProgressFromContext returns the progress reporter of the task whose handler
+// received ctx. Outside of a task handler it returns a reporter that discards
+// reports, so handlers can report unconditionally.
+func ProgressFromContext(ctx context.Context) *ProgressReporter {
+	taskContext, ok := ctx.Value(agentTaskContextKey{}).(agentTaskContext)
+	if !ok {
+		return &ProgressReporter{}
+	}
+	return &ProgressReporter{agent: taskContext.agent, task: taskContext.task}
+}
+
+// Report sets the task's percentage, stage and status message at once.
+func (r *ProgressReporter) Report(percent float64, stage string, message string) {
+	r.update(func(progress *AgentTaskProgress) {
+		progress.Percent = percent
+		progress.Stage = stage
+		progress.Message = message
+	})
+}
+
+// SetPercent sets how much of the task is done, between 0 and 100.
+func (r *ProgressReporter) SetPercent(percent float64) {
+	r.update(func(progress *AgentTaskProgress) {
+		progress.Percent = percent
+	})
+}
+
+// SetStep sets the percentage from the number of steps done out of total.
+func (r *ProgressReporter) SetStep(done int, total int) {
+	if total <= 0 {
+		return
+	}
+	r.SetPercent(100 * float64(done) / float64(total))
+}
+
+// SetStage starts a new stage, clearing the status message.
+func (r *ProgressReporter) SetStage(stage string) {
+	r.update(func(progress *AgentTaskProgress) {
+		progress.Stage = stage
+		progress.Message = ""
+	})
+}
+
+// SetMessage sets the status message, keeping the stage and percentage.
+func (r *ProgressReporter) SetMessage(message string) {
+	r.update(func(progress *AgentTaskProgress) {
+		progress.Message = message
+	})
+}
+
+func (r *ProgressReporter) update(fn func(progress *AgentTaskProgress)) {
+	if r.task == nil {
+		return
+	}
+	progress := r.task.updateProgress(func(progress *AgentTaskProgress) {
+		fn(progress)
+		if progress.Percent < 0 {
+			progress.Percent = 0
+		} else if progress.Percent > 100 {
+			progress.Percent = 100
+		}
+		progress.UpdatedAt = time.Now()
+	})
+	r.agent.emitTaskProgress(r.task, progress)
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskProgressReporting(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	events, unsubscribe := agent.Subscribe(100)
+	defer unsubscribe()
+	agent.Start()
+	defer agent.Kill()
+	pages := []string{"first", "second", "third", "fourth"}
+	task := NewAgentTask("scrape", testTaskType, func(ctx context.Context) (string, error) {
+		progress := ProgressFromContext(ctx)
+		progress.SetStage("scraping")
+		for i, page := range pages {
+			progress.SetMessage(page)
+			progress.SetStep(i+1, len(pages))
+		}
+		progress.Report(150, "done", "")
+		return "scraped", nil
+	})
+	assert.False(t, task.GetProgress().IsReported())
+	assert.Nil(t, agent.AddTask(task))
+	collected := collectEvents(t, events, AgentEventTaskCompleted)
+	var percents []float64
+	for _, event := range collected {
+		if event.Type == AgentEventTaskProgress {
+			assert.Equal(t, task.GetID(), event.TaskID)
+			percents = append(percents, event.Progress.Percent)
+		}
+	}
+	assert.Equal(t, []float64{0, 0, 25, 25, 50, 50, 75, 75, 100, 100}, percents)
+	progress := task.GetProgress()
+	assert.True(t, progress.IsReported())
+	assert.Equal(t, float64(100), progress.Percent)
+	assert.Equal(t, "done", progress.Stage)
+	assert.Equal(t, "", progress.Message)
+}
+
+func TestProgressFromContextOutsideTask(t *testing.T) {
+	progress := ProgressFromContext(context.Background())
+	progress.Report(50, "stage", "message")
+	progress.SetStep(1, 0)
+}
+
+func TestAgentTaskProgressBar(t *testing.T) {
+	progress := AgentTaskProgress{Percent: 50, Stage: "scraping", Message: "page 2 of 4"}
+	assert.Equal(t, "[#####-----]  50% scraping: page 2 of 4", progress.Bar(10))
+	assert.Equal(t, "[----------]   0%", AgentTaskProgress{}.Bar(10))
+}
+
+func TestAgentTaskProgressBarOutOfRange(t *testing.T) {
+	assert.Equal(t, "[]  50%", AgentTaskProgress{Percent: 50}.Bar(-5))
+	assert.Equal(t, "[##########] 150%", AgentTaskProgress{Percent: 150}.Bar(10))
+	assert.Equal(t, "[----------] -20%", AgentTaskProgress{Percent: -20}.Bar(10))
+}
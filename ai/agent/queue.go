@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// AgentTaskPriority orders the tasks waiting in an agent queue. Higher
+// priorities are picked first; tasks of equal priority run in the order they
+// were added.
+type AgentTaskPriority int
+
+const (
+	AgentTaskPriorityLow    AgentTaskPriority = -1 // Background work such as indexing or scraping
+	AgentTaskPriorityNormal AgentTaskPriority = 0  // Default priority
+	AgentTaskPriorityHigh   AgentTaskPriority = 1  // Interactive work a user is waiting on
+)
+
+const (
+	// DefaultQueueCapacity is how many tasks an agent queue holds before
+	// AddTask blocks.
+	DefaultQueueCapacity = 100
+	// DefaultPriorityAgingInterval is how long a task waits before it is
+	// treated as one priority level higher.
+	DefaultPriorityAgingInterval = 10 * time.Second
+)
+
+var errTaskQueueClosed = errors.New("task queue is closed")
+
+type agentQueuedTask struct {
+	task       IAgentTask
+	priority   AgentTaskPriority
+	enqueuedAt time.Time
+}
+
+// agentTaskQueue is a bounded priority queue of tasks. Tasks gain one
+// priority level for every aging interval they wait, up to
+// AgentTaskPriorityHigh, so that a steady stream of high priority tasks
+// cannot starve the others. All methods are safe for concurrent use.
+type agentTaskQueue struct {
+	tasks         []agentQueuedTask
+	capacity      int
+	agingInterval time.Duration // Zero disables aging
+	closed        bool
+	changed       chan struct{} // Closed and replaced whenever the queue changes
+	mutex         sync.Mutex
+}
+
+func newAgentTaskQueue(capacity int, agingInterval time.Duration) *agentTaskQueue {
+	if capacity <= 0 {
+		capacity = DefaultQueueCapacity
+	}
+	return &agentTaskQueue{
+		capacity:      capacity,
+		agingInterval: agingInterval,
+		changed:       make(chan struct{}),
+	}
+}
+
+// notifyLocked wakes every goroutine waiting on the queue. Callers must hold
+// the mutex.
+func (q *agentTaskQueue) notifyLocked() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+func (q *agentTaskQueue) setCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultQueueCapacity
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.capacity = capacity
+	q.notifyLocked()
+}
+
+func (q *agentTaskQueue) setAgingInterval(agingInterval time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.agingInterval = agingInterval
+}
+
+func (q *agentTaskQueue) len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.tasks)
+}
+
+// push adds task to the queue, blocking while the queue is full until ctx is
+// done.
+func (q *agentTaskQueue) push(ctx context.Context, task IAgentTask) error {
+	for {
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return errTaskQueueClosed
+		}
+		if len(q.tasks) < q.capacity {
+			q.tasks = append(q.tasks, agentQueuedTask{
+				task:       task,
+				priority:   task.GetPriority(),
+				enqueuedAt: time.Now(),
+			})
+			q.notifyLocked()
+			q.mutex.Unlock()
+			return nil
+		}
+		changed := q.changed
+		q.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// pop removes the next task from the queue, blocking until one is available.
+// It reports false once the queue is closed and empty, or ctx is done.
+func (q *agentTaskQueue) pop(ctx context.Context) (IAgentTask, bool) {
+	for {
+		tasks, changed, closed := q.take(func(task IAgentTask) bool { return true }, 1)
+		if len(tasks) > 0 {
+			return tasks[0], true
+		}
+		if closed {
+			return nil, false
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// take removes up to limit tasks for which accept reports true, visiting
+// tasks in priority order and stopping at the limit. A limit of zero or less
+// visits every task. It also returns a channel closed on the next change to
+// the queue, and whether the queue is closed and now empty.
+func (q *agentTaskQueue) take(accept func(task IAgentTask) bool, limit int) ([]IAgentTask, <-chan struct{}, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	taken := []IAgentTask{}
+	if len(q.tasks) > 0 {
+		now := time.Now()
+		visited := make([]bool, len(q.tasks))
+		for range q.tasks {
+			next := q.nextLocked(now, visited)
+			visited[next] = true
+			if accept(q.tasks[next].task) {
+				taken = append(taken, q.tasks[next].task)
+				q.tasks[next].task = nil
+				if limit > 0 && len(taken) == limit {
+					break
+				}
+			}
+		}
+		if len(taken) > 0 {
+			remaining := q.tasks[:0]
+			for _, queued := range q.tasks {
+				if queued.task != nil {
+					remaining = append(remaining, queued)
+				}
+			}
+			for i := len(remaining); i < len(q.tasks); i++ {
+				q.tasks[i] = agentQueuedTask{}
+			}
+			q.tasks = remaining
+			q.notifyLocked()
+		}
+	}
+	return taken, q.changed, q.closed && len(q.tasks) == 0
+}
+
+// nextLocked returns the index of the unvisited task with the highest
+// effective priority, the oldest one among equals. Callers must hold the
+// mutex and ensure an unvisited task remains.
+func (q *agentTaskQueue) nextLocked(now time.Time, visited []bool) int {
+	next := -1
+	var nextPriority AgentTaskPriority
+	for i, queued := range q.tasks {
+		if visited[i] {
+			continue
+		}
+		priority := q.effectivePriority(queued, now)
+		if next == -1 || priority > nextPriority {
+			next = i
+			nextPriority = priority
+		}
+	}
+	return next
+}
+
+// effectivePriority returns the priority of a queued task after aging.
+func (q *agentTaskQueue) effectivePriority(queued agentQueuedTask, now time.Time) AgentTaskPriority {
+	if q.agingInterval <= 0 || queued.priority >= AgentTaskPriorityHigh {
+		return queued.priority
+	}
+	priority := queued.priority + AgentTaskPriority(now.Sub(queued.enqueuedAt)/q.agingInterval)
+	if priority > AgentTaskPriorityHigh {
+		return AgentTaskPriorityHigh
+	}
+	return priority
+}
+
+// close stops the queue from accepting tasks. Queued tasks can still be
+// taken.
+func (q *agentTaskQueue) close() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.notifyLocked()
+}
+
+// SetQueueCapacity sets how many tasks each of the agent's queues holds
+// before AddTask blocks. A value of zero or less restores
+// DefaultQueueCapacity.
+func (a *Agent) SetQueueCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = DefaultQueueCapacity
+	}
+	a.sequentialQueueMutex.Lock()
+	a.queueCapacity = capacity
+	for _, sequentialTaskQueue := range a.sequentialTaskQueues {
+		sequentialTaskQueue.setCapacity(capacity)
+	}
+	a.sequentialQueueMutex.Unlock()
+	a.getTaskQueue().setCapacity(capacity)
+}
+
+func (a *Agent) GetQueueCapacity() int {
+	a.sequentialQueueMutex.Lock()
+	defer a.sequentialQueueMutex.Unlock()
+	return a.queueCapacity
+}
+
+// SetPriorityAgingInterval sets how long a queued task waits before it is
+// treated as one priority level higher, which keeps low priority tasks from
+// starving behind a steady stream of higher priority ones. Zero disables
+// aging.
+func (a *Agent) SetPriorityAgingInterval(agingInterval time.Duration) {
+	a.sequentialQueueMutex.Lock()
+	a.priorityAgingInterval = agingInterval
+	for _, sequentialTaskQueue := range a.sequentialTaskQueues {
+		sequentialTaskQueue.setAgingInterval(agingInterval)
+	}
+	a.sequentialQueueMutex.Unlock()
+	a.getTaskQueue().setAgingInterval(agingInterval)
+}
+
+func (a *Agent) GetPriorityAgingInterval() time.Duration {
+	a.sequentialQueueMutex.Lock()
+	defer a.sequentialQueueMutex.Unlock()
+	return a.priorityAgingInterval
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newPriorityTask(name string, priority AgentTaskPriority) *AgentTask[string] {
+	task := NewAgentTask(name, testTaskType, testTaskHandlerWithResult)
+	task.SetPriority(priority)
+	return task
+}
+
+func popNames(t *testing.T, queue *agentTaskQueue, count int) []string {
+	names := []string{}
+	for i := 0; i < count; i++ {
+		task, ok := queue.pop(context.Background())
+		assert.True(t, ok)
+		names = append(names, task.GetName())
+	}
+	return names
+}
+
+func TestTaskQueuePriorityOrder(t *testing.T) {
+	queue := newAgentTaskQueue(10, 0)
+	ctx := context.Background()
+	assert.Nil(t, queue.push(ctx, newPriorityTask("low", AgentTaskPriorityLow)))
+	assert.Nil(t, queue.push(ctx, newPriorityTask("normal#1", AgentTaskPriorityNormal)))
+	assert.Nil(t, queue.push(ctx, newPriorityTask("high", AgentTaskPriorityHigh)))
+	assert.Nil(t, queue.push(ctx, newPriorityTask("normal#2", AgentTaskPriorityNormal)))
+	assert.Equal(t, []string{"high", "normal#1", "normal#2", "low"}, popNames(t, queue, 4))
+	assert.Equal(t, 0, queue.len())
+}
+
+func TestTaskQueueAging(t *testing.T) {
+	queue := newAgentTaskQueue(10, 20*time.Millisecond)
+	ctx := context.Background()
+	assert.Nil(t, queue.push(ctx, newPriorityTask("low", AgentTaskPriorityLow)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Nil(t, queue.push(ctx, newPriorityTask("high", AgentTaskPriorityHigh)))
+	assert.Nil(t, queue.push(ctx, newPriorityTask("normal", AgentTaskPriorityNormal)))
+	assert.Equal(t, []string{"low", "high", "normal"}, popNames(t, queue, 3))
+}
+
+func TestTaskQueueCapacity(t *testing.T) {
+	queue := newAgentTaskQueue(1, 0)
+	assert.Nil(t, queue.push(context.Background(), newPriorityTask("first", AgentTaskPriorityNormal)))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := queue.push(ctx, newPriorityTask("second", AgentTaskPriorityNormal))
+	assert.Equal(t, context.DeadlineExceeded, err)
+	pushed := make(chan error)
+	go func() {
+		pushed <- queue.push(context.Background(), newPriorityTask("third", AgentTaskPriorityNormal))
+	}()
+	queue.setCapacity(2)
+	assert.Nil(t, <-pushed)
+	queue.close()
+	assert.Equal(t, errTaskQueueClosed, queue.push(context.Background(), newPriorityTask("fourth", AgentTaskPriorityNormal)))
+	assert.Equal(t, []string{"first", "third"}, popNames(t, queue, 2))
+	_, ok := queue.pop(context.Background())
+	assert.False(t, ok)
+}
+
+func TestAgentRunsHighestPriorityTaskFirst(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	log := &executionLog{}
+	release := make(chan bool)
+	started := make(chan bool)
+	blocking := NewAgentTask("blocking", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-release
+		return "blocking", nil
+	})
+	assert.Nil(t, agent.AddTask(blocking))
+	<-started
+	tasks := []*AgentTask[string]{}
+	for _, priority := range []AgentTaskPriority{AgentTaskPriorityLow, AgentTaskPriorityNormal, AgentTaskPriorityHigh} {
+		name := map[AgentTaskPriority]string{
+			AgentTaskPriorityLow:    "low",
+			AgentTaskPriorityNormal: "normal",
+			AgentTaskPriorityHigh:   "high",
+		}[priority]
+		task := NewAgentTask(name, testSequentialTaskType, log.handler(name))
+		task.SetPriority(priority)
+		assert.Nil(t, agent.AddTask(task))
+		tasks = append(tasks, task)
+	}
+	release <- true
+	for _, task := range tasks {
+		<-task.Done()
+	}
+	assert.Equal(t, []string{"high", "normal", "low"}, log.entries)
+}
+
+func TestSetQueueCapacity(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	assert.Equal(t, DefaultQueueCapacity, agent.GetQueueCapacity())
+	agent.SetQueueCapacity(1)
+	assert.Equal(t, 1, agent.GetQueueCapacity())
+	agent.SetQueueCapacity(0)
+	assert.Equal(t, DefaultQueueCapacity, agent.GetQueueCapacity())
+	agent.SetPriorityAgingInterval(time.Minute)
+	assert.Equal(t, time.Minute, agent.GetPriorityAgingInterval())
+}
+
+func TestAddTaskBlocksWhenQueueIsFull(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetQueueCapacity(1)
+	assert.Nil(t, agent.AddTask(NewAgentTask("first", testTaskType, testTaskHandlerWithResult)))
+	added := make(chan error)
+	second := NewAgentTask("second", testTaskType, testTaskHandlerWithResult)
+	go func() {
+		added <- agent.AddTask(second)
+	}()
+	select {
+	case <-added:
+		t.Fatal("AddTask did not block on a full queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+	agent.Start()
+	defer agent.Kill()
+	assert.Nil(t, <-added)
+	_, err := second.Await(context.Background())
+	assert.Nil(t, err)
+}
+
+func TestAddTaskForgetsTasksItCannotQueue(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetQueueCapacity(1)
+	agent.Start()
+	started := make(chan struct{})
+	assert.Nil(t, agent.AddTask(NewAgentTask("running", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		close(started)
+		<-ctx.Done()
+		return "", ctx.Err()
+	})))
+	<-started
+	assert.Nil(t, agent.AddTask(NewAgentTask("queued", testSequentialTaskType, testTaskHandlerWithResult)))
+	added := make(chan error)
+	blocked := NewAgentTask("blocked", testSequentialTaskType, testTaskHandlerWithResult)
+	go func() {
+		added <- agent.AddTask(blocked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	agent.Kill()
+	assert.ErrorIs(t, <-added, ErrAgentStopped)
+	_, exists := agent.GetTask(blocked.GetID())
+	assert.False(t, exists)
+	_, err := blocked.Await(context.Background())
+	assert.ErrorIs(t, err, ErrAgentStopped)
+}
@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type AgentTaskState string
+
+const (
+	AgentTaskStateQueued    AgentTaskState = "queued"
+	AgentTaskStateRunning   AgentTaskState = "running"
+	AgentTaskStateCompleted AgentTaskState = "completed"
+	AgentTaskStateFailed    AgentTaskState = "failed"
+	AgentTaskStateKilled    AgentTaskState = "killed"
+	AgentTaskStateSkipped   AgentTaskState = "skipped"
+)
+
+// IsFinished reports whether a task in this state will not run again.
+func (s AgentTaskState) IsFinished() bool {
+	switch s {
+	case AgentTaskStateCompleted, AgentTaskStateFailed, AgentTaskStateKilled, AgentTaskStateSkipped:
+		return true
+	}
+	return false
+}
+
+// AgentTaskRecord is a point-in-time snapshot of a task tracked by an Agent.
+// Records are copies, so holding on to one never races with the agent.
+type AgentTaskRecord struct {
+	ID         string
+	Name       string
+	Type       AgentTaskType
+	State      AgentTaskState
+	QueuedAt   time.Time
+	StartedAt  time.Time // Zero until the task starts
+	FinishedAt time.Time // Zero until the task finishes
+	Err        error     // Error reported by the task, if any
+	Attempts   int       // Number of times the handler ran
+	ParentID   string    // ID of the task that spawned this one, empty for top-level tasks
+	Task       IAgentTask
+}
+
+// GetDuration returns how long the task has been running, or how long it ran
+// if it has finished.
+func (r AgentTaskRecord) GetDuration() time.Duration {
+	if r.StartedAt.IsZero() {
+		return 0
+	}
+	if r.FinishedAt.IsZero() {
+		return time.Since(r.StartedAt)
+	}
+	return r.FinishedAt.Sub(r.StartedAt)
+}
+
+// agentTaskRegistry tracks the state of every task added to an agent. All
+// methods are safe for concurrent use.
+type agentTaskRegistry struct {
+	records map[string]*AgentTaskRecord
+	mutex   sync.RWMutex
+}
+
+func newAgentTaskRegistry() *agentTaskRegistry {
+	return &agentTaskRegistry{
+		records: make(map[string]*AgentTaskRecord),
+	}
+}
+
+// queue registers a task as queued. It reports false without error if the
+// task is already queued, and fails if the task is running or has finished.
+func (r *agentTaskRegistry) queue(task IAgentTask) (bool, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	record, exists := r.records[task.GetID()]
+	if exists {
+		if record.State == AgentTaskStateQueued {
+			return false, nil
+		}
+		return false, fmt.Errorf("task already exists with <ID: %s>", task.GetID())
+	}
+	r.records[task.GetID()] = &AgentTaskRecord{
+		ID:       task.GetID(),
+		Name:     task.GetName(),
+		Type:     task.GetType(),
+		State:    AgentTaskStateQueued,
+		QueuedAt: time.Now(),
+		ParentID: task.GetParentID(),
+		Task:     task,
+	}
+	return true, nil
+}
+
+// unqueue forgets a queued task that could not be added to a queue after
+// all. Tasks that left the queued state are left untouched, which is reported
+// by returning false.
+func (r *agentTaskRegistry) unqueue(task IAgentTask) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	record, exists := r.records[task.GetID()]
+	if !exists || record.State != AgentTaskStateQueued || record.Task != task {
+		return false
+	}
+	delete(r.records, task.GetID())
+	return true
+}
+
+// start moves a queued task to running. It fails if the task is not queued,
+// which keeps a task from being executed twice.
+func (r *agentTaskRegistry) start(task IAgentTask) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	record, exists := r.records[task.GetID()]
+	if !exists {
+		return fmt.Errorf("task <ID: %s> was never queued", task.GetID())
+	}
+	if record.State != AgentTaskStateQueued {
+		return fmt.Errorf("task <ID: %s> cannot start from state %s", task.GetID(), record.State)
+	}
+	record.State = AgentTaskStateRunning
+	record.StartedAt = time.Now()
+	return nil
+}
+
+// finish records the outcome of a task and returns its updated record. Tasks
+// already marked as killed keep that state, which is reported by returning
+// false.
+func (r *agentTaskRegistry) finish(task IAgentTask) (AgentTaskRecord, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	record, exists := r.records[task.GetID()]
+	if !exists || record.State == AgentTaskStateKilled {
+		return AgentTaskRecord{}, false
+	}
+	record.Err = task.GetError()
+	record.Attempts = task.GetAttempts()
+	switch {
+	case task.WasKilled():
+		record.State = AgentTaskStateKilled
+	case record.Err != nil:
+		record.State = AgentTaskStateFailed
+	default:
+		record.State = AgentTaskStateCompleted
+	}
+	record.FinishedAt = time.Now()
+	return *record, true
+}
+
+// skip records a task that will never run because of err. Tasks the registry
+// already tracks are left untouched, which is reported by returning false.
+func (r *agentTaskRegistry) skip(task IAgentTask, err error) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.records[task.GetID()]; exists {
+		return false
+	}
+	now := time.Now()
+	r.records[task.GetID()] = &AgentTaskRecord{
+		ID:         task.GetID(),
+		Name:       task.GetName(),
+		Type:       task.GetType(),
+		State:      AgentTaskStateSkipped,
+		QueuedAt:   now,
+		FinishedAt: now,
+		Err:        err,
+		Task:       task,
+	}
+	return true
+}
+
+// kill marks every queued and running task as killed and returns copies of
+// their records from before the kill, oldest first, so the caller can stop
+// them.
+func (r *agentTaskRegistry) kill() []AgentTaskRecord {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	killed := []AgentTaskRecord{}
+	for _, record := range r.records {
+		if record.State.IsFinished() {
+			continue
+		}
+		killed = append(killed, *record)
+		record.State = AgentTaskStateKilled
+		record.Err = ErrTaskKilled
+		record.FinishedAt = now
+	}
+	sort.Slice(killed, func(i, j int) bool {
+		return killed[i].QueuedAt.Before(killed[j].QueuedAt)
+	})
+	return killed
+}
+
+func (r *agentTaskRegistry) get(id string) (AgentTaskRecord, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	record, exists := r.records[id]
+	if !exists {
+		return AgentTaskRecord{}, false
+	}
+	return *record, true
+}
+
+// snapshot returns copies of the records matching filter, oldest first.
+func (r *agentTaskRegistry) snapshot(filter func(record *AgentTaskRecord) bool) []AgentTaskRecord {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	records := []AgentTaskRecord{}
+	for _, record := range r.records {
+		if filter == nil || filter(record) {
+			records = append(records, *record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].QueuedAt.Before(records[j].QueuedAt)
+	})
+	return records
+}
+
+func (r *agentTaskRegistry) taskMap(states ...AgentTaskState) AgentTaskMap {
+	taskMap := make(AgentTaskMap)
+	for _, record := range r.snapshot(matchTaskStates(states...)) {
+		task := record.Task
+		taskMap[record.ID] = &task
+	}
+	return taskMap
+}
+
+func matchTaskStates(states ...AgentTaskState) func(record *AgentTaskRecord) bool {
+	return func(record *AgentTaskRecord) bool {
+		for _, state := range states {
+			if record.State == state {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetTask(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testTaskType, testTaskHandlerWithResult)
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	_, err = task.Await(context.Background())
+	assert.Nil(t, err)
+	record, exists := agent.GetTask(task.GetID())
+	assert.True(t, exists)
+	assert.Equal(t, AgentTaskStateCompleted, record.State)
+	assert.Equal(t, "test", record.Name)
+	assert.Equal(t, testTaskType, record.Type)
+	assert.False(t, record.QueuedAt.IsZero())
+	assert.False(t, record.StartedAt.IsZero())
+	assert.False(t, record.FinishedAt.IsZero())
+	assert.False(t, record.FinishedAt.Before(record.StartedAt))
+	_, exists = agent.GetTask("missing")
+	assert.False(t, exists)
+}
+
+func TestGetTasksByState(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	succeeding := NewAgentTask("succeeding", testTaskType, testTaskHandlerWithResult)
+	failing := NewAgentTask("failing", testTaskType, testTaskHandlerWithError)
+	for _, task := range []*AgentTask[string]{succeeding, failing} {
+		err := agent.AddTask(task)
+		assert.Nil(t, err)
+		<-task.Done()
+	}
+	completed := agent.GetTasksByState(AgentTaskStateCompleted)
+	assert.Equal(t, 1, len(completed))
+	assert.Equal(t, succeeding.GetID(), completed[0].ID)
+	failed := agent.GetTasksByState(AgentTaskStateFailed)
+	assert.Equal(t, 1, len(failed))
+	assert.EqualError(t, failed[0].Err, "test error")
+	assert.Equal(t, 2, len(agent.GetCompletedTasks()))
+	assert.Equal(t, 2, len(agent.GetTasksByState(AgentTaskStateCompleted, AgentTaskStateFailed)))
+}
+
+func TestGetTasksByType(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	standardTask := NewAgentTask("standard", testTaskType, testTaskHandlerWithResult)
+	sequentialTask := NewAgentTask("sequential", testSequentialTaskType, testTaskHandlerWithResult)
+	for _, task := range []*AgentTask[string]{standardTask, sequentialTask} {
+		err := agent.AddTask(task)
+		assert.Nil(t, err)
+		<-task.Done()
+	}
+	records := agent.GetTasksByType(testSequentialTaskType)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, sequentialTask.GetID(), records[0].ID)
+	assert.Equal(t, 2, len(agent.GetTaskRecords()))
+}
+
+func TestKillMarksQueuedAndRunningTasks(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	started := make(chan bool)
+	runningTask := NewAgentTask("running", testSequentialTaskType, func(ctx context.Context) (string, error) {
+		started <- true
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	queuedTask := NewAgentTask("queued", testSequentialTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(runningTask))
+	assert.Nil(t, agent.AddTask(queuedTask))
+	<-started
+	agent.Kill()
+	_, err := queuedTask.Await(context.Background())
+	assert.Equal(t, ErrTaskKilled, err)
+	_, err = runningTask.Await(context.Background())
+	assert.Equal(t, ErrTaskKilled, err)
+	assert.Equal(t, 2, len(agent.GetKilledTasks()))
+	assert.Equal(t, 0, len(agent.GetRunningTasks()))
+}
+
+func TestTaskSnapshotsAreCopies(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	task := NewAgentTask("test", testTaskType, testTaskHandlerWithResult)
+	err := agent.AddTask(task)
+	assert.Nil(t, err)
+	<-task.Done()
+	completedTasks := agent.GetCompletedTasks()
+	delete(completedTasks, task.GetID())
+	assert.Equal(t, 1, len(agent.GetCompletedTasks()))
+	records := agent.GetTaskRecords()
+	records[0].State = AgentTaskStateFailed
+	record, _ := agent.GetTask(task.GetID())
+	assert.Equal(t, AgentTaskStateCompleted, record.State)
+}
+
+func TestConcurrentTaskRegistryAccess(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			taskType := testTaskType
+			if i%2 == 0 {
+				taskType = testSequentialTaskType
+			}
+			task := NewAgentTask(fmt.Sprintf("task#%d", i), taskType, testTaskHandlerWithResult)
+			assert.Nil(t, agent.AddTask(task))
+			_ = agent.GetRunningTasks()
+			_ = agent.GetTaskRecords()
+			_ = agent.IsRunning()
+			<-task.Done()
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 20, len(agent.GetCompletedTasks()))
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how often a failing task handler is re-run and how
+// long to wait between attempts. Policies can be set on individual tasks with
+// SetRetryPolicy or on every task of a type with Agent.SetTaskTypeRetryPolicy;
+// a task's own policy takes precedence.
+type RetryPolicy struct {
+	MaxAttempts    int                  // Total attempts including the first, values below 2 disable retries
+	InitialBackoff time.Duration        // Wait before the first retry
+	MaxBackoff     time.Duration        // Upper bound for the wait, zero for no bound
+	Multiplier     float64              // Backoff growth per retry, defaults to 2
+	Jitter         float64              // Fraction of the backoff randomised in both directions, between 0 and 1
+	Retryable      func(err error) bool // Reports whether err may be retried, nil retries every error
+}
+
+// NewRetryPolicy creates a RetryPolicy with exponential backoff that doubles
+// after every attempt, capped at 30 seconds, with 20% jitter.
+func NewRetryPolicy(maxAttempts int, initialBackoff time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:    maxAttempts,
+		InitialBackoff: initialBackoff,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// WithRetryable returns a copy of the policy that only retries errors for
+// which retryable reports true.
+func (p RetryPolicy) WithRetryable(retryable func(err error) bool) *RetryPolicy {
+	p.Retryable = retryable
+	return &p
+}
+
+// Backoff returns how long to wait after the given attempt failed, where the
+// first attempt is 1.
+func (p *RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+		if p.MaxBackoff > 0 && backoff >= float64(p.MaxBackoff) {
+			break
+		}
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		backoff += backoff * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// ShouldRetry reports whether another attempt should follow the given failed
+// attempt. Kills, cancellations and panics are never retried.
+func (p *RetryPolicy) ShouldRetry(attempt int, err error) bool {
+	if p == nil || err == nil || attempt >= p.MaxAttempts {
+		return false
+	}
+	if errors.Is(err, ErrTaskKilled) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var panicErr *AgentTaskPanicError
+	if errors.As(err, &panicErr) {
+		return false
+	}
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// Do calls fn until it succeeds, the policy gives up or ctx is done, sleeping
+// between attempts. It returns the error of every failed attempt alongside
+// the final error. A nil policy calls fn once.
+func (p *RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) ([]error, error) {
+	var attemptErrors []error
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return attemptErrors, nil
+		}
+		attemptErrors = append(attemptErrors, err)
+		if !p.ShouldRetry(attempt, err) || ctx.Err() != nil {
+			return attemptErrors, err
+		}
+		timer := time.NewTimer(p.Backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attemptErrors, err
+		}
+	}
+}
+
+// SetTaskTypeRetryPolicy sets the retry policy used by tasks of the given
+// type that do not carry their own. A nil policy removes it.
+func (a *Agent) SetTaskTypeRetryPolicy(taskType AgentTaskType, policy *RetryPolicy) {
+	a.retryPolicyMutex.Lock()
+	defer a.retryPolicyMutex.Unlock()
+	if policy == nil {
+		delete(a.retryPolicies, taskType)
+		return
+	}
+	a.retryPolicies[taskType] = policy
+}
+
+// GetTaskTypeRetryPolicy returns the retry policy for the given task type, or
+// nil if none is set.
+func (a *Agent) GetTaskTypeRetryPolicy(taskType AgentTaskType) *RetryPolicy {
+	a.retryPolicyMutex.RLock()
+	defer a.retryPolicyMutex.RUnlock()
+	return a.retryPolicies[taskType]
+}
+
+// getRetryPolicy returns the policy that applies to task.
+func (a *Agent) getRetryPolicy(task IAgentTask) *RetryPolicy {
+	policy := task.GetRetryPolicy()
+	if policy != nil {
+		return policy
+	}
+	return a.GetTaskTypeRetryPolicy(task.GetType())
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errTransient = errors.New("transient error")
+
+// flakyHandler fails with errTransient until it has been called failures
+// times, then succeeds.
+func flakyHandler(failures int32, calls *int32) HandlerFunction[string] {
+	return func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			return "", errTransient
+		}
+		return "test", nil
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := NewRetryPolicy(5, 10*time.Millisecond)
+	policy.Jitter = 0
+	policy.MaxBackoff = 30 * time.Millisecond
+	assert.Equal(t, 10*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 20*time.Millisecond, policy.Backoff(2))
+	assert.Equal(t, 30*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, 30*time.Millisecond, policy.Backoff(10))
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		backoff := policy.Backoff(1)
+		assert.GreaterOrEqual(t, backoff, 5*time.Millisecond)
+		assert.LessOrEqual(t, backoff, 15*time.Millisecond)
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	policy := NewRetryPolicy(3, time.Millisecond)
+	assert.True(t, policy.ShouldRetry(1, errTransient))
+	assert.True(t, policy.ShouldRetry(2, errTransient))
+	assert.False(t, policy.ShouldRetry(3, errTransient))
+	assert.False(t, policy.ShouldRetry(1, nil))
+	assert.False(t, policy.ShouldRetry(1, ErrTaskKilled))
+	assert.False(t, policy.ShouldRetry(1, context.Canceled))
+	policy = policy.WithRetryable(func(err error) bool {
+		return errors.Is(err, errTransient)
+	})
+	assert.True(t, policy.ShouldRetry(1, errTransient))
+	assert.False(t, policy.ShouldRetry(1, errors.New("permanent error")))
+	var nilPolicy *RetryPolicy
+	assert.False(t, nilPolicy.ShouldRetry(1, errTransient))
+}
+
+func TestTaskWithRetryPolicy(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, flakyHandler(2, &calls))
+	task.SetRetryPolicy(NewRetryPolicy(3, time.Millisecond))
+	assert.Nil(t, agent.AddTask(task))
+	result, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result)
+	assert.Equal(t, 3, task.GetAttempts())
+	assert.Equal(t, []error{errTransient, errTransient}, task.GetAttemptErrors())
+	record, _ := agent.GetTask(task.GetID())
+	assert.Equal(t, AgentTaskStateCompleted, record.State)
+	assert.Equal(t, 3, record.Attempts)
+}
+
+func TestTaskRetriesExhausted(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, flakyHandler(5, &calls))
+	task.SetRetryPolicy(NewRetryPolicy(3, time.Millisecond))
+	assert.Nil(t, agent.AddTask(task))
+	_, err := task.Await(context.Background())
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, 3, len(task.GetAttemptErrors()))
+	record, _ := agent.GetTask(task.GetID())
+	assert.Equal(t, AgentTaskStateFailed, record.State)
+}
+
+func TestTaskNotRetriedForNonRetryableError(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, flakyHandler(5, &calls))
+	task.SetRetryPolicy(NewRetryPolicy(3, time.Millisecond).WithRetryable(func(err error) bool {
+		return false
+	}))
+	assert.Nil(t, agent.AddTask(task))
+	_, err := task.Await(context.Background())
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, task.GetAttempts())
+}
+
+func TestTaskTypeRetryPolicy(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	policy := NewRetryPolicy(3, time.Millisecond)
+	agent.SetTaskTypeRetryPolicy(testSequentialTaskType, policy)
+	assert.Equal(t, policy, agent.GetTaskTypeRetryPolicy(testSequentialTaskType))
+	assert.Nil(t, agent.GetTaskTypeRetryPolicy(testTaskType))
+	agent.Start()
+	defer agent.Kill()
+	var sequentialCalls, standardCalls int32
+	sequentialTask := NewAgentTask("sequential", testSequentialTaskType, flakyHandler(1, &sequentialCalls))
+	standardTask := NewAgentTask("standard", testTaskType, flakyHandler(1, &standardCalls))
+	assert.Nil(t, agent.AddTask(sequentialTask))
+	assert.Nil(t, agent.AddTask(standardTask))
+	_, err := sequentialTask.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, sequentialTask.GetAttempts())
+	_, err = standardTask.Await(context.Background())
+	assert.Equal(t, errTransient, err)
+	assert.Equal(t, 1, standardTask.GetAttempts())
+	agent.SetTaskTypeRetryPolicy(testSequentialTaskType, nil)
+	assert.Nil(t, agent.GetTaskTypeRetryPolicy(testSequentialTaskType))
+}
+
+func TestKillDuringRetryBackoff(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, flakyHandler(5, &calls))
+	task.SetRetryPolicy(NewRetryPolicy(3, time.Hour))
+	assert.Nil(t, agent.AddTask(task))
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	agent.Kill()
+	_, err := task.Await(context.Background())
+	assert.Equal(t, ErrTaskKilled, err)
+	assert.Equal(t, 1, task.GetAttempts())
+}
+
+func TestTimeoutAppliesPerAttempt(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	var calls int32
+	task := NewAgentTask("test", testTaskType, func(ctx context.Context) (string, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return "test", nil
+	})
+	task.SetTimeout(10 * time.Millisecond)
+	task.SetRetryPolicy(NewRetryPolicy(2, time.Millisecond))
+	assert.Nil(t, agent.AddTask(task))
+	result, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result)
+	assert.Equal(t, []error{context.DeadlineExceeded}, task.GetAttemptErrors())
+}
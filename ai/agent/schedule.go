@@ -0,0 +1,284 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type AgentScheduleKind string
+
+const (
+	AgentScheduleKindOnce     AgentScheduleKind = "once"
+	AgentScheduleKindInterval AgentScheduleKind = "interval"
+	AgentScheduleKindCron     AgentScheduleKind = "cron"
+)
+
+// AgentTaskBuilder creates the task for one run of a schedule. Tasks can only
+// run once, so recurring schedules build a new one every time.
+type AgentTaskBuilder func() (IAgentTask, error)
+
+// AgentScheduleRecord is a point-in-time snapshot of a schedule registered
+// on an Agent.
+type AgentScheduleRecord struct {
+	ID         string
+	Name       string
+	Kind       AgentScheduleKind
+	Spec       string // Run time, interval or cron expression
+	CreatedAt  time.Time
+	NextRun    time.Time
+	LastRun    time.Time // Zero until the schedule first fires
+	Runs       int       // Number of tasks added by the schedule
+	Skipped    int       // Runs skipped because the previous task was still unfinished
+	LastTaskID string    // ID of the last task added by the schedule
+	Err        error     // Error from the last attempt to build or add a task
+}
+
+// agentSchedule is a schedule with the state needed to run it.
+type agentSchedule struct {
+	record   AgentScheduleRecord
+	next     func(previous time.Time) time.Time // Returns the zero time once the schedule is done
+	build    AgentTaskBuilder
+	lastTask IAgentTask
+	cancel   context.CancelFunc
+}
+
+// agentScheduler tracks the schedules of an agent. All methods are safe for
+// concurrent use.
+type agentScheduler struct {
+	schedules map[string]*agentSchedule
+	mutex     sync.Mutex
+}
+
+func newAgentScheduler() *agentScheduler {
+	return &agentScheduler{
+		schedules: make(map[string]*agentSchedule),
+	}
+}
+
+func (s *agentScheduler) add(schedule *agentSchedule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.schedules[schedule.record.ID] = schedule
+}
+
+// remove drops a schedule and cancels it, reporting false if it was not
+// registered.
+func (s *agentScheduler) remove(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schedule, exists := s.schedules[id]
+	if !exists {
+		return false
+	}
+	schedule.cancel()
+	delete(s.schedules, id)
+	return true
+}
+
+func (s *agentScheduler) cancelAll() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	cancelled := len(s.schedules)
+	for id, schedule := range s.schedules {
+		schedule.cancel()
+		delete(s.schedules, id)
+	}
+	return cancelled
+}
+
+// update applies fn to a registered schedule, reporting false if the
+// schedule was cancelled.
+func (s *agentScheduler) update(id string, fn func(schedule *agentSchedule)) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	schedule, exists := s.schedules[id]
+	if !exists {
+		return false
+	}
+	fn(schedule)
+	return true
+}
+
+func (s *agentScheduler) snapshot() []AgentScheduleRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	records := make([]AgentScheduleRecord, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		records = append(records, schedule.record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NextRun.Before(records[j].NextRun)
+	})
+	return records
+}
+
+// ScheduleAt adds the task built by build to the agent at the given time.
+func (a *Agent) ScheduleAt(name string, at time.Time, build AgentTaskBuilder) (string, error) {
+	next := func(previous time.Time) time.Time {
+		if previous.IsZero() {
+			return at
+		}
+		return time.Time{}
+	}
+	return a.schedule(name, AgentScheduleKindOnce, at.Format(time.RFC3339), next, build)
+}
+
+// ScheduleEvery adds a task built by build to the agent every interval,
+// starting one interval from now.
+func (a *Agent) ScheduleEvery(name string, interval time.Duration, build AgentTaskBuilder) (string, error) {
+	if interval <= 0 {
+		return "", fmt.Errorf("schedule %s: interval must be positive, got %s", name, interval)
+	}
+	start := time.Now()
+	next := func(previous time.Time) time.Time {
+		if previous.IsZero() {
+			return start.Add(interval)
+		}
+		return previous.Add(interval)
+	}
+	return a.schedule(name, AgentScheduleKindInterval, interval.String(), next, build)
+}
+
+// ScheduleCron adds a task built by build to the agent at every time matching
+// the five field cron expression, in local time. See CronExpression for the
+// supported syntax.
+func (a *Agent) ScheduleCron(name string, expression string, build AgentTaskBuilder) (string, error) {
+	cron, err := ParseCronExpression(expression)
+	if err != nil {
+		return "", err
+	}
+	start := time.Now()
+	if cron.Next(start).IsZero() {
+		return "", fmt.Errorf("schedule %s: cron expression %q never matches", name, expression)
+	}
+	next := func(previous time.Time) time.Time {
+		if previous.IsZero() {
+			return cron.Next(start)
+		}
+		return cron.Next(previous)
+	}
+	return a.schedule(name, AgentScheduleKindCron, cron.String(), next, build)
+}
+
+// schedule registers a schedule and starts the goroutine that runs it. It
+// returns the schedule's ID.
+func (a *Agent) schedule(name string, kind AgentScheduleKind, spec string, next func(previous time.Time) time.Time, build AgentTaskBuilder) (string, error) {
+	if build == nil {
+		return "", fmt.Errorf("schedule %s: task builder is nil", name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	schedule := &agentSchedule{
+		record: AgentScheduleRecord{
+			ID:        generateUUID(),
+			Name:      name,
+			Kind:      kind,
+			Spec:      spec,
+			CreatedAt: time.Now(),
+			NextRun:   next(time.Time{}),
+		},
+		next:   next,
+		build:  build,
+		cancel: cancel,
+	}
+	a.schedules.add(schedule)
+	zap.S().Infof("Scheduled %s <ID: %s, Name: %s> (%s) on agent <ID: %s, Name: %s>, next run at %s", kind, schedule.record.ID, name, spec, a.GetID(), a.GetName(), schedule.record.NextRun.Format(time.RFC3339))
+	go a.runSchedule(ctx, schedule.record.ID, schedule.record.NextRun)
+	return schedule.record.ID, nil
+}
+
+// runSchedule waits for each run of a schedule and fires it until the
+// schedule is done or cancelled.
+func (a *Agent) runSchedule(ctx context.Context, id string, nextRun time.Time) {
+	for !nextRun.IsZero() {
+		timer := time.NewTimer(time.Until(nextRun))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		scheduled := nextRun
+		var following time.Time
+		exists := a.schedules.update(id, func(schedule *agentSchedule) {
+			following = schedule.next(scheduled)
+			if now := time.Now(); !following.IsZero() && following.Before(now) {
+				// Runs missed while the agent was busy are not caught up on.
+				following = schedule.next(now)
+			}
+			schedule.record.NextRun = following
+		})
+		if !exists {
+			return
+		}
+		a.fireSchedule(id)
+		nextRun = following
+	}
+	a.schedules.remove(id)
+}
+
+// fireSchedule builds and adds the task for one run of a schedule. Recurring
+// schedules skip a run while the task added by the previous one is
+// unfinished, so slow tasks do not pile up.
+func (a *Agent) fireSchedule(id string) {
+	var build AgentTaskBuilder
+	var name string
+	skip := false
+	a.schedules.update(id, func(schedule *agentSchedule) {
+		name = schedule.record.Name
+		build = schedule.build
+		if schedule.lastTask != nil && !schedule.lastTask.IsCompleted() {
+			schedule.record.Skipped++
+			skip = true
+		}
+	})
+	if build == nil {
+		return
+	}
+	if skip {
+		zap.S().Infof("Skipping run of schedule <ID: %s, Name: %s> on agent <ID: %s, Name: %s>, previous task is unfinished", id, name, a.GetID(), a.GetName())
+		return
+	}
+	task, err := build()
+	if err == nil {
+		zap.S().Infof("Schedule <ID: %s, Name: %s> adding task <ID: %s, Name: %s> to agent <ID: %s, Name: %s>", id, name, task.GetID(), task.GetName(), a.GetID(), a.GetName())
+		err = a.AddTask(task)
+	}
+	if err != nil {
+		zap.S().Errorf("Schedule <ID: %s, Name: %s> on agent <ID: %s, Name: %s> failed to add a task: %v", id, name, a.GetID(), a.GetName(), err)
+	}
+	a.schedules.update(id, func(schedule *agentSchedule) {
+		schedule.record.LastRun = time.Now()
+		schedule.record.Err = err
+		if err == nil {
+			schedule.lastTask = task
+			schedule.record.Runs++
+			schedule.record.LastTaskID = task.GetID()
+		}
+	})
+}
+
+// GetSchedules returns snapshots of the agent's active schedules, soonest
+// first. Schedules that ran for the last time or were cancelled are not
+// listed.
+func (a *Agent) GetSchedules() []AgentScheduleRecord {
+	return a.schedules.snapshot()
+}
+
+// CancelSchedule cancels the schedule with the given ID, reporting false if
+// there is no such active schedule. Tasks it already added are not affected.
+func (a *Agent) CancelSchedule(id string) bool {
+	return a.schedules.remove(id)
+}
+
+// cancelSchedules cancels every schedule of the agent.
+func (a *Agent) cancelSchedules() {
+	cancelled := a.schedules.cancelAll()
+	if cancelled > 0 {
+		zap.S().Infof("Cancelled %d schedules on agent <ID: %s, Name: %s>", cancelled, a.GetID(), a.GetName())
+	}
+}
@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingBuilder returns a task builder whose tasks signal ran when they run.
+func countingBuilder(ran chan<- string) AgentTaskBuilder {
+	return func() (IAgentTask, error) {
+		task := NewAgentTask("scheduled", testTaskType, func(ctx context.Context) (string, error) {
+			ran <- "scheduled"
+			return "scheduled", nil
+		})
+		return task, nil
+	}
+}
+
+func awaitRun(t *testing.T, ran <-chan string) {
+	select {
+	case <-ran:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduled task did not run")
+	}
+}
+
+func TestScheduleAt(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	ran := make(chan string, 1)
+	id, err := agent.ScheduleAt("once", time.Now().Add(20*time.Millisecond), countingBuilder(ran))
+	assert.Nil(t, err)
+	schedules := agent.GetSchedules()
+	assert.Len(t, schedules, 1)
+	assert.Equal(t, id, schedules[0].ID)
+	assert.Equal(t, AgentScheduleKindOnce, schedules[0].Kind)
+	awaitRun(t, ran)
+	assert.Eventually(t, func() bool { return len(agent.GetSchedules()) == 0 }, time.Second, 5*time.Millisecond)
+	assert.False(t, agent.CancelSchedule(id))
+}
+
+func TestScheduleEvery(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	ran := make(chan string, 10)
+	id, err := agent.ScheduleEvery("recurring", 10*time.Millisecond, countingBuilder(ran))
+	assert.Nil(t, err)
+	awaitRun(t, ran)
+	awaitRun(t, ran)
+	schedules := agent.GetSchedules()
+	assert.Len(t, schedules, 1)
+	assert.GreaterOrEqual(t, schedules[0].Runs, 2)
+	assert.NotEmpty(t, schedules[0].LastTaskID)
+	assert.True(t, agent.CancelSchedule(id))
+	assert.Empty(t, agent.GetSchedules())
+	_, err = agent.ScheduleEvery("invalid", 0, countingBuilder(ran))
+	assert.NotNil(t, err)
+}
+
+func TestScheduleSkipsRunWhilePreviousTaskIsUnfinished(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	_, err := agent.ScheduleEvery("slow", 5*time.Millisecond, func() (IAgentTask, error) {
+		return NewAgentTask("slow", testTaskType, testTaskHandlerWaitForever), nil
+	})
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		schedules := agent.GetSchedules()
+		return len(schedules) == 1 && schedules[0].Skipped >= 2
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 1, agent.GetSchedules()[0].Runs)
+}
+
+func TestScheduleCron(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	ran := make(chan string, 1)
+	_, err := agent.ScheduleCron("invalid", "* * *", countingBuilder(ran))
+	assert.NotNil(t, err)
+	_, err = agent.ScheduleCron("never", "0 0 31 2 *", countingBuilder(ran))
+	assert.NotNil(t, err)
+	_, err = agent.ScheduleCron("hourly", "0 * * * *", countingBuilder(ran))
+	assert.Nil(t, err)
+	schedules := agent.GetSchedules()
+	assert.Len(t, schedules, 1)
+	assert.Equal(t, AgentScheduleKindCron, schedules[0].Kind)
+	assert.Equal(t, "0 * * * *", schedules[0].Spec)
+	assert.Equal(t, 0, schedules[0].NextRun.Minute())
+	assert.True(t, schedules[0].NextRun.After(time.Now()))
+}
+
+func TestStopAndKillCancelSchedules(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	ran := make(chan string, 10)
+	_, err := agent.ScheduleEvery("recurring", time.Hour, countingBuilder(ran))
+	assert.Nil(t, err)
+	_, err = agent.ScheduleAt("once", time.Now().Add(time.Hour), countingBuilder(ran))
+	assert.Nil(t, err)
+	assert.Len(t, agent.GetSchedules(), 2)
+	agent.Stop()
+	assert.Empty(t, agent.GetSchedules())
+	agent.Start()
+	_, err = agent.ScheduleEvery("recurring", time.Hour, countingBuilder(ran))
+	assert.Nil(t, err)
+	agent.Kill()
+	assert.Empty(t, agent.GetSchedules())
+}
@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// ErrNoParentTask is returned by SpawnSubTask when the context was not passed
+// to a task handler by an agent.
+var ErrNoParentTask = errors.New("context does not belong to an agent task")
+
+type agentTaskContextKey struct{}
+
+// agentTaskContext identifies the task whose handler received a context and
+// the agent running it.
+type agentTaskContext struct {
+	agent *Agent
+	task  IAgentTask
+}
+
+func contextWithTask(ctx context.Context, agent *Agent, task IAgentTask) context.Context {
+	return context.WithValue(ctx, agentTaskContextKey{}, agentTaskContext{agent: agent, task: task})
+}
+
+// TaskFromContext returns the task whose handler received ctx.
+func TaskFromContext(ctx context.Context) (IAgentTask, bool) {
+	taskContext, ok := ctx.Value(agentTaskContextKey{}).(agentTaskContext)
+	return taskContext.task, ok
+}
+
+// SpawnSubTask adds child to the agent running the task whose handler
+// received ctx, as a sub-task of that task. Killing the parent kills all of
+// its descendants. Sequential parents must not wait on sequential children of
+// their own type, as those only run once the parent returns.
+func SpawnSubTask(ctx context.Context, child IAgentTask) error {
+	taskContext, ok := ctx.Value(agentTaskContextKey{}).(agentTaskContext)
+	if !ok {
+		return ErrNoParentTask
+	}
+	return taskContext.agent.AddSubTask(taskContext.task, child)
+}
+
+// AddSubTask adds child to the agent as a sub-task of parent. The link is
+// recorded on both tasks and in child's AgentTaskRecord, and killing parent
+// kills child along with its own sub-tasks. It fails if parent was killed.
+func (a *Agent) AddSubTask(parent IAgentTask, child IAgentTask) error {
+	child.setParentID(parent.GetID())
+	err := parent.addSubTask(child)
+	if err != nil {
+		return err
+	}
+	zap.S().Infof("Spawning sub-task <ID: %s, Name: %s> of task <ID: %s, Name: %s> on agent <ID: %s, Name: %s>", child.GetID(), child.GetName(), parent.GetID(), parent.GetName(), a.GetID(), a.GetName())
+	return a.AddTask(child)
+}
+
+// GetSubTasks returns snapshots of the tasks spawned by the task with the
+// given ID, oldest first.
+func (a *Agent) GetSubTasks(parentID string) []AgentTaskRecord {
+	return a.tasks.snapshot(func(record *AgentTaskRecord) bool {
+		return record.ParentID == parentID
+	})
+}
+
+// AwaitAll waits for every task and returns their results in the same order.
+// The error is that of the first task, in argument order, that failed. It
+// returns early with ctx's error if ctx is done first.
+func AwaitAll[T any](ctx context.Context, tasks ...*AgentTask[T]) ([]T, error) {
+	results := make([]T, len(tasks))
+	var firstErr error
+	for i, task := range tasks {
+		select {
+		case <-task.Done():
+		case <-ctx.Done():
+			return results, ctx.Err()
+		}
+		result, err := task.Result()
+		results[i] = result
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("task <ID: %s, Name: %s>: %w", task.GetID(), task.GetName(), err)
+		}
+	}
+	return results, firstErr
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpawnSubTasksAggregatesResults(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	research := NewAgentTask("research", testTaskType, func(ctx context.Context) (string, error) {
+		parent, ok := TaskFromContext(ctx)
+		if !ok || parent.GetName() != "research" {
+			return "", errors.New("missing parent task in context")
+		}
+		searches := []*AgentTask[string]{}
+		for i := 0; i < 3; i++ {
+			name := fmt.Sprintf("search#%d", i)
+			search := NewAgentTask(name, testTaskType, func(ctx context.Context) (string, error) {
+				return name, nil
+			})
+			err := SpawnSubTask(ctx, search)
+			if err != nil {
+				return "", err
+			}
+			searches = append(searches, search)
+		}
+		results, err := AwaitAll(ctx, searches...)
+		return strings.Join(results, ","), err
+	})
+	assert.Nil(t, agent.AddTask(research))
+	result, err := research.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "search#0,search#1,search#2", result)
+	assert.Len(t, research.GetSubTasks(), 3)
+	records := agent.GetSubTasks(research.GetID())
+	assert.Len(t, records, 3)
+	for _, record := range records {
+		assert.Equal(t, research.GetID(), record.ParentID)
+		assert.Equal(t, research.GetID(), record.Task.GetParentID())
+	}
+	record, exists := agent.GetTask(research.GetID())
+	assert.True(t, exists)
+	assert.Equal(t, "", record.ParentID)
+}
+
+func TestKillCascadesToSubTasks(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	started := make(chan IAgentTask, 2)
+	blockUntilKilled := func(ctx context.Context) (string, error) {
+		task, _ := TaskFromContext(ctx)
+		started <- task
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	grandchild := NewAgentTask("grandchild", testTaskType, blockUntilKilled)
+	child := NewAgentTask("child", testTaskType, func(ctx context.Context) (string, error) {
+		err := SpawnSubTask(ctx, grandchild)
+		if err != nil {
+			return "", err
+		}
+		return blockUntilKilled(ctx)
+	})
+	parent := NewAgentTask("parent", testTaskType, func(ctx context.Context) (string, error) {
+		err := SpawnSubTask(ctx, child)
+		if err != nil {
+			return "", err
+		}
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+	assert.Nil(t, agent.AddTask(parent))
+	<-started
+	<-started
+	parent.Kill()
+	_, err := AwaitAll(context.Background(), parent, child, grandchild)
+	assert.True(t, errors.Is(err, ErrTaskKilled))
+	for _, task := range []*AgentTask[string]{parent, child, grandchild} {
+		assert.True(t, task.WasKilled())
+		assert.Equal(t, ErrTaskKilled, task.GetError())
+	}
+	assert.Equal(t, []IAgentTask{grandchild}, child.GetSubTasks())
+}
+
+func TestSpawnSubTaskErrors(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.Start()
+	defer agent.Kill()
+	orphan := NewAgentTask("orphan", testTaskType, testTaskHandlerWithResult)
+	assert.Equal(t, ErrNoParentTask, SpawnSubTask(context.Background(), orphan))
+	parent := NewAgentTask("parent", testTaskType, testTaskHandlerWithResult)
+	parent.Kill()
+	err := agent.AddSubTask(parent, orphan)
+	assert.True(t, errors.Is(err, ErrTaskKilled))
+	assert.Empty(t, parent.GetSubTasks())
+}
+
+func TestAwaitAllContextDone(t *testing.T) {
+	task := NewAgentTask("never run", testTaskType, testTaskHandlerWithResult)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := AwaitAll(ctx, task)
+	assert.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,466 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrUnknownAgent is returned when a supervisor has no agent of the given
+	// name.
+	ErrUnknownAgent = errors.New("unknown agent")
+	// ErrNoMessageHandler is returned when a message is sent to an agent that
+	// has no handler for its type.
+	ErrNoMessageHandler = errors.New("no handler for message type")
+)
+
+// SupervisedAgent is the part of an agent a supervisor needs. It is
+// implemented by *Agent and by agents embedding it, such as *ChatAgent.
+type SupervisedAgent interface {
+	GetID() string
+	GetName() string
+	IsRunning() bool
+	Start()
+	Shutdown(ctx context.Context) (AgentShutdownReport, error)
+	Kill()
+	AddTask(task IAgentTask) error
+	Subscribe(buffer int) (<-chan AgentEvent, func())
+}
+
+// SupervisorRestartPolicy controls when a supervisor restarts an agent that
+// crashed. An agent crashes when it stops or is killed without the
+// supervisor asking it to, or, with RestartOnPanic set, when one of its task
+// handlers panics.
+type SupervisorRestartPolicy struct {
+	MaxRestarts    int           // Restarts allowed within Window, zero never restarts
+	Window         time.Duration // Period over which restarts are counted, zero for the supervisor's lifetime
+	Backoff        time.Duration // Wait before restarting
+	RestartOnPanic bool          // Treat a panicking task handler as a crash
+}
+
+// NewSupervisorRestartPolicy creates a policy that restarts a crashed agent
+// up to maxRestarts times within window, without backoff.
+func NewSupervisorRestartPolicy(maxRestarts int, window time.Duration) *SupervisorRestartPolicy {
+	return &SupervisorRestartPolicy{
+		MaxRestarts: maxRestarts,
+		Window:      window,
+	}
+}
+
+// allows reports whether another restart fits within the policy given the
+// times of the previous ones.
+func (p *SupervisorRestartPolicy) allows(restarts []time.Time, now time.Time) bool {
+	if p == nil || p.MaxRestarts <= 0 {
+		return false
+	}
+	count := 0
+	for _, restart := range restarts {
+		if p.Window <= 0 || now.Sub(restart) < p.Window {
+			count++
+		}
+	}
+	return count < p.MaxRestarts
+}
+
+// AgentMessageType identifies the kind of message routed between supervised
+// agents, such as "requirements" or "research_request".
+type AgentMessageType string
+
+// AgentMessage is a message routed between the agents of a supervisor.
+type AgentMessage struct {
+	ID      string
+	From    string // Name of the sending agent, empty when sent from outside the supervisor
+	To      string // Name of the receiving agent
+	Type    AgentMessageType
+	Payload interface{}
+	SentAt  time.Time
+}
+
+// AgentMessageHandler handles messages delivered to a supervised agent. It
+// runs as a task on the receiving agent, and its result becomes the result of
+// the task returned by AgentSupervisor.Send.
+type AgentMessageHandler func(ctx context.Context, msg AgentMessage) (interface{}, error)
+
+// SupervisedAgentStatus is a point-in-time snapshot of an agent owned by a
+// supervisor.
+type SupervisedAgentStatus struct {
+	Name      string
+	AgentID   string
+	Running   bool
+	Restarts  int       // Number of times the supervisor restarted the agent
+	LastCrash time.Time // Zero if the agent never crashed
+	CrashErr  error     // Cause of the last crash
+	GaveUp    bool      // Whether the restart policy was exhausted
+}
+
+type supervisedAgent struct {
+	name        string
+	agent       SupervisedAgent
+	policy      *SupervisorRestartPolicy
+	handlers    map[AgentMessageType]AgentMessageHandler
+	restarts    []time.Time
+	status      SupervisedAgentStatus
+	unsubscribe func()
+}
+
+// AgentSupervisor owns a set of named agents. It starts and stops them
+// together, restarts the ones that crash according to their restart policy
+// and routes messages between them. All methods are safe for concurrent use.
+type AgentSupervisor struct {
+	name      string
+	agents    map[string]*supervisedAgent
+	order     []string // Agent names in the order they were added
+	isRunning bool
+	ctx       context.Context
+	cancel    context.CancelFunc
+	watchers  sync.WaitGroup
+	mutex     sync.Mutex
+}
+
+// NewAgentSupervisor creates a supervisor without agents. Add agents with
+// AddAgent and start them with Start.
+func NewAgentSupervisor(name string) *AgentSupervisor {
+	return &AgentSupervisor{
+		name:   name,
+		agents: make(map[string]*supervisedAgent),
+		cancel: func() {},
+	}
+}
+
+func (s *AgentSupervisor) GetName() string {
+	return s.name
+}
+
+func (s *AgentSupervisor) IsRunning() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.isRunning
+}
+
+// AddAgent puts agent under the supervisor's control under name. A nil
+// policy never restarts the agent. If the supervisor is running, the agent is
+// started right away.
+func (s *AgentSupervisor) AddAgent(name string, agent SupervisedAgent, policy *SupervisorRestartPolicy) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.agents[name]; exists {
+		return fmt.Errorf("supervisor %s already has an agent named %s", s.name, name)
+	}
+	supervised := &supervisedAgent{
+		name:     name,
+		agent:    agent,
+		policy:   policy,
+		handlers: make(map[AgentMessageType]AgentMessageHandler),
+		status: SupervisedAgentStatus{
+			Name:    name,
+			AgentID: agent.GetID(),
+		},
+	}
+	s.agents[name] = supervised
+	s.order = append(s.order, name)
+	zap.S().Infof("Supervisor %s supervising agent <ID: %s, Name: %s> as %s", s.name, agent.GetID(), agent.GetName(), name)
+	if s.isRunning {
+		s.startAgentLocked(supervised)
+	}
+	return nil
+}
+
+// GetAgent returns the agent added under name.
+func (s *AgentSupervisor) GetAgent(name string) (SupervisedAgent, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	supervised, exists := s.agents[name]
+	if !exists {
+		return nil, false
+	}
+	return supervised.agent, true
+}
+
+// GetAgentNames returns the names of the supervised agents in the order they
+// were added.
+func (s *AgentSupervisor) GetAgentNames() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.order...)
+}
+
+// GetStatus returns a snapshot of every supervised agent, in the order they
+// were added.
+func (s *AgentSupervisor) GetStatus() []SupervisedAgentStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	statuses := []SupervisedAgentStatus{}
+	for _, name := range s.order {
+		supervised := s.agents[name]
+		status := supervised.status
+		status.Running = supervised.agent.IsRunning()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Start starts every supervised agent, in the order they were added, and
+// begins watching them for crashes.
+func (s *AgentSupervisor) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.isRunning {
+		return
+	}
+	zap.S().Infof("Starting supervisor %s", s.name)
+	s.isRunning = true
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, name := range s.order {
+		s.startAgentLocked(s.agents[name])
+	}
+}
+
+// startAgentLocked starts an agent and the goroutine watching it. Callers
+// must hold the mutex.
+func (s *AgentSupervisor) startAgentLocked(supervised *supervisedAgent) {
+	events, unsubscribe := supervised.agent.Subscribe(256)
+	supervised.unsubscribe = unsubscribe
+	supervised.agent.Start()
+	s.watchers.Add(1)
+	go s.watchAgent(s.ctx, supervised, events)
+}
+
+// stopWatchingLocked ends the supervision of every agent and returns them in
+// reverse start order. Callers must hold the mutex.
+func (s *AgentSupervisor) stopWatchingLocked() []*supervisedAgent {
+	s.isRunning = false
+	s.cancel()
+	agents := []*supervisedAgent{}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		supervised := s.agents[s.order[i]]
+		if supervised.unsubscribe != nil {
+			supervised.unsubscribe()
+			supervised.unsubscribe = nil
+		}
+		agents = append(agents, supervised)
+	}
+	return agents
+}
+
+// Shutdown stops watching the agents and shuts them down in the reverse of
+// the order they were added, all sharing ctx as the deadline. It returns the
+// shutdown report of every agent that abandoned tasks and the first error.
+func (s *AgentSupervisor) Shutdown(ctx context.Context) (map[string]AgentShutdownReport, error) {
+	s.mutex.Lock()
+	if !s.isRunning {
+		s.mutex.Unlock()
+		return map[string]AgentShutdownReport{}, nil
+	}
+	zap.S().Infof("Shutting down supervisor %s", s.name)
+	agents := s.stopWatchingLocked()
+	s.mutex.Unlock()
+	s.watchers.Wait()
+	reports := make(map[string]AgentShutdownReport)
+	var firstErr error
+	for _, supervised := range agents {
+		report, err := supervised.agent.Shutdown(ctx)
+		if len(report.Abandoned) > 0 {
+			reports[supervised.name] = report
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("shutting down agent %s: %w", supervised.name, err)
+		}
+	}
+	return reports, firstErr
+}
+
+// Stop shuts every agent down gracefully, waiting for their tasks to finish.
+func (s *AgentSupervisor) Stop() {
+	_, _ = s.Shutdown(context.Background())
+}
+
+// Kill stops watching the agents and kills them.
+func (s *AgentSupervisor) Kill() {
+	s.mutex.Lock()
+	if !s.isRunning {
+		s.mutex.Unlock()
+		return
+	}
+	zap.S().Infof("Killing supervisor %s", s.name)
+	agents := s.stopWatchingLocked()
+	s.mutex.Unlock()
+	s.watchers.Wait()
+	for _, supervised := range agents {
+		supervised.agent.Kill()
+	}
+}
+
+// watchAgent restarts an agent when its events show that it crashed.
+func (s *AgentSupervisor) watchAgent(ctx context.Context, supervised *supervisedAgent, events <-chan AgentEvent) {
+	defer s.watchers.Done()
+	for {
+		var event AgentEvent
+		var ok bool
+		select {
+		case event, ok = <-events:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+		var crash error
+		switch event.Type {
+		case AgentEventAgentStopped, AgentEventAgentKilled:
+			// Events of our own restarts arrive after the agent runs again.
+			if !supervised.agent.IsRunning() {
+				crash = fmt.Errorf("agent %s", event.Type)
+			}
+		case AgentEventTaskFailed:
+			var panicErr *AgentTaskPanicError
+			if supervised.policy != nil && supervised.policy.RestartOnPanic && errors.As(event.Err, &panicErr) {
+				crash = fmt.Errorf("task <ID: %s, Name: %s> failed: %w", event.TaskID, event.TaskName, event.Err)
+			}
+		}
+		if crash != nil {
+			s.handleCrash(ctx, supervised, crash)
+		}
+	}
+}
+
+// handleCrash records a crash and restarts the agent if its policy allows.
+func (s *AgentSupervisor) handleCrash(ctx context.Context, supervised *supervisedAgent, crash error) {
+	now := time.Now()
+	s.mutex.Lock()
+	supervised.status.LastCrash = now
+	supervised.status.CrashErr = crash
+	allowed := supervised.policy.allows(supervised.restarts, now)
+	if !allowed {
+		supervised.status.GaveUp = supervised.policy != nil
+		s.mutex.Unlock()
+		zap.S().Errorf("Supervisor %s not restarting crashed agent %s: %v", s.name, supervised.name, crash)
+		return
+	}
+	supervised.restarts = append(supervised.restarts, now)
+	s.mutex.Unlock()
+	zap.S().Warnf("Supervisor %s restarting crashed agent %s: %v", s.name, supervised.name, crash)
+	if backoff := supervised.policy.Backoff; backoff > 0 {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ctx.Err() != nil {
+		return
+	}
+	if supervised.agent.IsRunning() {
+		supervised.agent.Kill()
+	}
+	supervised.agent.Start()
+	supervised.status.Restarts++
+}
+
+// HandleMessages registers handler for the messages of msgType sent to the
+// agent named agentName, replacing any previous handler.
+func (s *AgentSupervisor) HandleMessages(agentName string, msgType AgentMessageType, handler AgentMessageHandler) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	supervised, exists := s.agents[agentName]
+	if !exists {
+		return fmt.Errorf("%w %s in supervisor %s", ErrUnknownAgent, agentName, s.name)
+	}
+	supervised.handlers[msgType] = handler
+	return nil
+}
+
+// NewAgentMessageTaskType returns the task type of the tasks delivering
+// messages of msgType. Messages of the same type are handled one at a time
+// by each agent, in the order they were sent.
+func NewAgentMessageTaskType(msgType AgentMessageType) AgentTaskType {
+	return NewAgentTaskType("message:"+string(msgType), true)
+}
+
+// Send delivers a message from the agent named from to the agent named to,
+// as a task on the receiving agent running its handler for msgType. The
+// returned task resolves to the handler's result.
+func (s *AgentSupervisor) Send(from string, to string, msgType AgentMessageType, payload interface{}) (*AgentTask[interface{}], error) {
+	s.mutex.Lock()
+	supervised, exists := s.agents[to]
+	var handler AgentMessageHandler
+	if exists {
+		handler = supervised.handlers[msgType]
+	}
+	s.mutex.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("%w %s in supervisor %s", ErrUnknownAgent, to, s.name)
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("%w %s on agent %s", ErrNoMessageHandler, msgType, to)
+	}
+	msg := AgentMessage{
+		ID:      generateUUID(),
+		From:    from,
+		To:      to,
+		Type:    msgType,
+		Payload: payload,
+		SentAt:  time.Now(),
+	}
+	task := NewAgentTask(string(msgType), NewAgentMessageTaskType(msgType), func(ctx context.Context) (interface{}, error) {
+		return handler(ctx, msg)
+	})
+	zap.S().Infof("Supervisor %s routing %s message <ID: %s> from %q to %q", s.name, msgType, msg.ID, from, to)
+	err := supervised.agent.AddTask(task)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Broadcast sends a message to every agent other than from that handles
+// msgType, and returns the delivery tasks in the order the agents were
+// added.
+func (s *AgentSupervisor) Broadcast(from string, msgType AgentMessageType, payload interface{}) ([]*AgentTask[interface{}], error) {
+	recipients := []string{}
+	s.mutex.Lock()
+	for _, name := range s.order {
+		if _, handles := s.agents[name].handlers[msgType]; handles && name != from {
+			recipients = append(recipients, name)
+		}
+	}
+	s.mutex.Unlock()
+	tasks := []*AgentTask[interface{}]{}
+	for _, name := range recipients {
+		task, err := s.Send(from, name, msgType, payload)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// NewChatAgentMessageHandler returns a handler that sends the payload of each
+// message to chatAgent, as a user message if it is a string or as is if it is
+// a ChatAgentMessage, and results in the *ChatAgentMessage response. Killing
+// or timing out the message's task aborts the completion request.
+func NewChatAgentMessageHandler(chatAgent *ChatAgent) AgentMessageHandler {
+	return func(ctx context.Context, msg AgentMessage) (interface{}, error) {
+		var chatMessage ChatAgentMessage
+		switch payload := msg.Payload.(type) {
+		case string:
+			chatMessage = *NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, payload)
+		case ChatAgentMessage:
+			chatMessage = payload
+		case *ChatAgentMessage:
+			chatMessage = *payload
+		default:
+			return nil, fmt.Errorf("chat agent cannot handle %s message payload of type %T", msg.Type, msg.Payload)
+		}
+		return chatAgent.SendChatMessageWithContext(ctx, chatMessage)
+	}
+}
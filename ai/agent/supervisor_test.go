@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitFor polls condition until it holds or a second passes.
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAgentSupervisorStartsAndStopsAgents(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	discovery := NewAgent("discovery", "testAgentType", nil)
+	coder := NewAgent("coder", "testAgentType", nil)
+	assert.Nil(t, supervisor.AddAgent("discovery", discovery, nil))
+	assert.Nil(t, supervisor.AddAgent("coder", coder, nil))
+	assert.NotNil(t, supervisor.AddAgent("coder", coder, nil))
+	assert.Equal(t, []string{"discovery", "coder"}, supervisor.GetAgentNames())
+
+	supervisor.Start()
+	assert.True(t, supervisor.IsRunning())
+	assert.True(t, discovery.IsRunning())
+	assert.True(t, coder.IsRunning())
+
+	late := NewAgent("late", "testAgentType", nil)
+	assert.Nil(t, supervisor.AddAgent("late", late, nil))
+	assert.True(t, late.IsRunning())
+
+	reports, err := supervisor.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, reports)
+	assert.False(t, supervisor.IsRunning())
+	assert.False(t, discovery.IsRunning())
+	assert.False(t, coder.IsRunning())
+	assert.False(t, late.IsRunning())
+}
+
+func TestAgentSupervisorRestartsCrashedAgent(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	agent := NewAgent("testName", "testAgentType", nil)
+	assert.Nil(t, supervisor.AddAgent("worker", agent, NewSupervisorRestartPolicy(1, time.Minute)))
+	supervisor.Start()
+	defer supervisor.Kill()
+
+	agent.Kill()
+	waitFor(t, func() bool { return supervisor.GetStatus()[0].Restarts == 1 })
+	assert.True(t, agent.IsRunning())
+
+	// The policy allows a single restart within the window.
+	agent.Kill()
+	waitFor(t, func() bool { return supervisor.GetStatus()[0].GaveUp })
+	status := supervisor.GetStatus()[0]
+	assert.Equal(t, "worker", status.Name)
+	assert.Equal(t, 1, status.Restarts)
+	assert.False(t, status.Running)
+	assert.NotNil(t, status.CrashErr)
+}
+
+func TestAgentSupervisorRestartsOnPanic(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	agent := NewAgent("testName", "testAgentType", nil)
+	policy := NewSupervisorRestartPolicy(1, 0)
+	policy.RestartOnPanic = true
+	assert.Nil(t, supervisor.AddAgent("worker", agent, policy))
+	supervisor.Start()
+	defer supervisor.Kill()
+
+	task := NewAgentTask("test", testTaskType, func(ctx context.Context) (string, error) {
+		panic("boom")
+	})
+	assert.Nil(t, agent.AddTask(task))
+	waitFor(t, func() bool { return supervisor.GetStatus()[0].Restarts == 1 })
+	assert.True(t, agent.IsRunning())
+	var panicErr *AgentTaskPanicError
+	assert.True(t, errors.As(supervisor.GetStatus()[0].CrashErr, &panicErr))
+}
+
+func TestAgentSupervisorDoesNotRestartStoppedAgents(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	agent := NewAgent("testName", "testAgentType", nil)
+	assert.Nil(t, supervisor.AddAgent("worker", agent, NewSupervisorRestartPolicy(5, 0)))
+	supervisor.Start()
+	supervisor.Kill()
+	time.Sleep(20 * time.Millisecond)
+	assert.False(t, agent.IsRunning())
+	assert.Equal(t, 0, supervisor.GetStatus()[0].Restarts)
+}
+
+func TestAgentSupervisorRoutesMessages(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	research := NewAgent("research", "testAgentType", nil)
+	requirements := NewAgent("requirements", "testAgentType", nil)
+	assert.Nil(t, supervisor.AddAgent("research", research, nil))
+	assert.Nil(t, supervisor.AddAgent("requirements", requirements, nil))
+	supervisor.Start()
+	defer supervisor.Kill()
+
+	received := make(chan AgentMessage, 1)
+	assert.Nil(t, supervisor.HandleMessages("requirements", "findings", func(ctx context.Context, msg AgentMessage) (interface{}, error) {
+		received <- msg
+		task, ok := TaskFromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, NewAgentMessageTaskType("findings"), task.GetType())
+		return "noted " + msg.Payload.(string), nil
+	}))
+	assert.ErrorIs(t, supervisor.HandleMessages("coder", "findings", nil), ErrUnknownAgent)
+
+	task, err := supervisor.Send("research", "requirements", "findings", "rate limits")
+	assert.Nil(t, err)
+	result, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "noted rate limits", result)
+	msg := <-received
+	assert.Equal(t, "research", msg.From)
+	assert.Equal(t, "requirements", msg.To)
+	assert.Equal(t, AgentMessageType("findings"), msg.Type)
+	assert.NotEmpty(t, msg.ID)
+
+	_, err = supervisor.Send("research", "requirements", "unknown", nil)
+	assert.ErrorIs(t, err, ErrNoMessageHandler)
+	_, err = supervisor.Send("research", "coder", "findings", nil)
+	assert.ErrorIs(t, err, ErrUnknownAgent)
+}
+
+func TestAgentSupervisorBroadcast(t *testing.T) {
+	supervisor := NewAgentSupervisor("test")
+	handler := func(ctx context.Context, msg AgentMessage) (interface{}, error) {
+		return msg.To, nil
+	}
+	for _, name := range []string{"discovery", "research", "coder"} {
+		assert.Nil(t, supervisor.AddAgent(name, NewAgent(name, "testAgentType", nil), nil))
+		assert.Nil(t, supervisor.HandleMessages(name, "stop_work", handler))
+	}
+	supervisor.Start()
+	defer supervisor.Kill()
+
+	tasks, err := supervisor.Broadcast("discovery", "stop_work", nil)
+	assert.Nil(t, err)
+	results, err := AwaitAll(context.Background(), tasks...)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"research", "coder"}, results)
+}
+
+func TestChatAgentMessageHandlerAbortsKilledRequests(t *testing.T) {
+	requested := make(chan struct{})
+	aborted := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server notices the client going away only once the body is read.
+		_, _ = io.ReadAll(r.Body)
+		close(requested)
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer ts.Close()
+	chatAgent := NewChatAgent("chat", ai.NewAIConfig("test-openai-api-key"))
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	supervisor := NewAgentSupervisor("test")
+	assert.Nil(t, supervisor.AddAgent("discovery", NewAgent("discovery", "testAgentType", nil), nil))
+	assert.Nil(t, supervisor.AddAgent("chat", chatAgent, nil))
+	assert.Nil(t, supervisor.HandleMessages("chat", "ask", NewChatAgentMessageHandler(chatAgent)))
+	supervisor.Start()
+	defer supervisor.Kill()
+
+	task, err := supervisor.Send("discovery", "chat", "ask", "What is CSX Labs?")
+	assert.Nil(t, err)
+	<-requested
+	task.Kill()
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Fatal("completion request was not aborted")
+	}
+	_, err = task.Await(context.Background())
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool { return len(chatAgent.GetMessages()) == 0 }, time.Second, 10*time.Millisecond)
+}
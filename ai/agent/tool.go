@@ -0,0 +1,252 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+)
+
+var (
+	// ErrUnknownTool is returned when a tool that is not registered is called.
+	ErrUnknownTool = errors.New("unknown tool")
+	// ErrMaxToolIterations is returned when a ChatAgent keeps calling tools
+	// past its limit instead of responding.
+	ErrMaxToolIterations = errors.New("too many consecutive tool calls")
+)
+
+// DefaultMaxToolIterations is how many rounds of tool calls a ChatAgent runs
+// for one message before giving up.
+const DefaultMaxToolIterations = 5
+
+// ChatAgentToolTaskType is the type of the tasks running tool calls. Tool
+// calls run concurrently, as sub-tasks of the message that caused them.
+var ChatAgentToolTaskType = NewAgentTaskType("tool_call", false)
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ToolHandler runs a tool with the JSON object of arguments chosen by the
+// model and returns the result to show it.
+type ToolHandler func(ctx context.Context, arguments json.RawMessage) (string, error)
+
+// Tool is a Go function a ChatAgent offers the model to call.
+type Tool struct {
+	Name        string         // Letters, digits, underscores and dashes, up to 64 characters
+	Description string         // Tells the model when to use the tool
+	Parameters  *ai.JSONSchema // Object schema of the arguments, nil for none
+	Handler     ToolHandler
+}
+
+// NewTool creates a tool whose arguments are unmarshalled into a T before
+// calling handler.
+func NewTool[T any](name string, description string, parameters *ai.JSONSchema, handler func(ctx context.Context, arguments T) (string, error)) Tool {
+	return Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  parameters,
+		Handler: func(ctx context.Context, rawArguments json.RawMessage) (string, error) {
+			var arguments T
+			err := json.Unmarshal(rawArguments, &arguments)
+			if err != nil {
+				return "", fmt.Errorf("invalid arguments for tool %s: %w", name, err)
+			}
+			return handler(ctx, arguments)
+		},
+	}
+}
+
+// ToolRegistry holds the tools available to a ChatAgent. All methods are safe
+// for concurrent use, and tools registered while the agent runs are offered
+// from the next message on.
+type ToolRegistry struct {
+	tools map[string]Tool
+	order []string // Tool names in the order they were registered
+	mutex sync.RWMutex
+}
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]Tool),
+	}
+}
+
+// Register adds tool to the registry, replacing any tool of the same name.
+func (r *ToolRegistry) Register(tool Tool) error {
+	if !toolNamePattern.MatchString(tool.Name) {
+		return fmt.Errorf("invalid tool name %q: must match %s", tool.Name, toolNamePattern)
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("tool %s has no handler", tool.Name)
+	}
+	if tool.Parameters != nil && tool.Parameters.Type != ai.JSONSchemaTypeObject {
+		return fmt.Errorf("parameters of tool %s must be an object schema, got %q", tool.Name, tool.Parameters.Type)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.tools[tool.Name]; !exists {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = tool
+	return nil
+}
+
+// Unregister removes the tool with the given name, reporting false if there
+// was none.
+func (r *ToolRegistry) Unregister(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, exists := r.tools[name]; !exists {
+		return false
+	}
+	delete(r.tools, name)
+	for i, registered := range r.order {
+		if registered == name {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (r *ToolRegistry) Get(name string) (Tool, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tool, exists := r.tools[name]
+	return tool, exists
+}
+
+// GetTools returns the registered tools in the order they were registered.
+func (r *ToolRegistry) GetTools() []Tool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	tools := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tools = append(tools, r.tools[name])
+	}
+	return tools
+}
+
+// Call runs the tool named name with the given JSON object of arguments.
+func (r *ToolRegistry) Call(ctx context.Context, name string, arguments string) (string, error) {
+	tool, exists := r.Get(name)
+	if !exists {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTool, name)
+	}
+	if arguments == "" {
+		arguments = "{}"
+	}
+	if !json.Valid([]byte(arguments)) {
+		return "", fmt.Errorf("invalid arguments for tool %s: not valid JSON", name)
+	}
+	return tool.Handler(ctx, json.RawMessage(arguments))
+}
+
+func (r *ToolRegistry) toChatFunctions() []openai.ChatFunction {
+	functions := []openai.ChatFunction{}
+	for _, tool := range r.GetTools() {
+		functions = append(functions, openai.ChatFunction{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+		})
+	}
+	return functions
+}
+
+// SetToolRegistry offers the tools of registry to the model with every
+// message. When the model calls one, the agent runs it, sends back the result
+// and repeats until the model responds, up to the limit set with
+// SetMaxToolIterations. Nil removes the tools.
+func (c *ChatAgent) SetToolRegistry(registry *ToolRegistry) {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	c.tools = registry
+}
+
+func (c *ChatAgent) GetToolRegistry() *ToolRegistry {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	return c.tools
+}
+
+// SetMaxToolIterations sets how many rounds of tool calls the agent runs for
+// one message before failing it with ErrMaxToolIterations. A value of zero or
+// less restores DefaultMaxToolIterations.
+func (c *ChatAgent) SetMaxToolIterations(maxIterations int) {
+	if maxIterations <= 0 {
+		maxIterations = DefaultMaxToolIterations
+	}
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	c.maxToolIterations = maxIterations
+}
+
+func (c *ChatAgent) GetMaxToolIterations() int {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	return c.maxToolIterations
+}
+
+// offerTools sets the functions offered by the OpenAI chat client to the
+// registered tools.
+func (c *ChatAgent) offerTools() {
+	registry := c.GetToolRegistry()
+	if registry == nil {
+		c.OpenAIChatClient.SetFunctions(nil)
+		return
+	}
+	c.OpenAIChatClient.SetFunctions(registry.toChatFunctions())
+}
+
+// resolveToolCalls runs the tool calls of response, sends their results and
+// returns the response that follows, until the model responds without calling
+// a tool. complete requests the next response once a result was added.
+func (c *ChatAgent) resolveToolCalls(ctx context.Context, response *ChatAgentMessage, complete func() error) (*ChatAgentMessage, error) {
+	maxIterations := c.GetMaxToolIterations()
+	for iteration := 0; response.IsToolCallMessage(); iteration++ {
+		if iteration >= maxIterations {
+			return nil, fmt.Errorf("%w: stopped after %d rounds", ErrMaxToolIterations, maxIterations)
+		}
+		ProgressFromContext(ctx).Report(0, "calling tool", response.ToolCall.Name)
+		result := c.runToolCall(ctx, *response.ToolCall)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c.AddMessage(*NewChatAgentToolResultMessage(response.ToolCall.Name, result))
+		err := complete()
+		if err != nil {
+			return nil, err
+		}
+		response = c.recordResponse()
+	}
+	return response, nil
+}
+
+// runToolCall runs a tool call as a sub-task of the task whose handler
+// received ctx and returns its result. Failures are returned as the result,
+// so that the model can recover from them.
+func (c *ChatAgent) runToolCall(ctx context.Context, call ChatAgentToolCall) string {
+	registry := c.GetToolRegistry()
+	if registry == nil {
+		return fmt.Sprintf("error: %v: %s", ErrUnknownTool, call.Name)
+	}
+	task := NewAgentTask(call.Name, ChatAgentToolTaskType, func(ctx context.Context) (string, error) {
+		return registry.Call(ctx, call.Name, call.Arguments)
+	})
+	var result string
+	err := SpawnSubTask(ctx, task)
+	if errors.Is(err, ErrNoParentTask) {
+		result, err = registry.Call(ctx, call.Name, call.Arguments)
+	} else if err == nil {
+		result, err = task.Await(ctx)
+	}
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type webSearchArguments struct {
+	Query string `json:"query"`
+}
+
+func newTestWebSearchTool(queries *[]string) Tool {
+	return NewTool("web_search", "Search the web", ai.NewObjectSchema(map[string]*ai.JSONSchema{
+		"query": ai.NewStringSchema("The search query"),
+	}, "query"), func(ctx context.Context, arguments webSearchArguments) (string, error) {
+		*queries = append(*queries, arguments.Query)
+		return "CSX Labs is a research lab.", nil
+	})
+}
+
+func TestToolRegistry(t *testing.T) {
+	registry := NewToolRegistry()
+	var queries []string
+	assert.Nil(t, registry.Register(newTestWebSearchTool(&queries)))
+	assert.Nil(t, registry.Register(Tool{Name: "now", Handler: func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		return "noon", nil
+	}}))
+	assert.NotNil(t, registry.Register(Tool{Name: "web search", Handler: registry.tools["now"].Handler}))
+	assert.NotNil(t, registry.Register(Tool{Name: "no_handler"}))
+	assert.NotNil(t, registry.Register(Tool{Name: "bad_parameters", Parameters: ai.NewStringSchema(""), Handler: registry.tools["now"].Handler}))
+
+	tools := registry.GetTools()
+	assert.Equal(t, 2, len(tools))
+	assert.Equal(t, "web_search", tools[0].Name)
+	assert.Equal(t, "now", tools[1].Name)
+
+	result, err := registry.Call(context.Background(), "web_search", `{"query":"CSX Labs"}`)
+	assert.Nil(t, err)
+	assert.Equal(t, "CSX Labs is a research lab.", result)
+	assert.Equal(t, []string{"CSX Labs"}, queries)
+	result, err = registry.Call(context.Background(), "now", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "noon", result)
+	_, err = registry.Call(context.Background(), "web_search", `{"query":`)
+	assert.NotNil(t, err)
+	_, err = registry.Call(context.Background(), "web_search", `{"query":1}`)
+	assert.NotNil(t, err)
+	_, err = registry.Call(context.Background(), "weather", "{}")
+	assert.True(t, errors.Is(err, ErrUnknownTool))
+
+	assert.True(t, registry.Unregister("web_search"))
+	assert.False(t, registry.Unregister("web_search"))
+	assert.Equal(t, 1, len(registry.GetTools()))
+}
+
+func TestChatAgent_SendChatMessageCallsTools(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	requests := make(chan []byte, 2)
+	ts := openai.StartSequenceHTTPTestServer(requests, openai.SampleChatFunctionCallCompletion, openai.SampleChatJSONCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	registry := NewToolRegistry()
+	var queries []string
+	assert.Nil(t, registry.Register(newTestWebSearchTool(&queries)))
+	chatAgent.SetToolRegistry(registry)
+
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Who are CSX Labs?")
+	response, err := chatAgent.SendChatMessage(*msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "CSX Labs is an amazing organization.", response.Content)
+	assert.Equal(t, []string{"CSX Labs"}, queries)
+	assert.True(t, strings.Contains(string(<-requests), `"functions":[{"name":"web_search"`))
+	assert.True(t, strings.Contains(string(<-requests), `{"role":"function","content":"CSX Labs is a research lab.","name":"web_search"}`))
+
+	messages := chatAgent.GetMessages()
+	assert.Equal(t, 4, len(messages))
+	assert.True(t, messages[1].IsToolCallMessage())
+	assert.Equal(t, "web_search", messages[1].ToolCall.Name)
+	assert.True(t, messages[2].IsToolResultMessage())
+	assert.Equal(t, "web_search", messages[2].ToolName)
+	assert.Equal(t, "CSX Labs is a research lab.", messages[2].Content)
+
+	toolTasks := chatAgent.GetTasksByType(ChatAgentToolTaskType)
+	assert.Equal(t, 1, len(toolTasks))
+	assert.Equal(t, "web_search", toolTasks[0].Name)
+	assert.NotEmpty(t, toolTasks[0].ParentID)
+}
+
+func TestChatAgent_SendChatMessageStopsAfterMaxToolIterations(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartHTTPTestServer(openai.SampleChatFunctionCallCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	registry := NewToolRegistry()
+	var queries []string
+	assert.Nil(t, registry.Register(newTestWebSearchTool(&queries)))
+	chatAgent.SetToolRegistry(registry)
+	chatAgent.SetMaxToolIterations(2)
+
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Who are CSX Labs?")
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.True(t, errors.Is(err, ErrMaxToolIterations))
+	assert.Equal(t, 2, len(queries))
+	assert.Equal(t, 0, len(chatAgent.GetMessages()))
+}
+
+func TestChatAgent_ToolErrorsAreSentToModel(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := openai.StartSequenceHTTPTestServer(nil, openai.SampleChatFunctionCallCompletion, openai.SampleChatCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	chatAgent.SetToolRegistry(NewToolRegistry())
+
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Who are CSX Labs?")
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.Nil(t, err)
+	assert.Equal(t, "error: unknown tool: web_search", chatAgent.GetMessages()[2].Content)
+}
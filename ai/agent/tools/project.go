@@ -0,0 +1,63 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+)
+
+type projectFileArguments struct {
+	Path string `json:"path"`
+}
+
+// NewReadProjectFileTool creates the read_project_file tool, which returns
+// the contents of a file under root, truncated to maxLength characters. Paths
+// are relative to root and cannot leave it. A maxLength of zero or less uses
+// DefaultMaxResultLength.
+func NewReadProjectFileTool(root string, maxLength int) agent.Tool {
+	description := "Read a file of the project being generated. Paths are relative to the project root."
+	parameters := ai.NewObjectSchema(map[string]*ai.JSONSchema{
+		"path": ai.NewStringSchema("Path of the file relative to the project root, such as \"src/main.go\""),
+	}, "path")
+	return agent.NewTool(ReadProjectFileToolName, description, parameters, func(ctx context.Context, arguments projectFileArguments) (string, error) {
+		path, err := resolveProjectPath(root, arguments.Path)
+		if err != nil {
+			return "", err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return truncate(string(content), maxLength), nil
+	})
+}
+
+// resolveProjectPath returns the path of relativePath under root, failing if
+// it would point outside of root. Symbolic links are resolved first, so that
+// a link inside root cannot point outside of it either.
+func resolveProjectPath(root string, relativePath string) (string, error) {
+	if relativePath == "" || filepath.IsAbs(relativePath) {
+		return "", fmt.Errorf("path must be relative to the project root, got %q", relativePath)
+	}
+	absoluteRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absoluteRoot, err = filepath.EvalSymlinks(absoluteRoot)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(absoluteRoot, relativePath))
+	if err != nil {
+		return "", err
+	}
+	if path != absoluteRoot && !strings.HasPrefix(path, absoluteRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of the project root", relativePath)
+	}
+	return path, nil
+}
@@ -0,0 +1,145 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+	"github.com/CSXL/solus/context/db"
+	"github.com/CSXL/solus/query/search_clients"
+)
+
+const (
+	WebSearchToolName        = "web_search"
+	WikipediaSummaryToolName = "wikipedia_summary"
+	ScrapeURLToolName        = "scrape_url"
+	ReadProjectFileToolName  = "read_project_file"
+	ContextSearchToolName    = "context_search"
+)
+
+// DefaultMaxResultLength is how many characters of a page or file a tool
+// returns, so that a single result cannot fill the model's context.
+const DefaultMaxResultLength = 8000
+
+// DefaultContextSearchLimit is how many documents of the context store a
+// search returns.
+const DefaultContextSearchLimit = 5
+
+// WebSearcher searches the web, as *search_clients.GoogleSearchClient does.
+type WebSearcher interface {
+	SearchWithContext(ctx context.Context, query string) ([]*search_clients.GoogleSearchResult, error)
+}
+
+// WikipediaSearcher finds and summarizes Wikipedia pages, as
+// *search_clients.WikipediaClient does.
+type WikipediaSearcher interface {
+	SearchWithContext(ctx context.Context, query string) ([]search_clients.WikipediaQuerySearchResult, error)
+	GetPageSummaryWithContext(ctx context.Context, pageTitle string) (string, error)
+}
+
+// ContextSearcher looks up documents in the context store, as
+// *db.ChromaClient does.
+type ContextSearcher interface {
+	SearchWithContext(ctx context.Context, collection string, query string, limit int) ([]*db.Document, error)
+}
+
+type queryArguments struct {
+	Query string `json:"query"`
+}
+
+type urlArguments struct {
+	URL string `json:"url"`
+}
+
+var queryParameters = ai.NewObjectSchema(map[string]*ai.JSONSchema{
+	"query": ai.NewStringSchema("The search query"),
+}, "query")
+
+// NewWebSearchTool creates the web_search tool, which returns the results of
+// a web search as JSON.
+func NewWebSearchTool(searcher WebSearcher) agent.Tool {
+	description := "Search the web. Use it for questions about current events or anything you are unsure of. Returns the title, URL and summary of each result as JSON."
+	return agent.NewTool(WebSearchToolName, description, queryParameters, func(ctx context.Context, arguments queryArguments) (string, error) {
+		results, err := searcher.SearchWithContext(ctx, arguments.Query)
+		if err != nil {
+			return "", err
+		}
+		return search_clients.GoogleSearchResultsToJSON(results)
+	})
+}
+
+// NewWikipediaSummaryTool creates the wikipedia_summary tool, which returns
+// the summary of the Wikipedia page best matching a query.
+func NewWikipediaSummaryTool(searcher WikipediaSearcher) agent.Tool {
+	description := "Get the summary of the Wikipedia page that best matches a query. Use it for background on well known topics, technologies and organizations."
+	return agent.NewTool(WikipediaSummaryToolName, description, queryParameters, func(ctx context.Context, arguments queryArguments) (string, error) {
+		results, err := searcher.SearchWithContext(ctx, arguments.Query)
+		if err != nil {
+			return "", err
+		}
+		if len(results) == 0 {
+			return fmt.Sprintf("No Wikipedia page matches %q.", arguments.Query), nil
+		}
+		summary, err := searcher.GetPageSummaryWithContext(ctx, results[0].Title)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s\n\n%s", results[0].Title, summary), nil
+	})
+}
+
+// NewScrapeURLTool creates the scrape_url tool, which returns the title and
+// text of a web page, truncated to maxLength characters. A maxLength of zero
+// or less uses DefaultMaxResultLength.
+func NewScrapeURLTool(maxLength int) agent.Tool {
+	description := "Read the text of a web page, for example one found with web_search."
+	parameters := ai.NewObjectSchema(map[string]*ai.JSONSchema{
+		"url": ai.NewStringSchema("The absolute http or https URL of the page"),
+	}, "url")
+	return agent.NewTool(ScrapeURLToolName, description, parameters, func(ctx context.Context, arguments urlArguments) (string, error) {
+		if !strings.HasPrefix(arguments.URL, "http://") && !strings.HasPrefix(arguments.URL, "https://") {
+			return "", fmt.Errorf("not an http or https URL: %q", arguments.URL)
+		}
+		// Scrapers register their callbacks on every page, so each call gets a
+		// fresh one.
+		website, err := search_clients.NewScraper().ScrapePageWithContext(ctx, arguments.URL)
+		if err != nil {
+			return "", err
+		}
+		return truncate(fmt.Sprintf("%s\n\n%s", website.GetTitle(), website.GetTextContent()), maxLength), nil
+	})
+}
+
+// NewContextSearchTool creates the context_search tool, which returns the
+// documents of collection in the context store closest to a query.
+func NewContextSearchTool(searcher ContextSearcher, collection string, limit int) agent.Tool {
+	description := "Look up the documents of the project's context store most relevant to a query, such as earlier research or requirements."
+	return agent.NewTool(ContextSearchToolName, description, queryParameters, func(ctx context.Context, arguments queryArguments) (string, error) {
+		documents, err := searcher.SearchWithContext(ctx, collection, arguments.Query, limit)
+		if err != nil {
+			return "", err
+		}
+		if len(documents) == 0 {
+			return fmt.Sprintf("No documents match %q.", arguments.Query), nil
+		}
+		var result strings.Builder
+		for _, document := range documents {
+			fmt.Fprintf(&result, "Document %s:\n%s\n\n", document.ID, document.Content)
+		}
+		return strings.TrimSpace(result.String()), nil
+	})
+}
+
+// truncate shortens text to maxLength characters, noting that it did.
+func truncate(text string, maxLength int) string {
+	if maxLength <= 0 {
+		maxLength = DefaultMaxResultLength
+	}
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	return string(runes[:maxLength]) + "\n[truncated]"
+}
@@ -0,0 +1,144 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CSXL/solus/context/db"
+	"github.com/CSXL/solus/query/search_clients"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeWebSearcher struct {
+	query string
+}
+
+func (s *fakeWebSearcher) SearchWithContext(ctx context.Context, query string) ([]*search_clients.GoogleSearchResult, error) {
+	s.query = query
+	return []*search_clients.GoogleSearchResult{{Title: "CSX Labs", Url: "https://csxlabs.org"}}, nil
+}
+
+type fakeWikipediaSearcher struct {
+	results []search_clients.WikipediaQuerySearchResult
+}
+
+func (s *fakeWikipediaSearcher) SearchWithContext(ctx context.Context, query string) ([]search_clients.WikipediaQuerySearchResult, error) {
+	return s.results, nil
+}
+
+func (s *fakeWikipediaSearcher) GetPageSummaryWithContext(ctx context.Context, pageTitle string) (string, error) {
+	return "Summary of " + pageTitle, nil
+}
+
+type fakeContextSearcher struct{}
+
+func (s *fakeContextSearcher) SearchWithContext(ctx context.Context, collection string, query string, limit int) ([]*db.Document, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if collection != "research" {
+		return nil, errors.New("unknown collection")
+	}
+	return []*db.Document{{ID: "doc1", Content: "Use SQLite."}}, nil
+}
+
+func arguments(t *testing.T, value interface{}) json.RawMessage {
+	raw, err := json.Marshal(value)
+	assert.Nil(t, err)
+	return raw
+}
+
+func TestWebSearchTool(t *testing.T) {
+	searcher := &fakeWebSearcher{}
+	tool := NewWebSearchTool(searcher)
+	assert.Equal(t, WebSearchToolName, tool.Name)
+	result, err := tool.Handler(context.Background(), arguments(t, map[string]string{"query": "CSX Labs"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "CSX Labs", searcher.query)
+	assert.Contains(t, result, "https://csxlabs.org")
+}
+
+func TestWikipediaSummaryTool(t *testing.T) {
+	searcher := &fakeWikipediaSearcher{results: []search_clients.WikipediaQuerySearchResult{{Title: "Go (programming language)"}}}
+	tool := NewWikipediaSummaryTool(searcher)
+	result, err := tool.Handler(context.Background(), arguments(t, map[string]string{"query": "golang"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "Go (programming language)\n\nSummary of Go (programming language)", result)
+
+	searcher.results = nil
+	result, err = tool.Handler(context.Background(), arguments(t, map[string]string{"query": "golang"}))
+	assert.Nil(t, err)
+	assert.Contains(t, result, "No Wikipedia page")
+}
+
+func TestScrapeURLTool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><head><title>Example</title></head><body><p>"+strings.Repeat("a", 100)+"</p></body></html>")
+	}))
+	defer server.Close()
+
+	tool := NewScrapeURLTool(20)
+	result, err := tool.Handler(context.Background(), arguments(t, map[string]string{"url": server.URL}))
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(result, "Example\n\n"))
+	assert.True(t, strings.HasSuffix(result, "[truncated]"))
+
+	_, err = tool.Handler(context.Background(), arguments(t, map[string]string{"url": "file:///etc/passwd"}))
+	assert.NotNil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tool.Handler(ctx, arguments(t, map[string]string{"url": server.URL}))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReadProjectFileTool(t *testing.T) {
+	root := t.TempDir()
+	assert.Nil(t, os.MkdirAll(filepath.Join(root, "src"), 0o755))
+	assert.Nil(t, os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main"), 0o644))
+	tool := NewReadProjectFileTool(root, 0)
+
+	result, err := tool.Handler(context.Background(), arguments(t, map[string]string{"path": "src/main.go"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "package main", result)
+
+	for _, path := range []string{"../secret", "src/../../secret", "/etc/passwd", ""} {
+		_, err = tool.Handler(context.Background(), arguments(t, map[string]string{"path": path}))
+		assert.NotNil(t, err, path)
+	}
+
+	outside := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(root, "link")))
+	_, err = tool.Handler(context.Background(), arguments(t, map[string]string{"path": "link/secret"}))
+	assert.NotNil(t, err)
+	assert.Nil(t, os.Symlink(filepath.Join(root, "src"), filepath.Join(root, "source")))
+	result, err = tool.Handler(context.Background(), arguments(t, map[string]string{"path": "source/main.go"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "package main", result)
+}
+
+func TestContextSearchTool(t *testing.T) {
+	tool := NewContextSearchTool(&fakeContextSearcher{}, "research", 3)
+	result, err := tool.Handler(context.Background(), arguments(t, map[string]string{"query": "database"}))
+	assert.Nil(t, err)
+	assert.Equal(t, "Document doc1:\nUse SQLite.", result)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = tool.Handler(ctx, arguments(t, map[string]string{"query": "database"}))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "short", truncate("short", 10))
+	assert.Equal(t, "hé\n[truncated]", truncate("héllo", 2))
+}
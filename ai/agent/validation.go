@@ -0,0 +1,153 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CSXL/solus/ai"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidResponse is returned when the assistant's response still fails
+// validation after the repair attempts, see ResponseValidationError.
+var ErrInvalidResponse = errors.New("response does not match the expected format")
+
+// DefaultMaxRepairAttempts is how many corrective follow-ups a ChatAgent
+// sends for a response that fails validation before giving up.
+const DefaultMaxRepairAttempts = 2
+
+// repairPrompt asks the assistant to correct a response that failed
+// validation. It is sent as a system message and formatted with the reason.
+const repairPrompt = "Your previous response was rejected: %v\nRespond again with the complete response in the required format, without any text before or after it."
+
+// ResponseValidator checks the content of a response of the assistant. The
+// error it returns is sent back to the assistant as a correction, so it should
+// say what is wrong.
+type ResponseValidator func(content string) error
+
+// ResponseValidationError reports the last response that failed validation.
+// It unwraps to ErrInvalidResponse.
+type ResponseValidationError struct {
+	Attempts int    // Responses received, the first one and its repairs
+	Content  string // Content of the last response
+	Err      error  // Why the last response failed
+}
+
+func (e *ResponseValidationError) Error() string {
+	return fmt.Sprintf("%v after %d attempts: %v", ErrInvalidResponse, e.Attempts, e.Err)
+}
+
+func (e *ResponseValidationError) Unwrap() error {
+	return ErrInvalidResponse
+}
+
+// NewJSONSchemaValidator returns a validator accepting responses that are a
+// JSON document matching schema.
+func NewJSONSchemaValidator(schema *ai.JSONSchema) ResponseValidator {
+	return func(content string) error {
+		return schema.ValidateJSON([]byte(content))
+	}
+}
+
+// NewYAMLSchemaValidator returns a validator accepting responses that are a
+// YAML document matching schema.
+func NewYAMLSchemaValidator(schema *ai.JSONSchema) ResponseValidator {
+	return func(content string) error {
+		var value interface{}
+		err := yaml.Unmarshal([]byte(content), &value)
+		if err != nil {
+			return fmt.Errorf("invalid YAML: %w", err)
+		}
+		return schema.Validate(value)
+	}
+}
+
+// NewChatAgentMessageSchema returns the schema of the {"type", "content"}
+// envelope responses are wrapped in, see SendChatMessage, allowing the given
+// message types.
+func NewChatAgentMessageSchema(types ...ChatAgentMessageType) *ai.JSONSchema {
+	enum := make([]string, len(types))
+	for i, msgType := range types {
+		enum[i] = string(msgType)
+	}
+	return ai.NewObjectSchema(map[string]*ai.JSONSchema{
+		"type":    {Type: ai.JSONSchemaTypeString, Description: "The message type", Enum: enum},
+		"content": ai.NewStringSchema("The message content"),
+	}, "type", "content")
+}
+
+// SetResponseValidator makes the agent check every response that is not a
+// tool call with validator. When one fails, the reason is sent back to the
+// assistant and a new response requested, up to the limit set with
+// SetMaxRepairAttempts; the message then fails with a
+// *ResponseValidationError. Only the valid response is kept in the messages.
+// Nil accepts every response.
+func (c *ChatAgent) SetResponseValidator(validator ResponseValidator) {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	c.validator = validator
+}
+
+func (c *ChatAgent) GetResponseValidator() ResponseValidator {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	return c.validator
+}
+
+// SetMaxRepairAttempts sets how many corrective follow-ups the agent sends
+// for a response failing validation. Zero fails the message on the first
+// invalid response, and a negative value restores DefaultMaxRepairAttempts.
+func (c *ChatAgent) SetMaxRepairAttempts(maxAttempts int) {
+	if maxAttempts < 0 {
+		maxAttempts = DefaultMaxRepairAttempts
+	}
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	c.maxRepairAttempts = maxAttempts
+}
+
+func (c *ChatAgent) GetMaxRepairAttempts() int {
+	c.toolsMutex.Lock()
+	defer c.toolsMutex.Unlock()
+	return c.maxRepairAttempts
+}
+
+// repairResponse validates response, the last of the agent's messages, and
+// asks the assistant to correct it until it passes or the repair attempts
+// run out. complete requests the next response once a message was added. The
+// invalid responses and corrections are removed once a response passes.
+func (c *ChatAgent) repairResponse(ctx context.Context, response *ChatAgentMessage, complete func() error) (*ChatAgentMessage, error) {
+	validator := c.GetResponseValidator()
+	if validator == nil {
+		return response, nil
+	}
+	maxAttempts := c.GetMaxRepairAttempts()
+	firstResponse := len(c.GetMessages()) - 1
+	for attempt := 0; ; attempt++ {
+		validationErr := validator(response.Content)
+		if validationErr == nil {
+			break
+		}
+		if attempt >= maxAttempts {
+			return nil, &ResponseValidationError{Attempts: attempt + 1, Content: response.Content, Err: validationErr}
+		}
+		zap.S().Infof("Repairing invalid response of ChatAgent <ID: %s, Name: %s>: %v", c.GetID(), c.GetName(), validationErr)
+		ProgressFromContext(ctx).Report(0, "repairing response", validationErr.Error())
+		c.AddMessage(*NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleSystem, fmt.Sprintf(repairPrompt, validationErr)))
+		err := complete()
+		if err != nil {
+			return nil, err
+		}
+		response, err = c.resolveToolCalls(ctx, c.recordResponse(), complete)
+		if err != nil {
+			return nil, err
+		}
+	}
+	messages := c.GetMessages()
+	if lastResponse := len(messages) - 1; lastResponse > firstResponse {
+		c.SetMessages(append(messages[:firstResponse:firstResponse], messages[lastResponse]))
+	}
+	return response, nil
+}
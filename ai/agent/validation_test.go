@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEnvelopeValidator = NewJSONSchemaValidator(NewChatAgentMessageSchema(ChatAgentMessageTypeQuery, "message"))
+
+func TestNewJSONSchemaValidator(t *testing.T) {
+	assert.Nil(t, testEnvelopeValidator(`{"type":"query","content":"CSX Labs"}`))
+	assert.NotNil(t, testEnvelopeValidator(`Sure! {"type":"query","content":"CSX Labs"}`))
+	assert.NotNil(t, testEnvelopeValidator(`{"type":"search","content":"CSX Labs"}`))
+	assert.NotNil(t, testEnvelopeValidator(`{"type":"query"}`))
+}
+
+func TestNewYAMLSchemaValidator(t *testing.T) {
+	validator := NewYAMLSchemaValidator(ai.NewObjectSchema(map[string]*ai.JSONSchema{
+		"name":  ai.NewStringSchema("The name"),
+		"items": {Type: ai.JSONSchemaTypeArray, Items: ai.NewIntegerSchema("An item")},
+	}, "name"))
+	assert.Nil(t, validator("name: solus\nitems: [1, 2]\n"))
+	assert.NotNil(t, validator("items: [1, 2]\n"))
+	assert.NotNil(t, validator("name: solus\nitems: [1, two]\n"))
+	assert.NotNil(t, validator("name: [unclosed\n"))
+}
+
+func TestChatAgent_RepairsInvalidResponse(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	requests := make(chan []byte, 2)
+	ts := openai.StartSequenceHTTPTestServer(requests, openai.SampleChatCompletion, openai.SampleChatJSONCompletion)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	chatAgent.SetResponseValidator(testEnvelopeValidator)
+
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Who are CSX Labs?")
+	response, err := chatAgent.SendChatMessage(*msg)
+	assert.Nil(t, err)
+	assert.Equal(t, ChatAgentMessageType("message"), response.Type)
+	assert.Equal(t, "CSX Labs is an amazing organization.", response.Content)
+	<-requests
+	assert.Contains(t, string(<-requests), "Your previous response was rejected: invalid JSON")
+	// Only the repaired response is kept.
+	assert.Equal(t, 2, len(chatAgent.GetMessages()))
+	assert.Equal(t, 2, len(chatAgent.OpenAIChatClient.GetMessages()))
+	assert.Equal(t, *response, chatAgent.GetLastMessage())
+}
+
+func TestChatAgent_FailsWhenRepairsRunOut(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	var count int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Header().Set("content-Type", "application/json")
+		_, _ = w.Write([]byte(openai.SampleChatCompletion))
+	}))
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	chatAgent.SetResponseValidator(testEnvelopeValidator)
+	chatAgent.SetMaxRepairAttempts(1)
+
+	msg := NewChatAgentMessage(ChatAgentMessageTypeText, ChatAgentMessageRoleUser, "Who are CSX Labs?")
+	_, err := chatAgent.SendChatMessage(*msg)
+	assert.True(t, errors.Is(err, ErrInvalidResponse))
+	var validationErr *ResponseValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, 2, validationErr.Attempts)
+	assert.Contains(t, validationErr.Content, "Hi there!")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	assert.Empty(t, chatAgent.GetMessages())
+	assert.Empty(t, chatAgent.OpenAIChatClient.GetMessages())
+}
+
+func TestChatAgent_SetMaxRepairAttempts(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	assert.Equal(t, DefaultMaxRepairAttempts, chatAgent.GetMaxRepairAttempts())
+	chatAgent.SetMaxRepairAttempts(0)
+	assert.Equal(t, 0, chatAgent.GetMaxRepairAttempts())
+	chatAgent.SetMaxRepairAttempts(-1)
+	assert.Equal(t, DefaultMaxRepairAttempts, chatAgent.GetMaxRepairAttempts())
+}
+
+// startSequenceStreamingServer streams the chunks of each response in turn,
+// repeating the last one once they run out.
+func startSequenceStreamingServer(responses ...[]string) *httptest.Server {
+	var count int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&count, 1)) - 1
+		if i >= len(responses) {
+			i = len(responses) - 1
+		}
+		w.Header().Set("content-Type", "text/event-stream")
+		var body strings.Builder
+		for _, chunk := range responses[i] {
+			data, _ := json.Marshal(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"delta": map[string]string{"role": "assistant", "content": chunk}}},
+			})
+			fmt.Fprintf(&body, "data: %s\n\n", data)
+		}
+		body.WriteString("data: [DONE]\n\n")
+		_, _ = w.Write([]byte(body.String()))
+	}))
+}
+
+func TestChatAgent_StreamRepairsInvalidResponse(t *testing.T) {
+	chatAgent := NewChatAgent("testAgent", ai.NewAIConfig("test-key"))
+	chatAgent.Start()
+	defer chatAgent.Kill()
+	ts := startSequenceStreamingServer([]string{"Hi ", "there!"}, openai.SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	chatAgent.SetResponseValidator(testEnvelopeValidator)
+
+	response, deltas := streamChatMessage(t, chatAgent, "test-content")
+	expected := `CSX Labs is an "amazing" organization.`
+	assert.Equal(t, expected, response.Content)
+	assert.Equal(t, 2, len(chatAgent.GetMessages()))
+	// The repaired response restarts the stream's content.
+	assert.Equal(t, expected, deltas[len(deltas)-1].Content)
+	assert.Equal(t, "Hi there!", deltas[1].Content)
+	assert.Equal(t, "CSX Labs is ", deltas[2].Content)
+}
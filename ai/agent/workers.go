@@ -0,0 +1,95 @@
+package agent
+
+import "sync"
+
+// agentWorkerLimits bounds how many standard tasks an agent runs at once, both
+// overall and per task type. A limit of zero means unlimited. All methods are
+// safe for concurrent use.
+type agentWorkerLimits struct {
+	maxWorkers   int                   // Maximum concurrent standard tasks
+	typeLimits   map[AgentTaskType]int // Maximum concurrent tasks per type
+	active       int                   // Standard tasks currently running
+	activeByType map[AgentTaskType]int // Standard tasks currently running per type
+	released     chan struct{}         // Signalled when a worker slot frees up
+	mutex        sync.Mutex
+}
+
+func newAgentWorkerLimits() *agentWorkerLimits {
+	return &agentWorkerLimits{
+		typeLimits:   make(map[AgentTaskType]int),
+		activeByType: make(map[AgentTaskType]int),
+		released:     make(chan struct{}, 1),
+	}
+}
+
+func (l *agentWorkerLimits) setMaxWorkers(maxWorkers int) {
+	l.mutex.Lock()
+	l.maxWorkers = maxWorkers
+	l.mutex.Unlock()
+	l.notify()
+}
+
+func (l *agentWorkerLimits) getMaxWorkers() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.maxWorkers
+}
+
+func (l *agentWorkerLimits) setTypeLimit(taskType AgentTaskType, limit int) {
+	l.mutex.Lock()
+	if limit <= 0 {
+		delete(l.typeLimits, taskType)
+	} else {
+		l.typeLimits[taskType] = limit
+	}
+	l.mutex.Unlock()
+	l.notify()
+}
+
+func (l *agentWorkerLimits) getTypeLimit(taskType AgentTaskType) int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.typeLimits[taskType]
+}
+
+func (l *agentWorkerLimits) getActive() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.active
+}
+
+// tryAcquire claims a worker slot for a task of the given type, reporting
+// false if either the global or the per-type limit is reached.
+func (l *agentWorkerLimits) tryAcquire(taskType AgentTaskType) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.maxWorkers > 0 && l.active >= l.maxWorkers {
+		return false
+	}
+	typeLimit, hasTypeLimit := l.typeLimits[taskType]
+	if hasTypeLimit && l.activeByType[taskType] >= typeLimit {
+		return false
+	}
+	l.active++
+	l.activeByType[taskType]++
+	return true
+}
+
+func (l *agentWorkerLimits) release(taskType AgentTaskType) {
+	l.mutex.Lock()
+	l.active--
+	l.activeByType[taskType]--
+	if l.activeByType[taskType] <= 0 {
+		delete(l.activeByType, taskType)
+	}
+	l.mutex.Unlock()
+	l.notify()
+}
+
+// notify wakes the task loop without blocking if a wake-up is already pending.
+func (l *agentWorkerLimits) notify() {
+	select {
+	case l.released <- struct{}{}:
+	default:
+	}
+}
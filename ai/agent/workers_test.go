@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrencyTracker records the highest number of handlers running at once.
+type concurrencyTracker struct {
+	running    int
+	maxRunning int
+	mutex      sync.Mutex
+}
+
+func (c *concurrencyTracker) handler(ctx context.Context) (string, error) {
+	c.mutex.Lock()
+	c.running++
+	if c.running > c.maxRunning {
+		c.maxRunning = c.running
+	}
+	c.mutex.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	c.mutex.Lock()
+	c.running--
+	c.mutex.Unlock()
+	return "test", nil
+}
+
+func (c *concurrencyTracker) getMaxRunning() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.maxRunning
+}
+
+func addTrackedTasks(t *testing.T, agent *Agent, taskType AgentTaskType, tracker *concurrencyTracker, count int) []*AgentTask[string] {
+	var tasks []*AgentTask[string]
+	for i := 0; i < count; i++ {
+		task := NewAgentTask(fmt.Sprintf("task#%d", i), taskType, tracker.handler)
+		err := agent.AddTask(task)
+		assert.Nil(t, err)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func TestSetMaxWorkers(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetMaxWorkers(3)
+	assert.Equal(t, 3, agent.GetMaxWorkers())
+	agent.Start()
+	defer agent.Kill()
+	tracker := &concurrencyTracker{}
+	tasks := addTrackedTasks(t, agent, testTaskType, tracker, 20)
+	for _, task := range tasks {
+		_, err := task.Await(context.Background())
+		assert.Nil(t, err)
+	}
+	assert.LessOrEqual(t, tracker.getMaxRunning(), 3)
+	assert.Equal(t, 0, agent.GetActiveWorkerCount())
+}
+
+func TestSetTaskTypeConcurrencyLimit(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	limitedTaskType := NewAgentTaskType("limited", false)
+	agent.SetTaskTypeConcurrencyLimit(limitedTaskType, 2)
+	assert.Equal(t, 2, agent.GetTaskTypeConcurrencyLimit(limitedTaskType))
+	assert.Equal(t, 0, agent.GetTaskTypeConcurrencyLimit(testTaskType))
+	agent.Start()
+	defer agent.Kill()
+	limitedTracker := &concurrencyTracker{}
+	unlimitedTracker := &concurrencyTracker{}
+	tasks := addTrackedTasks(t, agent, limitedTaskType, limitedTracker, 10)
+	tasks = append(tasks, addTrackedTasks(t, agent, testTaskType, unlimitedTracker, 10)...)
+	for _, task := range tasks {
+		_, err := task.Await(context.Background())
+		assert.Nil(t, err)
+	}
+	assert.LessOrEqual(t, limitedTracker.getMaxRunning(), 2)
+}
+
+func TestTasksOverLimitStayQueued(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetMaxWorkers(1)
+	agent.Start()
+	defer agent.Kill()
+	release := make(chan bool)
+	blockingTask := NewAgentTask("blocking", testTaskType, func(ctx context.Context) (string, error) {
+		<-release
+		return "blocking", nil
+	})
+	waitingTask := NewAgentTask("waiting", testTaskType, testTaskHandlerWithResult)
+	assert.Nil(t, agent.AddTask(blockingTask))
+	assert.Nil(t, agent.AddTask(waitingTask))
+	time.Sleep(10 * time.Millisecond)
+	record, _ := agent.GetTask(waitingTask.GetID())
+	assert.Equal(t, AgentTaskStateQueued, record.State)
+	assert.Equal(t, 1, agent.GetActiveWorkerCount())
+	release <- true
+	result, err := waitingTask.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "test", result)
+}
+
+func TestStopRunsTasksOverLimit(t *testing.T) {
+	agent := NewAgent("testName", "testAgentType", nil)
+	agent.SetMaxWorkers(2)
+	agent.Start()
+	tracker := &concurrencyTracker{}
+	tasks := addTrackedTasks(t, agent, testTaskType, tracker, 10)
+	agent.Stop()
+	for _, task := range tasks {
+		_, err := task.Await(context.Background())
+		assert.Nil(t, err)
+	}
+	assert.LessOrEqual(t, tracker.getMaxRunning(), 2)
+}
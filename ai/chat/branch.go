@@ -0,0 +1,207 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CSXL/solus/ai/agent"
+	"go.uber.org/zap"
+)
+
+// DefaultBranchName is the branch a conversation starts on.
+const DefaultBranchName = "main"
+
+var (
+	ErrBranchExists    = errors.New("branch already exists")
+	ErrBranchNotFound  = errors.New("branch not found")
+	ErrMessageNotFound = errors.New("message not found")
+)
+
+// ConversationBranch is one line of a conversation. Every branch but
+// DefaultBranchName is forked from another, sharing its messages up to the
+// fork point; after that the two are independent.
+type ConversationBranch struct {
+	Name          string
+	Parent        string `json:",omitempty"` // Branch it was forked from, empty for DefaultBranchName
+	ForkMessageID string `json:",omitempty"` // Last message shared with Parent, empty when forked before the first
+	CreatedAt     time.Time
+	Messages      []agent.ChatAgentMessage `json:",omitempty"`
+}
+
+func newConversationBranches() map[string]*ConversationBranch {
+	return map[string]*ConversationBranch{
+		DefaultBranchName: {Name: DefaultBranchName, CreatedAt: time.Now()},
+	}
+}
+
+// GetCurrentBranch returns the name of the branch the conversation is on.
+func (c *Conversation) GetCurrentBranch() string {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	return c.branch
+}
+
+// GetBranches returns the branches of the conversation, oldest first.
+func (c *Conversation) GetBranches() []ConversationBranch {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	c.storeCurrentBranch()
+	return c.getBranches()
+}
+
+// GetBranch returns the branch of the conversation named name.
+func (c *Conversation) GetBranch(name string) (ConversationBranch, error) {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	c.storeCurrentBranch()
+	branch, ok := c.branches[name]
+	if !ok {
+		return ConversationBranch{}, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	return copyBranch(*branch), nil
+}
+
+// Fork creates a branch named name holding all the messages of the current
+// branch, and switches to it.
+func (c *Conversation) Fork(name string) error {
+	lastMessage := c.GetLastMessage()
+	return c.ForkAt(name, lastMessage.GetID())
+}
+
+// ForkAt creates a branch named name holding the messages of the current
+// branch up to and including the one with ID messageID, and switches to it.
+// An empty messageID forks an empty branch. The current branch is kept as it
+// is.
+func (c *Conversation) ForkAt(name string, messageID string) error {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	if name == "" {
+		return errors.New("branch name must not be empty")
+	}
+	if _, ok := c.branches[name]; ok {
+		return fmt.Errorf("%w: %s", ErrBranchExists, name)
+	}
+	messages := c.GetMessages()
+	forkIndex := -1
+	if messageID != "" {
+		forkIndex = findMessage(messages, messageID)
+		if forkIndex < 0 {
+			return fmt.Errorf("%w: %s", ErrMessageNotFound, messageID)
+		}
+	}
+	zap.S().Infof("Forking branch %s of conversation into %s at message %q", c.branch, name, messageID)
+	c.storeCurrentBranch()
+	c.branches[name] = &ConversationBranch{
+		Name:          name,
+		Parent:        c.branch,
+		ForkMessageID: messageID,
+		CreatedAt:     time.Now(),
+		Messages:      copyMessages(messages[:forkIndex+1]),
+	}
+	c.branch = name
+	c.SetMessages(copyMessages(messages[:forkIndex+1]))
+	return nil
+}
+
+// SwitchBranch continues the conversation on the branch named name.
+func (c *Conversation) SwitchBranch(name string) error {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	branch, ok := c.branches[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+	}
+	zap.S().Infof("Switching conversation from branch %s to %s", c.branch, name)
+	c.storeCurrentBranch()
+	c.branch = name
+	c.SetMessages(copyMessages(branch.Messages))
+	return nil
+}
+
+// Rewind removes the last turns turns of the current branch, where a turn is
+// a user message and everything that followed it.
+func (c *Conversation) Rewind(turns int) error {
+	if turns <= 0 {
+		return fmt.Errorf("turns must be positive, got %d", turns)
+	}
+	messages := c.GetMessages()
+	userMessages := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		if !messages[i].IsUserMessage() {
+			continue
+		}
+		userMessages++
+		if userMessages == turns {
+			zap.S().Infof("Rewinding conversation by %d turns", turns)
+			c.SetMessages(messages[:i])
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot rewind %d turns, the conversation has %d", turns, userMessages)
+}
+
+// EditAndRegenerate replaces the user message with ID messageID with one
+// holding content, drops every message after it and sends it again,
+// returning the new response. Fork first to keep the original messages on
+// a branch of their own. If the message cannot be sent, the messages are left
+// as they were.
+func (c *Conversation) EditAndRegenerate(messageID string, content string) (agent.ChatAgentMessage, error) {
+	messages := c.GetMessages()
+	index := findMessage(messages, messageID)
+	if index < 0 {
+		return agent.ChatAgentMessage{}, fmt.Errorf("%w: %s", ErrMessageNotFound, messageID)
+	}
+	if !messages[index].IsUserMessage() {
+		return agent.ChatAgentMessage{}, fmt.Errorf("only user messages can be edited, message %s is from the %s", messageID, messages[index].GetRole())
+	}
+	zap.S().Infof("Regenerating conversation from edited message %s", messageID)
+	c.SetMessages(messages[:index])
+	response, err := c.SendUserMessage(content)
+	if err != nil {
+		c.SetMessages(messages)
+		return agent.ChatAgentMessage{}, err
+	}
+	return response, nil
+}
+
+// storeCurrentBranch copies the messages of the agent into the current
+// branch, which holds them while another branch is current.
+func (c *Conversation) storeCurrentBranch() {
+	c.branches[c.branch].Messages = copyMessages(c.GetMessages())
+}
+
+func (c *Conversation) getBranches() []ConversationBranch {
+	branches := []ConversationBranch{}
+	for _, branch := range c.branches {
+		branches = append(branches, copyBranch(*branch))
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		if branches[i].CreatedAt.Equal(branches[j].CreatedAt) {
+			return branches[i].Name < branches[j].Name
+		}
+		return branches[i].CreatedAt.Before(branches[j].CreatedAt)
+	})
+	return branches
+}
+
+func findMessage(messages []agent.ChatAgentMessage, messageID string) int {
+	for i, message := range messages {
+		if message.GetID() == messageID {
+			return i
+		}
+	}
+	return -1
+}
+
+func copyBranch(branch ConversationBranch) ConversationBranch {
+	branch.Messages = copyMessages(branch.Messages)
+	return branch
+}
+
+func copyMessages(messages []agent.ChatAgentMessage) []agent.ChatAgentMessage {
+	copied := make([]agent.ChatAgentMessage, len(messages))
+	copy(copied, messages)
+	return copied
+}
@@ -0,0 +1,141 @@
+package chat
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+	"github.com/CSXL/solus/ai/openai"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newBranchTestConversation(t *testing.T, responses ...openai.OpenAIResponse) *Conversation {
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	requests := make(chan []byte, len(responses))
+	ts := openai.StartSequenceHTTPTestServer(requests, responses...)
+	t.Cleanup(ts.Close)
+	conversation.chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	return conversation
+}
+
+func addTestTurns(conversation *Conversation, turns int) {
+	for i := 0; i < turns; i++ {
+		conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, "question"))
+		conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleAssistant, "answer"))
+	}
+}
+
+func TestConversation_ForkAndSwitchBranch(t *testing.T) {
+	conversation := newBranchTestConversation(t)
+	addTestTurns(conversation, 2)
+	assert.Equal(t, DefaultBranchName, conversation.GetCurrentBranch())
+	forkMessage := conversation.GetMessages()[1]
+	assert.Nil(t, conversation.ForkAt("retry", forkMessage.GetID()))
+	assert.Equal(t, "retry", conversation.GetCurrentBranch())
+	assert.Equal(t, 2, conversation.GetMessageCount())
+	addTestTurns(conversation, 1)
+	assert.Equal(t, 4, conversation.GetMessageCount())
+
+	assert.ErrorIs(t, conversation.ForkAt("retry", ""), ErrBranchExists)
+	assert.ErrorIs(t, conversation.ForkAt("other", "missing-id"), ErrMessageNotFound)
+	assert.ErrorIs(t, conversation.SwitchBranch("missing"), ErrBranchNotFound)
+
+	assert.Nil(t, conversation.SwitchBranch(DefaultBranchName))
+	assert.Equal(t, 4, conversation.GetMessageCount())
+	branches := conversation.GetBranches()
+	assert.Len(t, branches, 2)
+	assert.Equal(t, DefaultBranchName, branches[0].Name)
+	assert.Equal(t, "retry", branches[1].Name)
+	assert.Equal(t, DefaultBranchName, branches[1].Parent)
+	assert.Equal(t, forkMessage.GetID(), branches[1].ForkMessageID)
+	assert.Len(t, branches[1].Messages, 4)
+	assert.Equal(t, forkMessage.GetID(), branches[1].Messages[1].GetID())
+	assert.NotEqual(t, branches[0].Messages[2].GetID(), branches[1].Messages[2].GetID())
+
+	assert.Nil(t, conversation.Fork("copy"))
+	assert.Equal(t, conversation.GetMessages(), mustGetBranch(t, conversation, DefaultBranchName).Messages)
+	assert.Nil(t, conversation.ForkAt("empty", ""))
+	assert.Equal(t, 0, conversation.GetMessageCount())
+}
+
+func mustGetBranch(t *testing.T, conversation *Conversation, name string) ConversationBranch {
+	branch, err := conversation.GetBranch(name)
+	assert.Nil(t, err)
+	return branch
+}
+
+func TestConversation_Rewind(t *testing.T) {
+	conversation := newBranchTestConversation(t)
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleSystem, "prompt"))
+	addTestTurns(conversation, 3)
+	assert.NotNil(t, conversation.Rewind(0))
+	assert.NotNil(t, conversation.Rewind(4))
+	assert.Equal(t, 7, conversation.GetMessageCount())
+	assert.Nil(t, conversation.Rewind(2))
+	assert.Equal(t, 3, conversation.GetMessageCount())
+	assert.Equal(t, "answer", conversation.GetMessages()[2].GetContent())
+	assert.Nil(t, conversation.Rewind(1))
+	assert.Equal(t, 1, conversation.GetMessageCount())
+	assert.Equal(t, "prompt", conversation.GetMessages()[0].GetContent())
+}
+
+func TestConversation_EditAndRegenerate(t *testing.T) {
+	conversation := newBranchTestConversation(t, openai.SampleChatJSONCompletion)
+	addTestTurns(conversation, 2)
+	edited := conversation.GetMessages()[2]
+	_, err := conversation.EditAndRegenerate(conversation.GetMessages()[1].GetID(), "edited question")
+	assert.NotNil(t, err)
+	_, err = conversation.EditAndRegenerate("missing-id", "edited question")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	assert.Nil(t, conversation.Fork("original"))
+	assert.Nil(t, conversation.SwitchBranch(DefaultBranchName))
+	response, err := conversation.EditAndRegenerate(edited.GetID(), "edited question")
+	assert.Nil(t, err)
+	assert.Equal(t, "CSX Labs is an amazing organization.", response.GetContent())
+	messages := conversation.GetMessages()
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "edited question", messages[2].GetContent())
+	assert.NotEqual(t, edited.GetID(), messages[2].GetID())
+	assert.Equal(t, "question", mustGetBranch(t, conversation, "original").Messages[2].GetContent())
+}
+
+func TestConversation_SaveAndLoadBranches(t *testing.T) {
+	conversation := newBranchTestConversation(t)
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	addTestTurns(conversation, 2)
+
+	assert.Nil(t, conversation.ForkAt("retry", conversation.GetMessages()[1].GetID()))
+	addTestTurns(conversation, 1)
+	assert.Nil(t, conversation.SaveToFile(filename))
+
+	loaded := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	assert.Nil(t, loaded.LoadFromFile(filename))
+	assert.Equal(t, "retry", loaded.GetCurrentBranch())
+	assert.Equal(t, 4, loaded.GetMessageCount())
+	assert.Equal(t, conversation.GetBranches()[1].ForkMessageID, loaded.GetBranches()[1].ForkMessageID)
+	assert.Nil(t, loaded.SwitchBranch(DefaultBranchName))
+	assert.Equal(t, 4, loaded.GetMessageCount())
+	assert.Equal(t, mustGetBranch(t, conversation, DefaultBranchName).Messages[3].GetID(), loaded.GetMessages()[3].GetID())
+
+	unforked := newBranchTestConversation(t)
+	assert.Nil(t, unforked.SaveToFile(filename))
+	assert.Nil(t, loaded.LoadFromFile(filename))
+	assert.Equal(t, DefaultBranchName, loaded.GetCurrentBranch())
+	assert.Len(t, loaded.GetBranches(), 1)
+}
+
+func TestConversation_EditAndRegenerateKeepsMessagesOnError(t *testing.T) {
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	ts := openai.StartFlakyHTTPTestServer(1, http.StatusBadRequest, openai.SampleChatJSONCompletion)
+	t.Cleanup(ts.Close)
+	conversation.chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	addTestTurns(conversation, 2)
+	original := conversation.GetMessages()
+	_, err := conversation.EditAndRegenerate(original[2].GetID(), "edited question")
+	assert.NotNil(t, err)
+	assert.Equal(t, original, conversation.GetMessages())
+}
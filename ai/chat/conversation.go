@@ -1,70 +1,165 @@
 package chat
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/agent"
 	"go.uber.org/zap"
 )
 
 type Conversation struct {
-	chatAgent *agent.ChatAgent
-	config    *ai.AIConfig
+	name               string
+	chatAgent          *agent.ChatAgent
+	supervisor         *agent.AgentSupervisor // Runs chatAgent, restarting it if it crashes
+	config             *ai.AIConfig
+	autosaveScheduleID string // ID of the autosave schedule, empty when disabled
+	branchesMutex      sync.Mutex
+	branch             string                         // Name of the current branch, whose messages are the agent's
+	branches           map[string]*ConversationBranch // By name
 }
 
+// ConversationAutosaveTaskType is the type of the tasks that periodically
+// save a conversation. Saves run one at a time.
+var ConversationAutosaveTaskType = agent.NewAgentTaskType("autosave", true)
+
+// ConversationMessageType is the type of the supervisor messages holding
+// chat messages for a conversation's agent, see GetSupervisor.
+const ConversationMessageType agent.AgentMessageType = "chat"
+
+// ConversationRestartPolicy is how the supervisor of a conversation restarts
+// its agent when it crashes.
+var ConversationRestartPolicy = agent.NewSupervisorRestartPolicy(3, time.Minute)
+
 // NewConversation creates a new conversation with the given name and config.
-// The underlying agent will be started automatically on the first message.
+// The underlying agent is run by a supervisor of its own, which starts
+// automatically on the first message.
 // If you want to start the agent preemptively, use the PreemptiveStart()
 // function.
 func NewConversation(name string, config *ai.AIConfig) *Conversation {
+	chatAgent := agent.NewChatAgent(name, config)
+	supervisor := agent.NewAgentSupervisor(name)
+	// Neither can fail on a new supervisor.
+	_ = supervisor.AddAgent(name, chatAgent, ConversationRestartPolicy)
+	_ = supervisor.HandleMessages(name, ConversationMessageType, agent.NewChatAgentMessageHandler(chatAgent))
 	return &Conversation{
-		chatAgent: agent.NewChatAgent(name, config),
-		config:    config,
+		name:       name,
+		chatAgent:  chatAgent,
+		supervisor: supervisor,
+		config:     config,
+		branch:     DefaultBranchName,
+		branches:   newConversationBranches(),
 	}
 }
 
+// GetSupervisor returns the supervisor running the conversation's agent,
+// under the conversation's name. Other agents added to it are started and
+// stopped along with the conversation, and can send it chat messages as
+// ConversationMessageType messages.
+func (c *Conversation) GetSupervisor() *agent.AgentSupervisor {
+	return c.supervisor
+}
+
 // Starts the agent underlying the conversation.
 // As the conversation is started automatically on the first message, this
 // function is only useful if you want to start the conversation preemptively
 // due to computational constraints.
 func (c *Conversation) PreemptiveStart() {
-	c.chatAgent.Start()
+	c.startIfNotStarted()
 }
 
+// DefaultCloseTimeout is how long Close waits for pending messages before
+// abandoning them.
+const DefaultCloseTimeout = 30 * time.Second
+
 // Close the conversation.
-// This will close the underlying agent.
-func (c *Conversation) Close() {
-	c.chatAgent.Stop()
+// This will shut down the underlying agent, waiting up to
+// DefaultCloseTimeout for pending messages to be sent.
+func (c *Conversation) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultCloseTimeout)
+	defer cancel()
+	_, err := c.Shutdown(ctx)
+	return err
+}
+
+// Shutdown the conversation and the other agents of its supervisor.
+// Pending messages are sent until ctx is done, after which the remaining
+// ones are abandoned and reported.
+func (c *Conversation) Shutdown(ctx context.Context) (agent.AgentShutdownReport, error) {
+	reports, err := c.supervisor.Shutdown(ctx)
+	return reports[c.name], err
 }
 
 // Kills and deletes the conversation.
-// This will kill and delete the underlying agent.
+// This will kill and delete the underlying agent, along with the other
+// agents of its supervisor.
 func (c *Conversation) Kill() {
-	c.chatAgent.Kill()
+	c.supervisor.Kill()
 	c.chatAgent.ResetMessages()
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	c.branch = DefaultBranchName
+	c.branches = newConversationBranches()
 }
 
+// startIfNotStarted starts the conversation's supervisor, and with it the
+// agent. An agent its supervisor gave up restarting is started again.
 func (c *Conversation) startIfNotStarted() {
-	if !c.chatAgent.IsRunning() {
-		c.chatAgent.Start()
+	if !c.supervisor.IsRunning() {
+		c.supervisor.Start()
+		return
+	}
+	for _, status := range c.supervisor.GetStatus() {
+		if status.Name == c.name && status.GaveUp && !status.Running {
+			c.chatAgent.Start()
+		}
 	}
 }
 
+// LoadFromFile loads the messages and branches of the conversation from
+// filename, migrating files saved by earlier versions.
 func (c *Conversation) LoadFromFile(filename string) error {
 	zap.S().Infof("Loading conversation from file %s", filename)
-	c.ResetMessages()
-	err := c.chatAgent.OpenAIChatClient.LoadMessages(filename)
+	document, err := ReadConversationDocument(filename)
 	if err != nil {
 		return err
 	}
-	for _, msg := range c.chatAgent.OpenAIChatClient.GetMessages() {
-		c.chatAgent.AddMessage(*agent.ChatAgentMessageFromOpenAIChatMessage(msg))
-	}
-	return nil
+	return c.LoadDocument(document)
 }
 
+// SaveToFile saves the conversation to filename as a ConversationDocument.
 func (c *Conversation) SaveToFile(filename string) error {
 	zap.S().Infof("Saving conversation to file %s", filename)
-	return c.chatAgent.OpenAIChatClient.SaveMessages(filename)
+	return WriteConversationDocument(filename, c.ToDocument())
+}
+
+// EnableAutosave saves the conversation to filename every interval until
+// DisableAutosave is called or the conversation is closed or killed. Enabling
+// it again replaces the previous schedule.
+func (c *Conversation) EnableAutosave(filename string, interval time.Duration) error {
+	c.DisableAutosave()
+	c.startIfNotStarted()
+	scheduleID, err := c.chatAgent.ScheduleEvery("autosave", interval, func() (agent.IAgentTask, error) {
+		return agent.NewAgentTask("autosave", ConversationAutosaveTaskType, func(ctx context.Context) (string, error) {
+			return filename, c.SaveToFile(filename)
+		}), nil
+	})
+	if err != nil {
+		return err
+	}
+	c.autosaveScheduleID = scheduleID
+	return nil
+}
+
+// DisableAutosave stops saving the conversation periodically.
+func (c *Conversation) DisableAutosave() {
+	if c.autosaveScheduleID == "" {
+		return
+	}
+	c.chatAgent.CancelSchedule(c.autosaveScheduleID)
+	c.autosaveScheduleID = ""
 }
 
 func (c *Conversation) GetMessages() []agent.ChatAgentMessage {
@@ -100,13 +195,51 @@ func (c *Conversation) GetConfig() *ai.AIConfig {
 	return c.config
 }
 
+// SetModelOptions sets the model and sampling parameters of the
+// conversation, replacing those of its config.
+func (c *Conversation) SetModelOptions(options ai.ModelOptions) error {
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+	c.config.ModelOptions = options
+	c.chatAgent.SetModelOptions(options)
+	return nil
+}
+
 // Send a message to the conversation.
 // The message will be sent to the agent and the agent will respond with a
 // completion.
 func (c *Conversation) SendUserMessage(msgContent string) (agent.ChatAgentMessage, error) {
+	return c.SendUserMessageWithOptions(msgContent, ai.ModelOptions{})
+}
+
+// Send a message to the conversation with the model options set in overrides
+// replacing the conversation's for this message only.
+func (c *Conversation) SendUserMessageWithOptions(msgContent string, overrides ai.ModelOptions) (agent.ChatAgentMessage, error) {
 	c.startIfNotStarted()
 	agentMsg := agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, msgContent)
-	aiResponse, err := c.chatAgent.SendChatMessage(*agentMsg)
+	aiResponse, err := c.chatAgent.SendChatMessageWithOptions(*agentMsg, overrides)
+	if aiResponse == nil {
+		return agent.ChatAgentMessage{}, err
+	}
+	return *aiResponse, err
+}
+
+// Stream a message to the conversation.
+// Like SendUserMessage, but the pieces of the agent's response are written to
+// deltas as they arrive. deltas is closed once the response is complete, and
+// must be read until then.
+func (c *Conversation) StreamUserMessage(msgContent string, deltas chan<- agent.ChatAgentMessageDelta) (agent.ChatAgentMessage, error) {
+	return c.StreamUserMessageWithOptions(msgContent, deltas, ai.ModelOptions{})
+}
+
+// Stream a message to the conversation with the model options set in
+// overrides replacing the conversation's for this message only.
+func (c *Conversation) StreamUserMessageWithOptions(msgContent string, deltas chan<- agent.ChatAgentMessageDelta, overrides ai.ModelOptions) (agent.ChatAgentMessage, error) {
+	c.startIfNotStarted()
+	agentMsg := agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, msgContent)
+	aiResponse, err := c.chatAgent.StreamChatMessageWithOptions(*agentMsg, deltas, overrides)
 	if aiResponse == nil {
 		return agent.ChatAgentMessage{}, err
 	}
@@ -1,7 +1,11 @@
 package chat
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/agent"
@@ -57,7 +61,61 @@ func TestConversation_Close(t *testing.T) {
 	convName := "test-conv"
 	config := ai.NewAIConfig("test-openai-api-key")
 	conversation := NewConversation(convName, config)
-	conversation.Close()
+	assert.Nil(t, conversation.Close())
+}
+
+func TestConversation_Shutdown(t *testing.T) {
+	convName := "test-conv"
+	config := ai.NewAIConfig("test-openai-api-key")
+	conversation := NewConversation(convName, config)
+	ts := openai.StartHTTPTestServer(openai.SampleChatCompletion)
+	defer ts.Close()
+	conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	_, err := conversation.SendUserMessage("test-content")
+	assert.Nil(t, err)
+	report, err := conversation.Shutdown(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(report.Abandoned))
+	assert.False(t, conversation.GetAgent().IsRunning())
+	_, err = conversation.SendUserMessage("test-content")
+	assert.Nil(t, err)
+	assert.Nil(t, conversation.Close())
+}
+
+func TestConversation_SupervisorRestartsCrashedAgent(t *testing.T) {
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	ts := openai.StartHTTPTestServer(openai.SampleChatCompletion)
+	defer ts.Close()
+	conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	conversation.PreemptiveStart()
+	defer conversation.Kill()
+	assert.True(t, conversation.GetSupervisor().IsRunning())
+	conversation.GetAgent().Kill()
+	assert.Eventually(t, func() bool {
+		status := conversation.GetSupervisor().GetStatus()[0]
+		return status.Running && status.Restarts == 1
+	}, time.Second, 10*time.Millisecond)
+	_, err := conversation.SendUserMessage("test-content")
+	assert.Nil(t, err)
+}
+
+func TestConversation_SupervisorRoutesChatMessages(t *testing.T) {
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	ts := openai.StartHTTPTestServer(openai.SampleChatCompletion)
+	defer ts.Close()
+	conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	supervisor := conversation.GetSupervisor()
+	assert.Nil(t, supervisor.AddAgent("research", agent.NewAgent("research", "testAgentType", nil), nil))
+	conversation.PreemptiveStart()
+	defer conversation.Kill()
+	task, err := supervisor.Send("research", "test-conv", ConversationMessageType, "test-content")
+	assert.Nil(t, err)
+	response, err := task.Await(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "\n\nHi there! How may I assist you today?", response.(*agent.ChatAgentMessage).Content)
+	assert.Equal(t, 2, conversation.GetMessageCount())
+	assert.Nil(t, conversation.Close())
+	assert.False(t, supervisor.IsRunning())
 }
 
 func TestConversation_Kill(t *testing.T) {
@@ -80,6 +138,45 @@ func TestConversation_SendUserMessage(t *testing.T) {
 	assert.NotNil(t, conversation.GetLastMessage())
 }
 
+func TestConversation_SendUserMessageWithOptions(t *testing.T) {
+	config := ai.NewAIConfig("test-openai-api-key")
+	config.Model = "gpt-3.5-turbo"
+	conversation := NewConversation("test-conv", config)
+	assert.Equal(t, "gpt-3.5-turbo", conversation.GetAgent().GetModelOptions().Model)
+	assert.NotNil(t, conversation.SetModelOptions(ai.ModelOptions{Temperature: ai.Float32(3)}))
+	assert.Nil(t, conversation.SetModelOptions(ai.ModelOptions{Model: "gpt-3.5-turbo-16k", Temperature: ai.Float32(0.5)}))
+	assert.Equal(t, "gpt-3.5-turbo-16k", conversation.GetConfig().Model)
+	requests := make(chan []byte, 1)
+	ts := openai.StartSequenceHTTPTestServer(requests, openai.SampleChatCompletion)
+	defer ts.Close()
+	conversation.chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	_, err := conversation.SendUserMessageWithOptions("test-content", ai.ModelOptions{Model: "gpt-4"})
+	assert.Nil(t, err)
+	request := string(<-requests)
+	assert.Contains(t, request, `"model":"gpt-4"`)
+	assert.Contains(t, request, `"temperature":0.5`)
+	assert.Equal(t, "gpt-3.5-turbo-16k", conversation.GetAgent().GetModelOptions().Model)
+}
+
+func TestConversation_StreamUserMessage(t *testing.T) {
+	convName := "test-conv"
+	config := ai.NewAIConfig("test-openai-api-key")
+	conversation := NewConversation(convName, config)
+	ts := openai.StartStreamingHTTPTestServer(openai.SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	conversation.chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	deltas := make(chan agent.ChatAgentMessageDelta, 16)
+	response, err := conversation.StreamUserMessage("test-content", deltas)
+	assert.Nil(t, err)
+	streamed := ""
+	for delta := range deltas {
+		streamed += delta.Delta
+	}
+	assert.Equal(t, response.GetContent(), streamed)
+	assert.Equal(t, 2, conversation.GetMessageCount())
+	assert.Equal(t, response, conversation.GetLastMessage())
+}
+
 func TestConversation_SendSystemMessage(t *testing.T) {
 	convName := "test-conv"
 	config := ai.NewAIConfig("test-openai-api-key")
@@ -93,3 +190,49 @@ func TestConversation_SendSystemMessage(t *testing.T) {
 	assert.Equal(t, 2, conversation.GetMessageCount())
 	assert.NotNil(t, conversation.GetLastMessage())
 }
+
+func TestConversation_Autosave(t *testing.T) {
+	convName := "test-conv"
+	config := ai.NewAIConfig("test-openai-api-key")
+	conversation := NewConversation(convName, config)
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, "test-content"))
+	assert.Nil(t, conversation.EnableAutosave(filename, 10*time.Millisecond))
+	assert.Len(t, conversation.GetAgent().GetSchedules(), 1)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(filename)
+		return err == nil
+	}, 2*time.Second, 5*time.Millisecond)
+	loaded := NewConversation(convName, config)
+	assert.Nil(t, loaded.LoadFromFile(filename))
+	assert.Equal(t, 1, loaded.GetMessageCount())
+	conversation.DisableAutosave()
+	assert.Empty(t, conversation.GetAgent().GetSchedules())
+	assert.Nil(t, conversation.EnableAutosave(filename, time.Hour))
+	assert.Nil(t, conversation.Close())
+	assert.Empty(t, conversation.GetAgent().GetSchedules())
+}
+
+func TestConversation_SaveAndLoadKeepsMetadata(t *testing.T) {
+	config := ai.NewAIConfig("test-openai-api-key")
+	conversation := NewConversation("test-conv", config)
+	ts := openai.StartHTTPTestServer(openai.SampleChatJSONCompletion)
+	defer ts.Close()
+	conversation.chatAgent.OpenAIChatClient.SetBaseURL(ts.URL)
+	response, err := conversation.SendUserMessage("test-content")
+	assert.Nil(t, err)
+	assert.Equal(t, "gpt-3.5-turbo-0301", response.GetMetadata().Model)
+	assert.Equal(t, 20, response.GetMetadata().TotalTokens())
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	assert.Nil(t, conversation.SaveToFile(filename))
+	loaded := NewConversation("test-conv", config)
+	assert.Nil(t, loaded.LoadFromFile(filename))
+	assert.Equal(t, conversation.GetMessageCount(), loaded.GetMessageCount())
+	for i, message := range conversation.GetMessages() {
+		loadedMessage := loaded.GetMessages()[i]
+		assert.Equal(t, message.GetID(), loadedMessage.GetID())
+		assert.Equal(t, message.GetMetadata().Model, loadedMessage.GetMetadata().Model)
+		assert.Equal(t, message.GetMetadata().Latency, loadedMessage.GetMetadata().Latency)
+		assert.True(t, message.GetMetadata().CreatedAt.Equal(loadedMessage.GetMetadata().CreatedAt))
+	}
+}
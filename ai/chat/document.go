@@ -0,0 +1,175 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+	"github.com/CSXL/solus/ai/openai"
+)
+
+// ConversationDocumentVersion is the version of the file format SaveToFile
+// writes. Files of earlier versions are migrated when they are read.
+//
+// Version 0 is the bare array of chat messages conversations used to be
+// saved as.
+const ConversationDocumentVersion = 1
+
+// ConversationDocument is a conversation as it is saved: its messages with
+// their types and metadata, its branches and the config it was held with.
+type ConversationDocument struct {
+	Version  int
+	Name     string
+	SavedAt  time.Time
+	Config   ConversationConfig
+	Branch   string                   // Name of the current branch
+	Messages []agent.ChatAgentMessage // Of the current branch
+	Branches []ConversationBranch     // Oldest first, without the messages of the current branch
+}
+
+// ConversationConfig is what a document keeps of the config of its
+// conversation, which is all of it but the API key.
+type ConversationConfig struct {
+	Provider       ai.ProviderConfig
+	EmbeddingModel string `json:",omitempty"`
+	ModelOptions   ai.ModelOptions
+}
+
+// ToDocument returns the conversation as it would be saved.
+func (c *Conversation) ToDocument() ConversationDocument {
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	c.storeCurrentBranch()
+	branches := c.getBranches()
+	for i := range branches {
+		if branches[i].Name == c.branch {
+			branches[i].Messages = nil
+		}
+	}
+	return ConversationDocument{
+		Version: ConversationDocumentVersion,
+		Name:    c.chatAgent.GetName(),
+		SavedAt: time.Now(),
+		Config: ConversationConfig{
+			Provider:       c.config.Provider,
+			EmbeddingModel: c.config.EmbeddingModel,
+			ModelOptions:   c.config.ModelOptions,
+		},
+		Branch:   c.branch,
+		Messages: copyMessages(c.GetMessages()),
+		Branches: branches,
+	}
+}
+
+// LoadDocument replaces the messages and branches of the conversation with
+// those of document, continuing on the branch that was current. The
+// conversation keeps its name and config.
+func (c *Conversation) LoadDocument(document ConversationDocument) error {
+	if document.Version != ConversationDocumentVersion {
+		return fmt.Errorf("unsupported conversation document version %d, expected %d", document.Version, ConversationDocumentVersion)
+	}
+	if document.Branch == "" {
+		document.Branch = DefaultBranchName
+	}
+	branches := map[string]*ConversationBranch{}
+	for i := range document.Branches {
+		branch := copyBranch(document.Branches[i])
+		if _, ok := branches[branch.Name]; ok {
+			return fmt.Errorf("invalid conversation document: %w: %s", ErrBranchExists, branch.Name)
+		}
+		branches[branch.Name] = &branch
+	}
+	if len(branches) == 0 && document.Branch == DefaultBranchName {
+		branches = newConversationBranches()
+	}
+	if _, ok := branches[document.Branch]; !ok {
+		return fmt.Errorf("invalid conversation document: %w: %s", ErrBranchNotFound, document.Branch)
+	}
+	c.branchesMutex.Lock()
+	defer c.branchesMutex.Unlock()
+	c.branch = document.Branch
+	c.branches = branches
+	c.SetMessages(copyMessages(document.Messages))
+	return nil
+}
+
+// ReadConversationDocument reads the conversation saved to filename,
+// migrating it to ConversationDocumentVersion.
+func ReadConversationDocument(filename string) (ConversationDocument, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return ConversationDocument{}, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return migrateMessagesFile(data)
+	}
+	var document ConversationDocument
+	err = json.Unmarshal(data, &document)
+	if err != nil {
+		return ConversationDocument{}, fmt.Errorf("invalid conversation document: %w", err)
+	}
+	if document.Version > ConversationDocumentVersion {
+		return ConversationDocument{}, fmt.Errorf("conversation document version %d is newer than the supported version %d", document.Version, ConversationDocumentVersion)
+	}
+	if document.Version < 1 {
+		return ConversationDocument{}, fmt.Errorf("invalid conversation document version %d", document.Version)
+	}
+	return document, nil
+}
+
+// WriteConversationDocument saves document to filename. The document is
+// written to a temporary file next to it first and renamed over it, so that
+// an interrupted save leaves the previous document in place.
+func WriteConversationDocument(filename string, document ConversationDocument) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Nothing is left to remove once the file was renamed.
+	defer os.Remove(file.Name()) // trunk-ignore(golangci-lint/errcheck)
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
+
+// migrateMessagesFile migrates a version 0 file, whose messages become those
+// of the default branch.
+func migrateMessagesFile(data []byte) (ConversationDocument, error) {
+	var chatMessages []openai.ChatMessage
+	err := json.Unmarshal(data, &chatMessages)
+	if err != nil {
+		return ConversationDocument{}, fmt.Errorf("invalid messages file: %w", err)
+	}
+	messages := []agent.ChatAgentMessage{}
+	for _, chatMessage := range chatMessages {
+		message := agent.ChatAgentMessageFromOpenAIChatMessage(chatMessage)
+		message.Serialize()
+		messages = append(messages, *message)
+	}
+	return ConversationDocument{
+		Version:  ConversationDocumentVersion,
+		Branch:   DefaultBranchName,
+		Messages: messages,
+	}, nil
+}
@@ -0,0 +1,123 @@
+package chat
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversation_SaveToFileWritesDocument(t *testing.T) {
+	config := ai.NewAIConfig("test-openai-api-key")
+	config.Model = "gpt-4"
+	config.Temperature = ai.Float32(0.5)
+	conversation := NewConversation("test-conv", config)
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeQuery, agent.ChatAgentMessageRoleAssistant, "CSX Labs"))
+	toolCall := agent.NewChatAgentMessage(agent.ChatAgentMessageTypeToolCall, agent.ChatAgentMessageRoleAssistant, "")
+	toolCall.ToolCall = &agent.ChatAgentToolCall{Name: "web_search", Arguments: `{"query":"CSX Labs"}`}
+	conversation.AddMessage(*toolCall)
+	conversation.AddMessage(*agent.NewChatAgentToolResultMessage("web_search", "results"))
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	assert.Nil(t, conversation.SaveToFile(filename))
+
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "test-openai-api-key")
+	document, err := ReadConversationDocument(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, ConversationDocumentVersion, document.Version)
+	assert.Equal(t, "test-conv", document.Name)
+	assert.False(t, document.SavedAt.IsZero())
+	assert.Equal(t, "gpt-4", document.Config.ModelOptions.Model)
+	assert.Equal(t, float32(0.5), *document.Config.ModelOptions.Temperature)
+	assert.Equal(t, DefaultBranchName, document.Branch)
+	assert.Len(t, document.Branches, 1)
+	assert.Empty(t, document.Branches[0].Messages)
+
+	loaded := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	assert.Nil(t, loaded.LoadFromFile(filename))
+	saved, err := json.Marshal(conversation.GetMessages())
+	assert.Nil(t, err)
+	restored, err := json.Marshal(loaded.GetMessages())
+	assert.Nil(t, err)
+	assert.JSONEq(t, string(saved), string(restored))
+	messages := loaded.GetMessages()
+	assert.True(t, messages[0].IsQueryMessage())
+	assert.Equal(t, "CSX Labs", messages[0].GetContent())
+	assert.Equal(t, "web_search", messages[1].ToolCall.Name)
+	assert.True(t, messages[2].IsToolResultMessage())
+	assert.Len(t, loaded.GetAgent().OpenAIChatClient.GetMessages(), 3)
+}
+
+func TestWriteConversationDocument_ReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "messages.json")
+	assert.Nil(t, os.WriteFile(filename, []byte("previous"), 0600))
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, "Hello"))
+	assert.Nil(t, WriteConversationDocument(filename, conversation.ToDocument()))
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	info, err := os.Stat(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm())
+	document, err := ReadConversationDocument(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello", document.Messages[0].GetContent())
+
+	assert.NotNil(t, WriteConversationDocument(filepath.Join(dir, "missing", "messages.json"), conversation.ToDocument()))
+}
+
+func TestReadConversationDocument_MigratesMessagesFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	legacy := `[{"Content":"{\"type\":\"text\",\"content\":\"Hello\"}","Role":"user"},` +
+		`{"Content":"{\"type\":\"query\",\"content\":\"CSX Labs\"}","Role":"assistant","Metadata":{"ID":"response-id","CreatedAt":"2023-06-01T12:00:00Z","Model":"gpt-4"}}]`
+	assert.Nil(t, os.WriteFile(filename, []byte(legacy), 0644))
+	document, err := ReadConversationDocument(filename)
+	assert.Nil(t, err)
+	assert.Equal(t, ConversationDocumentVersion, document.Version)
+	assert.Equal(t, DefaultBranchName, document.Branch)
+	assert.Len(t, document.Messages, 2)
+	assert.Equal(t, agent.ChatAgentMessageTypeText, document.Messages[0].Type)
+	assert.Equal(t, "Hello", document.Messages[0].Content)
+	assert.NotEmpty(t, document.Messages[0].GetID())
+	assert.Equal(t, agent.ChatAgentMessageTypeQuery, document.Messages[1].Type)
+	assert.Equal(t, "response-id", document.Messages[1].GetID())
+	assert.Equal(t, "gpt-4", document.Messages[1].Metadata.Model)
+
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	assert.Nil(t, conversation.LoadFromFile(filename))
+	assert.Equal(t, 2, conversation.GetMessageCount())
+	assert.Nil(t, conversation.SaveToFile(filename))
+	data, err := os.ReadFile(filename)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "{"))
+	assert.Nil(t, conversation.LoadFromFile(filename))
+	assert.Equal(t, "response-id", conversation.GetMessages()[1].GetID())
+}
+
+func TestReadConversationDocument_RejectsUnsupportedVersions(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"newer.json":   `{"Version":99,"Branch":"main"}`,
+		"missing.json": `{"Branch":"main"}`,
+		"invalid.json": `{"Version":`,
+	} {
+		filename := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(filename, []byte(content), 0644))
+		_, err := ReadConversationDocument(filename)
+		assert.NotNil(t, err, name)
+	}
+	conversation := NewConversation("test-conv", ai.NewAIConfig("test-openai-api-key"))
+	assert.NotNil(t, conversation.LoadDocument(ConversationDocument{Version: ConversationDocumentVersion, Branch: "missing"}))
+	assert.Nil(t, conversation.LoadDocument(ConversationDocument{Version: ConversationDocumentVersion}))
+	assert.Equal(t, DefaultBranchName, conversation.GetCurrentBranch())
+}
@@ -0,0 +1,330 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/CSXL/solus/ai/agent"
+)
+
+// ExportFormat is a format conversations can be exported to.
+type ExportFormat string
+
+const (
+	ExportFormatMarkdown ExportFormat = "markdown" // A readable transcript
+	ExportFormatHTML     ExportFormat = "html"     // A standalone page with the transcript
+	ExportFormatJSONL    ExportFormat = "jsonl"    // Chat completion examples for fine-tuning and evals
+)
+
+// ExportFormats lists the formats conversations can be exported to.
+var ExportFormats = []ExportFormat{ExportFormatMarkdown, ExportFormatHTML, ExportFormatJSONL}
+
+// ParseExportFormat returns the export format named name.
+func ParseExportFormat(name string) (ExportFormat, error) {
+	for _, format := range ExportFormats {
+		if string(format) == strings.ToLower(name) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown export format %q, expected one of %v", name, ExportFormats)
+}
+
+// ExportOptions selects what an export holds.
+type ExportOptions struct {
+	Title                string // Title of the transcript, the conversation's name when empty
+	Branch               string // Branch to export, the current one when empty
+	IncludeSystemPrompts bool   // Search results are exported either way
+}
+
+// Export writes the conversation to w in format.
+func (c *Conversation) Export(w io.Writer, format ExportFormat, options ExportOptions) error {
+	return ExportDocument(w, c.ToDocument(), format, options)
+}
+
+// ExportDocument writes the conversation saved as document to w in format.
+func ExportDocument(w io.Writer, document ConversationDocument, format ExportFormat, options ExportOptions) error {
+	switch format {
+	case ExportFormatMarkdown:
+		return ExportMarkdown(w, document, options)
+	case ExportFormatHTML:
+		return ExportHTML(w, document, options)
+	case ExportFormatJSONL:
+		return ExportJSONL(w, document, options)
+	default:
+		return fmt.Errorf("unknown export format %q, expected one of %v", format, ExportFormats)
+	}
+}
+
+// ExportMarkdown writes a transcript of the conversation saved as document
+// to w in Markdown.
+func ExportMarkdown(w io.Writer, document ConversationDocument, options ExportOptions) error {
+	entries, err := newTranscript(document, options)
+	if err != nil {
+		return err
+	}
+	var markdown strings.Builder
+	fmt.Fprintf(&markdown, "# %s\n", exportTitle(document, options))
+	for _, entry := range entries {
+		fmt.Fprintf(&markdown, "\n### %s\n\n", entry.Speaker())
+		if timestamp := entry.Timestamp(); timestamp != "" {
+			fmt.Fprintf(&markdown, "_%s_\n\n", timestamp)
+		}
+		switch {
+		case entry.Search:
+			fmt.Fprintf(&markdown, "*Searched for \"%s\"*\n", strings.TrimSpace(entry.Content))
+		case entry.ToolName != "":
+			fmt.Fprintf(&markdown, "*Called %s with %s*\n", markdownCode(entry.ToolName), markdownCode(entry.Content))
+		default:
+			fmt.Fprintf(&markdown, "%s\n", strings.TrimSpace(entry.Content))
+		}
+		for _, result := range entry.Results {
+			fmt.Fprintf(&markdown, "\n> %s\n", strings.ReplaceAll(strings.TrimSpace(result), "\n", "\n> "))
+		}
+	}
+	_, err = io.WriteString(w, markdown.String())
+	return err
+}
+
+// markdownCode formats text as an inline code span, fenced with more
+// backticks than the longest run of them in text so that none ends it early.
+func markdownCode(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	// Code spans drop one space from each end if both have one, and a
+	// backtick at either end would merge with the fence.
+	if strings.HasPrefix(text, "`") || strings.HasSuffix(text, "`") || (strings.HasPrefix(text, " ") && strings.HasSuffix(text, " ")) {
+		text = " " + text + " "
+	}
+	return fence + text + fence
+}
+
+var htmlTranscriptTemplate = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; line-height: 1.5; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; color: #1f2328; }
+section { border-left: 4px solid #d0d7de; margin: 1.5rem 0; padding: 0.25rem 1rem; }
+section.user { border-color: #0969da; }
+section.assistant { border-color: #1a7f37; }
+section.system { border-color: #9a6700; }
+header { font-weight: 600; }
+time { color: #656d76; font-weight: normal; margin-left: 0.5rem; }
+.content, pre { white-space: pre-wrap; }
+.action { font-style: italic; }
+pre { background: #f6f8fa; padding: 0.75rem; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{- range .Entries}}
+<section class="{{.Role}}">
+<header>{{.Speaker}}{{with .Timestamp}}<time>{{.}}</time>{{end}}</header>
+{{- if .Search}}
+<p class="action">Searched for &ldquo;{{.Content}}&rdquo;</p>
+{{- else if .ToolName}}
+<p class="action">Called <code>{{.ToolName}}</code> with <code>{{.Content}}</code></p>
+{{- else}}
+<div class="content">{{.Content}}</div>
+{{- end}}
+{{- range .Results}}
+<details><summary>Results</summary><pre>{{.}}</pre></details>
+{{- end}}
+</section>
+{{- end}}
+</body>
+</html>
+`))
+
+// ExportHTML writes a transcript of the conversation saved as document to w
+// as a standalone HTML page.
+func ExportHTML(w io.Writer, document ConversationDocument, options ExportOptions) error {
+	entries, err := newTranscript(document, options)
+	if err != nil {
+		return err
+	}
+	return htmlTranscriptTemplate.Execute(w, struct {
+		Title   string
+		Entries []transcriptEntry
+	}{exportTitle(document, options), entries})
+}
+
+type jsonlMessage struct {
+	Role         string             `json:"role"`
+	Content      string             `json:"content"`
+	Name         string             `json:"name,omitempty"`
+	FunctionCall *jsonlFunctionCall `json:"function_call,omitempty"`
+}
+
+type jsonlFunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ExportJSONL writes the conversation saved as document to w as chat
+// completion examples, one JSON object per line: each response of the
+// assistant with the messages it was given. Messages are written as they
+// were sent to the model.
+func ExportJSONL(w io.Writer, document ConversationDocument, options ExportOptions) error {
+	messages, err := documentBranchMessages(document, options.Branch)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	history := []jsonlMessage{}
+	for i, message := range messages {
+		if isSystemPrompt(messages, i) && !options.IncludeSystemPrompts {
+			continue
+		}
+		// Ignoring error for tolerance of AI Messages, as the agent does.
+		message.Marshal() // trunk-ignore(golangci-lint/errcheck)
+		chatMessage := message.ToOpenAIChatMessage()
+		jsonlMsg := jsonlMessage{Role: chatMessage.Role, Content: chatMessage.Content, Name: chatMessage.Name}
+		if chatMessage.FunctionCall != nil {
+			jsonlMsg.FunctionCall = &jsonlFunctionCall{Name: chatMessage.FunctionCall.Name, Arguments: chatMessage.FunctionCall.Arguments}
+		}
+		history = append(history, jsonlMsg)
+		if !message.IsAssistantMessage() {
+			continue
+		}
+		err = encoder.Encode(struct {
+			Messages []jsonlMessage `json:"messages"`
+		}{history})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transcriptEntry is a message as it is shown in a transcript, with the
+// results of the search or tool call it holds.
+type transcriptEntry struct {
+	Role      agent.ChatAgentMessageRole
+	CreatedAt time.Time
+	Content   string   // Text of the message, what was searched for, or the arguments of the tool call
+	Search    bool     // Whether the message is a search
+	ToolName  string   // Tool called by the message, if any
+	Results   []string // Of the search or tool call
+}
+
+// Speaker returns who wrote the entry.
+func (e transcriptEntry) Speaker() string {
+	switch e.Role {
+	case agent.ChatAgentMessageRoleUser:
+		return "User"
+	case agent.ChatAgentMessageRoleAssistant:
+		return "Assistant"
+	case agent.ChatAgentMessageRoleSystem:
+		return "System"
+	default:
+		return "Tool"
+	}
+}
+
+// Timestamp returns when the entry was written, empty if unknown.
+func (e transcriptEntry) Timestamp() string {
+	if e.CreatedAt.IsZero() {
+		return ""
+	}
+	return e.CreatedAt.UTC().Format("2006-01-02 15:04 MST")
+}
+
+// newTranscript returns the entries of a transcript of the branch of
+// document selected by options. Search results and tool results are folded
+// into the entries of the searches and tool calls they answer.
+func newTranscript(document ConversationDocument, options ExportOptions) ([]transcriptEntry, error) {
+	messages, err := documentBranchMessages(document, options.Branch)
+	if err != nil {
+		return nil, err
+	}
+	entries := []transcriptEntry{}
+	pending := -1 // Entry of the search or tool call awaiting results
+	for i, message := range messages {
+		message.Serialize()
+		switch {
+		case isSearchResults(messages, i) && pending >= 0:
+			entries[pending].Results = append(entries[pending].Results, message.Content)
+			pending = -1
+			continue
+		case message.IsToolResultMessage() && pending >= 0:
+			entries[pending].Results = append(entries[pending].Results, message.Content)
+			continue
+		case isSystemPrompt(messages, i) && !options.IncludeSystemPrompts:
+			continue
+		}
+		entry := transcriptEntry{
+			Role:      message.Role,
+			CreatedAt: message.Metadata.CreatedAt,
+			Content:   message.Content,
+		}
+		pending = -1
+		switch {
+		case message.IsQueryMessage():
+			entry.Search = true
+			pending = len(entries)
+		case message.IsToolCallMessage() && message.ToolCall != nil:
+			entry.ToolName = message.ToolCall.Name
+			entry.Content = message.ToolCall.Arguments
+			pending = len(entries)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// isSearchResults reports whether the message at i holds the results of the
+// search the assistant asked for in the message before it, which are sent
+// back as a system message.
+func isSearchResults(messages []agent.ChatAgentMessage, i int) bool {
+	if !messages[i].IsSystemMessage() || i == 0 {
+		return false
+	}
+	previous := messages[i-1]
+	previous.Serialize()
+	return previous.IsQueryMessage()
+}
+
+func isSystemPrompt(messages []agent.ChatAgentMessage, i int) bool {
+	return messages[i].IsSystemMessage() && !isSearchResults(messages, i)
+}
+
+// documentBranchMessages returns the messages of the branch of document
+// named name, or of the current branch if name is empty.
+func documentBranchMessages(document ConversationDocument, name string) ([]agent.ChatAgentMessage, error) {
+	if name == "" || name == document.Branch {
+		return document.Messages, nil
+	}
+	for _, branch := range document.Branches {
+		if branch.Name == name {
+			return branch.Messages, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrBranchNotFound, name)
+}
+
+func exportTitle(document ConversationDocument, options ExportOptions) string {
+	if options.Title != "" {
+		return options.Title
+	}
+	if document.Name != "" {
+		return document.Name
+	}
+	return "Conversation"
+}
@@ -0,0 +1,121 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newExportTestConversation() *Conversation {
+	conversation := NewConversation("discovery", ai.NewAIConfig("test-openai-api-key"))
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleSystem, "You are a helpful assistant."))
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleUser, "What is CSX Labs?"))
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeQuery, agent.ChatAgentMessageRoleAssistant, "CSX Labs"))
+	conversation.AddMessage(*agent.NewChatAgentMessage(agent.ChatAgentMessageTypeText, agent.ChatAgentMessageRoleSystem, "CSX Labs is a <research> organization."))
+	conversation.AddMessage(*agent.NewChatAgentMessage("message", agent.ChatAgentMessageRoleAssistant, "CSX Labs is an amazing organization."))
+	toolCall := agent.NewChatAgentMessage(agent.ChatAgentMessageTypeToolCall, agent.ChatAgentMessageRoleAssistant, "")
+	toolCall.ToolCall = &agent.ChatAgentToolCall{Name: "read_file", Arguments: `{"path":"README.md"}`}
+	conversation.AddMessage(*toolCall)
+	conversation.AddMessage(*agent.NewChatAgentToolResultMessage("read_file", "# Solus"))
+	return conversation
+}
+
+func TestParseExportFormat(t *testing.T) {
+	format, err := ParseExportFormat("HTML")
+	assert.Nil(t, err)
+	assert.Equal(t, ExportFormatHTML, format)
+	_, err = ParseExportFormat("pdf")
+	assert.NotNil(t, err)
+}
+
+func TestConversation_ExportMarkdown(t *testing.T) {
+	conversation := newExportTestConversation()
+	var markdown bytes.Buffer
+	assert.Nil(t, conversation.Export(&markdown, ExportFormatMarkdown, ExportOptions{}))
+	transcript := markdown.String()
+	assert.True(t, strings.HasPrefix(transcript, "# discovery\n"))
+	assert.NotContains(t, transcript, "You are a helpful assistant.")
+	assert.Contains(t, transcript, "### User\n")
+	assert.Contains(t, transcript, "What is CSX Labs?")
+	assert.Contains(t, transcript, "*Searched for \"CSX Labs\"*\n\n> CSX Labs is a <research> organization.\n")
+	assert.Contains(t, transcript, "### Assistant\n")
+	assert.Contains(t, transcript, "CSX Labs is an amazing organization.")
+	assert.Contains(t, transcript, "*Called `read_file` with `{\"path\":\"README.md\"}`*\n\n> # Solus\n")
+	assert.NotContains(t, transcript, "### System")
+	assert.NotContains(t, transcript, "### Tool")
+
+	markdown.Reset()
+	assert.Nil(t, conversation.Export(&markdown, ExportFormatMarkdown, ExportOptions{Title: "Kickoff", IncludeSystemPrompts: true}))
+	assert.True(t, strings.HasPrefix(markdown.String(), "# Kickoff\n"))
+	assert.Contains(t, markdown.String(), "### System\n")
+	assert.Contains(t, markdown.String(), "You are a helpful assistant.")
+}
+
+func TestMarkdownCode(t *testing.T) {
+	assert.Equal(t, "`read_file`", markdownCode("read_file"))
+	assert.Equal(t, "``{\"code\":\"`go test`\"}``", markdownCode("{\"code\":\"`go test`\"}"))
+	assert.Equal(t, "```` ```go ````", markdownCode("```go"))
+	assert.Equal(t, "`  x  `", markdownCode(" x "))
+}
+
+func TestConversation_ExportHTML(t *testing.T) {
+	conversation := newExportTestConversation()
+	var html bytes.Buffer
+	assert.Nil(t, conversation.Export(&html, ExportFormatHTML, ExportOptions{}))
+	page := html.String()
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, "<title>discovery</title>")
+	assert.Contains(t, page, "Searched for &ldquo;CSX Labs&rdquo;")
+	assert.Contains(t, page, "CSX Labs is a &lt;research&gt; organization.")
+	assert.NotContains(t, page, "<research>")
+	assert.Contains(t, page, "Called <code>read_file</code>")
+	assert.NotContains(t, page, "You are a helpful assistant.")
+	assert.True(t, strings.HasSuffix(page, "</html>\n"))
+}
+
+func TestConversation_ExportJSONL(t *testing.T) {
+	conversation := newExportTestConversation()
+	var jsonl bytes.Buffer
+	assert.Nil(t, conversation.Export(&jsonl, ExportFormatJSONL, ExportOptions{}))
+	lines := strings.Split(strings.TrimSpace(jsonl.String()), "\n")
+	assert.Len(t, lines, 3)
+	var example struct {
+		Messages []jsonlMessage `json:"messages"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(lines[2]), &example))
+	assert.Len(t, example.Messages, 5)
+	assert.Equal(t, "user", example.Messages[0].Role)
+	assert.Equal(t, `{"type":"text","content":"What is CSX Labs?"}`, example.Messages[0].Content)
+	assert.Equal(t, `{"type":"query","content":"CSX Labs"}`, example.Messages[1].Content)
+	assert.Equal(t, "system", example.Messages[2].Role)
+	assert.Equal(t, "assistant", example.Messages[4].Role)
+	assert.Equal(t, "read_file", example.Messages[4].FunctionCall.Name)
+
+	jsonl.Reset()
+	assert.Nil(t, conversation.Export(&jsonl, ExportFormatJSONL, ExportOptions{IncludeSystemPrompts: true}))
+	assert.Nil(t, json.Unmarshal([]byte(strings.Split(jsonl.String(), "\n")[0]), &example))
+	assert.Equal(t, "system", example.Messages[0].Role)
+	assert.Len(t, example.Messages, 3)
+}
+
+func TestExportDocument_Branch(t *testing.T) {
+	conversation := newExportTestConversation()
+	assert.Nil(t, conversation.ForkAt("retry", conversation.GetMessages()[1].GetID()))
+	conversation.AddMessage(*agent.NewChatAgentMessage("message", agent.ChatAgentMessageRoleAssistant, "A lab."))
+	document := conversation.ToDocument()
+	var markdown bytes.Buffer
+	assert.Nil(t, ExportDocument(&markdown, document, ExportFormatMarkdown, ExportOptions{}))
+	assert.Contains(t, markdown.String(), "A lab.")
+	markdown.Reset()
+	assert.Nil(t, ExportDocument(&markdown, document, ExportFormatMarkdown, ExportOptions{Branch: DefaultBranchName}))
+	assert.NotContains(t, markdown.String(), "A lab.")
+	assert.Contains(t, markdown.String(), "CSX Labs is an amazing organization.")
+	assert.ErrorIs(t, ExportDocument(&markdown, document, ExportFormatMarkdown, ExportOptions{Branch: "missing"}), ErrBranchNotFound)
+	assert.NotNil(t, ExportDocument(&markdown, document, "pdf", ExportOptions{}))
+}
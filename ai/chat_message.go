@@ -0,0 +1,71 @@
+package ai
+
+import (
+	"encoding/json"
+)
+
+type AIMessage struct {
+	Type    string `json:"type"`
+	Content string `json:"content"`
+}
+
+func (msg *AIMessage) GetContent() string {
+	return msg.Content
+}
+
+func (msg *AIMessage) GetType() string {
+	return msg.Type
+}
+
+func (msg *AIMessage) IsQuery() bool {
+	return msg.Type == "query"
+}
+
+func (msg *AIMessage) IsMessage() bool {
+	return msg.Type == "message"
+}
+
+type ChatMessage struct {
+	Content      string
+	Role         string
+	Name         string            `json:",omitempty"` // Name of the function whose result the message holds
+	FunctionCall *ChatFunctionCall `json:",omitempty"` // Function the assistant asked to call
+	Metadata     MessageMetadata
+}
+
+// ChatFunctionCall is a request from the assistant to call one of the
+// functions offered to it.
+type ChatFunctionCall struct {
+	Name      string
+	Arguments string // JSON object matching the function's parameters
+}
+
+// ChatFunction describes a function the assistant may ask to call instead of
+// responding.
+type ChatFunction struct {
+	Name        string
+	Description string
+	Parameters  *JSONSchema // Must describe an object
+}
+
+func (msg ChatMessage) GetContent() string {
+	return msg.Content
+}
+
+func (msg ChatMessage) GetRole() string {
+	return msg.Role
+}
+
+func (msg *ChatMessage) ToAIMessage() (AIMessage, error) {
+	marshalledContent := msg.GetContent()
+	var unMarshalledContent AIMessage
+	err := json.Unmarshal([]byte(marshalledContent), &unMarshalledContent)
+	if err != nil {
+		return unMarshalledContent, nil
+	}
+	return unMarshalledContent, err
+}
+
+// ChatCompletionDeltaHandler receives the pieces of a streamed completion's
+// content in the order they arrive.
+type ChatCompletionDeltaHandler func(delta string)
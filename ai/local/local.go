@@ -0,0 +1,118 @@
+package local
+
+import (
+	"context"
+	"errors"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+)
+
+// Provider is the ai.Provider of a server running models on-premises behind
+// an OpenAI-compatible API, such as the llama.cpp server or Ollama. Such
+// servers serve the models they have loaded under their own names, need no
+// API key and often lack function calling, so requests are adapted before
+// they are sent in the OpenAI wire format.
+type Provider struct {
+	baseURL           string
+	model             string
+	functionsDisabled bool
+	contextLimit      int // Of model, 0 when unknown
+	client            *openai.OpenAI
+}
+
+// NewProvider returns the provider of the server whose API is rooted at
+// baseURL, such as http://localhost:11434/v1 for Ollama or
+// http://localhost:8080/v1 for llama.cpp, serving model. Requests not naming
+// a model use model, and so do embeddings until SetEmbeddingModel is called.
+func NewProvider(baseURL string, model string) *Provider {
+	client := openai.NewOpenAIWithBaseURL("", baseURL)
+	client.SetEmbeddingModel(model)
+	return &Provider{
+		baseURL: baseURL,
+		model:   model,
+		client:  client,
+	}
+}
+
+// SetAPIKey sets the key sent to servers that require one.
+func (p *Provider) SetAPIKey(apiKey string) {
+	embeddingModel := p.client.GetEmbeddingModel()
+	p.client = openai.NewOpenAIWithBaseURL(apiKey, p.baseURL)
+	p.client.SetEmbeddingModel(embeddingModel)
+}
+
+func (p *Provider) SetEmbeddingModel(model string) {
+	p.client.SetEmbeddingModel(model)
+}
+
+// SetContextLimit sets the number of tokens fitting in the context window of
+// the provider's model, which depends on how the server loaded it. Unset, the
+// model is assumed to have openai.DefaultContextLimit.
+func (p *Provider) SetContextLimit(limit int) {
+	p.contextLimit = limit
+}
+
+// GetContextLimit returns the limit set with SetContextLimit if model is the
+// provider's model, and 0 otherwise.
+func (p *Provider) GetContextLimit(model string) int {
+	if model != p.model && model != "" {
+		return 0
+	}
+	return p.contextLimit
+}
+
+// SetFunctionsDisabled keeps function definitions out of requests, for
+// servers that reject them. The assistant can then only respond with text.
+func (p *Provider) SetFunctionsDisabled(disabled bool) {
+	p.functionsDisabled = disabled
+}
+
+func (p *Provider) GetBaseURL() string {
+	return p.baseURL
+}
+
+func (p *Provider) GetModel() string {
+	return p.model
+}
+
+func (p *Provider) CreateChatCompletion(ctx context.Context, request ai.ChatCompletionRequest) (ai.ChatMessage, error) {
+	request, err := p.adaptRequest(request)
+	if err != nil {
+		return ai.ChatMessage{}, err
+	}
+	return p.client.CreateChatCompletion(ctx, request)
+}
+
+func (p *Provider) CreateChatCompletionStream(ctx context.Context, request ai.ChatCompletionRequest, onDelta ai.ChatCompletionDeltaHandler) (ai.ChatMessage, error) {
+	request, err := p.adaptRequest(request)
+	if err != nil {
+		return ai.ChatMessage{}, err
+	}
+	return p.client.CreateChatCompletionStream(ctx, request, onDelta)
+}
+
+func (p *Provider) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	if p.baseURL == "" {
+		return nil, errors.New("local provider has no base URL")
+	}
+	return p.client.CreateEmbeddings(ctx, texts)
+}
+
+// adaptRequest fills in the provider's model and leaves out what the server
+// does not support.
+func (p *Provider) adaptRequest(request ai.ChatCompletionRequest) (ai.ChatCompletionRequest, error) {
+	if p.baseURL == "" {
+		return request, errors.New("local provider has no base URL")
+	}
+	if request.Options.Model == "" {
+		request.Options.Model = p.model
+	}
+	if request.Options.Model == "" {
+		return request, errors.New("local provider has no model")
+	}
+	if p.functionsDisabled {
+		request.Functions = nil
+	}
+	return request, nil
+}
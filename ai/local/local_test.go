@@ -0,0 +1,109 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// startLocalServer responds to every request with response, sending each
+// request to requests and its decoded body to bodies.
+func startLocalServer(requests chan<- *http.Request, bodies chan<- map[string]interface{}, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests <- r
+		bodies <- body
+		w.Header().Set("content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+}
+
+func TestProvider_CreateChatCompletion(t *testing.T) {
+	requests, bodies := make(chan *http.Request, 1), make(chan map[string]interface{}, 1)
+	ts := startLocalServer(requests, bodies, string(openai.SampleChatCompletion))
+	defer ts.Close()
+	provider := NewProvider(ts.URL, "llama2")
+	provider.SetFunctionsDisabled(true)
+	response, err := provider.CreateChatCompletion(context.Background(), ai.ChatCompletionRequest{
+		Messages:  []ai.ChatMessage{{Role: "user", Content: "Hello"}},
+		Functions: []ai.ChatFunction{{Name: "web_search"}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "assistant", response.Role)
+	request, body := <-requests, <-bodies
+	assert.Equal(t, "/chat/completions", request.URL.Path)
+	assert.Empty(t, request.Header.Get("Authorization"))
+	assert.Equal(t, "llama2", body["model"])
+	assert.NotContains(t, body, "functions")
+}
+
+func TestProvider_CreateChatCompletionKeepsRequestedModel(t *testing.T) {
+	requests, bodies := make(chan *http.Request, 1), make(chan map[string]interface{}, 1)
+	ts := startLocalServer(requests, bodies, string(openai.SampleChatCompletion))
+	defer ts.Close()
+	provider := NewProvider(ts.URL, "llama2")
+	provider.SetAPIKey("secret")
+	_, err := provider.CreateChatCompletion(context.Background(), ai.ChatCompletionRequest{
+		Messages:  []ai.ChatMessage{{Role: "user", Content: "Hello"}},
+		Functions: []ai.ChatFunction{{Name: "web_search"}},
+		Options:   ai.ModelOptions{Model: "mistral"},
+	})
+	assert.Nil(t, err)
+	request, body := <-requests, <-bodies
+	assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+	assert.Equal(t, "mistral", body["model"])
+	assert.Contains(t, body, "functions")
+}
+
+func TestProvider_CreateChatCompletionStream(t *testing.T) {
+	ts := openai.StartStreamingHTTPTestServer(openai.SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	provider := NewProvider(ts.URL, "llama2")
+	streamed := ""
+	response, err := provider.CreateChatCompletionStream(context.Background(), ai.ChatCompletionRequest{
+		Messages: []ai.ChatMessage{{Role: "user", Content: "Hello"}},
+	}, func(delta string) {
+		streamed += delta
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, response.Content, streamed)
+}
+
+func TestProvider_CreateEmbeddings(t *testing.T) {
+	requests, bodies := make(chan *http.Request, 1), make(chan map[string]interface{}, 1)
+	ts := startLocalServer(requests, bodies, `{"object":"list","data":[{"object":"embedding","embedding":[0.5,0.25],"index":0}],"model":"nomic-embed-text"}`)
+	defer ts.Close()
+	provider := NewProvider(ts.URL, "llama2")
+	provider.SetEmbeddingModel("nomic-embed-text")
+	embeddings, err := provider.CreateEmbeddings(context.Background(), []string{"Hello"})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]float32{{0.5, 0.25}}, embeddings)
+	request, body := <-requests, <-bodies
+	assert.Equal(t, "/embeddings", request.URL.Path)
+	assert.Equal(t, "nomic-embed-text", body["model"])
+}
+
+func TestProvider_SetContextLimit(t *testing.T) {
+	provider := NewProvider("http://localhost:11434/v1", "local-test-model")
+	assert.Equal(t, 0, provider.GetContextLimit("local-test-model"))
+	provider.SetContextLimit(32768)
+	assert.Equal(t, 32768, provider.GetContextLimit("local-test-model"))
+	assert.Equal(t, 32768, provider.GetContextLimit(""))
+	assert.Equal(t, 0, provider.GetContextLimit("other-model"))
+	assert.Equal(t, openai.DefaultContextLimit, openai.GetModelContextLimit("local-test-model"))
+}
+
+func TestProvider_RequiresBaseURL(t *testing.T) {
+	provider := NewProvider("", "llama2")
+	_, err := provider.CreateChatCompletion(context.Background(), ai.ChatCompletionRequest{})
+	assert.NotNil(t, err)
+	_, err = provider.CreateEmbeddings(context.Background(), []string{"Hello"})
+	assert.NotNil(t, err)
+}
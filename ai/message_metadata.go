@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MessageMetadata describes where a message of a chat came from. Every
+// message has an ID and creation time; the rest is only set on responses of
+// the assistant, by the provider that completed them.
+type MessageMetadata struct {
+	ID               string
+	CreatedAt        time.Time
+	Model            string        `json:",omitempty"` // Model that wrote the response, as reported by the provider
+	ResponseID       string        `json:",omitempty"` // ID the provider gave the completion
+	FinishReason     string        `json:",omitempty"` // Why the response ended, such as "stop" or "length" when it was cut off
+	PromptTokens     int           `json:",omitempty"` // Tokens of the request, which includes the history
+	CompletionTokens int           `json:",omitempty"` // Tokens of the response
+	TokensEstimated  bool          `json:",omitempty"` // Whether the token counts were estimated because the provider did not report them
+	Latency          time.Duration `json:",omitempty"` // From sending the request to receiving the whole response
+}
+
+// NewMessageMetadata returns the metadata of a message created now.
+func NewMessageMetadata() MessageMetadata {
+	return MessageMetadata{
+		ID:        uuid.New().String(),
+		CreatedAt: time.Now(),
+	}
+}
+
+// EnsureID gives metadata without an ID a new one, dated now unless it has a
+// creation time, such as the metadata of messages saved before messages had
+// IDs. IDs, once given, are kept.
+func (m *MessageMetadata) EnsureID() {
+	if m.ID != "" {
+		return
+	}
+	m.ID = uuid.New().String()
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = time.Now()
+	}
+}
+
+// TotalTokens returns the tokens billed for the response.
+func (m MessageMetadata) TotalTokens() int {
+	return m.PromptTokens + m.CompletionTokens
+}
@@ -1,11 +1,34 @@
 package ai
 
 type AIConfig struct {
-	OpenAIAPIKey string
+	Provider       ProviderConfig // Where completions and embeddings are requested
+	OpenAIAPIKey   string         // Also sent to local providers that require a key
+	EmbeddingModel string
+	ModelOptions   // Used for every chat completion unless overridden
 }
 
 func NewAIConfig(openAIAPIKey string) *AIConfig {
 	return &AIConfig{
-		OpenAIAPIKey: openAIAPIKey,
+		OpenAIAPIKey:   openAIAPIKey,
+		EmbeddingModel: DefaultEmbeddingModel,
+		ModelOptions: ModelOptions{
+			Model: DefaultChatModel,
+		},
+	}
+}
+
+// NewLocalAIConfig returns the config of a server with an OpenAI-compatible
+// API at baseURL, such as llama.cpp or Ollama, serving model for both chat
+// completions and embeddings.
+func NewLocalAIConfig(baseURL string, model string) *AIConfig {
+	return &AIConfig{
+		Provider: ProviderConfig{
+			Name:    ProviderLocal,
+			BaseURL: baseURL,
+		},
+		EmbeddingModel: model,
+		ModelOptions: ModelOptions{
+			Model: model,
+		},
 	}
 }
@@ -0,0 +1,149 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CSXL/solus/config"
+)
+
+const (
+	DefaultChatModel      = "gpt-4"
+	DefaultEmbeddingModel = "text-embedding-ada-002"
+)
+
+// ResponseFormat is the format a model is asked to respond in.
+type ResponseFormat string
+
+const (
+	ResponseFormatText       ResponseFormat = "text"
+	ResponseFormatJSONObject ResponseFormat = "json_object" // Only valid JSON objects
+)
+
+// ModelOptions are the model and sampling parameters of chat completions.
+// Zero values are unset, leaving the parameter to the model's default or,
+// when merging, to the options being overridden.
+type ModelOptions struct {
+	Model          string
+	Temperature    *float32 // Between 0 and 2
+	TopP           *float32 // Between 0 and 1
+	MaxTokens      int      // Tokens the response may take
+	Stop           []string // Sequences ending the response
+	Seed           *int     // Makes sampling repeatable, where supported
+	ResponseFormat ResponseFormat
+}
+
+// Float32 returns a pointer to v, for setting ModelOptions.
+func Float32(v float32) *float32 {
+	return &v
+}
+
+// Int returns a pointer to v, for setting ModelOptions.
+func Int(v int) *int {
+	return &v
+}
+
+// Merge returns the options with those set in overrides replacing them.
+func (o ModelOptions) Merge(overrides ModelOptions) ModelOptions {
+	if overrides.Model != "" {
+		o.Model = overrides.Model
+	}
+	if overrides.Temperature != nil {
+		o.Temperature = overrides.Temperature
+	}
+	if overrides.TopP != nil {
+		o.TopP = overrides.TopP
+	}
+	if overrides.MaxTokens != 0 {
+		o.MaxTokens = overrides.MaxTokens
+	}
+	if overrides.Stop != nil {
+		o.Stop = overrides.Stop
+	}
+	if overrides.Seed != nil {
+		o.Seed = overrides.Seed
+	}
+	if overrides.ResponseFormat != "" {
+		o.ResponseFormat = overrides.ResponseFormat
+	}
+	return o
+}
+
+// Validate reports the first option out of its range.
+func (o ModelOptions) Validate() error {
+	if o.Temperature != nil && (*o.Temperature < 0 || *o.Temperature > 2) {
+		return fmt.Errorf("temperature must be between 0 and 2, got %v", *o.Temperature)
+	}
+	if o.TopP != nil && (*o.TopP < 0 || *o.TopP > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1, got %v", *o.TopP)
+	}
+	if o.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", o.MaxTokens)
+	}
+	if len(o.Stop) > 4 {
+		return fmt.Errorf("at most 4 stop sequences are supported, got %d", len(o.Stop))
+	}
+	switch o.ResponseFormat {
+	case "", ResponseFormatText, ResponseFormatJSONObject:
+	default:
+		return fmt.Errorf("unknown response format %q", o.ResponseFormat)
+	}
+	return nil
+}
+
+type modelOptionsContextKey struct{}
+
+// ContextWithModelOptions returns a copy of ctx whose requests use overrides
+// on top of the options of the client sending them, and of any overrides ctx
+// already carries.
+func ContextWithModelOptions(ctx context.Context, overrides ModelOptions) context.Context {
+	if existing, ok := ModelOptionsFromContext(ctx); ok {
+		overrides = existing.Merge(overrides)
+	}
+	return context.WithValue(ctx, modelOptionsContextKey{}, overrides)
+}
+
+// ModelOptionsFromContext returns the overrides set on ctx with
+// ContextWithModelOptions.
+func ModelOptionsFromContext(ctx context.Context) (ModelOptions, bool) {
+	overrides, ok := ctx.Value(modelOptionsContextKey{}).(ModelOptions)
+	return overrides, ok
+}
+
+// ReadModelOptions reads the options under key of a YAML config, such as:
+//
+//	model:
+//	  name: gpt-3.5-turbo
+//	  temperature: 0.2
+//	  top_p: 1
+//	  max_tokens: 1024
+//	  stop: ["END"]
+//	  seed: 42
+//	  response_format: json_object
+//
+// Missing keys are left unset, and a missing section yields empty options.
+func ReadModelOptions(reader *config.Config, key string) (ModelOptions, error) {
+	options := ModelOptions{
+		Model:          reader.GetString(key + ".name"),
+		MaxTokens:      reader.GetInt(key + ".max_tokens"),
+		Stop:           reader.GetStringSlice(key + ".stop"),
+		ResponseFormat: ResponseFormat(reader.GetString(key + ".response_format")),
+	}
+	if len(options.Stop) == 0 {
+		options.Stop = nil
+	}
+	if reader.IsSet(key + ".temperature") {
+		options.Temperature = Float32(float32(reader.GetFloat64(key + ".temperature")))
+	}
+	if reader.IsSet(key + ".top_p") {
+		options.TopP = Float32(float32(reader.GetFloat64(key + ".top_p")))
+	}
+	if reader.IsSet(key + ".seed") {
+		options.Seed = Int(reader.GetInt(key + ".seed"))
+	}
+	err := options.Validate()
+	if err != nil {
+		return ModelOptions{}, fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	return options, nil
+}
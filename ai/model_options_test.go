@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CSXL/solus/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModelOptions_Merge(t *testing.T) {
+	base := ModelOptions{Model: "gpt-4", Temperature: Float32(0.7), Stop: []string{"END"}}
+	merged := base.Merge(ModelOptions{Temperature: Float32(0), Seed: Int(42)})
+	assert.Equal(t, "gpt-4", merged.Model)
+	assert.Equal(t, float32(0), *merged.Temperature)
+	assert.Equal(t, []string{"END"}, merged.Stop)
+	assert.Equal(t, 42, *merged.Seed)
+	assert.Equal(t, float32(0.7), *base.Temperature)
+}
+
+func TestModelOptions_Validate(t *testing.T) {
+	assert.Nil(t, ModelOptions{Temperature: Float32(2), TopP: Float32(0), ResponseFormat: ResponseFormatJSONObject}.Validate())
+	assert.NotNil(t, ModelOptions{Temperature: Float32(2.5)}.Validate())
+	assert.NotNil(t, ModelOptions{TopP: Float32(-1)}.Validate())
+	assert.NotNil(t, ModelOptions{MaxTokens: -1}.Validate())
+	assert.NotNil(t, ModelOptions{Stop: []string{"a", "b", "c", "d", "e"}}.Validate())
+	assert.NotNil(t, ModelOptions{ResponseFormat: "xml"}.Validate())
+}
+
+func TestContextWithModelOptions(t *testing.T) {
+	_, ok := ModelOptionsFromContext(context.Background())
+	assert.False(t, ok)
+	ctx := ContextWithModelOptions(context.Background(), ModelOptions{Model: "gpt-3.5-turbo", MaxTokens: 100})
+	ctx = ContextWithModelOptions(ctx, ModelOptions{Model: "gpt-4"})
+	overrides, ok := ModelOptionsFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, ModelOptions{Model: "gpt-4", MaxTokens: 100}, overrides)
+}
+
+func TestReadModelOptions(t *testing.T) {
+	dir := t.TempDir()
+	yaml := "model:\n  name: gpt-3.5-turbo\n  temperature: 0\n  max_tokens: 512\n  stop: [\"END\"]\n  seed: 7\n  response_format: json_object\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test_config.yaml"), []byte(yaml), 0644))
+	reader := config.New()
+	assert.Nil(t, reader.Read("test_config", dir))
+
+	options, err := ReadModelOptions(reader, "model")
+	assert.Nil(t, err)
+	assert.Equal(t, "gpt-3.5-turbo", options.Model)
+	assert.Equal(t, float32(0), *options.Temperature)
+	assert.Nil(t, options.TopP)
+	assert.Equal(t, 512, options.MaxTokens)
+	assert.Equal(t, []string{"END"}, options.Stop)
+	assert.Equal(t, 7, *options.Seed)
+	assert.Equal(t, ResponseFormatJSONObject, options.ResponseFormat)
+
+	options, err = ReadModelOptions(reader, "missing")
+	assert.Nil(t, err)
+	assert.Equal(t, ModelOptions{}, options)
+}
@@ -5,82 +5,128 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"sync"
 
+	"github.com/CSXL/solus/ai"
 	"github.com/sashabaranov/go-openai"
 )
 
-type AIMessage struct {
-	Type    string `json:"type"`
-	Content string `json:"content"`
+// The chat types are shared by every ai.Provider. The aliases keep the names
+// this package has always exported.
+type (
+	AIMessage        = ai.AIMessage
+	ChatMessage      = ai.ChatMessage
+	ChatFunctionCall = ai.ChatFunctionCall
+	ChatFunction     = ai.ChatFunction
+)
+
+// ChatClient keeps the history of a chat and requests its completions from
+// an ai.Provider, by default the OpenAI API.
+type ChatClient struct {
+	messages      []ChatMessage
+	messagesMutex sync.RWMutex // Guards messages, which may be saved while a message is sent
+	functions     []ChatFunction
+	options       ai.ModelOptions
+	budget        *ContextBudget
+	summary       chatSummary // Summary of the turns the budget left out
+	provider      ai.Provider
 }
 
-func (msg *AIMessage) GetContent() string {
-	return msg.Content
+func NewChatClient(apiKey string) *ChatClient {
+	return NewChatClientWithProvider(NewOpenAI(apiKey))
 }
 
-func (msg *AIMessage) GetType() string {
-	return msg.Type
+// NewChatClientWithProvider returns a client requesting completions from
+// provider.
+func NewChatClientWithProvider(provider ai.Provider) *ChatClient {
+	return &ChatClient{
+		messages: []ChatMessage{},
+		options:  ai.ModelOptions{Model: ai.DefaultChatModel},
+		budget:   NewContextBudget(),
+		provider: provider,
+	}
 }
 
-func (msg *AIMessage) IsQuery() bool {
-	return msg.Type == "query"
+// SetProvider sets the provider completions are requested from.
+func (c *ChatClient) SetProvider(provider ai.Provider) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.provider = provider
 }
 
-func (msg *AIMessage) IsMessage() bool {
-	return msg.Type == "message"
+func (c *ChatClient) GetProvider() ai.Provider {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
+	return c.provider
 }
 
-type ChatMessage struct {
-	Content string
-	Role    string
+func (c *ChatClient) GetMessages() []ChatMessage {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
+	return append([]ChatMessage{}, c.messages...)
 }
 
-func (msg ChatMessage) GetContent() string {
-	return msg.Content
+func (c *ChatClient) SetMessages(messages []ChatMessage) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.messages = messages
 }
 
-func (msg ChatMessage) GetRole() string {
-	return msg.Role
+// SetFunctions sets the functions offered to the assistant with every
+// completion request. Nil offers none.
+func (c *ChatClient) SetFunctions(functions []ChatFunction) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.functions = functions
 }
 
-func (msg *ChatMessage) ToAIMessage() (AIMessage, error) {
-	marshalledContent := msg.GetContent()
-	var unMarshalledContent AIMessage
-	err := json.Unmarshal([]byte(marshalledContent), &unMarshalledContent)
-	if err != nil {
-		return unMarshalledContent, nil
-	}
-	return unMarshalledContent, err
+func (c *ChatClient) GetFunctions() []ChatFunction {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
+	return c.functions
 }
 
-type ChatClient struct {
-	apiKey       string
-	messages     []ChatMessage
-	openAIClient *OpenAI
+// SetModelOptions sets the model and sampling parameters of completions.
+// Requests whose context carries overrides, see ai.ContextWithModelOptions,
+// apply them on top.
+func (c *ChatClient) SetModelOptions(options ai.ModelOptions) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.options = options
 }
 
-func NewChatClient(apiKey string) *ChatClient {
-	return &ChatClient{
-		apiKey:       apiKey,
-		messages:     []ChatMessage{},
-		openAIClient: NewOpenAI(apiKey),
-	}
+func (c *ChatClient) GetModelOptions() ai.ModelOptions {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
+	return c.options
 }
 
-func (c *ChatClient) GetMessages() []ChatMessage {
-	return c.messages
+// GetModel returns the model completions are requested from, unless a
+// request overrides it.
+func (c *ChatClient) GetModel() string {
+	return c.getRequestOptions(context.Background()).Model
 }
 
-func (c *ChatClient) SetMessages(messages []ChatMessage) {
-	c.messages = messages
+// getRequestOptions returns the options of a request made with ctx.
+func (c *ChatClient) getRequestOptions(ctx context.Context) ai.ModelOptions {
+	options := c.GetModelOptions()
+	if overrides, ok := ai.ModelOptionsFromContext(ctx); ok {
+		options = options.Merge(overrides)
+	}
+	if options.Model == "" {
+		options.Model = ai.DefaultChatModel
+	}
+	return options
 }
 
+// SetBaseURL makes the client request completions from the OpenAI API at
+// baseURL, replacing its provider.
 func (c *ChatClient) SetBaseURL(baseURL string) {
-	c.openAIClient = NewOpenAIWithBaseURL("test", baseURL)
+	c.SetProvider(NewOpenAIWithBaseURL("test", baseURL))
 }
 
 func (c *ChatClient) ClearMessages() {
-	c.messages = []ChatMessage{}
+	c.SetMessages([]ChatMessage{})
 }
 
 func (c *ChatClient) LoadMessages(filename string) error {
@@ -90,7 +136,7 @@ func (c *ChatClient) LoadMessages(filename string) error {
 	}
 	defer handle.Close()
 	messages, err := c.unmarshalMessages(handle)
-	c.messages = messages
+	c.SetMessages(messages)
 	if err != nil {
 		return err
 	}
@@ -103,6 +149,10 @@ func (c *ChatClient) unmarshalMessages(handle io.Reader) ([]ChatMessage, error)
 	if err != nil {
 		return nil, err
 	}
+	for i := range messages {
+		// Messages saved before messages had metadata are given an ID now.
+		messages[i].Metadata.EnsureID()
+	}
 	return messages, nil
 }
 
@@ -116,17 +166,76 @@ func (c *ChatClient) SaveMessages(filename string) error {
 }
 
 func (c *ChatClient) AddMessage(role string, content string) {
-	c.messages = append(c.messages, ChatMessage{content, role})
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.messages = append(c.messages, ChatMessage{Content: content, Role: role, Metadata: ai.NewMessageMetadata()})
+}
+
+// AddFunctionResult adds the result of a function the assistant asked to
+// call to the history, without requesting a completion.
+func (c *ChatClient) AddFunctionResult(name string, result string) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.messages = append(c.messages, ChatMessage{Content: result, Role: openai.ChatMessageRoleFunction, Name: name, Metadata: ai.NewMessageMetadata()})
 }
 
 func (c *ChatClient) SendMessage(content string, role string) error {
+	return c.SendMessageWithContext(context.Background(), content, role)
+}
+
+// SendMessageWithContext adds a message to the history and requests a
+// completion for it. The request is aborted when ctx is cancelled.
+func (c *ChatClient) SendMessageWithContext(ctx context.Context, content string, role string) error {
 	c.AddMessage(role, content)
-	messages, err := c.CreateChatCompletion(c.messages, openai.GPT4)
-	c.messages = messages
-	return err
+	return c.RequestCompletion(ctx)
+}
+
+// SendMessageStream is SendMessageWithContext with the response streamed:
+// onDelta receives each piece of the response's content as it arrives.
+func (c *ChatClient) SendMessageStream(ctx context.Context, content string, role string, onDelta ChatCompletionDeltaHandler) error {
+	c.AddMessage(role, content)
+	return c.RequestCompletionStream(ctx, onDelta)
+}
+
+// RequestCompletion requests a completion for the history as it is, for
+// example after adding a function result, and appends the response. Only the
+// part of the history that fits the context budget is sent, see
+// SetContextBudget; the history itself is kept whole.
+func (c *ChatClient) RequestCompletion(ctx context.Context) error {
+	history := c.GetMessages()
+	model := c.getRequestOptions(ctx).Model
+	messages, err := c.fitContext(ctx, history, model)
+	if err != nil {
+		return err
+	}
+	messages, err = c.CreateChatCompletionWithContext(ctx, messages, model)
+	if err != nil {
+		return err
+	}
+	c.SetMessages(append(history, messages[len(messages)-1]))
+	return nil
+}
+
+// RequestCompletionStream is RequestCompletion with the response streamed to
+// onDelta.
+func (c *ChatClient) RequestCompletionStream(ctx context.Context, onDelta ChatCompletionDeltaHandler) error {
+	history := c.GetMessages()
+	model := c.getRequestOptions(ctx).Model
+	messages, err := c.fitContext(ctx, history, model)
+	if err != nil {
+		return err
+	}
+	messages, err = c.CreateChatCompletionStream(ctx, messages, model, onDelta)
+	if err != nil {
+		return err
+	}
+	c.SetMessages(append(history, messages[len(messages)-1]))
+	return nil
 }
 
 func (c *ChatClient) GetLastMessage() ChatMessage {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
 	if len(c.messages) == 0 {
 		return ChatMessage{}
 	}
@@ -149,27 +258,127 @@ func (c *ChatClient) SendSystemMessage(msg string) error {
 }
 
 func (c *ChatClient) CreateChatCompletion(messages []ChatMessage, model string) ([]ChatMessage, error) {
+	return c.CreateChatCompletionWithContext(context.Background(), messages, model)
+}
+
+// CreateChatCompletionWithContext requests a completion for messages and
+// returns them with the response appended. The request uses the client's
+// model options with any overrides carried by ctx, and model unless it is
+// empty. The request is aborted when ctx is cancelled.
+func (c *ChatClient) CreateChatCompletionWithContext(ctx context.Context, messages []ChatMessage, model string) ([]ChatMessage, error) {
+	request := c.newChatCompletionRequest(ctx, messages, model)
+	response, err := c.GetProvider().CreateChatCompletion(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return append(messages, c.completeMetadata(request, response)), nil
+}
+
+// completeMetadata fills in what the provider left out of the metadata of
+// response to request: an ID, and estimates of the token counts if it did not
+// report usage, as is the case for most streams.
+func (c *ChatClient) completeMetadata(request ai.ChatCompletionRequest, response ChatMessage) ChatMessage {
+	response.Metadata.EnsureID()
+	if response.Metadata.PromptTokens != 0 || response.Metadata.CompletionTokens != 0 {
+		return response
+	}
+	budget := c.GetRequestBudget(request.Options.Model)
+	if budget == nil {
+		budget = NewContextBudget().ForModel(request.Options.Model)
+	}
+	response.Metadata.PromptTokens = budget.CountMessageTokens(request.Messages) + countFunctionTokens(budget.getTokenizer(), request.Functions)
+	response.Metadata.CompletionTokens = countMessageTokens(budget.getTokenizer(), ChatMessage{Content: response.Content, FunctionCall: response.FunctionCall}) - messageTokenOverhead
+	response.Metadata.TokensEstimated = true
+	return response
+}
+
+func (c *ChatClient) newChatCompletionRequest(ctx context.Context, messages []ChatMessage, model string) ai.ChatCompletionRequest {
+	options := c.getRequestOptions(ctx)
+	if model != "" {
+		options.Model = model
+	}
+	return ai.ChatCompletionRequest{
+		Messages:  messages,
+		Functions: c.GetFunctions(),
+		Options:   options,
+	}
+}
+
+func toOpenAIChatMessages(messages []ChatMessage) []openai.ChatCompletionMessage {
 	var openaiMessages []openai.ChatCompletionMessage
 	for _, message := range messages {
-		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
+		openaiMessage := openai.ChatCompletionMessage{
 			Content: message.GetContent(),
 			Role:    message.GetRole(),
+			Name:    message.Name,
+		}
+		if message.FunctionCall != nil {
+			openaiMessage.FunctionCall = &openai.FunctionCall{
+				Name:      message.FunctionCall.Name,
+				Arguments: message.FunctionCall.Arguments,
+			}
+		}
+		openaiMessages = append(openaiMessages, openaiMessage)
+	}
+	return openaiMessages
+}
+
+func fromOpenAIChatMessage(openaiMessage openai.ChatCompletionMessage) ChatMessage {
+	message := ChatMessage{
+		Content: openaiMessage.Content,
+		Role:    openaiMessage.Role,
+		Name:    openaiMessage.Name,
+	}
+	if openaiMessage.FunctionCall != nil {
+		message.FunctionCall = &ChatFunctionCall{
+			Name:      openaiMessage.FunctionCall.Name,
+			Arguments: openaiMessage.FunctionCall.Arguments,
+		}
+	}
+	return message
+}
+
+func toOpenAIFunctions(functions []ChatFunction) []*openai.FunctionDefine {
+	if len(functions) == 0 {
+		return nil
+	}
+	openaiFunctions := []*openai.FunctionDefine{}
+	for _, function := range functions {
+		parameters := &openai.FunctionParams{Type: openai.JSONSchemaTypeObject}
+		if function.Parameters != nil {
+			parameters.Properties = toOpenAIJSONSchemaProperties(function.Parameters.Properties)
+			parameters.Required = function.Parameters.Required
+		}
+		openaiFunctions = append(openaiFunctions, &openai.FunctionDefine{
+			Name:        function.Name,
+			Description: function.Description,
+			Parameters:  parameters,
 		})
 	}
-	resp, err := c.openAIClient.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    model,
-			Messages: openaiMessages,
-		},
-	)
-	if err != nil {
-		return nil, err
+	return openaiFunctions
+}
+
+func toOpenAIJSONSchema(schema *ai.JSONSchema) *openai.JSONSchemaDefine {
+	if schema == nil {
+		return nil
 	}
-	newMessage := resp.Choices[0].Message
-	messages = append(messages, ChatMessage{
-		Content: newMessage.Content,
-		Role:    newMessage.Role,
-	})
-	return messages, nil
+	return &openai.JSONSchemaDefine{
+		Type:        openai.JSONSchemaType(schema.Type),
+		Description: schema.Description,
+		Enum:        schema.Enum,
+		Properties:  toOpenAIJSONSchemaProperties(schema.Properties),
+		Required:    schema.Required,
+		Items:       toOpenAIJSONSchema(schema.Items),
+	}
+}
+
+func toOpenAIJSONSchemaProperties(properties map[string]*ai.JSONSchema) map[string]*openai.JSONSchemaDefine {
+	if properties == nil {
+		return nil
+	}
+	openaiProperties := make(map[string]*openai.JSONSchemaDefine, len(properties))
+	for name, property := range properties {
+		openaiProperties[name] = toOpenAIJSONSchema(property)
+	}
+	return openaiProperties
 }
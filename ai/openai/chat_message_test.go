@@ -1,10 +1,16 @@
 package openai
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/CSXL/solus/ai"
 )
 
 func TestChatMessage__ToAIMessage(t *testing.T) {
@@ -81,6 +87,9 @@ func TestUnmarhsalMessages(t *testing.T) {
 		if actualMessages[i].GetRole() != expectedMessages[i].GetRole() {
 			t.Errorf("unmarshalMessages() returned wrong message role: %v", actualMessages[i].GetRole())
 		}
+		if actualMessages[i].Metadata.ID == "" {
+			t.Errorf("unmarshalMessages() left message %d without an ID", i)
+		}
 	}
 }
 
@@ -119,7 +128,7 @@ func TestCreateChatCompletion(t *testing.T) {
 			return
 		}
 	}))
-	client.openAIClient = NewOpenAIWithBaseURL("test", ts.URL)
+	client.SetProvider(NewOpenAIWithBaseURL("test", ts.URL))
 	messages := []ChatMessage{
 		{
 			Content: "Hello World",
@@ -140,6 +149,79 @@ func TestCreateChatCompletion(t *testing.T) {
 	}
 }
 
+func TestCreateChatCompletionMetadata(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartHTTPTestServer(SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	err := client.SendMessage("Hello World", "user")
+	if err != nil {
+		t.Fatalf("SendMessage() returned error: %v", err)
+	}
+	request, response := client.GetMessages()[0], client.GetLastMessage()
+	if request.Metadata.ID == "" || request.Metadata.CreatedAt.IsZero() {
+		t.Errorf("SendMessage() recorded request without ID or timestamp: %+v", request.Metadata)
+	}
+	metadata := response.Metadata
+	if metadata.ID == "" || metadata.ID == request.Metadata.ID {
+		t.Errorf("SendMessage() recorded response with wrong ID: %q", metadata.ID)
+	}
+	if metadata.Model != "gpt-3.5-turbo-0301" || metadata.ResponseID != "chatcmpl-123" || metadata.FinishReason != "stop" {
+		t.Errorf("SendMessage() recorded wrong response metadata: %+v", metadata)
+	}
+	if metadata.PromptTokens != 9 || metadata.CompletionTokens != 11 || metadata.TokensEstimated {
+		t.Errorf("SendMessage() recorded wrong token usage: %+v", metadata)
+	}
+	if metadata.Latency <= 0 {
+		t.Errorf("SendMessage() recorded no latency: %v", metadata.Latency)
+	}
+}
+
+func TestCreateChatCompletionStreamMetadata(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartStreamingHTTPTestServer(SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	err := client.SendMessageStream(context.Background(), "Hello World", "user", nil)
+	if err != nil {
+		t.Fatalf("SendMessageStream() returned error: %v", err)
+	}
+	metadata := client.GetLastMessage().Metadata
+	if metadata.ID == "" || metadata.Model != "gpt-4-0314" || metadata.ResponseID != "chatcmpl-123" {
+		t.Errorf("SendMessageStream() recorded wrong response metadata: %+v", metadata)
+	}
+	if !metadata.TokensEstimated || metadata.PromptTokens <= 0 || metadata.CompletionTokens <= 0 {
+		t.Errorf("SendMessageStream() did not estimate token usage: %+v", metadata)
+	}
+}
+
+func TestSaveAndLoadMessagesKeepsMetadata(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartHTTPTestServer(SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	err := client.SendMessage("Hello World", "user")
+	if err != nil {
+		t.Fatalf("SendMessage() returned error: %v", err)
+	}
+	filename := filepath.Join(t.TempDir(), "messages.json")
+	err = client.SaveMessages(filename)
+	if err != nil {
+		t.Fatalf("SaveMessages() returned error: %v", err)
+	}
+	loaded := NewChatClient("test")
+	err = loaded.LoadMessages(filename)
+	if err != nil {
+		t.Fatalf("LoadMessages() returned error: %v", err)
+	}
+	for i, message := range client.GetMessages() {
+		got := loaded.GetMessages()[i].Metadata
+		if got.ID != message.Metadata.ID || !got.CreatedAt.Equal(message.Metadata.CreatedAt) || got.Latency != message.Metadata.Latency || got.TotalTokens() != message.Metadata.TotalTokens() {
+			t.Errorf("LoadMessages() loaded wrong metadata for message %d: %+v", i, got)
+		}
+	}
+}
+
 func TestSendMessage(t *testing.T) {
 	client := NewChatClient("test")
 	// Fake the response from the OpenAI API
@@ -153,7 +235,7 @@ func TestSendMessage(t *testing.T) {
 			return
 		}
 	}))
-	client.openAIClient = NewOpenAIWithBaseURL("test", ts.URL)
+	client.SetProvider(NewOpenAIWithBaseURL("test", ts.URL))
 	err := client.SendMessage("Hello World", "user")
 	if err != nil {
 		t.Errorf("SendMessage() returned error: %v", err)
@@ -163,3 +245,207 @@ func TestSendMessage(t *testing.T) {
 		t.Errorf("SendMessage() returned wrong completion: %v", lastMessageContent)
 	}
 }
+
+func TestSendMessageWithCanceledContext(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartHTTPTestServer(SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := client.SendMessageWithContext(ctx, "Hello World", "user")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SendMessageWithContext() returned wrong error: %v", err)
+	}
+	if len(client.GetMessages()) != 1 {
+		t.Errorf("SendMessageWithContext() changed message history: %v", client.GetMessages())
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	statusCodes := map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+	}
+	for statusCode, retryable := range statusCodes {
+		client := NewChatClient("test")
+		ts := StartFlakyHTTPTestServer(1, statusCode, SampleChatCompletion)
+		client.SetBaseURL(ts.URL)
+		err := client.SendMessage("Hello World", "user")
+		ts.Close()
+		if err == nil {
+			t.Fatalf("SendMessage() returned no error for status code %d", statusCode)
+		}
+		if IsRetryableError(err) != retryable {
+			t.Errorf("IsRetryableError() = %v for status code %d: %v", !retryable, statusCode, err)
+		}
+	}
+	if IsRetryableError(errors.New("test error")) {
+		t.Errorf("IsRetryableError() = true for a plain error")
+	}
+}
+
+func TestCreateChatCompletionStream(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartStreamingHTTPTestServer(SampleChatJSONCompletionDeltas)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	deltas := []string{}
+	err := client.SendMessageStream(context.Background(), "Hello World", "user", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("SendMessageStream() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(deltas, SampleChatJSONCompletionDeltas) {
+		t.Errorf("SendMessageStream() streamed wrong deltas: %v", deltas)
+	}
+	lastMessage := client.GetLastMessage()
+	if lastMessage.GetRole() != "assistant" {
+		t.Errorf("SendMessageStream() recorded wrong role: %v", lastMessage.GetRole())
+	}
+	if lastMessage.GetContent() != `{"type":"message","content":"CSX Labs is an \"amazing\" organization."}` {
+		t.Errorf("SendMessageStream() recorded wrong completion: %v", lastMessage.GetContent())
+	}
+	if len(client.GetMessages()) != 2 {
+		t.Errorf("SendMessageStream() recorded wrong number of messages: %v", len(client.GetMessages()))
+	}
+}
+
+func TestSendMessageStreamWithError(t *testing.T) {
+	client := NewChatClient("test")
+	ts := StartFlakyHTTPTestServer(1, http.StatusTooManyRequests, SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	err := client.SendMessageStream(context.Background(), "Hello World", "user", nil)
+	if !IsRetryableError(err) {
+		t.Errorf("SendMessageStream() returned wrong error: %v", err)
+	}
+	if len(client.GetMessages()) != 1 {
+		t.Errorf("SendMessageStream() changed message history: %v", client.GetMessages())
+	}
+}
+
+func TestSendMessageWithFunctions(t *testing.T) {
+	client := NewChatClient("test")
+	requests := make(chan []byte, 2)
+	ts := StartSequenceHTTPTestServer(requests, SampleChatFunctionCallCompletion, SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	client.SetFunctions([]ChatFunction{{
+		Name:        "web_search",
+		Description: "Search the web",
+		Parameters: ai.NewObjectSchema(map[string]*ai.JSONSchema{
+			"query": ai.NewStringSchema("The search query"),
+		}, "query"),
+	}})
+	err := client.SendMessage("Who are CSX Labs?", "user")
+	if err != nil {
+		t.Fatalf("SendMessage() returned error: %v", err)
+	}
+	request := string(<-requests)
+	if !strings.Contains(request, `"functions":[{"name":"web_search","description":"Search the web","parameters":{"type":"object","properties":{"query":{"type":"string","description":"The search query"}},"required":["query"]}}]`) {
+		t.Errorf("SendMessage() sent wrong functions: %v", request)
+	}
+	functionCall := client.GetLastMessage().FunctionCall
+	if functionCall == nil || functionCall.Name != "web_search" || functionCall.Arguments != "{\n  \"query\": \"CSX Labs\"\n}" {
+		t.Fatalf("SendMessage() returned wrong function call: %v", functionCall)
+	}
+
+	client.AddFunctionResult("web_search", "CSX Labs is a research lab.")
+	err = client.RequestCompletion(context.Background())
+	if err != nil {
+		t.Fatalf("RequestCompletion() returned error: %v", err)
+	}
+	request = string(<-requests)
+	if !strings.Contains(request, `{"role":"assistant","content":"","function_call":{"name":"web_search","arguments":"{\n  \"query\": \"CSX Labs\"\n}"}},{"role":"function","content":"CSX Labs is a research lab.","name":"web_search"}`) {
+		t.Errorf("RequestCompletion() sent wrong history: %v", request)
+	}
+	if len(client.GetMessages()) != 4 {
+		t.Errorf("RequestCompletion() recorded wrong number of messages: %v", len(client.GetMessages()))
+	}
+}
+
+func TestCreateChatCompletionStreamWithFunctionCall(t *testing.T) {
+	client := NewChatClient("test")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-Type", "text/event-stream")
+		_, err := w.Write([]byte(`data: {"choices":[{"delta":{"role":"assistant","content":null,"function_call":{"name":"web_search","arguments":""}}}]}
+
+data: {"choices":[{"delta":{"function_call":{"arguments":"{\"query\":"}}}]}
+
+data: {"choices":[{"delta":{"function_call":{"arguments":"\"CSX Labs\"}"}}}]}
+
+data: {"choices":[{"delta":{},"finish_reason":"function_call"}]}
+
+data: [DONE]
+
+`))
+		if err != nil {
+			return
+		}
+	}))
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	deltas := 0
+	err := client.SendMessageStream(context.Background(), "Who are CSX Labs?", "user", func(delta string) {
+		deltas++
+	})
+	if err != nil {
+		t.Fatalf("SendMessageStream() returned error: %v", err)
+	}
+	if deltas != 0 {
+		t.Errorf("SendMessageStream() streamed %d deltas for a function call", deltas)
+	}
+	functionCall := client.GetLastMessage().FunctionCall
+	if functionCall == nil || functionCall.Name != "web_search" || functionCall.Arguments != `{"query":"CSX Labs"}` {
+		t.Errorf("SendMessageStream() returned wrong function call: %v", functionCall)
+	}
+}
+
+func TestRequestCompletionWithModelOptions(t *testing.T) {
+	client := NewChatClient("test")
+	requests := make(chan []byte, 2)
+	ts := StartSequenceHTTPTestServer(requests, SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	client.SetModelOptions(ai.ModelOptions{
+		Model:          "gpt-3.5-turbo",
+		Temperature:    ai.Float32(0),
+		Stop:           []string{"END"},
+		Seed:           ai.Int(42),
+		ResponseFormat: ai.ResponseFormatJSONObject,
+	})
+	err := client.SendMessage("Hello", "user")
+	if err != nil {
+		t.Fatalf("SendMessage() returned error: %v", err)
+	}
+	request := string(<-requests)
+	for _, expected := range []string{`"model":"gpt-3.5-turbo"`, `"temperature":0`, `"stop":["END"]`, `"seed":42`, `"response_format":{"type":"json_object"}`} {
+		if !strings.Contains(request, expected) {
+			t.Errorf("SendMessage() request is missing %s: %v", expected, request)
+		}
+	}
+	if strings.Contains(request, "top_p") {
+		t.Errorf("SendMessage() sent an unset option: %v", request)
+	}
+
+	ctx := ai.ContextWithModelOptions(context.Background(), ai.ModelOptions{Model: "gpt-4", MaxTokens: 100})
+	client.AddMessage("user", "Hello again")
+	err = client.RequestCompletion(ctx)
+	if err != nil {
+		t.Fatalf("RequestCompletion() returned error: %v", err)
+	}
+	request = string(<-requests)
+	for _, expected := range []string{`"model":"gpt-4"`, `"max_tokens":100`, `"seed":42`} {
+		if !strings.Contains(request, expected) {
+			t.Errorf("RequestCompletion() request is missing %s: %v", expected, request)
+		}
+	}
+	if client.GetModel() != "gpt-3.5-turbo" {
+		t.Errorf("RequestCompletion() changed the client's model to %v", client.GetModel())
+	}
+}
@@ -0,0 +1,422 @@
+package openai
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrContextLengthExceeded is returned when a request does not fit in the
+// model's context window, even after applying the ContextBudget.
+var ErrContextLengthExceeded = errors.New("input does not fit in the model's context window")
+
+// ContextLengthError reports by how much a request exceeds the context window
+// of its model. It wraps ErrContextLengthExceeded.
+type ContextLengthError struct {
+	Model    string
+	Limit    int // Tokens available to the prompt
+	Required int // Tokens the prompt needs, after applying the budget
+}
+
+func (e *ContextLengthError) Error() string {
+	return fmt.Sprintf("%v: %s takes at most %d prompt tokens, %d required", ErrContextLengthExceeded, e.Model, e.Limit, e.Required)
+}
+
+func (e *ContextLengthError) Unwrap() error {
+	return ErrContextLengthExceeded
+}
+
+// ContextStrategy is what a ContextBudget does with the history when it does
+// not fit in the context window.
+type ContextStrategy string
+
+const (
+	// Fail the request with a ContextLengthError.
+	ContextStrategyError ContextStrategy = "error"
+	// Leave out the oldest turns.
+	ContextStrategyTrimOldest ContextStrategy = "trim_oldest"
+	// Replace the oldest turns with a summary written by the model.
+	ContextStrategySummarize ContextStrategy = "summarize"
+)
+
+const (
+	// DefaultCompletionTokens is how many tokens of the context window are
+	// kept free for the response by default.
+	DefaultCompletionTokens = 1024
+	// DefaultSummaryTokens is how long summaries of older turns can be.
+	DefaultSummaryTokens = 256
+)
+
+const truncationMarker = "\n[truncated]"
+
+// minTruncatedTokens is the shortest a message is cut to. Requests that only
+// fit with shorter messages fail instead of sending them emptied.
+const minTruncatedTokens = 16
+
+const summaryPrompt = "Summarize the conversation below for the assistant that will continue it. Keep every decision, requirement, name and open question, and leave out pleasantries."
+
+// ContextBudget decides which messages of a chat history are sent so that a
+// request fits in the model's context window. System prompts are always sent
+// whole, as is the latest message unless it is too long on its own.
+type ContextBudget struct {
+	Tokenizer         Tokenizer       // Nil uses the model's tokenizer, see ForModel
+	MaxContextTokens  int             // Zero uses the model's limit, see GetModelContextLimit
+	CompletionTokens  int             // Kept free for the response
+	SummaryTokens     int             // Length of summaries, for ContextStrategySummarize
+	Strategy          ContextStrategy // Applied to older turns when the history does not fit
+	TruncateOversized bool            // Cut the content of messages too long to fit on their own
+}
+
+// NewContextBudget returns the default budget, which leaves out the oldest
+// turns and truncates oversized messages.
+func NewContextBudget() *ContextBudget {
+	return &ContextBudget{
+		CompletionTokens:  DefaultCompletionTokens,
+		SummaryTokens:     DefaultSummaryTokens,
+		Strategy:          ContextStrategyTrimOldest,
+		TruncateOversized: true,
+	}
+}
+
+// ForModel returns the budget to apply to requests to model, counting tokens
+// with GetModelTokenizer unless the budget sets a Tokenizer. Budgets not
+// bound to a model count with EstimatingTokenizer.
+func (b *ContextBudget) ForModel(model string) *ContextBudget {
+	if b.Tokenizer != nil {
+		return b
+	}
+	forModel := *b
+	forModel.Tokenizer = GetModelTokenizer(model)
+	return &forModel
+}
+
+func (b *ContextBudget) getTokenizer() Tokenizer {
+	if b.Tokenizer == nil {
+		return EstimatingTokenizer{}
+	}
+	return b.Tokenizer
+}
+
+func (b *ContextBudget) CountTokens(text string) int {
+	return b.getTokenizer().CountTokens(text)
+}
+
+// CountMessageTokens returns the tokens messages take in a prompt, including
+// the tokens priming the reply.
+func (b *ContextBudget) CountMessageTokens(messages []ChatMessage) int {
+	tokens := replyTokenOverhead
+	for _, message := range messages {
+		tokens += countMessageTokens(b.getTokenizer(), message)
+	}
+	return tokens
+}
+
+// GetPromptLimit returns how many tokens the prompt of a request to model can
+// take, once the tokens for the response are set aside.
+func (b *ContextBudget) GetPromptLimit(model string) int {
+	limit := b.MaxContextTokens
+	if limit <= 0 {
+		limit = GetModelContextLimit(model)
+	}
+	return limit - b.CompletionTokens
+}
+
+// TruncateText cuts text to at most maxTokens tokens, marking that it did.
+func (b *ContextBudget) TruncateText(text string, maxTokens int) string {
+	if b.CountTokens(text) <= maxTokens {
+		return text
+	}
+	runes := []rune(text)
+	// Binary search for the longest prefix that fits with the marker.
+	low, high := 0, len(runes)
+	for low < high {
+		middle := (low + high + 1) / 2
+		if b.CountTokens(string(runes[:middle])+truncationMarker) <= maxTokens {
+			low = middle
+		} else {
+			high = middle - 1
+		}
+	}
+	if low == 0 {
+		return ""
+	}
+	return string(runes[:low]) + truncationMarker
+}
+
+// Summarizer condenses messages left out of a request into a short text.
+type Summarizer func(ctx context.Context, messages []ChatMessage) (string, error)
+
+// Fit returns the messages to send in a request to model, with functions
+// offered, applying the budget's strategy if the history does not fit.
+// summarize is only used with ContextStrategySummarize.
+func (b *ContextBudget) Fit(ctx context.Context, messages []ChatMessage, functions []ChatFunction, model string, summarize Summarizer) ([]ChatMessage, error) {
+	b = b.ForModel(model)
+	limit := b.GetPromptLimit(model) - countFunctionTokens(b.getTokenizer(), functions)
+	required := b.CountMessageTokens(messages)
+	if required <= limit {
+		return messages, nil
+	}
+	if b.Strategy == ContextStrategyError || b.Strategy == "" {
+		return nil, &ContextLengthError{Model: model, Limit: limit, Required: required}
+	}
+	reserved := 0
+	if b.Strategy == ContextStrategySummarize {
+		reserved = b.SummaryTokens + messageTokenOverhead + b.CountTokens(string(openai.ChatMessageRoleSystem)) + b.CountTokens(summaryPrefix)
+	}
+	kept, dropped := b.trimOldest(messages, limit-reserved)
+	if len(dropped) > 0 && b.Strategy == ContextStrategySummarize {
+		if summarize == nil {
+			return nil, fmt.Errorf("context strategy %s needs a summarizer", b.Strategy)
+		}
+		summary, err := summarize(ctx, dropped)
+		if err != nil {
+			return nil, fmt.Errorf("summarizing older messages: %w", err)
+		}
+		kept = insertSummary(kept, b.TruncateText(summary, b.SummaryTokens))
+	}
+	required = b.CountMessageTokens(kept)
+	if required > limit && b.TruncateOversized {
+		kept = b.truncateOversized(kept, limit)
+		required = b.CountMessageTokens(kept)
+	}
+	if required > limit {
+		return nil, &ContextLengthError{Model: model, Limit: limit, Required: required}
+	}
+	return kept, nil
+}
+
+// trimOldest leaves out the oldest turns until messages fit in limit tokens,
+// keeping system prompts, the latest message and, if that is a function
+// result, the call it answers. Function results are left out with their
+// call. It returns the messages kept and those left out, both in order.
+func (b *ContextBudget) trimOldest(messages []ChatMessage, limit int) ([]ChatMessage, []ChatMessage) {
+	protected := make([]bool, len(messages))
+	for i, message := range messages {
+		protected[i] = message.Role == openai.ChatMessageRoleSystem
+	}
+	last := len(messages) - 1
+	protected[last] = true
+	if messages[last].Role == openai.ChatMessageRoleFunction && last > 0 {
+		protected[last-1] = true
+	}
+	dropped := make([]bool, len(messages))
+	required := b.CountMessageTokens(messages)
+	for i := 0; i < len(messages) && required > limit; i++ {
+		if protected[i] || dropped[i] {
+			continue
+		}
+		dropped[i] = true
+		required -= countMessageTokens(b.getTokenizer(), messages[i])
+		if messages[i].FunctionCall == nil {
+			continue
+		}
+		for j := i + 1; j < len(messages) && messages[j].Role == openai.ChatMessageRoleFunction && !protected[j]; j++ {
+			dropped[j] = true
+			required -= countMessageTokens(b.getTokenizer(), messages[j])
+		}
+	}
+	var kept, left []ChatMessage
+	for i, message := range messages {
+		if dropped[i] {
+			left = append(left, message)
+		} else {
+			kept = append(kept, message)
+		}
+	}
+	return kept, left
+}
+
+const summaryPrefix = "Summary of the earlier conversation:\n"
+
+// insertSummary adds summary as a system message after the leading system
+// prompts, where the turns it replaces were.
+func insertSummary(messages []ChatMessage, summary string) []ChatMessage {
+	position := 0
+	for position < len(messages) && messages[position].Role == openai.ChatMessageRoleSystem {
+		position++
+	}
+	summaryMessage := ChatMessage{Role: openai.ChatMessageRoleSystem, Content: summaryPrefix + summary}
+	withSummary := append([]ChatMessage{}, messages[:position]...)
+	withSummary = append(withSummary, summaryMessage)
+	return append(withSummary, messages[position:]...)
+}
+
+// truncateOversized cuts the content of the longest messages other than
+// system prompts until messages fit in limit tokens. Messages are not cut
+// below minTruncatedTokens, so the result may still not fit, for instance
+// when the latest message cannot fit on its own.
+func (b *ContextBudget) truncateOversized(messages []ChatMessage, limit int) []ChatMessage {
+	truncated := append([]ChatMessage{}, messages...)
+	atMinimum := make([]bool, len(truncated))
+	for {
+		excess := b.CountMessageTokens(truncated) - limit
+		if excess <= 0 {
+			return truncated
+		}
+		longest, longestTokens := -1, minTruncatedTokens
+		for i, message := range truncated {
+			tokens := b.CountTokens(message.Content)
+			if message.Role != openai.ChatMessageRoleSystem && !atMinimum[i] && tokens > longestTokens {
+				longest, longestTokens = i, tokens
+			}
+		}
+		if longest < 0 {
+			return truncated
+		}
+		target := longestTokens - excess
+		if target <= minTruncatedTokens {
+			target = minTruncatedTokens
+			atMinimum[longest] = true
+		}
+		truncated[longest].Content = b.TruncateText(truncated[longest].Content, target)
+	}
+}
+
+// SetContextBudget sets the budget applied to the history before every
+// completion request. Nil sends the whole history, leaving requests that are
+// too long to fail at the API.
+func (c *ChatClient) SetContextBudget(budget *ContextBudget) {
+	c.messagesMutex.Lock()
+	defer c.messagesMutex.Unlock()
+	c.budget = budget
+}
+
+func (c *ChatClient) GetContextBudget() *ContextBudget {
+	c.messagesMutex.RLock()
+	defer c.messagesMutex.RUnlock()
+	return c.budget
+}
+
+// GetRequestBudget returns the budget applied to requests to model: the
+// client's budget, counting tokens with the model's tokenizer and bounded by
+// the context window the provider reports for model, see
+// ai.ContextLimitedProvider. It returns nil if the client has no budget.
+func (c *ChatClient) GetRequestBudget(model string) *ContextBudget {
+	budget := c.GetContextBudget()
+	if budget == nil {
+		return nil
+	}
+	budget = budget.ForModel(model)
+	provider, ok := c.GetProvider().(ai.ContextLimitedProvider)
+	if !ok || budget.MaxContextTokens > 0 {
+		return budget
+	}
+	if limit := provider.GetContextLimit(model); limit > 0 {
+		limited := *budget
+		limited.MaxContextTokens = limit
+		budget = &limited
+	}
+	return budget
+}
+
+// fitContext returns the messages of history to send in a request to model,
+// according to the client's budget.
+func (c *ChatClient) fitContext(ctx context.Context, history []ChatMessage, model string) ([]ChatMessage, error) {
+	budget := c.GetRequestBudget(model)
+	if budget == nil || len(history) == 0 {
+		return history, nil
+	}
+	if maxTokens := c.getRequestOptions(ctx).MaxTokens; maxTokens > budget.CompletionTokens {
+		// The response may take more than the budget sets aside.
+		widened := *budget
+		widened.CompletionTokens = maxTokens
+		budget = &widened
+	}
+	summarize := func(ctx context.Context, messages []ChatMessage) (string, error) {
+		return c.summarize(ctx, messages, model)
+	}
+	return budget.Fit(ctx, history, c.GetFunctions(), model, summarize)
+}
+
+// chatSummary is the last summary written of turns left out of requests.
+// Turns are left out oldest first, so the next summary extends it with the
+// turns left out since.
+type chatSummary struct {
+	digest  string // Digest of the messages summarized
+	count   int    // Number of messages summarized
+	summary string
+	mutex   sync.Mutex
+}
+
+// summarize asks the model to summarize messages, reusing the summary of
+// the messages they start with if there is one.
+func (c *ChatClient) summarize(ctx context.Context, messages []ChatMessage, model string) (string, error) {
+	c.summary.mutex.Lock()
+	defer c.summary.mutex.Unlock()
+	previous, newMessages := "", messages
+	if c.summary.count > 0 && c.summary.count <= len(messages) && c.summary.digest == digestMessages(messages[:c.summary.count]) {
+		previous, newMessages = c.summary.summary, messages[c.summary.count:]
+	}
+	if len(newMessages) == 0 {
+		return previous, nil
+	}
+	budget := *c.GetRequestBudget(model)
+	budget.CompletionTokens = budget.SummaryTokens
+	prompt := []ChatMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+		{Role: openai.ChatMessageRoleUser},
+	}
+	// The oldest turns are left out of the transcript if it does not fit in
+	// the summary request.
+	transcriptLimit := budget.GetPromptLimit(model) - budget.CountMessageTokens(prompt)
+	prompt[1].Content = budget.formatTranscript(previous, newMessages, transcriptLimit)
+	request := ai.ChatCompletionRequest{
+		Messages: prompt,
+		Options: ai.ModelOptions{
+			Model:     model,
+			MaxTokens: budget.SummaryTokens,
+		},
+	}
+	response, err := c.GetProvider().CreateChatCompletion(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	c.summary.digest = digestMessages(messages)
+	c.summary.count = len(messages)
+	c.summary.summary = response.Content
+	return c.summary.summary, nil
+}
+
+// formatTranscript writes messages, after the previous summary if there is
+// one, as a transcript of at most maxTokens tokens. The latest messages are
+// kept, the latest one cut if it is too long on its own.
+func (b *ContextBudget) formatTranscript(previous string, messages []ChatMessage, maxTokens int) string {
+	header := ""
+	if previous != "" {
+		header = fmt.Sprintf("%s%s\n\n", summaryPrefix, previous)
+	}
+	remaining := maxTokens - b.CountTokens(header)
+	var lines []string
+	for i := len(messages) - 1; i >= 0 && remaining > 0; i-- {
+		line := fmt.Sprintf("%s: %s\n", messages[i].Role, messages[i].Content)
+		tokens := b.CountTokens(line)
+		if tokens > remaining {
+			if len(lines) == 0 {
+				lines = append(lines, b.TruncateText(line, remaining))
+			}
+			break
+		}
+		lines = append(lines, line)
+		remaining -= tokens
+	}
+	var transcript strings.Builder
+	transcript.WriteString(header)
+	for i := len(lines) - 1; i >= 0; i-- {
+		transcript.WriteString(lines[i])
+	}
+	return transcript.String()
+}
+
+func digestMessages(messages []ChatMessage) string {
+	encoded, _ := json.Marshal(messages)
+	digest := sha256.Sum256(encoded)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,296 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// wordTokenizer counts a token per word, which keeps expectations readable.
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+func newTestBudget(maxContextTokens int, strategy ContextStrategy) *ContextBudget {
+	budget := NewContextBudget()
+	budget.Tokenizer = wordTokenizer{}
+	budget.MaxContextTokens = maxContextTokens
+	budget.CompletionTokens = 0
+	budget.SummaryTokens = 5
+	budget.Strategy = strategy
+	return budget
+}
+
+func TestEstimatingTokenizer(t *testing.T) {
+	tokenizer := EstimatingTokenizer{}
+	tests := []struct {
+		text   string
+		tokens int
+	}{
+		{"", 0},
+		{"Hello", 1},
+		{"Hello world", 2},
+		{"12345", 2},
+		{"a, b", 3},
+		{"café", 3},
+		{"你好", 6},
+		{"नमस्ते", 18},
+	}
+	for _, test := range tests {
+		if tokens := tokenizer.CountTokens(test.text); tokens != test.tokens {
+			t.Errorf("CountTokens(%q) = %d, want %d", test.text, tokens, test.tokens)
+		}
+	}
+	long := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)
+	if tokens := tokenizer.CountTokens(long); tokens < 900 || tokens > 1200 {
+		t.Errorf("CountTokens() = %d for 1000 words of English", tokens)
+	}
+}
+
+func TestBPETokenizer(t *testing.T) {
+	tokenizer, err := NewBPETokenizer("cl100k_base")
+	if err != nil {
+		t.Skipf("cl100k_base could not be loaded: %v", err)
+	}
+	if tokens := tokenizer.CountTokens("Hello world"); tokens != 2 {
+		t.Errorf("CountTokens() = %d, want 2", tokens)
+	}
+	for _, text := range []string{"Hello world, this is a test.", "नमस्ते दुनिया, आप कैसे हैं?", "你好，世界。", "Ελληνικά κείμενα"} {
+		if estimate, tokens := (EstimatingTokenizer{}).CountTokens(text), tokenizer.CountTokens(text); estimate < tokens {
+			t.Errorf("EstimatingTokenizer counts %d tokens for %q, cl100k_base %d", estimate, text, tokens)
+		}
+	}
+}
+
+func TestGetModelTokenizer(t *testing.T) {
+	if _, ok := GetModelTokenizer("local-model").(EstimatingTokenizer); !ok {
+		t.Error("GetModelTokenizer() does not estimate the tokens of unknown models")
+	}
+	switch tokenizer := GetModelTokenizer("gpt-4-0613").(type) {
+	case *BPETokenizer:
+		if tokenizer != GetModelTokenizer("gpt-3.5-turbo") {
+			t.Error("GetModelTokenizer() loaded cl100k_base twice")
+		}
+	case EstimatingTokenizer:
+		t.Log("cl100k_base could not be loaded, token counts are estimated")
+	default:
+		t.Errorf("GetModelTokenizer() returned %T", tokenizer)
+	}
+}
+
+func TestGetModelContextLimit(t *testing.T) {
+	tests := map[string]int{
+		"gpt-4":             8192,
+		"gpt-4-0613":        8192,
+		"gpt-4-32k-0613":    32768,
+		"gpt-3.5-turbo":     4096,
+		"unknown-model":     DefaultContextLimit,
+		"gpt-3.5-turbo-16k": 16384,
+	}
+	for model, limit := range tests {
+		if got := GetModelContextLimit(model); got != limit {
+			t.Errorf("GetModelContextLimit(%q) = %d, want %d", model, got, limit)
+		}
+	}
+}
+
+func TestGetRequestBudgetUsesProviderContextLimit(t *testing.T) {
+	provider := NewOpenAI("test")
+	client := NewChatClientWithProvider(provider)
+	if limit := client.GetRequestBudget("local-model").GetPromptLimit("local-model"); limit != DefaultContextLimit-DefaultCompletionTokens {
+		t.Errorf("GetPromptLimit() = %d without a provider limit", limit)
+	}
+	provider.SetContextLimit("local-model", 2048)
+	if limit := client.GetRequestBudget("local-model").GetPromptLimit("local-model"); limit != 2048-DefaultCompletionTokens {
+		t.Errorf("GetPromptLimit() = %d with a provider limit of 2048", limit)
+	}
+	if limit := NewChatClient("test").GetRequestBudget("local-model").GetPromptLimit("local-model"); limit != DefaultContextLimit-DefaultCompletionTokens {
+		t.Errorf("GetPromptLimit() = %d for another client", limit)
+	}
+	budget := NewContextBudget()
+	budget.MaxContextTokens = 1000
+	client.SetContextBudget(budget)
+	if limit := client.GetRequestBudget("local-model").GetPromptLimit("local-model"); limit != 1000-DefaultCompletionTokens {
+		t.Errorf("GetPromptLimit() = %d, want the budget's own limit", limit)
+	}
+	client.SetContextBudget(nil)
+	if client.GetRequestBudget("local-model") != nil {
+		t.Error("GetRequestBudget() returned a budget for a client without one")
+	}
+}
+
+func TestContextBudgetFitKeepsHistoryThatFits(t *testing.T) {
+	messages := []ChatMessage{{Role: "system", Content: "Be brief"}, {Role: "user", Content: "Hi"}}
+	fitted, err := newTestBudget(100, ContextStrategyTrimOldest).Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if err != nil {
+		t.Fatalf("Fit() returned error: %v", err)
+	}
+	if len(fitted) != 2 {
+		t.Errorf("Fit() returned %d messages, want 2", len(fitted))
+	}
+}
+
+func TestContextBudgetFitTrimsOldestTurns(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "one two three"},
+		{Role: "user", Content: "first question asked"},
+		{Role: "assistant", Content: "", FunctionCall: &ChatFunctionCall{Name: "web_search", Arguments: "{}"}},
+		{Role: "function", Name: "web_search", Content: "long result of the search"},
+		{Role: "assistant", Content: "first answer given"},
+		{Role: "user", Content: "second question"},
+	}
+	// The system prompt and last message take 6 + 2 + 2*3 + 3 tokens with the
+	// chat format, leaving room for the first answer only.
+	fitted, err := newTestBudget(30, ContextStrategyTrimOldest).Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if err != nil {
+		t.Fatalf("Fit() returned error: %v", err)
+	}
+	var contents []string
+	for _, message := range fitted {
+		contents = append(contents, message.Role+":"+message.Content)
+	}
+	want := "system:one two three|assistant:first answer given|user:second question"
+	if strings.Join(contents, "|") != want {
+		t.Errorf("Fit() kept %v, want %v", strings.Join(contents, "|"), want)
+	}
+
+	_, err = newTestBudget(30, ContextStrategyError).Fit(context.Background(), messages, nil, "gpt-4", nil)
+	var lengthErr *ContextLengthError
+	if !errors.As(err, &lengthErr) || !errors.Is(err, ErrContextLengthExceeded) {
+		t.Fatalf("Fit() with the error strategy returned %v", err)
+	}
+	if lengthErr.Limit != 30 || lengthErr.Required <= 30 {
+		t.Errorf("Fit() returned wrong error: %v", lengthErr)
+	}
+}
+
+func TestContextBudgetFitSummarizesOldestTurns(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "my project is called solus"},
+		{Role: "assistant", Content: "noted the name"},
+		{Role: "user", Content: "what is it called"},
+	}
+	var summarized []ChatMessage
+	summarize := func(ctx context.Context, messages []ChatMessage) (string, error) {
+		summarized = messages
+		return "project named solus", nil
+	}
+	fitted, err := newTestBudget(30, ContextStrategySummarize).Fit(context.Background(), messages, nil, "gpt-4", summarize)
+	if err != nil {
+		t.Fatalf("Fit() returned error: %v", err)
+	}
+	if len(summarized) == 0 || summarized[0].Content != "my project is called solus" {
+		t.Errorf("Fit() summarized wrong messages: %v", summarized)
+	}
+	if len(fitted) < 3 || fitted[1].Role != "system" || fitted[1].Content != summaryPrefix+"project named solus" {
+		t.Fatalf("Fit() did not insert the summary: %v", fitted)
+	}
+	if fitted[len(fitted)-1].Content != "what is it called" {
+		t.Errorf("Fit() dropped the last message: %v", fitted)
+	}
+}
+
+func TestContextBudgetFitTruncatesOversizedMessages(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: strings.Repeat("word ", 100)},
+	}
+	budget := newTestBudget(30, ContextStrategyTrimOldest)
+	fitted, err := budget.Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if err != nil {
+		t.Fatalf("Fit() returned error: %v", err)
+	}
+	if fitted[0].Content != "be brief" {
+		t.Errorf("Fit() changed the system prompt: %q", fitted[0].Content)
+	}
+	if !strings.HasSuffix(fitted[1].Content, truncationMarker) || budget.CountMessageTokens(fitted) > 30 {
+		t.Errorf("Fit() did not truncate the oversized message: %q", fitted[1].Content)
+	}
+	if messages[1].Content != strings.Repeat("word ", 100) {
+		t.Error("Fit() changed the caller's messages")
+	}
+
+	budget.TruncateOversized = false
+	_, err = budget.Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("Fit() without truncation returned %v", err)
+	}
+}
+
+func TestContextBudgetFitFailsRatherThanEmptyingMessages(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: strings.Repeat("rule ", 18)},
+		{Role: "user", Content: strings.Repeat("word ", 100)},
+	}
+	_, err := newTestBudget(30, ContextStrategyTrimOldest).Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("Fit() returned %v for a message that only fits emptied", err)
+	}
+}
+
+func TestFormatTranscriptKeepsLatestMessages(t *testing.T) {
+	budget := newTestBudget(30, ContextStrategySummarize)
+	messages := []ChatMessage{
+		{Role: "user", Content: "first turn"},
+		{Role: "assistant", Content: "second turn"},
+		{Role: "user", Content: "third turn"},
+	}
+	transcript := budget.formatTranscript("earlier", messages, 12)
+	if transcript != summaryPrefix+"earlier\n\nassistant: second turn\nuser: third turn\n" {
+		t.Errorf("formatTranscript() = %q", transcript)
+	}
+	if transcript := budget.formatTranscript("", messages, 100); !strings.HasPrefix(transcript, "user: first turn\n") {
+		t.Errorf("formatTranscript() = %q with room for every message", transcript)
+	}
+	long := []ChatMessage{{Role: "user", Content: strings.Repeat("word ", 100)}}
+	if transcript := budget.formatTranscript("", long, 10); budget.CountTokens(transcript) > 10 || !strings.HasSuffix(transcript, truncationMarker) {
+		t.Errorf("formatTranscript() = %q for a message longer than the limit", transcript)
+	}
+}
+
+func TestContextBudgetFitNeverDropsSystemPrompts(t *testing.T) {
+	messages := []ChatMessage{
+		{Role: "system", Content: strings.Repeat("rule ", 50)},
+		{Role: "user", Content: "hello"},
+	}
+	_, err := newTestBudget(30, ContextStrategyTrimOldest).Fit(context.Background(), messages, nil, "gpt-4", nil)
+	if !errors.Is(err, ErrContextLengthExceeded) {
+		t.Errorf("Fit() returned %v for a system prompt longer than the context window", err)
+	}
+}
+
+func TestRequestCompletionSendsFittedHistory(t *testing.T) {
+	client := NewChatClient("test")
+	requests := make(chan []byte, 1)
+	ts := StartSequenceHTTPTestServer(requests, SampleChatCompletion)
+	defer ts.Close()
+	client.SetBaseURL(ts.URL)
+	client.SetContextBudget(newTestBudget(20, ContextStrategyTrimOldest))
+	client.SetMessages([]ChatMessage{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "an old question that no longer fits"},
+		{Role: "assistant", Content: "an old answer that no longer fits"},
+	})
+	err := client.SendMessage("hi", "user")
+	if err != nil {
+		t.Fatalf("SendMessage() returned error: %v", err)
+	}
+	var request struct {
+		Messages []ChatMessage `json:"messages"`
+	}
+	err = json.Unmarshal(<-requests, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Messages) != 2 || request.Messages[1].Content != "hi" {
+		t.Errorf("SendMessage() sent %v", request.Messages)
+	}
+	if len(client.GetMessages()) != 5 {
+		t.Errorf("SendMessage() kept %d messages, want the whole history of 5", len(client.GetMessages()))
+	}
+}
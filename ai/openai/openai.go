@@ -1,38 +1,83 @@
 package openai
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/CSXL/solus/ai"
 	openai "github.com/sashabaranov/go-openai"
 )
 
+// OpenAI is the ai.Provider of the OpenAI API. Its requests only use the
+// OpenAI wire format, so it also serves other APIs implementing it.
 type OpenAI struct {
-	apiKey string
-	ctx    context.Context
-	client *openai.Client
+	apiKey         string
+	baseURL        string
+	embeddingModel string
+	contextLimits  map[string]int // Set with SetContextLimit
+	ctx            context.Context
+	client         *openai.Client
+	httpClient     *http.Client // Used for the requests the client library does not support
 }
 
 func NewOpenAI(apiKey string) *OpenAI {
-	return &OpenAI{
-		apiKey: apiKey,
-		ctx:    context.Background(),
-		client: openai.NewClient(apiKey),
-	}
+	return NewOpenAIWithBaseURL(apiKey, openai.DefaultConfig(apiKey).BaseURL)
 }
 
 func NewOpenAIWithBaseURL(apiKey string, baseURL string) *OpenAI {
 	cfg := openai.DefaultConfig(apiKey)
 	cfg.BaseURL = baseURL
 	return &OpenAI{
-		apiKey: apiKey,
-		ctx:    context.Background(),
-		client: openai.NewClientWithConfig(cfg),
+		apiKey:         apiKey,
+		baseURL:        baseURL,
+		embeddingModel: ai.DefaultEmbeddingModel,
+		ctx:            context.Background(),
+		client:         openai.NewClientWithConfig(cfg),
+		httpClient:     cfg.HTTPClient,
 	}
 }
 
+// SetEmbeddingModel sets the model GetEmbeddings uses, by default
+// ai.DefaultEmbeddingModel.
+func (o *OpenAI) SetEmbeddingModel(model string) {
+	o.embeddingModel = model
+}
+
+func (o *OpenAI) GetEmbeddingModel() string {
+	return o.embeddingModel
+}
+
+// SetContextLimit sets the number of tokens fitting in the context window of
+// model as served at the client's base URL, for models unknown to
+// GetModelContextLimit or served with a different window.
+func (o *OpenAI) SetContextLimit(model string, limit int) {
+	if o.contextLimits == nil {
+		o.contextLimits = map[string]int{}
+	}
+	o.contextLimits[model] = limit
+}
+
+// GetContextLimit returns the context window set for model with
+// SetContextLimit, or 0 if none was.
+func (o *OpenAI) GetContextLimit(model string) int {
+	return o.contextLimits[model]
+}
+
 func (o *OpenAI) GetCompletion(prompt string, model string) (string, error) {
+	return o.GetCompletionWithContext(o.ctx, prompt, model)
+}
+
+// GetCompletionWithContext is GetCompletion with a caller-provided context
+// that can cancel the request.
+func (o *OpenAI) GetCompletionWithContext(ctx context.Context, prompt string, model string) (string, error) {
 	resp, err := o.client.CreateCompletion(
-		o.ctx,
+		ctx,
 		openai.CompletionRequest{
 			Prompt: prompt,
 			Model:  model,
@@ -45,13 +90,30 @@ func (o *OpenAI) GetCompletion(prompt string, model string) (string, error) {
 }
 
 func (o *OpenAI) GetEmbeddings(texts []string) ([][]float32, error) {
-	resp, err := o.client.CreateEmbeddings(
-		o.ctx,
-		openai.EmbeddingRequest{
-			Input: texts,
-			Model: openai.AdaEmbeddingV2,
-		},
-	)
+	return o.GetEmbeddingsWithContext(o.ctx, texts)
+}
+
+// GetEmbeddingsWithContext is GetEmbeddings with a caller-provided context
+// that can cancel the request.
+func (o *OpenAI) GetEmbeddingsWithContext(ctx context.Context, texts []string) ([][]float32, error) {
+	return o.CreateEmbeddings(ctx, texts)
+}
+
+// CreateEmbeddings returns the embeddings of texts from the embedding model,
+// see SetEmbeddingModel.
+func (o *OpenAI) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	// The client library only sends the embedding models it knows of.
+	request := struct {
+		Input []string `json:"input"`
+		Model string   `json:"model"`
+	}{
+		Input: texts,
+		Model: o.embeddingModel,
+	}
+	var resp struct {
+		Data []openai.Embedding `json:"data"`
+	}
+	err := o.postJSON(ctx, "/embeddings", request, &resp)
 	if err != nil {
 		return nil, err
 	}
@@ -66,3 +128,125 @@ func embeddingsToVectors(embeddings []openai.Embedding) [][]float32 {
 	}
 	return vector
 }
+
+// CreateChatCompletion returns the assistant's response to request.
+func (o *OpenAI) CreateChatCompletion(ctx context.Context, request ai.ChatCompletionRequest) (ai.ChatMessage, error) {
+	start := time.Now()
+	var resp openai.ChatCompletionResponse
+	err := o.postJSON(ctx, "/chat/completions", newChatCompletionRequest(request), &resp)
+	if err != nil {
+		return ai.ChatMessage{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return ai.ChatMessage{}, errors.New("chat completion has no choices")
+	}
+	response := fromOpenAIChatMessage(resp.Choices[0].Message)
+	response.Metadata = newResponseMetadata(request, resp.ID, resp.Model, string(resp.Choices[0].FinishReason), resp.Usage, start)
+	return response, nil
+}
+
+// newResponseMetadata returns the metadata of a response to request that was
+// sent at start. Usage is left unset if the provider did not report it.
+func newResponseMetadata(request ai.ChatCompletionRequest, responseID string, model string, finishReason string, usage openai.Usage, start time.Time) ai.MessageMetadata {
+	metadata := ai.NewMessageMetadata()
+	metadata.ResponseID = responseID
+	metadata.Model = model
+	if metadata.Model == "" {
+		metadata.Model = request.Options.Model
+	}
+	metadata.FinishReason = finishReason
+	metadata.PromptTokens = usage.PromptTokens
+	metadata.CompletionTokens = usage.CompletionTokens
+	metadata.Latency = time.Since(start)
+	return metadata
+}
+
+// chatCompletionRequest adds the parameters the client library does not
+// know of to its request. Sampling parameters are pointers so that zero, a
+// valid temperature, is sent rather than left out.
+type chatCompletionRequest struct {
+	openai.ChatCompletionRequest
+	Temperature    *float32                      `json:"temperature,omitempty"`
+	TopP           *float32                      `json:"top_p,omitempty"`
+	Seed           *int                          `json:"seed,omitempty"`
+	ResponseFormat *chatCompletionResponseFormat `json:"response_format,omitempty"`
+}
+
+type chatCompletionResponseFormat struct {
+	Type ai.ResponseFormat `json:"type"`
+}
+
+func newChatCompletionRequest(request ai.ChatCompletionRequest) chatCompletionRequest {
+	options := request.Options
+	wireRequest := chatCompletionRequest{
+		ChatCompletionRequest: openai.ChatCompletionRequest{
+			Model:     options.Model,
+			Messages:  toOpenAIChatMessages(request.Messages),
+			MaxTokens: options.MaxTokens,
+			Stop:      options.Stop,
+			Functions: toOpenAIFunctions(request.Functions),
+		},
+		Temperature: options.Temperature,
+		TopP:        options.TopP,
+		Seed:        options.Seed,
+	}
+	if options.ResponseFormat != "" {
+		wireRequest.ResponseFormat = &chatCompletionResponseFormat{Type: options.ResponseFormat}
+	}
+	return wireRequest
+}
+
+// postJSON posts request to the API endpoint at path and decodes the
+// response into response.
+func (o *OpenAI) postJSON(ctx context.Context, path string, request interface{}, response interface{}) error {
+	body, err := o.post(ctx, path, request, "application/json")
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(response)
+}
+
+// post posts request to the API endpoint at path and returns the body of the
+// response, which the caller must close. It is used for the requests the
+// client library cannot express, such as those with parameters it does not
+// know. Error responses are returned as *openai.APIError or
+// *openai.RequestError, like the client library does.
+func (o *OpenAI) post(ctx context.Context, path string, request interface{}, accept string) (io.ReadCloser, error) {
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(o.baseURL, "/")+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", accept)
+	if accept == "text/event-stream" {
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+	if o.apiKey != "" {
+		// Servers running models locally often need no key.
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, decodeErrorResponse(resp)
+	}
+	return resp.Body, nil
+}
+
+func decodeErrorResponse(resp *http.Response) error {
+	var errorResponse openai.ErrorResponse
+	err := json.NewDecoder(resp.Body).Decode(&errorResponse)
+	if err != nil || errorResponse.Error == nil {
+		return &openai.RequestError{HTTPStatusCode: resp.StatusCode, Err: err}
+	}
+	errorResponse.Error.HTTPStatusCode = resp.StatusCode
+	return errorResponse.Error
+}
@@ -1,6 +1,7 @@
 package openai
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -61,3 +62,38 @@ func TestGetEmbeddings(t *testing.T) {
 		t.Errorf("GetEmbeddings() returned wrong number of dimensions: %v", len(embeddings[0]))
 	}
 }
+
+func TestGetEmbeddingsWithModel(t *testing.T) {
+	var model string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Model string `json:"model"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			return
+		}
+		model = request.Model
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write([]byte(`{"data":[{"embedding":[0.1,0.2],"index":0,"object":"embedding"}],"model":"nomic-embed-text","object":"list"}`))
+		if err != nil {
+			return
+		}
+	}))
+	defer ts.Close()
+	client := NewOpenAIWithBaseURL("test", ts.URL)
+	if client.GetEmbeddingModel() != "text-embedding-ada-002" {
+		t.Errorf("GetEmbeddingModel() returned wrong default: %v", client.GetEmbeddingModel())
+	}
+	client.SetEmbeddingModel("nomic-embed-text")
+	embeddings, err := client.GetEmbeddings([]string{"Hello World"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings() returned error: %v", err)
+	}
+	if model != "nomic-embed-text" {
+		t.Errorf("GetEmbeddings() requested wrong model: %v", model)
+	}
+	if len(embeddings) != 1 || len(embeddings[0]) != 2 {
+		t.Errorf("GetEmbeddings() returned wrong embeddings: %v", embeddings)
+	}
+}
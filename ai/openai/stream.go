@@ -0,0 +1,145 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatCompletionDeltaHandler is ai.ChatCompletionDeltaHandler, kept under
+// the name this package has always exported.
+type ChatCompletionDeltaHandler = ai.ChatCompletionDeltaHandler
+
+// chatCompletionStreamChunk is one server-sent event of a streamed chat
+// completion. The client library drops the function call from stream deltas,
+// so streams are read with this type instead.
+type chatCompletionStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Role         string               `json:"role"`
+			Content      string               `json:"content"`
+			FunctionCall *openai.FunctionCall `json:"function_call"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openai.Usage `json:"usage"` // Only sent by some servers, with the last chunk
+}
+
+// CreateChatCompletionStream requests a completion for messages as a stream
+// of server-sent events, passing each piece of content to onDelta as it
+// arrives, and returns the messages with the full response appended. Function
+// calls are assembled from their pieces rather than passed to onDelta. The
+// request is aborted when ctx is cancelled.
+func (c *ChatClient) CreateChatCompletionStream(ctx context.Context, messages []ChatMessage, model string, onDelta ChatCompletionDeltaHandler) ([]ChatMessage, error) {
+	request := c.newChatCompletionRequest(ctx, messages, model)
+	response, err := c.GetProvider().CreateChatCompletionStream(ctx, request, onDelta)
+	if err != nil {
+		return nil, err
+	}
+	return append(messages, c.completeMetadata(request, response)), nil
+}
+
+// CreateChatCompletionStream streams the assistant's response to request as
+// server-sent events, passing each piece of content to onDelta as it arrives.
+// Function calls are assembled from their pieces rather than passed to
+// onDelta.
+func (o *OpenAI) CreateChatCompletionStream(ctx context.Context, request ai.ChatCompletionRequest, onDelta ai.ChatCompletionDeltaHandler) (ai.ChatMessage, error) {
+	start := time.Now()
+	wireRequest := newChatCompletionRequest(request)
+	wireRequest.Stream = true
+	body, err := o.post(ctx, "/chat/completions", wireRequest, "text/event-stream")
+	if err != nil {
+		return ai.ChatMessage{}, err
+	}
+	defer body.Close()
+	response := ChatMessage{Role: openai.ChatMessageRoleAssistant}
+	var content strings.Builder
+	var functionCall *ChatFunctionCall
+	var responseID, model, finishReason string
+	var usage openai.Usage
+	err = readServerSentEvents(body, func(data []byte) error {
+		var chunk chatCompletionStreamChunk
+		err := json.Unmarshal(data, &chunk)
+		if err != nil {
+			return err
+		}
+		if chunk.ID != "" {
+			responseID = chunk.ID
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			return nil
+		}
+		if chunk.Choices[0].FinishReason != "" {
+			finishReason = chunk.Choices[0].FinishReason
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Role != "" {
+			response.Role = delta.Role
+		}
+		if delta.FunctionCall != nil {
+			if functionCall == nil {
+				functionCall = &ChatFunctionCall{}
+			}
+			functionCall.Name += delta.FunctionCall.Name
+			functionCall.Arguments += delta.FunctionCall.Arguments
+		}
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			if onDelta != nil {
+				onDelta(delta.Content)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ai.ChatMessage{}, err
+	}
+	response.Content = content.String()
+	response.FunctionCall = functionCall
+	response.Metadata = newResponseMetadata(request, responseID, model, finishReason, usage, start)
+	return response, nil
+}
+
+// readServerSentEvents passes the data of each event of a stream to onData
+// until the stream ends or sends the "[DONE]" sentinel.
+func readServerSentEvents(body io.Reader, onData func(data []byte) error) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			line = bytes.TrimSpace(line)
+			if bytes.HasPrefix(line, []byte("data:")) {
+				data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+				if string(data) == "[DONE]" {
+					return nil
+				}
+				dataErr := onData(data)
+				if dataErr != nil {
+					return dataErr
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
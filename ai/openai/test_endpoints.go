@@ -2,8 +2,14 @@
 package openai
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
+
+	"github.com/sashabaranov/go-openai"
 )
 
 type OpenAIResponse string
@@ -15,6 +21,14 @@ const (
 	SampleChatFileCompletion OpenAIResponse = `{"id":"chatcmpl-123","object":"chat.completion","created":1679367552,"model":"gpt-3.5-turbo-0301","usage":{"prompt_tokens":9,"completion_tokens":11,"total_tokens":20},"choices":[{"message":{"role":"assistant","content":"//// FILE~test/text.txt ////\nHello world\n//// END FILE ////\n//// FILE~test/test2.txt////\nHello Again\n//// END FILE ////\n"},"finish_reason":"stop","index":0}]}`
 )
 
+// SampleChatFunctionCallCompletion asks to call a web_search function.
+const SampleChatFunctionCallCompletion OpenAIResponse = `{"id":"chatcmpl-123","object":"chat.completion","created":1687182516,"model":"gpt-4-0613","usage":{"prompt_tokens":82,"completion_tokens":18,"total_tokens":100},"choices":[{"message":{"role":"assistant","content":null,"function_call":{"name":"web_search","arguments":"{\n  \"query\": \"CSX Labs\"\n}"}},"finish_reason":"function_call","index":0}]}`
+
+// SampleChatJSONCompletionDeltas is SampleChatJSONCompletion split into the
+// pieces of a streamed response, breaking the JSON message mid-key and
+// mid-escape.
+var SampleChatJSONCompletionDeltas = []string{`{"ty`, `pe":"mess`, `age","con`, `tent":"CSX Labs is `, `an \`, `"amazing\" organization.`, `"}`}
+
 func StartHTTPTestServer(response OpenAIResponse) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("content-Type", "application/json")
@@ -24,3 +38,88 @@ func StartHTTPTestServer(response OpenAIResponse) *httptest.Server {
 		}
 	}))
 }
+
+// StartSequenceHTTPTestServer responds to each request with the next of
+// responses, repeating the last one once they run out. Request bodies are
+// sent to requests, if it is not nil.
+func StartSequenceHTTPTestServer(requests chan<- []byte, responses ...OpenAIResponse) *httptest.Server {
+	var count int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return
+			}
+			requests <- body
+		}
+		i := int(atomic.AddInt32(&count, 1)) - 1
+		if i >= len(responses) {
+			i = len(responses) - 1
+		}
+		w.Header().Set("content-Type", "application/json")
+		_, err := w.Write([]byte(responses[i]))
+		if err != nil {
+			return
+		}
+	}))
+}
+
+// StartFlakyHTTPTestServer responds to the first failures requests with an
+// OpenAI error and the given status code, then with response.
+func StartFlakyHTTPTestServer(failures int, statusCode int, response OpenAIResponse) *httptest.Server {
+	var requests int32
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-Type", "application/json")
+		if int(atomic.AddInt32(&requests, 1)) <= failures {
+			w.WriteHeader(statusCode)
+			_, err := w.Write([]byte(`{"error":{"message":"Rate limit reached","type":"requests"}}`))
+			if err != nil {
+				return
+			}
+			return
+		}
+		_, err := w.Write([]byte(response))
+		if err != nil {
+			return
+		}
+	}))
+}
+
+// StartStreamingHTTPTestServer responds to chat completion requests with a
+// server-sent event stream sending each of deltas as a chunk of the
+// assistant's response.
+func StartStreamingHTTPTestServer(deltas []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-Type", "text/event-stream")
+		flusher, _ := w.(http.Flusher)
+		for i, delta := range deltas {
+			chunk := openai.ChatCompletionStreamResponse{
+				ID:      "chatcmpl-123",
+				Object:  "chat.completion.chunk",
+				Created: 1679367552,
+				Model:   "gpt-4-0314",
+				Choices: []openai.ChatCompletionStreamChoice{{
+					Delta: openai.ChatCompletionStreamChoiceDelta{Content: delta},
+				}},
+			}
+			if i == 0 {
+				chunk.Choices[0].Delta.Role = openai.ChatMessageRoleAssistant
+			}
+			data, err := json.Marshal(chunk)
+			if err != nil {
+				return
+			}
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		_, err := fmt.Fprint(w, "data: [DONE]\n\n")
+		if err != nil {
+			return
+		}
+	}))
+}
@@ -0,0 +1,219 @@
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	tiktoken "github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"go.uber.org/zap"
+)
+
+// Tokenizer counts the tokens a model reads for a piece of text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// EstimatingTokenizer estimates token counts without a vocabulary, for
+// models whose encoding is unknown or could not be loaded. Text is split the
+// way byte pair encodings pre-split it, into words with their leading space,
+// digit groups, punctuation and whitespace. ASCII pieces are counted by their
+// length: common words are a single token, longer ones a token per six bytes,
+// which is close for English and code. Every byte of other text counts as a
+// token, since byte pair encodings never need more than one token per byte:
+// Devanagari or CJK text is overcounted rather than overflowing the context
+// window.
+type EstimatingTokenizer struct{}
+
+type runeClass int
+
+const (
+	runeClassNone runeClass = iota
+	runeClassLetter
+	runeClassDigit
+	runeClassSpace
+	runeClassNewline
+	runeClassOther
+)
+
+func classifyRune(r rune) runeClass {
+	switch {
+	case r == '\n' || r == '\r':
+		return runeClassNewline
+	case unicode.IsSpace(r):
+		return runeClassSpace
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return runeClassLetter
+	case unicode.IsDigit(r):
+		return runeClassDigit
+	}
+	return runeClassOther
+}
+
+func (EstimatingTokenizer) CountTokens(text string) int {
+	tokens := 0
+	class := runeClassNone
+	length := 0 // ASCII bytes in the current piece, or digits for digit groups
+	flush := func() {
+		switch {
+		case length == 0:
+		case class == runeClassDigit:
+			// Numbers are split into groups of up to three digits.
+			tokens += (length + 2) / 3
+		case class == runeClassLetter:
+			tokens += 1 + (length-1)/6
+		case class == runeClassOther:
+			tokens += (length + 1) / 2
+		default:
+			tokens += (length + 3) / 4
+		}
+		length = 0
+	}
+	for _, r := range text {
+		next := classifyRune(r)
+		if next != class {
+			if class == runeClassSpace && length == 1 && next != runeClassNewline {
+				// A single space is merged into the piece that follows it.
+				length = 0
+			}
+			flush()
+			class = next
+		}
+		if r >= utf8.RuneSelf {
+			tokens += utf8.RuneLen(r)
+		} else {
+			length++
+		}
+	}
+	flush()
+	return tokens
+}
+
+// BPETokenizer counts tokens with the byte pair encoding of OpenAI models,
+// exactly as the API does.
+type BPETokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+// NewBPETokenizer loads the byte pair encoding named encodingName, such as
+// "cl100k_base". Encodings are downloaded on first use and cached in the
+// directory named by TIKTOKEN_CACHE_DIR, or the system's temporary directory.
+func NewBPETokenizer(encodingName string) (*BPETokenizer, error) {
+	encoding, err := tiktoken.GetEncoding(encodingName)
+	if err != nil {
+		return nil, err
+	}
+	return &BPETokenizer{encoding: encoding}, nil
+}
+
+func (t *BPETokenizer) CountTokens(text string) int {
+	return len(t.encoding.EncodeOrdinary(text))
+}
+
+var (
+	modelTokenizers      = map[string]Tokenizer{} // By encoding name
+	modelTokenizersMutex sync.Mutex
+)
+
+// GetModelTokenizer returns the tokenizer of model: a BPETokenizer for
+// OpenAI models, and an EstimatingTokenizer for other models or when the
+// encoding cannot be loaded, for instance offline.
+func GetModelTokenizer(model string) Tokenizer {
+	encodingName, ok := getModelEncoding(model)
+	if !ok {
+		return EstimatingTokenizer{}
+	}
+	modelTokenizersMutex.Lock()
+	defer modelTokenizersMutex.Unlock()
+	if tokenizer, ok := modelTokenizers[encodingName]; ok {
+		return tokenizer
+	}
+	var tokenizer Tokenizer = EstimatingTokenizer{}
+	bpeTokenizer, err := NewBPETokenizer(encodingName)
+	if err != nil {
+		// Failures are remembered too, so that requests do not wait on the
+		// download again.
+		zap.S().Warnf("Estimating token counts, the %s encoding could not be loaded: %v", encodingName, err)
+	} else {
+		tokenizer = bpeTokenizer
+	}
+	modelTokenizers[encodingName] = tokenizer
+	return tokenizer
+}
+
+// getModelEncoding returns the name of the encoding of model, if it is an
+// OpenAI model. Dated snapshots such as "gpt-4-0613" share the encoding of
+// their model.
+func getModelEncoding(model string) (string, bool) {
+	if encodingName, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encodingName, true
+	}
+	for prefix, encodingName := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return encodingName, true
+		}
+	}
+	return "", false
+}
+
+// Tokens added by the chat format, as documented by OpenAI for the chat
+// models.
+const (
+	messageTokenOverhead = 3 // Every message is wrapped in <|start|>{role}\n{content}<|end|>
+	nameTokenOverhead    = 1 // Messages with a name
+	replyTokenOverhead   = 3 // Every reply is primed with <|start|>assistant<|message|>
+)
+
+// DefaultContextLimit is the context window assumed for models without a
+// known limit.
+const DefaultContextLimit = 4096
+
+var modelContextLimits = map[string]int{
+	openai.GPT4:             8192,
+	openai.GPT432K:          32768,
+	openai.GPT3Dot5Turbo:    4096,
+	openai.GPT3Dot5Turbo16K: 16384,
+}
+
+// GetModelContextLimit returns the number of tokens fitting in the context
+// window of model, prompt and completion together. Dated snapshots such as
+// "gpt-4-0613" share the limit of their model.
+func GetModelContextLimit(model string) int {
+	if limit, ok := modelContextLimits[model]; ok {
+		return limit
+	}
+	limit, longestPrefix := DefaultContextLimit, 0
+	for name, nameLimit := range modelContextLimits {
+		if len(name) > longestPrefix && strings.HasPrefix(model, name+"-") {
+			limit, longestPrefix = nameLimit, len(name)
+		}
+	}
+	return limit
+}
+
+func countMessageTokens(tokenizer Tokenizer, message ChatMessage) int {
+	tokens := messageTokenOverhead + tokenizer.CountTokens(message.Role) + tokenizer.CountTokens(message.Content)
+	if message.Name != "" {
+		tokens += nameTokenOverhead + tokenizer.CountTokens(message.Name)
+	}
+	if message.FunctionCall != nil {
+		tokens += tokenizer.CountTokens(message.FunctionCall.Name) + tokenizer.CountTokens(message.FunctionCall.Arguments)
+	}
+	return tokens
+}
+
+// countFunctionTokens estimates the tokens taken by the definitions of
+// functions, which the API adds to the prompt.
+func countFunctionTokens(tokenizer Tokenizer, functions []ChatFunction) int {
+	if len(functions) == 0 {
+		return 0
+	}
+	definitions, err := json.Marshal(toOpenAIFunctions(functions))
+	if err != nil {
+		return 0
+	}
+	return tokenizer.CountTokens(string(definitions))
+}
@@ -0,0 +1,94 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CSXL/solus/config"
+)
+
+// ChatCompletionRequest asks a provider for the assistant's next message.
+type ChatCompletionRequest struct {
+	Messages  []ChatMessage
+	Functions []ChatFunction // Functions the assistant may ask to call, nil for none
+	Options   ModelOptions   // Unset options are left to the provider
+}
+
+// Provider serves the models behind chat completions and embeddings, such
+// as the OpenAI API or a server running models on-premises.
+type Provider interface {
+	// CreateChatCompletion returns the assistant's response to request. It is
+	// aborted when ctx is cancelled.
+	CreateChatCompletion(ctx context.Context, request ChatCompletionRequest) (ChatMessage, error)
+	// CreateChatCompletionStream is CreateChatCompletion with the response's
+	// content passed to onDelta piece by piece as it arrives.
+	CreateChatCompletionStream(ctx context.Context, request ChatCompletionRequest, onDelta ChatCompletionDeltaHandler) (ChatMessage, error)
+	// CreateEmbeddings returns the embedding of each of texts, in order.
+	CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// ContextLimitedProvider is implemented by providers that know the context
+// window of the models they serve, when it differs from the model's usual
+// limit.
+type ContextLimitedProvider interface {
+	// GetContextLimit returns the number of tokens fitting in the context
+	// window of model, or 0 if the provider does not know it.
+	GetContextLimit(model string) int
+}
+
+// ProviderName names an implementation of Provider.
+type ProviderName string
+
+const (
+	ProviderOpenAI ProviderName = "openai" // The OpenAI API, the default
+	ProviderLocal  ProviderName = "local"  // A server with an OpenAI-compatible API, such as llama.cpp or Ollama
+)
+
+// ProviderConfig selects the provider of an AIConfig and where to reach it.
+type ProviderConfig struct {
+	Name              ProviderName // ProviderOpenAI when empty
+	BaseURL           string       // Root of the API, such as http://localhost:11434/v1; required for local providers
+	ContextLimit      int          // Context window of the model in tokens, 0 for the known or default limit
+	FunctionsDisabled bool         // Keep function definitions out of requests to local servers that reject them
+}
+
+// Validate reports whether the config names a known provider with what it
+// needs to reach it.
+func (p ProviderConfig) Validate() error {
+	switch p.Name {
+	case "", ProviderOpenAI:
+	case ProviderLocal:
+		if p.BaseURL == "" {
+			return fmt.Errorf("the %s provider needs a base_url", p.Name)
+		}
+	default:
+		return fmt.Errorf("unknown provider %q", p.Name)
+	}
+	if p.ContextLimit < 0 {
+		return fmt.Errorf("context_limit must not be negative, got %d", p.ContextLimit)
+	}
+	return nil
+}
+
+// ReadProviderConfig reads the provider under key of a YAML config, such as:
+//
+//	provider:
+//	  name: local
+//	  base_url: http://localhost:11434/v1
+//	  context_limit: 8192
+//	  functions_disabled: true
+//
+// A missing section selects the OpenAI API.
+func ReadProviderConfig(reader *config.Config, key string) (ProviderConfig, error) {
+	provider := ProviderConfig{
+		Name:              ProviderName(reader.GetString(key + ".name")),
+		BaseURL:           reader.GetString(key + ".base_url"),
+		ContextLimit:      reader.GetInt(key + ".context_limit"),
+		FunctionsDisabled: reader.GetBool(key + ".functions_disabled"),
+	}
+	err := provider.Validate()
+	if err != nil {
+		return ProviderConfig{}, fmt.Errorf("invalid %s config: %w", key, err)
+	}
+	return provider, nil
+}
@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CSXL/solus/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviderConfig_Validate(t *testing.T) {
+	assert.Nil(t, ProviderConfig{}.Validate())
+	assert.Nil(t, ProviderConfig{Name: ProviderOpenAI, BaseURL: "https://example.com/v1"}.Validate())
+	assert.Nil(t, ProviderConfig{Name: ProviderLocal, BaseURL: "http://localhost:11434/v1"}.Validate())
+	assert.NotNil(t, ProviderConfig{Name: ProviderLocal}.Validate())
+	assert.NotNil(t, ProviderConfig{Name: "anthropic"}.Validate())
+	assert.NotNil(t, ProviderConfig{ContextLimit: -1}.Validate())
+}
+
+func TestReadProviderConfig(t *testing.T) {
+	dir := t.TempDir()
+	yaml := "provider:\n  name: local\n  base_url: http://localhost:8080/v1\n  context_limit: 8192\n  functions_disabled: true\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test_config.yaml"), []byte(yaml), 0644))
+	reader := config.New()
+	assert.Nil(t, reader.Read("test_config", dir))
+
+	provider, err := ReadProviderConfig(reader, "provider")
+	assert.Nil(t, err)
+	assert.Equal(t, ProviderConfig{Name: ProviderLocal, BaseURL: "http://localhost:8080/v1", ContextLimit: 8192, FunctionsDisabled: true}, provider)
+
+	provider, err = ReadProviderConfig(reader, "missing")
+	assert.Nil(t, err)
+	assert.Equal(t, ProviderConfig{}, provider)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test_config.yaml"), []byte("provider:\n  name: local\n"), 0644))
+	assert.Nil(t, reader.Read("test_config", dir))
+	_, err = ReadProviderConfig(reader, "provider")
+	assert.NotNil(t, err)
+}
+
+func TestNewLocalAIConfig(t *testing.T) {
+	config := NewLocalAIConfig("http://localhost:11434/v1", "llama2")
+	assert.Equal(t, ProviderLocal, config.Provider.Name)
+	assert.Equal(t, "llama2", config.Model)
+	assert.Equal(t, "llama2", config.EmbeddingModel)
+	assert.Nil(t, config.Provider.Validate())
+}
@@ -0,0 +1,65 @@
+package providers
+
+import (
+	"context"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/local"
+	"github.com/CSXL/solus/ai/openai"
+)
+
+// New returns the provider selected by config.Provider. A provider config
+// that does not validate yields a provider failing every request with the
+// reason, so that constructors which cannot fail need not check it. Configs
+// read with ai.ReadProviderConfig are always valid.
+func New(config *ai.AIConfig) ai.Provider {
+	err := config.Provider.Validate()
+	if err != nil {
+		return &unavailableProvider{err: err}
+	}
+	switch config.Provider.Name {
+	case ai.ProviderLocal:
+		provider := local.NewProvider(config.Provider.BaseURL, config.Model)
+		if config.OpenAIAPIKey != "" {
+			provider.SetAPIKey(config.OpenAIAPIKey)
+		}
+		// Local servers do not serve OpenAI's default embedding model, so the
+		// chat model stands in for it.
+		if config.EmbeddingModel != "" && config.EmbeddingModel != ai.DefaultEmbeddingModel {
+			provider.SetEmbeddingModel(config.EmbeddingModel)
+		}
+		if config.Provider.ContextLimit > 0 {
+			provider.SetContextLimit(config.Provider.ContextLimit)
+		}
+		provider.SetFunctionsDisabled(config.Provider.FunctionsDisabled)
+		return provider
+	}
+	provider := openai.NewOpenAI(config.OpenAIAPIKey)
+	if config.Provider.BaseURL != "" {
+		provider = openai.NewOpenAIWithBaseURL(config.OpenAIAPIKey, config.Provider.BaseURL)
+	}
+	if config.EmbeddingModel != "" {
+		provider.SetEmbeddingModel(config.EmbeddingModel)
+	}
+	if config.Provider.ContextLimit > 0 && config.Model != "" {
+		provider.SetContextLimit(config.Model, config.Provider.ContextLimit)
+	}
+	return provider
+}
+
+// unavailableProvider stands in for a provider that could not be created.
+type unavailableProvider struct {
+	err error
+}
+
+func (p *unavailableProvider) CreateChatCompletion(ctx context.Context, request ai.ChatCompletionRequest) (ai.ChatMessage, error) {
+	return ai.ChatMessage{}, p.err
+}
+
+func (p *unavailableProvider) CreateChatCompletionStream(ctx context.Context, request ai.ChatCompletionRequest, onDelta ai.ChatCompletionDeltaHandler) (ai.ChatMessage, error) {
+	return ai.ChatMessage{}, p.err
+}
+
+func (p *unavailableProvider) CreateEmbeddings(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, p.err
+}
@@ -0,0 +1,48 @@
+package providers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/local"
+	"github.com/CSXL/solus/ai/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	provider := New(ai.NewAIConfig("test-openai-api-key"))
+	assert.IsType(t, &openai.OpenAI{}, provider)
+	assert.Equal(t, ai.DefaultEmbeddingModel, provider.(*openai.OpenAI).GetEmbeddingModel())
+
+	provider = New(ai.NewLocalAIConfig("http://localhost:11434/v1", "llama2"))
+	assert.IsType(t, &local.Provider{}, provider)
+	assert.Equal(t, "llama2", provider.(*local.Provider).GetModel())
+	assert.Equal(t, "http://localhost:11434/v1", provider.(*local.Provider).GetBaseURL())
+}
+
+func TestNew_ContextLimit(t *testing.T) {
+	config := ai.NewAIConfig("test-openai-api-key")
+	config.Model = "gpt-4-custom"
+	config.Provider.ContextLimit = 16384
+	provider := New(config)
+	assert.Equal(t, 16384, provider.(ai.ContextLimitedProvider).GetContextLimit("gpt-4-custom"))
+	assert.Equal(t, 0, New(ai.NewAIConfig("test-openai-api-key")).(ai.ContextLimitedProvider).GetContextLimit("gpt-4-custom"))
+
+	config = ai.NewLocalAIConfig("http://localhost:11434/v1", "llama2")
+	config.Provider.ContextLimit = 8192
+	assert.Equal(t, 8192, New(config).(ai.ContextLimitedProvider).GetContextLimit("llama2"))
+	assert.Equal(t, openai.DefaultContextLimit, openai.GetModelContextLimit("llama2"))
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	config := ai.NewAIConfig("test-openai-api-key")
+	config.Provider.Name = ai.ProviderLocal
+	provider := New(config)
+	_, err := provider.CreateChatCompletion(context.Background(), ai.ChatCompletionRequest{})
+	assert.NotNil(t, err)
+	_, err = provider.CreateChatCompletionStream(context.Background(), ai.ChatCompletionRequest{}, nil)
+	assert.NotNil(t, err)
+	_, err = provider.CreateEmbeddings(context.Background(), []string{"Hello"})
+	assert.NotNil(t, err)
+}
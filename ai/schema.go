@@ -0,0 +1,216 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// JSONSchemaType is the type keyword of a JSON schema.
+type JSONSchemaType string
+
+const (
+	JSONSchemaTypeObject  JSONSchemaType = "object"
+	JSONSchemaTypeArray   JSONSchemaType = "array"
+	JSONSchemaTypeString  JSONSchemaType = "string"
+	JSONSchemaTypeNumber  JSONSchemaType = "number"
+	JSONSchemaTypeInteger JSONSchemaType = "integer"
+	JSONSchemaTypeBoolean JSONSchemaType = "boolean"
+	JSONSchemaTypeNull    JSONSchemaType = "null"
+)
+
+// JSONSchema is the subset of JSON schema understood by language models,
+// used to describe the parameters of tools and the shape of structured
+// responses.
+type JSONSchema struct {
+	Type        JSONSchemaType         `json:"type,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Properties  map[string]*JSONSchema `json:"properties,omitempty"` // For objects
+	Required    []string               `json:"required,omitempty"`   // For objects
+	Items       *JSONSchema            `json:"items,omitempty"`      // For arrays
+}
+
+// NewObjectSchema returns the schema of an object with the given properties,
+// of which the listed ones are required.
+func NewObjectSchema(properties map[string]*JSONSchema, required ...string) *JSONSchema {
+	return &JSONSchema{
+		Type:       JSONSchemaTypeObject,
+		Properties: properties,
+		Required:   required,
+	}
+}
+
+// NewStringSchema returns the schema of a string with the given description.
+func NewStringSchema(description string) *JSONSchema {
+	return &JSONSchema{
+		Type:        JSONSchemaTypeString,
+		Description: description,
+	}
+}
+
+// NewIntegerSchema returns the schema of an integer with the given
+// description.
+func NewIntegerSchema(description string) *JSONSchema {
+	return &JSONSchema{
+		Type:        JSONSchemaTypeInteger,
+		Description: description,
+	}
+}
+
+// ValidateJSON reports the first way the JSON document data does not match
+// the schema.
+func (s *JSONSchema) ValidateJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+	return s.Validate(value)
+}
+
+// Validate reports the first way value does not match the schema. value is a
+// document as decoded into an interface{} by encoding/json or a YAML decoder:
+// maps, slices, strings, numbers, booleans and nil. The error names the path
+// of the offending value, such as $.requirements[2].
+func (s *JSONSchema) Validate(value interface{}) error {
+	return s.validate("$", value)
+}
+
+func (s *JSONSchema) validate(path string, value interface{}) error {
+	if s == nil {
+		return nil
+	}
+	if s.Type != "" && !matchesJSONSchemaType(s.Type, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, s.Type, describeJSONValue(value))
+	}
+	if len(s.Enum) > 0 {
+		str, ok := value.(string)
+		if !ok || !containsString(s.Enum, str) {
+			return fmt.Errorf("%s: must be one of %s, got %s", path, quoteAll(s.Enum), describeJSONValue(value))
+		}
+	}
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		names := make([]string, 0, len(s.Properties))
+		for name := range s.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if property, ok := value[name]; ok {
+				err := s.Properties[name].validate(path+"."+name, property)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func matchesJSONSchemaType(schemaType JSONSchemaType, value interface{}) bool {
+	switch schemaType {
+	case JSONSchemaTypeObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case JSONSchemaTypeArray:
+		_, ok := value.([]interface{})
+		return ok
+	case JSONSchemaTypeString:
+		_, ok := value.(string)
+		return ok
+	case JSONSchemaTypeNumber:
+		_, ok := toFloat64(value)
+		return ok
+	case JSONSchemaTypeInteger:
+		number, ok := toFloat64(value)
+		return ok && number == math.Trunc(number)
+	case JSONSchemaTypeBoolean:
+		_, ok := value.(bool)
+		return ok
+	case JSONSchemaTypeNull:
+		return value == nil
+	}
+	return true
+}
+
+func toFloat64(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+// describeJSONValue names the type of value for error messages, quoting
+// strings short enough to be useful.
+func describeJSONValue(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		if len(value) <= 40 {
+			return fmt.Sprintf("string %q", value)
+		}
+		return "string"
+	case bool:
+		return "boolean"
+	}
+	if _, ok := toFloat64(value); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,45 @@
+package ai
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONSchema_ValidateJSON(t *testing.T) {
+	schema := NewObjectSchema(map[string]*JSONSchema{
+		"type":    {Type: JSONSchemaTypeString, Enum: []string{"query", "message"}},
+		"content": NewStringSchema("The content"),
+		"count":   NewIntegerSchema("A count"),
+		"tags":    {Type: JSONSchemaTypeArray, Items: NewStringSchema("A tag")},
+	}, "type", "content")
+	assert.Nil(t, schema.ValidateJSON([]byte(`{"type":"query","content":"CSX Labs","count":3,"tags":["a"],"extra":null}`)))
+
+	tests := []struct {
+		document string
+		err      string
+	}{
+		{document: `Sure! {"type":"query"}`, err: "invalid JSON"},
+		{document: `{"type":"query","content":"a"} trailing`, err: "invalid JSON"},
+		{document: `["query"]`, err: "$: expected object, got array"},
+		{document: `{"type":"query"}`, err: `$: missing required property "content"`},
+		{document: `{"type":"search","content":"a"}`, err: `$.type: must be one of "query", "message", got string "search"`},
+		{document: `{"type":"query","content":1}`, err: "$.content: expected string, got number"},
+		{document: `{"type":"query","content":"a","count":1.5}`, err: "$.count: expected integer, got number"},
+		{document: `{"type":"query","content":"a","tags":["a",false]}`, err: "$.tags[1]: expected string, got boolean"},
+	}
+	for _, test := range tests {
+		err := schema.ValidateJSON([]byte(test.document))
+		if assert.NotNil(t, err, test.document) {
+			assert.Contains(t, err.Error(), test.err)
+		}
+	}
+}
+
+func TestJSONSchema_ValidateYAMLValues(t *testing.T) {
+	schema := &JSONSchema{Type: JSONSchemaTypeArray, Items: NewIntegerSchema("A number")}
+	assert.Nil(t, schema.Validate([]interface{}{1, int64(2), 3.0}))
+	assert.NotNil(t, schema.Validate([]interface{}{1, 2.5}))
+	var nilSchema *JSONSchema
+	assert.Nil(t, nilSchema.Validate("anything"))
+}
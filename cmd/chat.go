@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/CSXL/solus/ai/chat"
+	"github.com/spf13/cobra"
+)
+
+var ExportConversationFile string
+var ExportFormat string
+var ExportOutputFile string
+var ExportBranch string
+var ExportTitle string
+var ExportSystemPrompts bool
+
+func init() {
+	chatExportCmd.Flags().StringVarP(&ExportConversationFile, "conversation-file", "f", "", "The path to the saved conversation.")
+	_ = chatExportCmd.MarkFlagRequired("conversation-file")
+	chatExportCmd.Flags().StringVarP(&ExportFormat, "format", "F", string(chat.ExportFormatMarkdown), fmt.Sprintf("The format to export to, one of %v.", chat.ExportFormats))
+	chatExportCmd.Flags().StringVarP(&ExportOutputFile, "output-file", "o", "", "Write the export to a file instead of standard output.")
+	chatExportCmd.Flags().StringVarP(&ExportBranch, "branch", "b", "", "The branch to export, the current one by default.")
+	chatExportCmd.Flags().StringVarP(&ExportTitle, "title", "t", "", "The title of the transcript, the conversation's name by default.")
+	chatExportCmd.Flags().BoolVar(&ExportSystemPrompts, "system-prompts", false, "Include system prompts in the export.")
+	chatCmd.AddCommand(chatExportCmd)
+	rootCmd.AddCommand(chatCmd)
+}
+
+var chatCmd = &cobra.Command{
+	Use:   "chat",
+	Short: "Work with saved conversations",
+	Long:  `Work with conversations saved by the chat interface`,
+}
+
+var chatExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a saved conversation to Markdown, HTML or JSONL",
+	Long:  `Export a saved conversation to a readable Markdown transcript, a standalone HTML page, or JSONL chat completion examples for fine-tuning and evals`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, err := chat.ParseExportFormat(ExportFormat)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		document, err := chat.ReadConversationDocument(ExportConversationFile)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		options := chat.ExportOptions{
+			Title:                ExportTitle,
+			Branch:               ExportBranch,
+			IncludeSystemPrompts: ExportSystemPrompts,
+		}
+		export := func(w io.Writer) error {
+			return chat.ExportDocument(w, document, format, options)
+		}
+		if ExportOutputFile == "" {
+			err = export(os.Stdout)
+		} else {
+			err = writeFileAtomically(ExportOutputFile, export)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if ExportOutputFile != "" {
+			fmt.Println("Exported conversation to " + ExportOutputFile)
+		}
+	},
+}
+
+// writeFileAtomically writes filename with write. The output goes to a
+// temporary file next to it first and is renamed over it only if write
+// succeeds, so that a failed export leaves no partial file behind.
+func writeFileAtomically(filename string, write func(io.Writer) error) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// Nothing is left to remove once the file was renamed.
+	defer os.Remove(file.Name()) // trunk-ignore(golangci-lint/errcheck)
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), filename)
+}
@@ -27,6 +27,16 @@ var codeCmd = &cobra.Command{
 			return
 		}
 		codeGenerator := code.NewCodeGenerator(GenerationFolder, codeConfig)
+		defer codeGenerator.Close()
+		resumed, err := codeGenerator.Resume()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if resumed > 0 {
+			fmt.Println("Resumed interrupted code generation successfully!")
+			return
+		}
 		err = codeGenerator.Generate()
 		if err != nil {
 			fmt.Println(err)
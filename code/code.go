@@ -1,24 +1,38 @@
 package code
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
+	"github.com/CSXL/solus/ai/agent/tools"
 	"github.com/CSXL/solus/ai/chat"
+	"github.com/CSXL/solus/ai/openai"
 	"github.com/CSXL/solus/code/syncfiles"
 	"github.com/CSXL/solus/config"
+	"github.com/CSXL/solus/context/db"
 	"github.com/joho/godotenv"
 )
 
 type CodeConfig struct {
-	CodePrompt       string // The prompt to use when generating code
-	OpenAIAPIKey     string // The OpenAI API key to use when generating code
-	GenerationFolder string // The folder to generate code in
+	CodePrompt       string                // The prompt to use when generating code
+	OpenAIAPIKey     string                // The OpenAI API key to use when generating code
+	GenerationFolder string                // The folder to generate code in
+	ModelOptions     ai.ModelOptions       // The model and sampling parameters, unset ones use the defaults
+	Provider         ai.ProviderConfig     // The provider serving the model, by default OpenAI
+	ContextStore     db.ContextStoreConfig // The context store the model can search, if any
 }
 
 func (c *CodeConfig) ToAIConfig() *ai.AIConfig {
-	return ai.NewAIConfig(c.OpenAIAPIKey)
+	aiConfig := ai.NewAIConfig(c.OpenAIAPIKey)
+	aiConfig.ModelOptions = aiConfig.ModelOptions.Merge(c.ModelOptions)
+	aiConfig.Provider = c.Provider
+	return aiConfig
 }
 
 func NewCodeConfig(generationFolder string, openAIAPIKey string) *CodeConfig {
@@ -41,13 +55,30 @@ func LoadCodeConfig(generationFolder string) (*CodeConfig, error) {
 	openAIAPIKey := os.Getenv("OPENAI_API_KEY")
 	code_config := NewCodeConfig(generationFolder, openAIAPIKey)
 	code_config.CodePrompt = config_reader.GetString("code_prompt")
+	code_config.ModelOptions, err = ai.ReadModelOptions(config_reader, "model")
+	if err != nil {
+		return nil, err
+	}
+	code_config.Provider, err = ai.ReadProviderConfig(config_reader, "provider")
+	if err != nil {
+		return nil, err
+	}
+	code_config.ContextStore, err = db.ReadContextStoreConfig(config_reader, "context_store")
+	if err != nil {
+		return nil, err
+	}
 	return code_config, nil
 }
 
+// CodeGeneratorTaskTypeApplyUpdate is the type of the journaled tasks that
+// write a model response to the generation folder.
+var CodeGeneratorTaskTypeApplyUpdate = agent.NewAgentTaskType("apply_update", true)
+
 type CodeGenerator struct {
 	Conversation *chat.Conversation
 	codeConfig   *CodeConfig
 	ProjectState string
+	journal      *agent.AgentJournal
 }
 
 // Creates a new CodeGenerator
@@ -57,6 +88,8 @@ func NewCodeGenerator(generationFolder string, config *CodeConfig) *CodeGenerato
 	conversationName := "code"
 	aiConfig := config.ToAIConfig()
 	conversation := chat.NewConversation(conversationName, aiConfig)
+	// Responses that would not update the project are sent back for repair.
+	conversation.GetAgent().SetResponseValidator(syncfiles.Validate)
 	config.GenerationFolder = generationFolder
 	return &CodeGenerator{
 		Conversation: conversation,
@@ -64,15 +97,94 @@ func NewCodeGenerator(generationFolder string, config *CodeConfig) *CodeGenerato
 	}
 }
 
-func (c *CodeGenerator) buildPrompt() string {
+// buildPrompt returns the system prompt holding the project state. When it
+// does not fit in the model's context window, the longest files are truncated
+// so that every file is still listed, unless the conversation's context
+// budget forbids truncating oversized inputs.
+func (c *CodeGenerator) buildPrompt() (string, error) {
+	prompt := c.formatPrompt(c.ProjectState)
+	chatClient := c.Conversation.GetAgent().OpenAIChatClient
+	model := chatClient.GetModel()
+	budget := chatClient.GetRequestBudget(model)
+	if budget == nil {
+		return prompt, nil
+	}
+	limit := budget.GetPromptLimit(model)
+	required := countPromptTokens(budget, prompt)
+	if required <= limit {
+		return prompt, nil
+	}
+	if !budget.TruncateOversized {
+		return "", &openai.ContextLengthError{Model: model, Limit: limit, Required: required}
+	}
+	files := syncfiles.Parse(c.ProjectState)
+	// Every file keeps its markers; the rest of the budget is shared out so
+	// that the longest files are cut first.
+	emptyFiles := make([]syncfiles.File, len(files))
+	for i, file := range files {
+		emptyFiles[i] = syncfiles.File{Path: file.Path}
+	}
+	contentLimit := limit - countPromptTokens(budget, c.formatPrompt(joinFiles(emptyFiles)))
+	contentTokens := make([]int, len(files))
+	for i, file := range files {
+		contentTokens[i] = budget.CountTokens(file.Content)
+	}
+	for maxFileTokens := fairShare(contentTokens, contentLimit); maxFileTokens >= 0; maxFileTokens -= 1 + maxFileTokens/10 {
+		truncatedFiles := make([]syncfiles.File, len(files))
+		for i, file := range files {
+			truncatedFiles[i] = syncfiles.File{Path: file.Path, Content: budget.TruncateText(file.Content, maxFileTokens)}
+		}
+		prompt = c.formatPrompt(joinFiles(truncatedFiles))
+		required = countPromptTokens(budget, prompt)
+		if required <= limit {
+			return prompt, nil
+		}
+	}
+	return "", &openai.ContextLengthError{Model: model, Limit: limit, Required: required}
+}
+
+func (c *CodeGenerator) formatPrompt(projectState string) string {
 	inputPrompt := c.codeConfig.CodePrompt
 	beginCurrentState := "\n====CURRENT STATE====\n"
-	currentState := c.ProjectState
 	endCurrentState := "\n====END CURRENT STATE====\n"
-	prompt := fmt.Sprintf("%s%s%s%s", inputPrompt, beginCurrentState, currentState, endCurrentState)
+	prompt := fmt.Sprintf("%s%s%s%s", inputPrompt, beginCurrentState, projectState, endCurrentState)
 	return prompt
 }
 
+func countPromptTokens(budget *openai.ContextBudget, prompt string) int {
+	return budget.CountMessageTokens([]openai.ChatMessage{{Role: string(agent.ChatAgentMessageRoleSystem), Content: prompt}})
+}
+
+func joinFiles(files []syncfiles.File) string {
+	var state strings.Builder
+	for _, file := range files {
+		state.WriteString(file.String())
+	}
+	return state.String()
+}
+
+// fairShare returns the largest number of tokens each file can keep so that
+// files of the given lengths take at most limit tokens together, or -1 if
+// not even empty files fit.
+func fairShare(lengths []int, limit int) int {
+	if limit < 0 {
+		return -1
+	}
+	sorted := append([]int{}, lengths...)
+	sort.Ints(sorted)
+	for i, length := range sorted {
+		remaining := len(sorted) - i
+		if length*remaining > limit {
+			return limit / remaining
+		}
+		limit -= length
+	}
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[len(sorted)-1]
+}
+
 func (c *CodeGenerator) loadProjectState() error {
 	projectState, err := syncfiles.Load(c.codeConfig.GenerationFolder)
 	if err != nil {
@@ -82,6 +194,124 @@ func (c *CodeGenerator) loadProjectState() error {
 	return nil
 }
 
+// openJournal opens the task journal kept in the generation folder and
+// attaches it to the conversation's agent.
+func (c *CodeGenerator) openJournal() error {
+	if c.journal != nil {
+		return nil
+	}
+	journalPath := filepath.Join(c.codeConfig.GenerationFolder, agent.DefaultJournalFileName)
+	journal, err := agent.OpenAgentJournal(journalPath)
+	if err != nil {
+		return err
+	}
+	c.journal = journal
+	chatAgent := c.Conversation.GetAgent()
+	chatAgent.SetJournal(journal)
+	chatAgent.RegisterTaskFactory(CodeGeneratorTaskTypeApplyUpdate, func(entry agent.AgentJournalEntry) (agent.IAgentTask, error) {
+		var update string
+		err := entry.DecodePayload(&update)
+		if err != nil {
+			return nil, err
+		}
+		return c.newApplyUpdateTask(update), nil
+	})
+	return nil
+}
+
+// registerTools lets the model read the files of the generation folder and,
+// if a context store is configured, search it while generating.
+func (c *CodeGenerator) registerTools() error {
+	chatAgent := c.Conversation.GetAgent()
+	if chatAgent.GetToolRegistry() != nil {
+		return nil
+	}
+	registry := agent.NewToolRegistry()
+	err := registry.Register(tools.NewReadProjectFileTool(c.codeConfig.GenerationFolder, tools.DefaultMaxResultLength))
+	if err != nil {
+		return err
+	}
+	if store := c.codeConfig.ContextStore; store.URL != "" {
+		ctx := context.Background()
+		chromaClient, err := db.NewChromaClient(&ctx, store.URL, *c.codeConfig.ToAIConfig())
+		if err != nil {
+			return err
+		}
+		err = registry.Register(tools.NewContextSearchTool(chromaClient, store.Collection, tools.DefaultContextSearchLimit))
+		if err != nil {
+			return err
+		}
+	}
+	chatAgent.SetToolRegistry(registry)
+	return nil
+}
+
+func (c *CodeGenerator) newApplyUpdateTask(update string) *agent.AgentTask[string] {
+	task := agent.NewAgentTask(CodeGeneratorTaskTypeApplyUpdate.Type, CodeGeneratorTaskTypeApplyUpdate, func(ctx context.Context) (string, error) {
+		return update, c.updateProjectState(update)
+	})
+	task.SetPayload(update)
+	return task
+}
+
+// applyUpdate writes update to the generation folder through a journaled
+// task, so that an interrupted write is finished by the next Resume.
+func (c *CodeGenerator) applyUpdate(update string) error {
+	c.Conversation.PreemptiveStart()
+	chatAgent := c.Conversation.GetAgent()
+	task := c.newApplyUpdateTask(update)
+	err := chatAgent.AddTask(task)
+	if err != nil {
+		return err
+	}
+	_, err = task.Await(context.Background())
+	return err
+}
+
+// Resume finishes the work a previous run left interrupted, according to the
+// journal in the generation folder. Interrupted updates are applied again;
+// other interrupted tasks, such as prompts that never got a response, are
+// reported and dropped. It returns the number of tasks that were resumed.
+func (c *CodeGenerator) Resume() (int, error) {
+	err := c.openJournal()
+	if err != nil {
+		return 0, err
+	}
+	c.Conversation.PreemptiveStart()
+	chatAgent := c.Conversation.GetAgent()
+	resumed, unresumed, err := chatAgent.ResumeInterruptedTasks()
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range unresumed {
+		fmt.Printf("Dropping interrupted task %s queued at %s\n", entry.TaskName, entry.Time.Format("2006-01-02 15:04:05"))
+		err = c.journal.MarkInterrupted(entry)
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, task := range resumed {
+		fmt.Printf("Resuming interrupted task %s\n", task.GetName())
+		<-task.Done()
+		if taskErr := task.GetError(); taskErr != nil {
+			return len(resumed), taskErr
+		}
+	}
+	return len(resumed), nil
+}
+
+// Close shuts down the conversation and closes the journal.
+func (c *CodeGenerator) Close() error {
+	err := c.Conversation.Close()
+	if c.journal != nil {
+		journalErr := c.journal.Close()
+		if err == nil {
+			err = journalErr
+		}
+	}
+	return err
+}
+
 func (c *CodeGenerator) updateProjectState(update string) error {
 	err := syncfiles.Update(c.codeConfig.GenerationFolder, update)
 	if err != nil {
@@ -91,7 +321,10 @@ func (c *CodeGenerator) updateProjectState(update string) error {
 }
 
 func (c *CodeGenerator) promptModel() (string, error) {
-	prompt := c.buildPrompt()
+	prompt, err := c.buildPrompt()
+	if err != nil {
+		return "", err
+	}
 	c.Conversation.ResetMessages()
 	responseMessage, err := c.Conversation.SendSystemMessage(prompt)
 	if err != nil {
@@ -103,8 +336,17 @@ func (c *CodeGenerator) promptModel() (string, error) {
 }
 
 // Generates the code for the project based on the given directory state.
+// Progress is journaled in the generation folder, see Resume.
 func (c *CodeGenerator) Generate() error {
-	err := c.loadProjectState()
+	err := c.openJournal()
+	if err != nil {
+		return err
+	}
+	err = c.registerTools()
+	if err != nil {
+		return err
+	}
+	err = c.loadProjectState()
 	if err != nil {
 		return err
 	}
@@ -113,5 +355,5 @@ func (c *CodeGenerator) Generate() error {
 		return err
 	}
 	fmt.Printf("Response: %s\n", responseContent)
-	return c.updateProjectState(responseContent)
+	return c.applyUpdate(responseContent)
 }
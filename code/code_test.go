@@ -1,10 +1,15 @@
 package code
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/CSXL/solus/ai/agent"
 	"github.com/CSXL/solus/ai/openai"
+	"github.com/CSXL/solus/code/syncfiles"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -31,3 +36,99 @@ func TestCodeGenerator_Generate(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, testGenerator.ProjectState)
 }
+
+func TestCodeGenerator_GenerateRepairsResponseWithoutFiles(t *testing.T) {
+	testGenerationFolder := t.TempDir()
+	testConfig := NewCodeConfig(testGenerationFolder, "test key")
+	testGenerator := NewCodeGenerator(testGenerationFolder, testConfig)
+	defer testGenerator.Close()
+	ts := openai.StartSequenceHTTPTestServer(nil, openai.SampleChatCompletion, openai.SampleChatFileCompletion)
+	defer ts.Close()
+	testGenerator.Conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	assert.Nil(t, testGenerator.Generate())
+	content, err := os.ReadFile(filepath.Join(testGenerationFolder, "test", "test2.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello Again", string(content))
+}
+
+func TestCodeGenerator_GenerateReadsProjectFiles(t *testing.T) {
+	testGenerationFolder := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(testGenerationFolder, "requirements.yaml"), []byte("mission: To advance technology"), 0644))
+	testConfig := NewCodeConfig(testGenerationFolder, "test key")
+	testGenerator := NewCodeGenerator(testGenerationFolder, testConfig)
+	defer testGenerator.Close()
+	readFileCompletion := openai.OpenAIResponse(`{"id":"chatcmpl-123","object":"chat.completion","created":1687182516,"model":"gpt-4-0613","usage":{"prompt_tokens":82,"completion_tokens":18,"total_tokens":100},"choices":[{"message":{"role":"assistant","content":null,"function_call":{"name":"read_project_file","arguments":"{\"path\": \"requirements.yaml\"}"}},"finish_reason":"function_call","index":0}]}`)
+	requests := make(chan []byte, 2)
+	ts := openai.StartSequenceHTTPTestServer(requests, readFileCompletion, openai.SampleChatFileCompletion)
+	defer ts.Close()
+	testGenerator.Conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	assert.Nil(t, testGenerator.Generate())
+	assert.Contains(t, string(<-requests), `"name":"read_project_file"`)
+	assert.Contains(t, string(<-requests), "mission: To advance technology")
+	content, err := os.ReadFile(filepath.Join(testGenerationFolder, "test", "test2.txt"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Hello Again", string(content))
+}
+
+func TestCodeGenerator_Resume(t *testing.T) {
+	testGenerationFolder, err := os.MkdirTemp("", "test_generation_folder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(testGenerationFolder)
+	journal, err := agent.OpenAgentJournal(filepath.Join(testGenerationFolder, agent.DefaultJournalFileName))
+	assert.Nil(t, err)
+	update := "//// FILE~main.go ////\npackage main\n//// END FILE ////"
+	payload, err := json.Marshal(update)
+	assert.Nil(t, err)
+	interruptedUpdate := agent.AgentJournalEntry{
+		TaskID:   "interrupted update",
+		TaskName: CodeGeneratorTaskTypeApplyUpdate.Type,
+		TaskType: CodeGeneratorTaskTypeApplyUpdate,
+		State:    agent.AgentTaskStateQueued,
+		Payload:  payload,
+	}
+	assert.Nil(t, journal.Append(interruptedUpdate))
+	assert.Nil(t, journal.Close())
+
+	testConfig := NewCodeConfig(testGenerationFolder, "test key")
+	testGenerator := NewCodeGenerator(testGenerationFolder, testConfig)
+	defer testGenerator.Close()
+	resumed, err := testGenerator.Resume()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, resumed)
+	content, err := os.ReadFile(filepath.Join(testGenerationFolder, "main.go"))
+	assert.Nil(t, err)
+	assert.Equal(t, "package main", string(content))
+	assert.NotContains(t, testGenerator.ProjectState, agent.DefaultJournalFileName)
+	resumed, err = testGenerator.Resume()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, resumed)
+}
+
+func TestCodeGenerator_BuildPromptTruncatesLongFiles(t *testing.T) {
+	testConfig := NewCodeConfig("test generation folder", "test key")
+	testConfig.CodePrompt = "Write the code."
+	testGenerator := NewCodeGenerator("test generation folder", testConfig)
+	budget := openai.NewContextBudget()
+	budget.MaxContextTokens = 400
+	budget.CompletionTokens = 100
+	testGenerator.Conversation.GetAgent().SetContextBudget(budget)
+	testGenerator.ProjectState = syncfiles.File{Path: "small.txt", Content: "Hello"}.String() +
+		syncfiles.File{Path: "large.txt", Content: strings.Repeat("lorem ipsum ", 1000)}.String()
+
+	prompt, err := testGenerator.buildPrompt()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, budget.CountMessageTokens([]openai.ChatMessage{{Role: "system", Content: prompt}}), 300)
+	files := syncfiles.Parse(prompt)
+	assert.Len(t, files, 2)
+	assert.Equal(t, "Hello", files[0].Content)
+	assert.True(t, strings.HasSuffix(files[1].Content, "[truncated]"))
+
+	budget.TruncateOversized = false
+	_, err = testGenerator.buildPrompt()
+	assert.ErrorIs(t, err, openai.ErrContextLengthExceeded)
+
+	budget.TruncateOversized = true
+	testConfig.CodePrompt = strings.Repeat("rule ", 400)
+	_, err = testGenerator.buildPrompt()
+	assert.ErrorIs(t, err, openai.ErrContextLengthExceeded)
+}
@@ -1,6 +1,7 @@
 package syncfiles
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,22 +10,81 @@ import (
 )
 
 var (
-	filePattern = regexp.MustCompile(`(?s)//// FILE~(?P<filepath>.*?) ////\n(?P<content>.*?)\n//// END FILE ////`)
+	filePattern = regexp.MustCompile(`(?s)//// FILE~(?P<filepath>.*?) ?////\n(?P<content>.*?)\n//// END FILE ////`)
 	ignoreList  = []string{
 		"messages.json",
 		".git",
+		".solus_journal.jsonl",
 	}
 )
 
+// File is a file of a project state, as written between FILE markers.
+type File struct {
+	Path    string // Relative to the project folder
+	Content string
+}
+
+// String formats the file the way Load writes it and Update reads it.
+func (f File) String() string {
+	return fmt.Sprintf("//// FILE~%s ////\n%s\n//// END FILE ////", f.Path, f.Content)
+}
+
+// Parse returns the files of a project state or update, in order.
+func Parse(state string) []File {
+	files := []File{}
+	for _, match := range filePattern.FindAllStringSubmatch(state, -1) {
+		files = append(files, File{Path: match[1], Content: match[2]})
+	}
+	return files
+}
+
+// Validate reports the first problem of an update: no file blocks, a block
+// without its END FILE marker, or a path that is not inside the project
+// folder. The message says how to fix it, so that it can be sent back to the
+// model that wrote the update.
+func Validate(update string) error {
+	files := Parse(update)
+	if len(files) == 0 {
+		return errors.New("no files found; write every file between a \"//// FILE~<path> ////\" line and a \"//// END FILE ////\" line")
+	}
+	headers, ends := strings.Count(update, "//// FILE~"), strings.Count(update, "//// END FILE ////")
+	if headers != len(files) || ends != len(files) {
+		return fmt.Errorf("found %d FILE markers and %d END FILE markers but only %d complete files; every file must end with a \"//// END FILE ////\" line", headers, ends, len(files))
+	}
+	for _, file := range files {
+		err := validatePath(file.Path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validatePath(path string) error {
+	if strings.TrimSpace(path) == "" {
+		return errors.New("a file has an empty path")
+	}
+	if filepath.IsAbs(path) || strings.HasPrefix(path, "/") {
+		return fmt.Errorf("file path %q must be relative to the project folder", path)
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(path))
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("file path %q is outside the project folder", path)
+	}
+	return nil
+}
+
 func Update(parentFolder, update string) error {
 	if !filepath.IsAbs(parentFolder) {
 		return fmt.Errorf("parent folder path: %q is not absolute", parentFolder)
 	}
 
-	matches := filePattern.FindAllStringSubmatch(update, -1)
-	for _, match := range matches {
-		filePath := filepath.Join(parentFolder, match[1])
-		content := match[2]
+	for _, file := range Parse(update) {
+		if err := validatePath(file.Path); err != nil {
+			return err
+		}
+		filePath := filepath.Join(parentFolder, file.Path)
+		content := file.Content
 
 		if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
 			return fmt.Errorf("failed to create directories for file: %q: %v", filePath, err)
@@ -70,7 +130,7 @@ func Load(parentFolder string) (string, error) {
 			return fmt.Errorf("failed to get relative path for file: %q: %v", path, err)
 		}
 
-		result.WriteString(fmt.Sprintf("//// FILE~%s ////\n%s\n//// END FILE ////", relativePath, content))
+		result.WriteString(File{Path: relativePath, Content: string(content)}.String())
 		return nil
 	})
 
@@ -62,3 +62,33 @@ func TestLoad(t *testing.T) {
 		t.Fatalf("Unexpected loaded content. Got: %q, Expected: %q", loaded, expectedLoaded)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	valid := "//// FILE~main.go ////\npackage main\n//// END FILE ////\n//// FILE~cmd/root.go////\npackage cmd\n//// END FILE ////\n"
+	if err := Validate(valid); err != nil {
+		t.Errorf("Validate(%q) returned error: %v", valid, err)
+	}
+	invalid := []string{
+		"Here is your project!",
+		"//// FILE~main.go ////\npackage main\n",
+		"//// FILE~main.go ////\npackage main\n//// END FILE ////\n//// FILE~util.go ////\npackage main\n",
+		"//// FILE~/etc/passwd ////\nroot\n//// END FILE ////",
+		"//// FILE~../outside.go ////\npackage main\n//// END FILE ////",
+	}
+	for _, update := range invalid {
+		if err := Validate(update); err == nil {
+			t.Errorf("Validate(%q) returned no error", update)
+		}
+	}
+}
+
+func TestUpdateRejectsPathsOutsideFolder(t *testing.T) {
+	parentFolder := t.TempDir()
+	update := "//// FILE~../outside.txt ////\nHello\n//// END FILE ////"
+	if err := Update(filepath.Join(parentFolder, "project"), update); err == nil {
+		t.Errorf("Update(%q) returned no error", update)
+	}
+	if _, err := os.Stat(filepath.Join(parentFolder, "outside.txt")); !os.IsNotExist(err) {
+		t.Errorf("Update wrote a file outside the project folder")
+	}
+}
@@ -3,34 +3,67 @@ package db
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
 
 	chromadb "github.com/CSXL/go-chroma"
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/openai"
+	"github.com/CSXL/solus/ai/providers"
+	"github.com/CSXL/solus/config"
 )
 
 type Metadatas map[string]interface{}
 
 // ChromaClient is a client for interacting with the Chroma database
 type ChromaClient struct {
-	context      *context.Context
-	db           *chromadb.ChromaClient
-	aiConfig     *ai.AIConfig
-	openAIClient *openai.OpenAI
+	context  *context.Context
+	db       *chromadb.ChromaClient
+	aiConfig *ai.AIConfig
+	provider ai.Provider // Computes the embeddings of documents and queries
 }
 
 // NewChromaClient creates a new ChromaClient
 func NewChromaClient(ctx *context.Context, basePath string, aiConfig ai.AIConfig) (*ChromaClient, error) {
+	err := aiConfig.Provider.Validate()
+	if err != nil {
+		return nil, err
+	}
 	chromadbClient := chromadb.NewChromaClient(basePath)
-	openAIClient := openai.NewOpenAI(aiConfig.OpenAIAPIKey)
 	return &ChromaClient{
-		context:      ctx,
-		db:           chromadbClient,
-		aiConfig:     &aiConfig,
-		openAIClient: openAIClient,
+		context:  ctx,
+		db:       chromadbClient,
+		aiConfig: &aiConfig,
+		provider: providers.New(&aiConfig),
 	}, nil
 }
 
+// ContextStoreConfig locates the collection of a Chroma server holding the
+// context of a project, such as earlier research or requirements.
+type ContextStoreConfig struct {
+	URL        string // Base URL of the Chroma server, empty if there is none
+	Collection string // Collection holding the project's documents
+}
+
+// ReadContextStoreConfig reads the context store section named key of a
+// config file, such as
+//
+//	context_store:
+//	  url: http://localhost:8000
+//	  collection: solus
+//
+// A missing section leaves the URL empty.
+func ReadContextStoreConfig(reader *config.Config, key string) (ContextStoreConfig, error) {
+	store := ContextStoreConfig{
+		URL:        reader.GetString(key + ".url"),
+		Collection: reader.GetString(key + ".collection"),
+	}
+	if store.URL != "" && store.Collection == "" {
+		return ContextStoreConfig{}, fmt.Errorf("invalid %s config: a collection is required", key)
+	}
+	return store, nil
+}
+
 // GetChromaClient returns the internal chromadb client
 func (c *ChromaClient) GetChromaDB() *chromadb.ChromaClient {
 	return c.db
@@ -81,7 +114,11 @@ func (c *ChromaClient) Reset() error {
 
 // GhangeOpenAIBaseURL changes the base URL for the OpenAI client
 func (c *ChromaClient) ChangeOpenAIBaseURL(baseURL string) {
-	c.openAIClient = openai.NewOpenAIWithBaseURL(c.aiConfig.OpenAIAPIKey, baseURL)
+	openAIClient := openai.NewOpenAIWithBaseURL(c.aiConfig.OpenAIAPIKey, baseURL)
+	if c.aiConfig.EmbeddingModel != "" {
+		openAIClient.SetEmbeddingModel(c.aiConfig.EmbeddingModel)
+	}
+	c.provider = openAIClient
 }
 
 // GetContext returns the context
@@ -91,7 +128,13 @@ func (c *ChromaClient) GetContext() *context.Context {
 
 // GetEmbeddings returns the embeddings for a given text
 func (c *ChromaClient) GetEmbeddings(text string) ([]float32, error) {
-	embeddings, err := c.openAIClient.GetEmbeddings([]string{text})
+	return c.GetEmbeddingsWithContext(context.Background(), text)
+}
+
+// GetEmbeddingsWithContext returns the embeddings for a given text, giving up
+// when ctx is done.
+func (c *ChromaClient) GetEmbeddingsWithContext(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := c.provider.CreateEmbeddings(ctx, []string{text})
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +233,13 @@ type searchResponse struct {
 
 // Search searches the database based on a query
 func (c *ChromaClient) Search(collection string, query string, limit int) ([]*Document, error) {
-	queryEmbeddings, err := c.GetEmbeddings(query)
+	return c.SearchWithContext(context.Background(), collection, query, limit)
+}
+
+// SearchWithContext searches the database based on a query, giving up when
+// ctx is done.
+func (c *ChromaClient) SearchWithContext(ctx context.Context, collection string, query string, limit int) ([]*Document, error) {
+	queryEmbeddings, err := c.GetEmbeddingsWithContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -203,8 +252,11 @@ func (c *ChromaClient) Search(collection string, query string, limit int) ([]*Do
 		NResults:        limit,
 		Include:         []string{"metadatas", "documents", "distances", "embeddings"},
 	}
-	response, err := c.db.GetNearestNeighbors(collection, &QueryEmbeddingRequest)
+	response, err := c.dbWithContext(ctx).GetNearestNeighbors(collection, &QueryEmbeddingRequest)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, err
 	}
 	defer response.Body.Close()
@@ -252,3 +304,28 @@ func NewDocumentWithEmbedding(id string, metadata Metadatas, content string, emb
 		Embedding: embeddingInterface,
 	}
 }
+
+// dbWithContext returns a copy of the Chroma client whose requests are
+// cancelled when ctx is done, since the generated client sends them without
+// one.
+func (c *ChromaClient) dbWithContext(ctx context.Context) *chromadb.ChromaClient {
+	client := *c.db.Client
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &contextTransport{ctx: ctx, base: base}
+	db := *c.db
+	db.Client = &client
+	return &db
+}
+
+// contextTransport sends every request with its context replaced by ctx.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
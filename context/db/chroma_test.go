@@ -4,9 +4,14 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	chromadb "github.com/CSXL/go-chroma"
 	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,6 +23,27 @@ func TestNewChromaClient(t *testing.T) {
 	assert.NotNil(t, client)
 }
 
+func TestReadContextStoreConfig(t *testing.T) {
+	dir := t.TempDir()
+	yaml := "context_store:\n  url: http://localhost:8000\n  collection: solus\n"
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test_config.yaml"), []byte(yaml), 0644))
+	reader := config.New()
+	assert.Nil(t, reader.Read("test_config", dir))
+
+	store, err := ReadContextStoreConfig(reader, "context_store")
+	assert.Nil(t, err)
+	assert.Equal(t, ContextStoreConfig{URL: "http://localhost:8000", Collection: "solus"}, store)
+
+	store, err = ReadContextStoreConfig(reader, "missing")
+	assert.Nil(t, err)
+	assert.Equal(t, ContextStoreConfig{}, store)
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "test_config.yaml"), []byte("context_store:\n  url: http://localhost:8000\n"), 0644))
+	assert.Nil(t, reader.Read("test_config", dir))
+	_, err = ReadContextStoreConfig(reader, "context_store")
+	assert.NotNil(t, err)
+}
+
 func TestGetChromaDB(t *testing.T) {
 	ctx := context.Background()
 	aiConfig := ai.NewAIConfig("testKey")
@@ -238,3 +264,22 @@ func TestSearch(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, results)
 }
+
+func TestSearchWithContextCancelled(t *testing.T) {
+	ctx := context.Background()
+	aiConfig := ai.NewAIConfig("testKey")
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	client, err := NewChromaClient(&ctx, ts.URL, *aiConfig)
+	assert.Nil(t, err)
+	searchCtx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.dbWithContext(searchCtx).GetNearestNeighbors("test", &chromadb.QueryEmbedding{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = client.SearchWithContext(searchCtx, "test", "query", 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
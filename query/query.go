@@ -49,10 +49,20 @@ func (q *QueryBuilder) GetResults() (string, error) {
 
 // Execute executes the query and stores the results in the QueryBuilder.
 func (q *QueryBuilder) Execute() *QueryBuilder {
-	if q.err != nil {
+	return q.ExecuteWithContext(q.ctx)
+}
+
+// ExecuteWithContext is Execute with a caller-provided context that can
+// cancel the underlying search requests.
+// Executing again, for instance to retry a failed search, replaces the
+// previous results and error.
+func (q *QueryBuilder) ExecuteWithContext(ctx context.Context) *QueryBuilder {
+	if q.googleSearchClient == nil {
 		return q
 	}
-	googleSearchResults, err := q.googleSearchClient.Search(q.queryText)
+	q.results = ""
+	q.err = nil
+	googleSearchResults, err := q.googleSearchClient.SearchWithContext(ctx, q.queryText)
 	if err != nil {
 		q.err = err
 		return q
@@ -1,6 +1,9 @@
 package search_clients
 
 import (
+	"context"
+	"net/http"
+
 	"github.com/PuerkitoBio/goquery"
 	colly "github.com/gocolly/colly/v2"
 )
@@ -125,6 +128,32 @@ func (s *Scraper) ScrapePage(url string) (*Website, error) {
 	return &w, err
 }
 
+// ScrapePageWithContext scrapes a page like ScrapePage, cancelling the
+// request when ctx is done.
+func (s *Scraper) ScrapePageWithContext(ctx context.Context, url string) (*Website, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.c.WithTransport(&contextTransport{ctx: ctx, base: http.DefaultTransport})
+	defer s.c.WithTransport(http.DefaultTransport)
+	website, err := s.ScrapePage(url)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	return website, err
+}
+
+// contextTransport sends every request with its context replaced by ctx,
+// since Colly builds requests without one.
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
 // Gets text content from an HTML element
 func (s *Scraper) getTextContent(e HTMLElement) string {
 	doc := goquery.NewDocumentFromNode(e.DOM.Nodes[0])
@@ -1,10 +1,12 @@
 package search_clients
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -73,6 +75,30 @@ func TestScraper_ScrapePage(t *testing.T) {
 	assert.Equal(t, "https://github.com/CSXL", page.Links[0])
 }
 
+func TestScraper_ScrapePageWithContext(t *testing.T) {
+	s := NewScraper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, testHTML)
+	}))
+	defer ts.Close()
+	page, err := s.ScrapePageWithContext(context.Background(), ts.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, "CSX Labs: Launching ideas into cyberspace.", page.Title)
+}
+
+func TestScraper_ScrapePageWithContextCancelled(t *testing.T) {
+	s := NewScraper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	page, err := s.ScrapePageWithContext(ctx, ts.URL)
+	assert.Nil(t, page)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 func TestScraper_Scrape(t *testing.T) {
 	t.Skip("This test relies on external websites, and should be run manually.")
 	s := NewScraper()
@@ -48,7 +48,13 @@ func (gsc *GoogleSearchClient) SetBasePath(basePath string) {
 }
 
 func (gsc *GoogleSearchClient) Search(query string) ([]*GoogleSearchResult, error) {
-	response, err := gsc.client.Cse.List().Q(query).Cx(gsc.googleSearchEngineID).Do()
+	return gsc.SearchWithContext(gsc.ctx, query)
+}
+
+// SearchWithContext is Search with a caller-provided context that can cancel
+// the request.
+func (gsc *GoogleSearchClient) SearchWithContext(ctx context.Context, query string) ([]*GoogleSearchResult, error) {
+	response, err := gsc.client.Cse.List().Q(query).Cx(gsc.googleSearchEngineID).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -3,6 +3,7 @@ package search_clients
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -90,3 +91,48 @@ func TestGoogleSearchResultsToJSON(t *testing.T) {
 		t.Errorf("GoogleSearchResultsToJSON() returned wrong JSON: %s", json)
 	}
 }
+
+func TestGoogleSearchClient_SearchWithCanceledContext(t *testing.T) {
+	client, err := NewGoogleSearchClient(context.Background(), "test", "test")
+	if err != nil {
+		t.Errorf("NewGoogleSearchClient() returned error: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("SearchWithContext() sent a request with a canceled context")
+	}))
+	defer ts.Close()
+	client.SetBasePath(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.SearchWithContext(ctx, "test_query")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchWithContext() returned wrong error: %v", err)
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	statusCodes := map[int]bool{
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusBadRequest:          false,
+		http.StatusForbidden:           false,
+	}
+	for statusCode, retryable := range statusCodes {
+		client, err := NewGoogleSearchClient(context.Background(), "test", "test")
+		if err != nil {
+			t.Fatalf("NewGoogleSearchClient() returned error: %v", err)
+		}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(statusCode)
+		}))
+		client.SetBasePath(ts.URL)
+		_, err = client.Search("test_query")
+		ts.Close()
+		if err == nil {
+			t.Fatalf("Search() returned no error for status code %d", statusCode)
+		}
+		if IsRetryableError(err) != retryable {
+			t.Errorf("IsRetryableError() = %v for status code %d: %v", !retryable, statusCode, err)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package search_clients
 
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
 type SearchClientConfig struct {
 	GoogleSearchAPIKey   string
 	GoogleSearchEngineID string
@@ -19,3 +27,14 @@ func (sc *SearchClientConfig) GetGoogleSearchAPIKey() string {
 func (sc *SearchClientConfig) GetGoogleSearchEngineID() string {
 	return sc.GoogleSearchEngineID
 }
+
+// IsRetryableError reports whether err is a transient search failure worth
+// retrying: rate limiting (429), server errors (5xx) and network errors.
+func IsRetryableError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusTooManyRequests || apiErr.Code >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
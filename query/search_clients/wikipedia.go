@@ -30,13 +30,13 @@ func NewWikipediaClient(ctx context.Context) (*WikipediaClient, error) {
 	}, nil
 }
 
-func (c *WikipediaClient) doRequest(query url.Values) (*http.Response, error) {
+func (c *WikipediaClient) doRequest(ctx context.Context, query url.Values) (*http.Response, error) {
 	requestUrl, err := url.Parse(c.wikipediaActionBaseUrl)
 	if err != nil {
 		return nil, err
 	}
 	requestUrl.RawQuery = query.Encode()
-	req, err := http.NewRequest("GET", requestUrl.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl.String(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -44,14 +44,14 @@ func (c *WikipediaClient) doRequest(query url.Values) (*http.Response, error) {
 	return c.httpclient.Do(req)
 }
 
-func (c *WikipediaClient) doSearchRequest(query string) (*http.Response, error) {
+func (c *WikipediaClient) doSearchRequest(ctx context.Context, query string) (*http.Response, error) {
 	url_query := url.Values{
 		"action":   {"query"},
 		"list":     {"search"},
 		"srsearch": {query},
 		"format":   {"json"},
 	}
-	response, err := c.doRequest(url_query)
+	response, err := c.doRequest(ctx, url_query)
 	if err != nil {
 		return nil, err
 	}
@@ -80,7 +80,13 @@ type wikipediaQuery struct {
 // Search performs a search on Wikipedia and returns a list of results.
 // See https://en.wikipedia.org/w/api.php?action=help&modules=query%2Bsearch for more information.
 func (c *WikipediaClient) Search(query string) ([]WikipediaQuerySearchResult, error) {
-	response, err := c.doSearchRequest(query)
+	return c.SearchWithContext(c.ctx, query)
+}
+
+// SearchWithContext is Search with a caller-provided context that can cancel
+// the request.
+func (c *WikipediaClient) SearchWithContext(ctx context.Context, query string) ([]WikipediaQuerySearchResult, error) {
+	response, err := c.doSearchRequest(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -94,13 +100,13 @@ func (c *WikipediaClient) Search(query string) ([]WikipediaQuerySearchResult, er
 	return results, nil
 }
 
-func (c *WikipediaClient) doParseRequest(pageTitle string) (*http.Response, error) {
+func (c *WikipediaClient) doParseRequest(ctx context.Context, pageTitle string) (*http.Response, error) {
 	url_query := url.Values{
 		"action": {"parse"},
 		"page":   {pageTitle},
 		"format": {"json"},
 	}
-	response, err := c.doRequest(url_query)
+	response, err := c.doRequest(ctx, url_query)
 	if err != nil {
 		return nil, err
 	}
@@ -120,7 +126,13 @@ type wikipediaParse struct {
 }
 
 func (c *WikipediaClient) GetPage(pageTitle string) (string, error) {
-	response, err := c.doParseRequest(pageTitle)
+	return c.GetPageWithContext(c.ctx, pageTitle)
+}
+
+// GetPageWithContext is GetPage with a caller-provided context that can
+// cancel the request.
+func (c *WikipediaClient) GetPageWithContext(ctx context.Context, pageTitle string) (string, error) {
+	response, err := c.doParseRequest(ctx, pageTitle)
 	if err != nil {
 		return "", err
 	}
@@ -134,7 +146,7 @@ func (c *WikipediaClient) GetPage(pageTitle string) (string, error) {
 	return data, nil
 }
 
-func (c *WikipediaClient) doPageSummaryRequest(pageTitle string) (*http.Response, error) {
+func (c *WikipediaClient) doPageSummaryRequest(ctx context.Context, pageTitle string) (*http.Response, error) {
 	url_query := url.Values{
 		"action":      {"query"},
 		"prop":        {"extracts"},
@@ -143,7 +155,7 @@ func (c *WikipediaClient) doPageSummaryRequest(pageTitle string) (*http.Response
 		"titles":      {pageTitle},
 		"format":      {"json"},
 	}
-	response, err := c.doRequest(url_query)
+	response, err := c.doRequest(ctx, url_query)
 	if err != nil {
 		return nil, err
 	}
@@ -166,7 +178,13 @@ type wikipediaQuerySummary struct {
 // GetPageSummary returns the summary of a Wikipedia page.
 // See https://en.wikipedia.org/w/api.php?action=help&modules=query%2Bextracts for more information.
 func (c *WikipediaClient) GetPageSummary(pageTitle string) (string, error) {
-	response, err := c.doPageSummaryRequest(pageTitle)
+	return c.GetPageSummaryWithContext(c.ctx, pageTitle)
+}
+
+// GetPageSummaryWithContext is GetPageSummary with a caller-provided context
+// that can cancel the request.
+func (c *WikipediaClient) GetPageSummaryWithContext(ctx context.Context, pageTitle string) (string, error) {
+	response, err := c.doPageSummaryRequest(ctx, pageTitle)
 	if err != nil {
 		return "", err
 	}
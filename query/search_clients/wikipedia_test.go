@@ -2,6 +2,7 @@ package search_clients
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"net/http"
@@ -94,3 +95,21 @@ func TestWikipediaClient_GetPageSummary(t *testing.T) {
 		t.Errorf("GetPageSummary() returned wrong summary: %s", page_summary)
 	}
 }
+
+func TestWikipediaClient_SearchWithCanceledContext(t *testing.T) {
+	client, err := NewWikipediaClient(context.Background())
+	if err != nil {
+		t.Errorf("NewWikipediaClient() returned error: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("SearchWithContext() sent a request with a canceled context")
+	}))
+	defer ts.Close()
+	client.wikipediaActionBaseUrl = ts.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.SearchWithContext(ctx, "Computing")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("SearchWithContext() returned wrong error: %v", err)
+	}
+}
@@ -5,18 +5,24 @@ import (
 	"os"
 
 	"github.com/CSXL/solus/ai"
+	"github.com/CSXL/solus/ai/agent"
 	"github.com/CSXL/solus/ai/chat"
 	"github.com/CSXL/solus/config"
 	"github.com/joho/godotenv"
 )
 
 type RequirementsConfig struct {
-	RequirementsPrompt string // The prompt to use when generating requirements
-	OpenAIAPIKey       string // The OpenAI API key to use when generating requirements
+	RequirementsPrompt string            // The prompt to use when generating requirements
+	OpenAIAPIKey       string            // The OpenAI API key to use when generating requirements
+	ModelOptions       ai.ModelOptions   // The model and sampling parameters, unset ones use the defaults
+	Provider           ai.ProviderConfig // The provider serving the model, by default OpenAI
 }
 
 func (r *RequirementsConfig) ToAIConfig() *ai.AIConfig {
-	return ai.NewAIConfig(r.OpenAIAPIKey)
+	aiConfig := ai.NewAIConfig(r.OpenAIAPIKey)
+	aiConfig.ModelOptions = aiConfig.ModelOptions.Merge(r.ModelOptions)
+	aiConfig.Provider = r.Provider
+	return aiConfig
 }
 
 func NewRequirementsConfig(requirementsPrompt string, openAIAPIKey string) *RequirementsConfig {
@@ -39,9 +45,25 @@ func LoadRequirementsConfig() (*RequirementsConfig, error) {
 	requirementsPrompt := config_reader.Get("requirements_prompt").(string)
 	openAIAPIKey := os.Getenv("OPENAI_API_KEY")
 	requirements_config := NewRequirementsConfig(requirementsPrompt, openAIAPIKey)
+	requirements_config.ModelOptions, err = ai.ReadModelOptions(config_reader, "model")
+	if err != nil {
+		return nil, err
+	}
+	requirements_config.Provider, err = ai.ReadProviderConfig(config_reader, "provider")
+	if err != nil {
+		return nil, err
+	}
 	return requirements_config, nil
 }
 
+// RequirementsSchema is the schema of the generated requirements YAML, as
+// described by the requirements prompt. Requirements may nest.
+var RequirementsSchema = ai.NewObjectSchema(map[string]*ai.JSONSchema{
+	"name":         ai.NewStringSchema("The project name"),
+	"mission":      ai.NewStringSchema("The project mission"),
+	"requirements": {Type: ai.JSONSchemaTypeArray, Description: "The requirements of the project"},
+}, "mission", "requirements")
+
 type RequirementsGenerator struct {
 	Conversation          *chat.Conversation
 	requirementsConfig    *RequirementsConfig
@@ -56,6 +78,9 @@ func NewRequirementsGenerator(inputConversation string, config *RequirementsConf
 	conversationName := "requirements"
 	aiConfig := config.ToAIConfig()
 	conversation := chat.NewConversation(conversationName, aiConfig)
+	// Requirements that do not parse are sent back for repair rather than
+	// passed on to code generation.
+	conversation.GetAgent().SetResponseValidator(agent.NewYAMLSchemaValidator(RequirementsSchema))
 	return &RequirementsGenerator{
 		inputConversation:     inputConversation,
 		Conversation:          conversation,
@@ -29,3 +29,14 @@ func TestRequirementsGenerator_Generate(t *testing.T) {
 	_, _ = testGenerator.Generate()
 	assert.NotNil(t, testGenerator.GeneratedRequirements)
 }
+
+func TestRequirementsGenerator_GenerateRepairsInvalidYAML(t *testing.T) {
+	testConfig := NewRequirementsConfig("test input prompt", "test key")
+	testGenerator := NewRequirementsGenerator("test input conversation", testConfig)
+	ts := openai.StartSequenceHTTPTestServer(nil, openai.SampleChatCompletion, openai.SampleChatYAMLCompletion)
+	defer ts.Close()
+	testGenerator.Conversation.GetAgent().OpenAIChatClient.SetBaseURL(ts.URL)
+	requirements, err := testGenerator.Generate()
+	assert.Nil(t, err)
+	assert.Contains(t, requirements, "mission: To advance technology for humanity")
+}
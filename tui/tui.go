@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/CSXL/solus/ai"
 	"github.com/CSXL/solus/ai/agent"
+	"github.com/CSXL/solus/ai/agent/tools"
 	"github.com/CSXL/solus/ai/chat"
 	"github.com/CSXL/solus/config"
-	"github.com/CSXL/solus/query"
+	"github.com/CSXL/solus/context/db"
 	"github.com/CSXL/solus/query/search_clients"
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textinput"
@@ -89,6 +91,10 @@ var (
 	}
 )
 
+// searchRetryPolicy retries the tool calls requested by the assistant when
+// they fail with a transient error.
+var searchRetryPolicy = agent.NewRetryPolicy(3, time.Second).WithRetryable(search_clients.IsRetryableError)
+
 type screen struct {
 	width  int
 	height int
@@ -100,19 +106,79 @@ type TUIConfig struct {
 	APIKey               string // In environment variable OPENAI_API_KEY
 	LoadMessagesFromFile bool
 	Debug                bool
+	ModelOptions         ai.ModelOptions       // Model and sampling parameters of the discovery chat
+	Provider             ai.ProviderConfig     // Provider serving the model, by default OpenAI
+	ProjectFolder        string                // Folder of the project being discussed, whose files the assistant can read, if any
+	ContextStore         db.ContextStoreConfig // Context store the assistant can search, if any
 }
 
 type model struct {
 	Conversation *chat.Conversation
-	QueryClient  *query.QueryBuilder
 	screen       screen
 	input        textinput.Model
 	viewport     viewport.Model
 	tui_config   TUIConfig
+	agentEvents  <-chan agent.AgentEvent // Lifecycle events of the conversation's agent
+	sending      bool                    // Whether a user message is awaiting its response
+	streamed     string                  // Response received so far to the message being sent
 	err          error
 }
 
-func NewModel(tui_config TUIConfig, query_client *query.QueryBuilder) model {
+// agentEventMsg carries an agent lifecycle event into the update loop so the
+// view is redrawn, for example to move a progress bar.
+type agentEventMsg agent.AgentEvent
+
+// messageSentMsg reports that a user message got its response.
+type messageSentMsg struct {
+	err error
+}
+
+// messageDeltaMsg carries a piece of the response to a user message, along
+// with the channels to wait on for the rest of it.
+type messageDeltaMsg struct {
+	delta  agent.ChatAgentMessageDelta
+	deltas <-chan agent.ChatAgentMessageDelta
+	sent   <-chan error
+}
+
+// waitForAgentEvent returns a command that waits for the next agent event.
+func waitForAgentEvent(events <-chan agent.AgentEvent) tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-events
+		if !ok {
+			return nil
+		}
+		return agentEventMsg(event)
+	}
+}
+
+// streamUserMessage returns a command that sends a message in the
+// background and streams the response into the view as it is generated.
+func streamUserMessage(conversation *chat.Conversation, message string) tea.Cmd {
+	return func() tea.Msg {
+		deltas := make(chan agent.ChatAgentMessageDelta, 64)
+		sent := make(chan error, 1)
+		go func() {
+			_, err := conversation.StreamUserMessage(message, deltas)
+			sent <- err
+		}()
+		return waitForMessageDelta(deltas, sent)()
+	}
+}
+
+// waitForMessageDelta returns a command that waits for the next piece of a
+// streamed response, or for the response to complete.
+func waitForMessageDelta(deltas <-chan agent.ChatAgentMessageDelta, sent <-chan error) tea.Cmd {
+	return func() tea.Msg {
+		delta, ok := <-deltas
+		if !ok {
+			return messageSentMsg{err: <-sent}
+		}
+		return messageDeltaMsg{delta: delta, deltas: deltas, sent: sent}
+	}
+}
+
+func NewModel(tui_config TUIConfig) model {
 	ti := textinput.New()
 	ti.Prompt = ""
 	ti.Placeholder = "Enter your message here..."
@@ -121,18 +187,24 @@ func NewModel(tui_config TUIConfig, query_client *query.QueryBuilder) model {
 	ti.Width = 80
 	conversationName := "Solus TUI Conversation"
 	conversationConfig := ai.NewAIConfig(tui_config.APIKey)
+	conversationConfig.ModelOptions = conversationConfig.ModelOptions.Merge(tui_config.ModelOptions)
+	conversationConfig.Provider = tui_config.Provider
 	conversation := chat.NewConversation(conversationName, conversationConfig)
+	// The discovery prompt asks for every response in this envelope; malformed
+	// ones are sent back for repair instead of being shown as raw JSON.
+	conversation.GetAgent().SetResponseValidator(agent.NewJSONSchemaValidator(agent.NewChatAgentMessageSchema("message")))
+	agentEvents, _ := conversation.GetAgent().Subscribe(64)
 	return model{
 		Conversation: conversation,
 		input:        ti,
 		viewport:     viewport.New(80, 20),
 		tui_config:   tui_config,
-		QueryClient:  query_client,
+		agentEvents:  agentEvents,
 	}
 }
 
 func (m model) Init() tea.Cmd {
-	return textinput.Blink
+	return tea.Batch(textinput.Blink, waitForAgentEvent(m.agentEvents))
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -142,6 +214,18 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.WindowSizeMsg:
 		m.screen.width = msg.Width
 		m.screen.height = msg.Height
+	case agentEventMsg:
+		cmds = append(cmds, waitForAgentEvent(m.agentEvents))
+	case messageDeltaMsg:
+		m.streamed = msg.delta.Content
+		cmds = append(cmds, waitForMessageDelta(msg.deltas, msg.sent))
+	case messageSentMsg:
+		m.sending = false
+		m.streamed = ""
+		m.err = msg.err
+		if msg.err == nil {
+			m.input.SetValue("")
+		}
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, keybindings.Quit):
@@ -159,10 +243,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, tea.Println("This is a temporary help message that will be replaced by a help view.")
 			}
 		case key.Matches(msg, keybindings.Enter):
-			if m.input.Value() != "" {
-				messageToSend := m.input.Value()
-				_, _ = m.Conversation.SendUserMessage(messageToSend)
-				m.input.SetValue("")
+			if m.input.Value() != "" && !m.sending {
+				m.sending = true
+				m.err = nil
+				cmds = append(cmds, streamUserMessage(m.Conversation, m.input.Value()))
 			}
 		case key.Matches(msg, keybindings.Save):
 			_ = m.Conversation.SaveToFile(m.tui_config.SavedMessagesFile)
@@ -194,40 +278,29 @@ func (m model) View() string {
 	return s
 }
 
-func (m model) resolveLastMessage() error {
-	lastMessage := m.Conversation.GetLastMessage()
-	if lastMessage.GetRole() == "assistant" {
-		if lastMessage.IsQueryMessage() {
-			queryResults, err := m.QueryClient.SetType("search").SetQueryText(lastMessage.GetContent()).Execute().GetResults()
-			if err != nil {
-				return err
-			}
-			_, err = m.Conversation.SendSystemMessage(queryResults)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
 func (m model) ChatView() string {
 	var s string
 
-	err := m.resolveLastMessage()
-	if err != nil {
-		m.err = err
-		return m.err.Error()
-	}
-
 	for _, msg := range m.Conversation.GetMessages() {
-		if msg.GetRole() != "system" || m.tui_config.Debug {
+		if !isHiddenMessage(msg) || m.tui_config.Debug {
 			formattedMessage := m.formatMessage(msg)
 			s += styles.secondary.Render(formattedMessage)
 			s += "\n"
 		}
 	}
 
+	if m.streamed != "" {
+		s += styles.secondary.Render(fmt.Sprintf("[ASSISTANT]: %s", m.streamed))
+		s += "\n"
+	}
+
+	s += m.ProgressView()
+
+	if m.err != nil {
+		s += styles.specialText.Render(fmt.Sprintf("[ERROR]: %s", m.err.Error()))
+		s += "\n"
+	}
+
 	s += styles.secondary.Render("[USER]: ")
 	s += styles.primary.Render(m.input.View())
 
@@ -239,10 +312,35 @@ func (m model) ChatView() string {
 	return s
 }
 
+// ProgressView renders a progress bar for every running task of the
+// conversation's agent.
+func (m model) ProgressView() string {
+	var s string
+	for _, record := range m.Conversation.GetAgent().GetTasksByState(agent.AgentTaskStateRunning) {
+		progress := record.Task.GetProgress()
+		status := progress.Bar(30)
+		if !progress.IsReported() {
+			status = fmt.Sprintf("running for %s", record.GetDuration().Round(time.Second))
+		}
+		s += styles.specialText.Render(fmt.Sprintf("[%s]: %s", strings.ToUpper(record.Name), status))
+		s += "\n"
+	}
+	return s
+}
+
+// isHiddenMessage reports whether msg is only shown in debug mode, such as
+// system prompts and the results of tool calls.
+func isHiddenMessage(msg agent.ChatAgentMessage) bool {
+	return msg.GetRole() == agent.ChatAgentMessageRoleSystem || msg.IsToolResultMessage()
+}
+
 func (m model) formatMessage(chatMsg agent.ChatAgentMessage) string {
 	if chatMsg.IsQueryMessage() {
 		return m.formatQueryMessage(chatMsg)
 	}
+	if chatMsg.IsToolCallMessage() {
+		return m.formatToolCallMessage(chatMsg)
+	}
 
 	return m.formatNonQueryMessage(chatMsg)
 }
@@ -254,6 +352,13 @@ func (m model) formatQueryMessage(chatMsg agent.ChatAgentMessage) string {
 	return formatted_message
 }
 
+func (m model) formatToolCallMessage(chatMsg agent.ChatAgentMessage) string {
+	coloredArguments := styles.specialText.Render(strings.Trim(chatMsg.ToolCall.Arguments, " \n"))
+	formatted_message := fmt.Sprintf("Calling %s: %s\n\n", chatMsg.ToolCall.Name, coloredArguments)
+
+	return formatted_message
+}
+
 func (m model) formatNonQueryMessage(chatMsg agent.ChatAgentMessage) string {
 	formatted_role := strings.ToUpper(string(chatMsg.GetRole()))
 	markdown_renderer, _ := glamour.NewTermRenderer(glamour.WithAutoStyle())
@@ -274,6 +379,19 @@ func readTUIConfig() (TUIConfig, error) {
 	tui_config.DiscoveryMessage = config_reader.Get("discovery_message").(string)
 	tui_config.LoadMessagesFromFile = config_reader.Get("load_messages_from_file").(bool)
 	tui_config.Debug = config_reader.Get("debug").(bool)
+	tui_config.ModelOptions, err = ai.ReadModelOptions(config_reader, "model")
+	if err != nil {
+		return TUIConfig{}, err
+	}
+	tui_config.Provider, err = ai.ReadProviderConfig(config_reader, "provider")
+	if err != nil {
+		return TUIConfig{}, err
+	}
+	tui_config.ProjectFolder = config_reader.GetString("project_folder")
+	tui_config.ContextStore, err = db.ReadContextStoreConfig(config_reader, "context_store")
+	if err != nil {
+		return TUIConfig{}, err
+	}
 	return tui_config, nil
 }
 
@@ -298,7 +416,8 @@ func prepareConversation(config TUIConfig, conversation *chat.Conversation) erro
 			return err
 		}
 	} else {
-		_, err := conversation.SendSystemMessage(config.DiscoveryMessage)
+		discoveryMessage := strings.ReplaceAll(config.DiscoveryMessage, ToolsPlaceholder, describeTools(conversation.GetAgent().GetToolRegistry()))
+		_, err := conversation.SendSystemMessage(discoveryMessage)
 		if err != nil {
 			return err
 		}
@@ -306,6 +425,24 @@ func prepareConversation(config TUIConfig, conversation *chat.Conversation) erro
 	return nil
 }
 
+// ToolsPlaceholder is replaced in the discovery message by the description
+// of the tools the assistant can call.
+const ToolsPlaceholder = "{{tools}}"
+
+// describeTools lists the tools of registry for the discovery message, so
+// that only the tools actually registered are described.
+func describeTools(registry *agent.ToolRegistry) string {
+	if registry == nil || len(registry.GetTools()) == 0 {
+		return "You have no functions to call, answer from what you know."
+	}
+	var description strings.Builder
+	description.WriteString("To research a topic or look something up, call the following functions rather than guessing:")
+	for _, tool := range registry.GetTools() {
+		fmt.Fprintf(&description, "\n* %s: %s", tool.Name, tool.Description)
+	}
+	return description.String()
+}
+
 func loadSearchEngineConfig() (search_clients.SearchClientConfig, error) {
 	err := godotenv.Load()
 	if err != nil {
@@ -318,6 +455,46 @@ func loadSearchEngineConfig() (search_clients.SearchClientConfig, error) {
 	return search_engine_config, nil
 }
 
+// registerTools lets the conversation's agent search the web, look up
+// Wikipedia and read web pages on its own, as well as read the files of the
+// project folder and search the context store when they are configured.
+func registerTools(ctx context.Context, conversation *chat.Conversation, tuiConfig TUIConfig, searchConfig search_clients.SearchClientConfig) error {
+	googleClient, err := search_clients.NewGoogleSearchClient(ctx, searchConfig.GoogleSearchAPIKey, searchConfig.GoogleSearchEngineID)
+	if err != nil {
+		return err
+	}
+	wikipediaClient, err := search_clients.NewWikipediaClient(ctx)
+	if err != nil {
+		return err
+	}
+	registry := agent.NewToolRegistry()
+	conversationTools := []agent.Tool{
+		tools.NewWebSearchTool(googleClient),
+		tools.NewWikipediaSummaryTool(wikipediaClient),
+		tools.NewScrapeURLTool(tools.DefaultMaxResultLength),
+	}
+	if tuiConfig.ProjectFolder != "" {
+		conversationTools = append(conversationTools, tools.NewReadProjectFileTool(tuiConfig.ProjectFolder, tools.DefaultMaxResultLength))
+	}
+	if store := tuiConfig.ContextStore; store.URL != "" {
+		chromaClient, err := db.NewChromaClient(&ctx, store.URL, *conversation.GetConfig())
+		if err != nil {
+			return err
+		}
+		conversationTools = append(conversationTools, tools.NewContextSearchTool(chromaClient, store.Collection, tools.DefaultContextSearchLimit))
+	}
+	for _, tool := range conversationTools {
+		err = registry.Register(tool)
+		if err != nil {
+			return err
+		}
+	}
+	chatAgent := conversation.GetAgent()
+	chatAgent.SetToolRegistry(registry)
+	chatAgent.SetTaskTypeRetryPolicy(agent.ChatAgentToolTaskType, searchRetryPolicy)
+	return nil
+}
+
 func NewLogger() (*zap.Logger, error) {
 	cfg := zap.NewProductionConfig()
 	cfg.OutputPaths = []string{"debug.log"}
@@ -347,8 +524,11 @@ func Run() (tea.Model, error) {
 	undo := zap.ReplaceGlobals(logger)
 	defer undo()
 	defer logFile.Close()
-	query_client := query.NewQuery(ctx, search_engine_config)
-	m := NewModel(tui_config, query_client)
+	m := NewModel(tui_config)
+	err = registerTools(ctx, m.Conversation, tui_config, search_engine_config)
+	if err != nil {
+		return nil, err
+	}
 	err = prepareConversation(tui_config, m.Conversation)
 	if err != nil {
 		return nil, err